@@ -4,13 +4,17 @@
 
 // The pkgscript command interprets a Starlark file.
 // With no arguments, it starts a read-eval-print loop (REPL).
+// With -format, it instead prints the named files in canonical
+// format, or with -w rewrites them in place, like gofmt.
 package main // import "github.com/andrewchambers/pkgscript/cmd/pkgscript"
 
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
 	"runtime/pprof"
 	"strings"
@@ -19,6 +23,7 @@ import (
 	"github.com/andrewchambers/pkgscript/repl"
 	"github.com/andrewchambers/pkgscript/resolve"
 	"github.com/andrewchambers/pkgscript/pkgscript"
+	"github.com/andrewchambers/pkgscript/syntax"
 )
 
 // flags
@@ -28,6 +33,8 @@ var (
 	profile    = flag.String("profile", "", "gather Starlark time profile in this file")
 	showenv    = flag.Bool("showenv", false, "on success, print final global environment")
 	execprog   = flag.String("c", "", "execute program `prog`")
+	format     = flag.Bool("format", false, "print the named files in canonical format instead of executing them")
+	write      = flag.Bool("w", false, "with -format, rewrite the files in place")
 )
 
 func init() {
@@ -85,6 +92,10 @@ func doMain() int {
 		}()
 	}
 
+	if *format {
+		return doFormat(flag.Args())
+	}
+
 	thread := &pkgscript.Thread{Load: repl.MakeLoad()}
 	globals := make(pkgscript.StringDict)
 
@@ -112,7 +123,11 @@ func doMain() int {
 	case flag.NArg() == 0:
 		fmt.Println("Welcome to Starlark (github.com/andrewchambers/pkgscript)")
 		thread.Name = "REPL"
-		repl.REPL(thread, globals)
+		var opts repl.Options
+		if home, err := os.UserHomeDir(); err == nil {
+			opts.HistoryFile = filepath.Join(home, ".pkgscript_history")
+		}
+		repl.REPL(thread, globals, opts)
 		return 0
 	default:
 		log.Print("want at most one Starlark file name")
@@ -131,6 +146,35 @@ func doMain() int {
 	return 0
 }
 
+// doFormat formats each named file, printing the result
+// to stdout or, with -w, writing it back to the file.
+func doFormat(filenames []string) int {
+	if len(filenames) == 0 {
+		log.Print("-format requires at least one file name")
+		return 1
+	}
+	status := 0
+	for _, filename := range filenames {
+		f, err := syntax.Parse(filename, nil, syntax.RetainComments)
+		if err != nil {
+			log.Print(err)
+			status = 1
+			continue
+		}
+		out := syntax.Format(f)
+		if *write {
+			err = ioutil.WriteFile(filename, out, 0666)
+		} else {
+			_, err = os.Stdout.Write(out)
+		}
+		if err != nil {
+			log.Print(err)
+			status = 1
+		}
+	}
+	return status
+}
+
 func check(err error) {
 	if err != nil {
 		log.Fatal(err)
@@ -1,5 +1,9 @@
 module github.com/andrewchambers/pkgscript
 
-go 1.12
+go 1.16
 
-require github.com/chzyer/readline v0.0.0-20180603132655-2972be24d48e
+require (
+	github.com/BurntSushi/toml v1.3.2
+	github.com/chzyer/readline v0.0.0-20180603132655-2972be24d48e
+	gopkg.in/yaml.v3 v3.0.1
+)
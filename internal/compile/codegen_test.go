@@ -72,6 +72,130 @@ func TestPlusFolding(t *testing.T) {
 	}
 }
 
+// TestConstantFolding ensures that the compiler evaluates
+// constant expressions, and only those that cannot fail.
+func TestConstantFolding(t *testing.T) {
+	defer func() { resolve.AllowFloat = false }()
+	resolve.AllowFloat = true
+
+	isPredeclared := func(name string) bool { return name == "x" }
+	isUniversal := func(name string) bool { return name == "True" || name == "False" }
+	for i, test := range []struct {
+		src  string // source expression
+		want string // disassembled code
+	}{
+		{`2 * 3`, `constant 6; return`},
+		{`-(1 + 2) * 4 - 1`, `constant -13; return`},
+		{`-7 // 2, -7 % 2`, `constant -4; constant 1; maketuple<2>; return`},
+		{`~5 & 0xff | 1 << 8`, `constant 506; return`},
+		{`1 << 64`, `constant 18446744073709551616; return`},
+		{`"a" + "b" == "ab"`, `universal True; return`},
+		{`not (1 < 2 and "")`, `universal True; return`},
+		{`2.5 * 2.0 + 1.0`, `constant 6; return`},
+		{`x if 0 else 1`, `constant 1; return`},
+		{`True and x`, `predeclared x; return`},
+		{`0 or x`, `predeclared x; return`},
+		{`x or 1 + 1`, `predeclared x; dup; cjmp<11>; nop; nop; nop; pop; constant 2; return`},
+		// Operations that mix int and float are not folded.
+		{`2.5 * 2`, `constant 2.5; constant 2; star; return`},
+		{`9007199254740993 == 9007199254740992.0`, `constant 9007199254740993; constant 9.007199254740992e+15; eql; return`},
+		// Operations that may fail at run time are not folded.
+		{`1 // 0`, `constant 1; constant 0; slashslash; return`},
+		{`1 % 0`, `constant 1; constant 0; percent; return`},
+		{`1 << -1`, `constant 1; constant -1; ltlt; return`},
+		{`1 / 2`, `constant 1; constant 2; slash; return`},
+		{`1 + "a"`, `constant 1; constant "a"; plus; return`},
+		{`-0.0`, `constant 0; uminus; return`},
+	} {
+		expr, err := syntax.ParseExpr("in.star", test.src, 0)
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		locals, err := resolve.Expr(expr, isPredeclared, isUniversal)
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		got := disassemble(Expr(expr, "<expr>", locals).Toplevel)
+		if test.want != got {
+			t.Errorf("expression <<%s>> generated <<%s>>, want <<%s>>",
+				test.src, got, test.want)
+		}
+	}
+}
+
+// TestConstantCondition ensures that the compiler generates no code
+// for the branch of an if statement not taken by a constant condition.
+func TestConstantCondition(t *testing.T) {
+	defer func() { resolve.AllowGlobalReassign = false }()
+	resolve.AllowGlobalReassign = true
+
+	const src = `
+if 1 > 2:
+    f()
+elif not False:
+    g()
+else:
+    h()
+`
+	isPredeclared := func(name string) bool { return name == "f" || name == "g" || name == "h" }
+	isUniversal := func(name string) bool { return name == "False" }
+	f, err := syntax.Parse("in.star", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resolve.File(f, isPredeclared, isUniversal); err != nil {
+		t.Fatal(err)
+	}
+	module := f.Module.(*resolve.Module)
+	prog := File(f.Stmts, syntax.MakePosition(&f.Path, 1, 1), "<toplevel>", module.Locals, module.Globals)
+	const want = `predeclared g; call<0>; pop; none; return`
+	if got := disassemble(prog.Toplevel); got != want {
+		t.Errorf("if statement generated <<%s>>, want <<%s>>", got, want)
+	}
+}
+
+// TestUnreachableCode ensures that the compiler generates no code
+// for statements after a return, break, or continue.
+func TestUnreachableCode(t *testing.T) {
+	defer func() { resolve.AllowNestedDef = false }()
+	resolve.AllowNestedDef = true
+
+	const src = `
+def f(x):
+    for y in x:
+        continue
+        g(y)
+    return x
+    g("unreachable")
+    def h(): pass
+`
+	isPredeclared := func(name string) bool { return name == "g" }
+	isUniversal := func(name string) bool { return false }
+	f, err := syntax.Parse("in.star", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resolve.File(f, isPredeclared, isUniversal); err != nil {
+		t.Fatal(err)
+	}
+	module := f.Module.(*resolve.Module)
+	prog := File(f.Stmts, syntax.MakePosition(&f.Path, 1, 1), "<toplevel>", module.Locals, module.Globals)
+	if len(prog.Functions) != 1 {
+		t.Errorf("got %d functions, want 1 (unreachable def h was compiled)", len(prog.Functions))
+	}
+	const want = `local x; iterpush; iterjmp<15>; nop; nop; nop; setlocal<1>; jmp<3>; nop; nop; nop; iterpop; local x; return`
+	if got := disassemble(prog.Functions[0]); got != want {
+		t.Errorf("function f generated <<%s>>, want <<%s>>", got, want)
+	}
+	for _, c := range prog.Constants {
+		if c == "unreachable" {
+			t.Errorf("constant of unreachable code was compiled")
+		}
+	}
+}
+
 // disassemble is a trivial disassembler tailored to the accumulator test.
 func disassemble(f *Funcode) string {
 	out := new(bytes.Buffer)
@@ -107,7 +231,7 @@ func disassemble(f *Funcode) string {
 				}
 			case LOCAL:
 				fmt.Fprintf(out, " %s", f.Locals[arg].Name)
-			case PREDECLARED:
+			case PREDECLARED, UNIVERSAL:
 				fmt.Fprintf(out, " %s", f.Prog.Names[arg])
 			default:
 				fmt.Fprintf(out, "<%d>", arg)
@@ -32,8 +32,10 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
 
 	"github.com/andrewchambers/pkgscript/resolve"
 	"github.com/andrewchambers/pkgscript/syntax"
@@ -41,12 +43,31 @@ import (
 
 // Disassemble causes the assembly code for each function
 // to be printed to stderr as it is generated.
+// It applies to every compilation in the process, so it must not
+// be modified while a program is being compiled.
 var Disassemble = false
 
+// coverage is nonzero while coverage mode is enabled; see SetCoverage.
+// It is accessed atomically.
+var coverage uint32
+
+// SetCoverage enables or disables coverage mode. In coverage mode, a
+// branch that a constant condition makes unreachable, such as the
+// body of "if False:", is compiled rather than pruned, so that a
+// coverage report lists its lines as not executed instead of omitting
+// them. The mode applies to each program compiled while it is enabled.
+func SetCoverage(on bool) {
+	var v uint32
+	if on {
+		v = 1
+	}
+	atomic.StoreUint32(&coverage, v)
+}
+
 const debug = false // make code generation verbose, for debugging the compiler
 
 // Increment this to force recompilation of saved bytecode files.
-const Version = 10
+const Version = 14
 
 type Opcode uint8
 
@@ -104,6 +125,7 @@ const (
 	NOT         //          value NOT         bool
 	RETURN      //          value RETURN      -
 	SETINDEX    //        a i new SETINDEX    -
+	DELINDEX    //            a i DELINDEX    -
 	INDEX       //            a i INDEX       elem
 	SETDICT     // dict key value SETDICT     -
 	SETDICTUNIQ // dict key value SETDICTUNIQ -
@@ -129,6 +151,8 @@ const (
 	LOAD        //   from1 ... fromN module LOAD<n>      v1 ... vN
 	SETLOCAL    //             value SETLOCAL<local>     -
 	SETGLOBAL   //             value SETGLOBAL<global>   -
+	DELLOCAL    //                 - DELLOCAL<local>     -
+	DELGLOBAL   //                 - DELGLOBAL<global>   -
 	LOCAL       //                 - LOCAL<local>        value
 	FREE        //                 - FREE<freevar>       cell
 	GLOBAL      //                 - GLOBAL<global>      value
@@ -136,6 +160,7 @@ const (
 	UNIVERSAL   //                 - UNIVERSAL<name>     value
 	ATTR        //                 x ATTR<name>          y           y = x.name
 	SETFIELD    //               x y SETFIELD<name>      -           x.name = y
+	DELFIELD    //                 x DELFIELD<name>      -           del x.name
 	UNPACK      //          iterable UNPACK<n>           vn ... v1
 
 	// n>>8 is #positional args and n&0xff is #named args (pairs).
@@ -162,6 +187,10 @@ var opcodeNames = [...]string{
 	CIRCUMFLEX:  "circumflex",
 	CJMP:        "cjmp",
 	CONSTANT:    "constant",
+	DELFIELD:    "delfield",
+	DELGLOBAL:   "delglobal",
+	DELINDEX:    "delindex",
+	DELLOCAL:    "dellocal",
 	DUP2:        "dup2",
 	DUP:         "dup",
 	EQL:         "eql",
@@ -235,6 +264,10 @@ var stackEffect = [...]int8{
 	CIRCUMFLEX:  -1,
 	CJMP:        -1,
 	CONSTANT:    +1,
+	DELFIELD:    -1,
+	DELGLOBAL:   0,
+	DELINDEX:    -2,
+	DELLOCAL:    0,
 	DUP2:        +2,
 	DUP:         +1,
 	EQL:         -1,
@@ -304,11 +337,14 @@ func (op Opcode) String() string {
 // Programs are serialized by the Program.Encode method,
 // which must be updated whenever this declaration is changed.
 type Program struct {
-	Names     []string      // names of attributes and predeclared variables
-	Constants []interface{} // = string | int64 | float64 | *big.Int
-	Functions []*Funcode
-	Globals   []Binding // for error messages and tracing
-	Toplevel  *Funcode  // module initialization function
+	Names       []string      // names of attributes and predeclared variables
+	Predeclared []string      // names of predeclared variables used, sorted
+	Constants   []interface{} // = string | int64 | float64 | *big.Int
+	Functions   []*Funcode
+	Globals     []Binding // for error messages and tracing
+	Toplevel    *Funcode  // module initialization function
+	Recursion   bool      // disable recursion check for functions in this file
+	Float       bool      // allow x / y to yield a float
 }
 
 // A Funcode is the code of a compiled Starlark function.
@@ -351,9 +387,11 @@ type Binding struct {
 type pcomp struct {
 	prog *Program // what we're building
 
-	names     map[string]uint32
-	constants map[interface{}]uint32
-	functions map[*Funcode]uint32
+	names       map[string]uint32
+	predeclared map[string]bool
+	constants   map[interface{}]uint32
+	functions   map[*Funcode]uint32
+	coverage    bool // compile unreachable branches too; see SetCoverage
 }
 
 // An fcomp holds the compiler state for a Funcode.
@@ -423,6 +461,79 @@ func (fn *Funcode) Position(pc uint32) syntax.Position {
 	return pos
 }
 
+// ExactPosition returns the source position recorded for the
+// instruction at program counter pc. Unlike Position, it reports
+// false if the instruction has no position of its own, as is the case
+// for instructions that cannot fail after the first of each statement.
+func (fn *Funcode) ExactPosition(pc uint32) (syntax.Position, bool) {
+	fn.lntOnce.Do(fn.decodeLNT)
+
+	i := sort.Search(len(fn.lnt), func(i int) bool { return fn.lnt[i].pc >= pc })
+	if i == len(fn.lnt) || fn.lnt[i].pc != pc {
+		return syntax.Position{}, false
+	}
+	pos := fn.Pos // copy the filename
+	pos.Line = fn.lnt[i].line
+	pos.Col = fn.lnt[i].col
+	return pos, true
+}
+
+// A PCPosition associates a program counter with a source position.
+type PCPosition struct {
+	PC  uint32
+	Pos syntax.Position
+}
+
+// PCPositions returns the rows of the function's line number table,
+// in increasing order of program counter. Each row gives the source
+// position of the instructions from its PC up to that of the next row.
+func (fn *Funcode) PCPositions() []PCPosition {
+	fn.lntOnce.Do(fn.decodeLNT)
+
+	rows := make([]PCPosition, len(fn.lnt))
+	for i, entry := range fn.lnt {
+		pos := fn.Pos // copy the filename
+		pos.Line = entry.line
+		pos.Col = entry.col
+		rows[i] = PCPosition{entry.pc, pos}
+	}
+	return rows
+}
+
+// Lines returns the distinct source lines of the function's code,
+// in increasing order.
+func (fn *Funcode) Lines() []int32 {
+	fn.lntOnce.Do(fn.decodeLNT)
+
+	seen := make(map[int32]bool)
+	var lines []int32
+	for _, entry := range fn.lnt {
+		if !seen[entry.line] {
+			seen[entry.line] = true
+			lines = append(lines, entry.line)
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i] < lines[j] })
+	return lines
+}
+
+// NumInstructions returns the number of instructions in the function's code.
+func (fn *Funcode) NumInstructions() int {
+	n := 0
+	for pc := 0; pc < len(fn.Code); n++ {
+		op := Opcode(fn.Code[pc])
+		pc++
+		if op >= OpcodeArgMin {
+			// Skip the varint-encoded argument.
+			for fn.Code[pc] >= 0x80 {
+				pc++
+			}
+			pc++
+		}
+	}
+	return n
+}
+
 // decodeLNT decodes the line number table and populates fn.lnt.
 // It is called at most once.
 func (fn *Funcode) decodeLNT() {
@@ -487,11 +598,14 @@ func File(stmts []syntax.Stmt, pos syntax.Position, name string, locals, globals
 		prog: &Program{
 			Globals: bindings(globals),
 		},
-		names:     make(map[string]uint32),
-		constants: make(map[interface{}]uint32),
-		functions: make(map[*Funcode]uint32),
+		names:       make(map[string]uint32),
+		predeclared: make(map[string]bool),
+		constants:   make(map[interface{}]uint32),
+		functions:   make(map[*Funcode]uint32),
+		coverage:    atomic.LoadUint32(&coverage) != 0,
 	}
 	pcomp.prog.Toplevel = pcomp.function(name, pos, stmts, locals, nil)
+	sort.Strings(pcomp.prog.Predeclared)
 
 	return pcomp.prog
 }
@@ -864,11 +978,11 @@ func PrintOp(fn *Funcode, pc uint32, op Opcode, arg uint32) {
 		}
 	case MAKEFUNC:
 		comment = fn.Prog.Functions[arg].Name
-	case SETLOCAL, LOCAL:
+	case SETLOCAL, LOCAL, DELLOCAL:
 		comment = fn.Locals[arg].Name
-	case SETGLOBAL, GLOBAL:
+	case SETGLOBAL, GLOBAL, DELGLOBAL:
 		comment = fn.Prog.Globals[arg].Name
-	case ATTR, SETFIELD, PREDECLARED, UNIVERSAL:
+	case ATTR, SETFIELD, DELFIELD, PREDECLARED, UNIVERSAL:
 		comment = fn.Prog.Names[arg]
 	case FREE:
 		comment = fn.Freevars[arg].Name
@@ -922,6 +1036,7 @@ func (fcomp *fcomp) jump(b *block) {
 	}
 	fcomp.block.jmp = b
 	fcomp.block = nil
+	fcomp.pos = syntax.Position{} // don't attribute the next block to this one
 }
 
 // condjump emits a conditional jump (CJMP or ITERJMP)
@@ -952,6 +1067,9 @@ func (pcomp *pcomp) nameIndex(name string) uint32 {
 // constantIndex returns the index of the specified constant
 // within the constant pool, adding it if necessary.
 func (pcomp *pcomp) constantIndex(v interface{}) uint32 {
+	if s, ok := v.(string); ok {
+		v = intern(s)
+	}
 	index, ok := pcomp.constants[v]
 	if !ok {
 		index = uint32(len(pcomp.prog.Constants))
@@ -1023,6 +1141,10 @@ func (fcomp *fcomp) lookup(id *syntax.Ident) {
 	case resolve.Global:
 		fcomp.emit1(GLOBAL, uint32(bind.Index))
 	case resolve.Predeclared:
+		if pcomp := fcomp.pcomp; !pcomp.predeclared[id.Name] {
+			pcomp.predeclared[id.Name] = true
+			pcomp.prog.Predeclared = append(pcomp.prog.Predeclared, id.Name)
+		}
 		fcomp.emit1(PREDECLARED, fcomp.pcomp.nameIndex(id.Name))
 	case resolve.Universal:
 		fcomp.emit1(UNIVERSAL, fcomp.pcomp.nameIndex(id.Name))
@@ -1034,10 +1156,18 @@ func (fcomp *fcomp) lookup(id *syntax.Ident) {
 func (fcomp *fcomp) stmts(stmts []syntax.Stmt) {
 	for _, stmt := range stmts {
 		fcomp.stmt(stmt)
+		if resolve.Terminates(stmt) {
+			break // the remaining statements are unreachable
+		}
 	}
 }
 
 func (fcomp *fcomp) stmt(stmt syntax.Stmt) {
+	// Record the start of each statement so that
+	// every line containing code appears in the line table.
+	start, _ := stmt.Span()
+	fcomp.setPos(start)
+
 	switch stmt := stmt.(type) {
 	case *syntax.ExprStmt:
 		if _, ok := stmt.X.(*syntax.Literal); ok {
@@ -1161,6 +1291,13 @@ func (fcomp *fcomp) stmt(stmt syntax.Stmt) {
 		body := fcomp.newBlock()
 		tail := fcomp.newBlock()
 
+		// With an else clause, break skips it,
+		// but must still pop the iterator.
+		brk := tail
+		if len(stmt.Else) > 0 {
+			brk = fcomp.newBlock()
+		}
+
 		fcomp.expr(stmt.X)
 		fcomp.setPos(stmt.For)
 		fcomp.emit(ITERPUSH)
@@ -1171,7 +1308,7 @@ func (fcomp *fcomp) stmt(stmt syntax.Stmt) {
 
 		fcomp.block = body
 		fcomp.assign(stmt.For, stmt.Vars)
-		fcomp.loops = append(fcomp.loops, loop{break_: tail, continue_: head})
+		fcomp.loops = append(fcomp.loops, loop{break_: brk, continue_: head})
 		fcomp.stmts(stmt.Body)
 		fcomp.loops = fcomp.loops[:len(fcomp.loops)-1]
 		fcomp.jump(head)
@@ -1179,14 +1316,33 @@ func (fcomp *fcomp) stmt(stmt syntax.Stmt) {
 		fcomp.block = tail
 		fcomp.emit(ITERPOP)
 
+		if brk != tail {
+			fcomp.stmts(stmt.Else)
+			done := fcomp.newBlock()
+			fcomp.jump(done)
+
+			fcomp.block = brk
+			fcomp.emit(ITERPOP)
+			fcomp.jump(done)
+
+			fcomp.block = done
+		}
+
 	case *syntax.WhileStmt:
 		head := fcomp.newBlock()
 		body := fcomp.newBlock()
 		done := fcomp.newBlock()
 
+		// The else clause, if any, runs when the condition
+		// becomes false, but not after break.
+		els := done
+		if len(stmt.Else) > 0 {
+			els = fcomp.newBlock()
+		}
+
 		fcomp.jump(head)
 		fcomp.block = head
-		fcomp.ifelse(stmt.Cond, body, done)
+		fcomp.ifelse(stmt.Cond, body, els)
 
 		fcomp.block = body
 		fcomp.loops = append(fcomp.loops, loop{break_: done, continue_: head})
@@ -1194,6 +1350,12 @@ func (fcomp *fcomp) stmt(stmt syntax.Stmt) {
 		fcomp.loops = fcomp.loops[:len(fcomp.loops)-1]
 		fcomp.jump(head)
 
+		if els != done {
+			fcomp.block = els
+			fcomp.stmts(stmt.Else)
+			fcomp.jump(done)
+		}
+
 		fcomp.block = done
 
 	case *syntax.ReturnStmt:
@@ -1205,6 +1367,11 @@ func (fcomp *fcomp) stmt(stmt syntax.Stmt) {
 		fcomp.emit(RETURN)
 		fcomp.block = fcomp.newBlock() // dead code
 
+	case *syntax.DelStmt:
+		for _, target := range stmt.Targets {
+			fcomp.del(target)
+		}
+
 	case *syntax.LoadStmt:
 		for i := range stmt.From {
 			fcomp.string(stmt.From[i].Name)
@@ -1263,6 +1430,46 @@ func (fcomp *fcomp) assign(pos syntax.Position, lhs syntax.Expr) {
 	}
 }
 
+// del emits code to delete the variable, element, or field
+// denoted by a target of a del statement.
+func (fcomp *fcomp) del(target syntax.Expr) {
+	switch target := target.(type) {
+	case *syntax.ParenExpr:
+		// del (x)
+		fcomp.del(target.X)
+
+	case *syntax.Ident:
+		// del x
+		bind := target.Binding.(*resolve.Binding)
+		fcomp.setPos(target.NamePos)
+		switch bind.Scope {
+		case resolve.Local, resolve.Cell:
+			// The interpreter rejects deletion of a cell.
+			fcomp.emit1(DELLOCAL, uint32(bind.Index))
+		case resolve.Global:
+			fcomp.emit1(DELGLOBAL, uint32(bind.Index))
+		default:
+			log.Panicf("%s: del(%s): not global/local/cell (%d)", target.NamePos, target.Name, bind.Scope)
+		}
+
+	case *syntax.IndexExpr:
+		// del x[y]
+		fcomp.expr(target.X)
+		fcomp.expr(target.Y)
+		fcomp.setPos(target.Lbrack)
+		fcomp.emit(DELINDEX)
+
+	case *syntax.DotExpr:
+		// del x.f
+		fcomp.expr(target.X)
+		fcomp.setPos(target.Dot)
+		fcomp.emit1(DELFIELD, fcomp.pcomp.nameIndex(target.Name.Name))
+
+	default:
+		panic(target)
+	}
+}
+
 func (fcomp *fcomp) assignSequence(pos syntax.Position, lhs []syntax.Expr) {
 	fcomp.setPos(pos)
 	fcomp.emit1(UNPACK, uint32(len(lhs)))
@@ -1362,6 +1569,10 @@ func (fcomp *fcomp) expr(e syntax.Expr) {
 		}
 
 	case *syntax.UnaryExpr:
+		if v, ok := constant(e); ok {
+			fcomp.folded(v)
+			break
+		}
 		fcomp.expr(e.X)
 		fcomp.setPos(e.OpPos)
 		switch e.Op {
@@ -1378,11 +1589,24 @@ func (fcomp *fcomp) expr(e syntax.Expr) {
 		}
 
 	case *syntax.BinaryExpr:
+		if v, ok := constant(e); ok {
+			fcomp.folded(v)
+			break
+		}
 		switch e.Op {
 		// short-circuit operators
 		// TODO(adonovan): use ifelse to simplify conditions.
 		case syntax.OR:
 			// x or y  =>  if x then x else y
+			if x, ok := constant(e.X); ok {
+				// The operand x is constant but y is not.
+				if truth(x) {
+					fcomp.folded(x)
+				} else {
+					fcomp.expr(e.Y)
+				}
+				break
+			}
 			done := fcomp.newBlock()
 			y := fcomp.newBlock()
 
@@ -1399,6 +1623,15 @@ func (fcomp *fcomp) expr(e syntax.Expr) {
 
 		case syntax.AND:
 			// x and y  =>  if x then y else x
+			if x, ok := constant(e.X); ok {
+				// The operand x is constant but y is not.
+				if truth(x) {
+					fcomp.expr(e.Y)
+				} else {
+					fcomp.folded(x)
+				}
+				break
+			}
 			done := fcomp.newBlock()
 			y := fcomp.newBlock()
 
@@ -1529,7 +1762,6 @@ func (fcomp *fcomp) plus(e *syntax.BinaryExpr) {
 func addable(e syntax.Expr) rune {
 	switch e := e.(type) {
 	case *syntax.Literal:
-		// TODO(adonovan): opt: support INT/FLOAT/BIGINT constant folding.
 		switch e.Token {
 		case syntax.STRING:
 			return 's'
@@ -1850,6 +2082,18 @@ func (fcomp *fcomp) function(f *resolve.Function) {
 // ifelse emits a Boolean control flow decision.
 // On return, the current block is unset.
 func (fcomp *fcomp) ifelse(cond syntax.Expr, t, f *block) {
+	// A constant condition selects one branch;
+	// the other is unreachable and thus not generated,
+	// unless it must appear in a coverage report.
+	if v, ok := constant(cond); ok && !fcomp.pcomp.coverage {
+		if truth(v) {
+			fcomp.jump(t)
+		} else {
+			fcomp.jump(f)
+		}
+		return
+	}
+
 	switch cond := cond.(type) {
 	case *syntax.UnaryExpr:
 		if cond.Op == syntax.NOT {
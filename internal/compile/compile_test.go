@@ -2,8 +2,12 @@ package compile_test
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
 	"strings"
 	"testing"
+	"unsafe"
 
 	"github.com/andrewchambers/pkgscript/pkgscript"
 )
@@ -72,3 +76,122 @@ func TestGarbage(t *testing.T) {
 		t.Fatalf("CompiledProgram reported the wrong error when decoding garbage: %v", err)
 	}
 }
+
+// TestVersionMismatch verifies that a program compiled by a different
+// version of the compiler is rejected with a specific error.
+func TestVersionMismatch(t *testing.T) {
+	_, prog, err := pkgscript.SourceProgram("x.star", "x = 1", func(string) bool { return false })
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := prog.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// The version follows the magic number and the string offset.
+	if v, n := binary.Varint(data[8:]); v != pkgscript.CompilerVersion || n != 1 {
+		t.Fatalf("encoded version = %d (%d bytes), want %d (1 byte)", v, n, pkgscript.CompilerVersion)
+	}
+	binary.PutVarint(data[8:9], pkgscript.CompilerVersion+1)
+
+	_, err = pkgscript.CompiledProgram(bytes.NewReader(data))
+	want := fmt.Sprintf("compiled with pkgscript bytecode v%d, this binary supports v%d",
+		pkgscript.CompilerVersion+1, pkgscript.CompilerVersion)
+	if err == nil {
+		t.Fatalf("CompiledProgram accepted a program of the wrong version")
+	} else if err.Error() != want {
+		t.Fatalf("CompiledProgram reported %q, want %q", err, want)
+	}
+
+	// Truncated headers are rejected without panicking.
+	for _, n := range []int{4, 7, 8} {
+		_, err = pkgscript.CompiledProgram(bytes.NewReader(data[:n]))
+		if err == nil || !strings.Contains(err.Error(), "not a compiled module") {
+			t.Errorf("CompiledProgram of %d-byte header: got %v, want not a compiled module", n, err)
+		}
+	}
+}
+
+// TestPredeclared verifies that a compiled program records the
+// predeclared names it requires, and that Init reports a missing one.
+func TestPredeclared(t *testing.T) {
+	predeclared := pkgscript.StringDict{
+		"x":      pkgscript.MakeInt(1),
+		"y":      pkgscript.MakeInt(2),
+		"unused": pkgscript.None,
+	}
+	const src = `
+def f():
+    return y
+
+z = x + f() + len([])
+`
+	_, prog, err := pkgscript.SourceProgram("pre.star", src, predeclared.Has)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := prog.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	prog, err = pkgscript.CompiledProgram(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(prog.Predeclared()), "[x y]"; got != want {
+		t.Errorf("Predeclared() = %s, want %s", got, want)
+	}
+
+	delete(predeclared, "x")
+	_, err = prog.Init(new(pkgscript.Thread), predeclared)
+	if err == nil {
+		t.Fatal("Init succeeded with missing predeclared name")
+	} else if got, want := err.Error(), "pre.star: undefined: x"; got != want {
+		t.Errorf("Init reported %q, want %q", got, want)
+	}
+}
+
+func TestInternStrings(t *testing.T) {
+	defer pkgscript.InternStrings(false)
+
+	// data returns the address of the storage of the string constant of prog.
+	data := func(prog *pkgscript.Program) uintptr {
+		globals, err := prog.Init(new(pkgscript.Thread), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := string(globals["x"].(pkgscript.String))
+		return (*reflect.StringHeader)(unsafe.Pointer(&s)).Data
+	}
+	compile := func(src string) *pkgscript.Program {
+		_, prog, err := pkgscript.SourceProgram("x.star", src, pkgscript.StringDict(nil).Has)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return prog
+	}
+	const src = `x = "a shared literal"`
+
+	if data(compile(src)) == data(compile(src)) {
+		t.Errorf("string constants of distinct programs share storage without interning")
+	}
+
+	pkgscript.InternStrings(true)
+	p, q := compile(src), compile("y = 1; x = 'a shared literal'")
+	if data(p) != data(q) {
+		t.Errorf("interned string constants do not share storage")
+	}
+	buf := new(bytes.Buffer)
+	if err := p.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	r, err := pkgscript.CompiledProgram(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data(r) != data(p) {
+		t.Errorf("interned string constants of a decoded program do not share storage")
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compile
+
+// This file defines constant folding, the evaluation at compile time
+// of expressions whose operands are all literals.
+//
+// An expression is folded only if its evaluation cannot fail,
+// so that errors such as 1//0 are still reported at run time,
+// with a backtrace. The folded value of an expression is an
+// int (*big.Int), float64, string, or bool.
+
+import (
+	"math"
+	"math/big"
+
+	"github.com/andrewchambers/pkgscript/resolve"
+	"github.com/andrewchambers/pkgscript/syntax"
+)
+
+// constant returns the value of e if it is a constant expression.
+func constant(e syntax.Expr) (interface{}, bool) {
+	switch e := e.(type) {
+	case *syntax.ParenExpr:
+		return constant(e.X)
+
+	case *syntax.Literal:
+		switch x := e.Value.(type) {
+		case int64:
+			return big.NewInt(x), true
+		case *big.Int:
+			return x, true
+		case float64, string:
+			return x, true
+		}
+
+	case *syntax.Ident:
+		// True and False are constants unless shadowed.
+		if bind, ok := e.Binding.(*resolve.Binding); ok && bind.Scope == resolve.Universal {
+			switch e.Name {
+			case "True":
+				return true, true
+			case "False":
+				return false, true
+			}
+		}
+
+	case *syntax.UnaryExpr:
+		x, ok := constant(e.X)
+		if !ok {
+			return nil, false
+		}
+		if e.Op == syntax.NOT {
+			return !truth(x), true
+		}
+		switch x := x.(type) {
+		case *big.Int:
+			switch e.Op {
+			case syntax.PLUS:
+				return x, true
+			case syntax.MINUS:
+				return new(big.Int).Neg(x), true
+			case syntax.TILDE:
+				return new(big.Int).Not(x), true
+			}
+		case float64:
+			switch e.Op {
+			case syntax.PLUS:
+				return x, true
+			case syntax.MINUS:
+				return checkFloat(-x)
+			}
+		}
+
+	case *syntax.BinaryExpr:
+		x, ok := constant(e.X)
+		if !ok {
+			return nil, false
+		}
+		switch e.Op {
+		case syntax.AND:
+			if !truth(x) {
+				return x, true
+			}
+			return constant(e.Y)
+		case syntax.OR:
+			if truth(x) {
+				return x, true
+			}
+			return constant(e.Y)
+		}
+		y, ok := constant(e.Y)
+		if !ok {
+			return nil, false
+		}
+		return binaryConstant(e.Op, x, y)
+	}
+	return nil, false
+}
+
+// binaryConstant returns the value of the constant expression x op y,
+// unless its evaluation might fail.
+func binaryConstant(op syntax.Token, x, y interface{}) (interface{}, bool) {
+	switch x := x.(type) {
+	// Operations that mix int and float are left to the interpreter,
+	// since converting the int operand to float64 may lose precision.
+	case *big.Int:
+		if y, ok := y.(*big.Int); ok {
+			return intBinary(op, x, y)
+		}
+
+	case float64:
+		if y, ok := y.(float64); ok {
+			return floatBinary(op, x, y)
+		}
+
+	case string:
+		if y, ok := y.(string); ok {
+			switch op {
+			case syntax.PLUS:
+				return x + y, true
+			case syntax.EQL, syntax.NEQ, syntax.LT, syntax.LE, syntax.GT, syntax.GE:
+				cmp := 0
+				if x < y {
+					cmp = -1
+				} else if x > y {
+					cmp = +1
+				}
+				return compare(op, cmp), true
+			}
+		}
+
+	case bool:
+		if y, ok := y.(bool); ok {
+			switch op {
+			case syntax.EQL:
+				return x == y, true
+			case syntax.NEQ:
+				return x != y, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// intBinary returns the value of the constant expression x op y.
+// Division and modulo by zero and invalid shifts are not folded.
+func intBinary(op syntax.Token, x, y *big.Int) (interface{}, bool) {
+	z := new(big.Int)
+	switch op {
+	case syntax.PLUS:
+		return z.Add(x, y), true
+	case syntax.MINUS:
+		return z.Sub(x, y), true
+	case syntax.STAR:
+		return z.Mul(x, y), true
+	case syntax.SLASHSLASH, syntax.PERCENT:
+		if y.Sign() == 0 {
+			return nil, false
+		}
+		// Starlark division rounds toward negative infinity.
+		quo, rem := z.QuoRem(x, y, new(big.Int))
+		if rem.Sign() != 0 && rem.Sign() != y.Sign() {
+			quo.Sub(quo, big.NewInt(1))
+			rem.Add(rem, y)
+		}
+		if op == syntax.SLASHSLASH {
+			return quo, true
+		}
+		return rem, true
+	case syntax.AMP:
+		return z.And(x, y), true
+	case syntax.PIPE:
+		return z.Or(x, y), true
+	case syntax.CIRCUMFLEX:
+		return z.Xor(x, y), true
+	case syntax.LTLT:
+		if y.Sign() < 0 || y.Cmp(big.NewInt(512)) >= 0 {
+			return nil, false
+		}
+		return z.Lsh(x, uint(y.Int64())), true
+	case syntax.GTGT:
+		if y.Sign() < 0 || y.Cmp(big.NewInt(512)) >= 0 {
+			return nil, false
+		}
+		return z.Rsh(x, uint(y.Int64())), true
+	case syntax.EQL, syntax.NEQ, syntax.LT, syntax.LE, syntax.GT, syntax.GE:
+		return compare(op, x.Cmp(y)), true
+	}
+	return nil, false
+}
+
+// floatBinary returns the value of the constant expression x op y.
+// Division, and comparisons involving NaN, are not folded.
+func floatBinary(op syntax.Token, x, y float64) (interface{}, bool) {
+	switch op {
+	case syntax.PLUS:
+		return checkFloat(x + y)
+	case syntax.MINUS:
+		return checkFloat(x - y)
+	case syntax.STAR:
+		return checkFloat(x * y)
+	case syntax.EQL, syntax.NEQ, syntax.LT, syntax.LE, syntax.GT, syntax.GE:
+		if math.IsNaN(x) || math.IsNaN(y) {
+			return nil, false
+		}
+		cmp := 0
+		if x < y {
+			cmp = -1
+		} else if x > y {
+			cmp = +1
+		}
+		return compare(op, cmp), true
+	}
+	return nil, false
+}
+
+// checkFloat returns x unless it is negative zero, which is not
+// folded because the constant pool does not distinguish it from zero.
+func checkFloat(x float64) (interface{}, bool) {
+	if x == 0 && math.Signbit(x) {
+		return nil, false
+	}
+	return x, true
+}
+
+// compare returns the result of a comparison whose operands compare as cmp.
+func compare(op syntax.Token, cmp int) bool {
+	switch op {
+	case syntax.EQL:
+		return cmp == 0
+	case syntax.NEQ:
+		return cmp != 0
+	case syntax.LT:
+		return cmp < 0
+	case syntax.LE:
+		return cmp <= 0
+	case syntax.GT:
+		return cmp > 0
+	case syntax.GE:
+		return cmp >= 0
+	}
+	panic(op)
+}
+
+// truth returns the truth value of a constant.
+func truth(x interface{}) bool {
+	switch x := x.(type) {
+	case *big.Int:
+		return x.Sign() != 0
+	case float64:
+		return x != 0
+	case string:
+		return x != ""
+	case bool:
+		return x
+	}
+	panic(x)
+}
+
+// folded emits code to push the value of a constant expression.
+func (fcomp *fcomp) folded(v interface{}) {
+	switch v := v.(type) {
+	case *big.Int:
+		if v.IsInt64() {
+			fcomp.emit1(CONSTANT, fcomp.pcomp.constantIndex(v.Int64()))
+		} else {
+			fcomp.emit1(CONSTANT, fcomp.pcomp.constantIndex(v))
+		}
+	case bool:
+		name := "False"
+		if v {
+			name = "True"
+		}
+		fcomp.emit1(UNIVERSAL, fcomp.pcomp.nameIndex(name))
+	default: // float64, string
+		fcomp.emit1(CONSTANT, fcomp.pcomp.constantIndex(v))
+	}
+}
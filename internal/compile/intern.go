@@ -0,0 +1,69 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compile
+
+// This file defines the intern table for string constants.
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// maxInternBytes bounds the total size of the strings in the intern
+// table. When adding a string would exceed it, the table is discarded
+// and a new one started. Constants interned earlier remain valid, but
+// later constants no longer share their storage.
+const maxInternBytes = 16 << 20
+
+var (
+	internEnabled int32 // atomic; nonzero => intern string constants
+
+	internMu    sync.Mutex
+	internTable map[string]string
+	internBytes int // total length of the strings in internTable
+)
+
+// InternStrings enables or disables the interning of string constants.
+//
+// When enabled, each string constant of a program compiled or
+// decoded thereafter shares its storage with every equal constant
+// of every other such program, which reduces the memory used by an
+// application that loads many programs with literals in common.
+// The intern table holds at most maxInternBytes of strings, and
+// disabling interning discards it.
+func InternStrings(enable bool) {
+	var x int32
+	if enable {
+		x = 1
+	}
+	atomic.StoreInt32(&internEnabled, x)
+	if !enable {
+		internMu.Lock()
+		internTable, internBytes = nil, 0
+		internMu.Unlock()
+	}
+}
+
+// intern returns the canonical copy of s if interning is enabled,
+// and s otherwise.
+func intern(s string) string {
+	if atomic.LoadInt32(&internEnabled) == 0 || len(s) > maxInternBytes {
+		return s
+	}
+	internMu.Lock()
+	defer internMu.Unlock()
+	if canon, ok := internTable[s]; ok {
+		return canon
+	}
+	if internTable == nil || internBytes+len(s) > maxInternBytes {
+		internTable, internBytes = make(map[string]string), 0
+	}
+	// Copy s, which may refer to a larger string or buffer,
+	// such as the source text or the encoded program.
+	canon := string([]byte(s))
+	internTable[canon] = canon
+	internBytes += len(canon)
+	return canon
+}
@@ -0,0 +1,40 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compile
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInternBound(t *testing.T) {
+	InternStrings(true)
+	defer InternStrings(false)
+
+	big := strings.Repeat("x", maxInternBytes/2+1)
+	if intern(big) != big {
+		t.Fatal("intern changed the value of a string")
+	}
+	if internBytes != len(big) {
+		t.Errorf("internBytes = %d, want %d", internBytes, len(big))
+	}
+	// A second large string does not fit, so the table is restarted.
+	big2 := strings.Repeat("y", maxInternBytes/2+1)
+	intern(big2)
+	if len(internTable) != 1 || internBytes != len(big2) {
+		t.Errorf("after overflow, table has %d strings of %d bytes, want 1 of %d", len(internTable), internBytes, len(big2))
+	}
+	// Strings larger than the bound are not interned.
+	huge := strings.Repeat("z", maxInternBytes+1)
+	intern(huge)
+	if _, ok := internTable[huge]; ok {
+		t.Errorf("string larger than maxInternBytes was interned")
+	}
+
+	InternStrings(false)
+	if internTable != nil || internBytes != 0 {
+		t.Errorf("disabling interning did not discard the table")
+	}
+}
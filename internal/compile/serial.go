@@ -10,14 +10,18 @@ package compile
 // Encoding
 //
 // Program:
-//	"sky!"		[4]byte		# magic number
+//	"!sky"		[4]byte		# magic number
 //	str		uint32le	# offset of <strings> section
 //	version		varint		# must match Version
 //	filename	string
+//	recursion	varint (0 or 1)
+//	float		varint (0 or 1)
 //	numloads	varint
 //	loads		[]Ident
 //	numnames	varint
 //	names		[]string
+//	numpredeclared	varint
+//	predeclared	[]string
 //	numconsts	varint
 //	consts		[]Constant
 //	numglobals	varint
@@ -98,10 +102,16 @@ func (prog *Program) Encode() []byte {
 	e.p = append(e.p, "????"...) // string data offset; filled in later
 	e.int(Version)
 	e.string(prog.Toplevel.Pos.Filename())
+	e.int(b2i(prog.Recursion))
+	e.int(b2i(prog.Float))
 	e.int(len(prog.Names))
 	for _, name := range prog.Names {
 		e.string(name)
 	}
+	e.int(len(prog.Predeclared))
+	for _, name := range prog.Predeclared {
+		e.string(name)
+	}
 	e.int(len(prog.Constants))
 	for _, c := range prog.Constants {
 		switch c := c.(type) {
@@ -220,31 +230,53 @@ func DecodeProgram(data []byte) (_ *Program, err error) {
 		}
 	}()
 
+	if len(data) < 8 {
+		return nil, fmt.Errorf("not a compiled module: truncated header")
+	}
+
+	// Check the version before anything whose layout may vary by version.
+	v, n := binary.Varint(data[8:])
+	if n <= 0 {
+		return nil, fmt.Errorf("not a compiled module: no version number")
+	}
+	if v != Version {
+		return nil, fmt.Errorf("compiled with pkgscript bytecode v%d, this binary supports v%d", v, Version)
+	}
+
 	offset := binary.LittleEndian.Uint32(data[4:8])
+	if offset < uint32(8+n) || offset > uint32(len(data)) {
+		return nil, fmt.Errorf("not a compiled module: invalid string offset %d", offset)
+	}
 	d := decoder{
-		p: data[8:offset],
+		p: data[8+n : offset],
 		s: append([]byte(nil), data[offset:]...), // allocate a copy, which will persist
 	}
 
-	if v := d.int(); v != Version {
-		return nil, fmt.Errorf("version mismatch: read %d, want %d", v, Version)
-	}
-
 	filename := d.string()
 	d.filename = &filename
+	recursion := d.bool()
+	float := d.bool()
 
 	names := make([]string, d.int())
 	for i := range names {
 		names[i] = d.string()
 	}
 
+	var predeclared []string
+	if n := d.int(); n > 0 {
+		predeclared = make([]string, n)
+		for i := range predeclared {
+			predeclared[i] = d.string()
+		}
+	}
+
 	// constants
 	constants := make([]interface{}, d.int())
 	for i := range constants {
 		var c interface{}
 		switch d.int() {
 		case 0:
-			c = d.string()
+			c = intern(d.string())
 		case 1:
 			c = d.int64()
 		case 2:
@@ -263,11 +295,14 @@ func DecodeProgram(data []byte) (_ *Program, err error) {
 	}
 
 	prog := &Program{
-		Names:     names,
-		Constants: constants,
-		Globals:   globals,
-		Functions: funcs,
-		Toplevel:  toplevel,
+		Names:       names,
+		Predeclared: predeclared,
+		Constants:   constants,
+		Globals:     globals,
+		Functions:   funcs,
+		Toplevel:    toplevel,
+		Recursion:   recursion,
+		Float:       float,
 	}
 	toplevel.Prog = prog
 	for _, f := range funcs {
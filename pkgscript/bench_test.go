@@ -6,8 +6,10 @@ package pkgscript_test
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 
@@ -108,3 +110,42 @@ func BenchmarkProgram(b *testing.B) {
 		}
 	})
 }
+
+// BenchmarkInternStrings measures the memory retained by 1000
+// compiled programs whose string literals are mostly the same,
+// with and without interning of string constants.
+func BenchmarkInternStrings(b *testing.B) {
+	defer pkgscript.InternStrings(false)
+
+	var src strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&src, "x%d = %q\n", i, strings.Repeat("literal", 20)+fmt.Sprint(i))
+	}
+
+	for _, intern := range []bool{false, true} {
+		b.Run(fmt.Sprintf("intern=%t", intern), func(b *testing.B) {
+			pkgscript.InternStrings(intern)
+			var before, after runtime.MemStats
+			var retained int64
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+				progs := make([]*pkgscript.Program, 1000)
+				for j := range progs {
+					var err error
+					_, progs[j], err = pkgscript.SourceProgram("lit.star", src.String(), pkgscript.StringDict(nil).Has)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				runtime.KeepAlive(progs)
+				// HeapAlloc may shrink between the readings, so
+				// take the difference as a signed quantity.
+				retained += int64(after.HeapAlloc) - int64(before.HeapAlloc)
+			}
+			b.ReportMetric(float64(retained)/float64(b.N), "retained-bytes/op")
+		})
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkgscript
+
+// This file defines line-level coverage instrumentation.
+//
+// While coverage is enabled, each call of a Starlark function obtains
+// a table of counters, one per byte of the function's code, and the
+// interpreter increments the counter of each instruction it executes.
+// The table is created the first time any function of a program is
+// called, at which point tables are created for all the program's
+// functions, so that the lines of functions that are never called
+// are reported as uncovered.
+//
+// When coverage is stopped, the instruction counts are converted to
+// line counts using the position table of each function. The count
+// of a line is the largest count of any instruction on that line.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/andrewchambers/pkgscript/internal/compile"
+)
+
+// StartCoverage enables coverage instrumentation of all Starlark
+// threads. It must be followed by a call to StopCoverage to stop
+// recording and obtain the result.
+//
+// Execution counts are aggregated across all calls to Starlark
+// functions, including the initialization of files by ExecFile and
+// Program.Init, while coverage is enabled.
+//
+// Programs compiled while coverage is enabled retain code that a
+// constant condition makes unreachable, such as the body of
+// "if False:", so that its lines are reported as not executed.
+// Programs compiled earlier omit such code, and its lines.
+//
+// StartCoverage returns an error if coverage was already enabled.
+func StartCoverage() error {
+	coverage.mu.Lock()
+	defer coverage.mu.Unlock()
+	if coverage.on {
+		return fmt.Errorf("coverage already enabled")
+	}
+	coverage.on = true
+	compile.SetCoverage(true)
+	coverage.state.Store(&coverageState{
+		counters: make(map[*compile.Funcode][]uint32),
+		programs: make(map[*compile.Program]bool),
+	})
+	return nil
+}
+
+// StopCoverage stops the recording of coverage started by a prior call
+// to StartCoverage and returns the line counts. It returns nil if
+// coverage was not enabled.
+//
+// Calls that are in progress when StopCoverage is called may
+// continue to update counters that are no longer reported.
+func StopCoverage() *Coverage {
+	coverage.mu.Lock()
+	defer coverage.mu.Unlock()
+	if !coverage.on {
+		return nil
+	}
+	cov := coverage.state.Load().(*coverageState)
+	coverage.on = false
+	compile.SetCoverage(false)
+	coverage.state.Store((*coverageState)(nil))
+	return cov.result()
+}
+
+// globals
+var coverage struct {
+	mu    sync.Mutex   // serializes StartCoverage and StopCoverage
+	on    bool         // coverage enabled
+	state atomic.Value // *coverageState, nil when disabled
+}
+
+type coverageState struct {
+	mu       sync.Mutex
+	counters map[*compile.Funcode][]uint32 // counts by pc of instruction
+	programs map[*compile.Program]bool     // programs whose counters are created
+}
+
+// coverageCounters returns the instruction counters for f,
+// or nil if coverage is not enabled.
+func coverageCounters(f *compile.Funcode) []uint32 {
+	cov, _ := coverage.state.Load().(*coverageState)
+	if cov == nil {
+		return nil
+	}
+	cov.mu.Lock()
+	defer cov.mu.Unlock()
+	if !cov.programs[f.Prog] {
+		cov.programs[f.Prog] = true
+		cov.counters[f.Prog.Toplevel] = make([]uint32, len(f.Prog.Toplevel.Code))
+		for _, fn := range f.Prog.Functions {
+			cov.counters[fn] = make([]uint32, len(fn.Code))
+		}
+	}
+	counters := cov.counters[f]
+	if counters == nil { // not part of its Program (e.g. from Eval)
+		counters = make([]uint32, len(f.Code))
+		cov.counters[f] = counters
+	}
+	return counters
+}
+
+// result computes line counts from the instruction counters.
+func (cov *coverageState) result() *Coverage {
+	cov.mu.Lock()
+	defer cov.mu.Unlock()
+	res := &Coverage{files: make(map[string]map[int]uint64)}
+	for fn, counters := range cov.counters {
+		filename := fn.Pos.Filename()
+		lines := res.files[filename]
+		if lines == nil {
+			lines = make(map[int]uint64)
+			res.files[filename] = lines
+		}
+		for _, line := range fn.Lines() {
+			if line > 0 {
+				if _, ok := lines[int(line)]; !ok {
+					lines[int(line)] = 0
+				}
+			}
+		}
+		for pc := range counters {
+			n := uint64(atomic.LoadUint32(&counters[pc]))
+			if n == 0 {
+				continue
+			}
+			line := int(fn.Position(uint32(pc)).Line)
+			if line > 0 && n > lines[line] {
+				lines[line] = n
+			}
+		}
+	}
+	return res
+}
+
+// A Coverage records the number of times each line of source code
+// was executed while coverage was enabled. Only lines that contain
+// code are recorded.
+type Coverage struct {
+	files map[string]map[int]uint64 // execution count by line, by filename
+}
+
+// Files returns the names of the files for which coverage was
+// recorded, in sorted order.
+func (c *Coverage) Files() []string {
+	files := make([]string, 0, len(c.files))
+	for filename := range c.files {
+		files = append(files, filename)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Lines returns the execution counts of the lines of the named file
+// that contain code. A count of zero means the line was not executed.
+func (c *Coverage) Lines(filename string) map[int]uint64 {
+	lines := make(map[int]uint64, len(c.files[filename]))
+	for line, n := range c.files[filename] {
+		lines[line] = n
+	}
+	return lines
+}
+
+// Covered returns the number of lines that were executed and the
+// number of lines that contain code, summed over all files.
+func (c *Coverage) Covered() (covered, total int) {
+	for _, lines := range c.files {
+		for _, n := range lines {
+			if n > 0 {
+				covered++
+			}
+			total++
+		}
+	}
+	return covered, total
+}
+
+// WriteLCOV writes the coverage to w in the LCOV tracefile format
+// read by genhtml and most CI coverage services.
+func (c *Coverage) WriteLCOV(w io.Writer) error {
+	bufw := bufio.NewWriter(w)
+	fmt.Fprintf(bufw, "TN:\n")
+	for _, filename := range c.Files() {
+		lines := c.files[filename]
+		sorted := make([]int, 0, len(lines))
+		for line := range lines {
+			sorted = append(sorted, line)
+		}
+		sort.Ints(sorted)
+
+		fmt.Fprintf(bufw, "SF:%s\n", filename)
+		hit := 0
+		for _, line := range sorted {
+			if lines[line] > 0 {
+				hit++
+			}
+			fmt.Fprintf(bufw, "DA:%d,%d\n", line, lines[line])
+		}
+		fmt.Fprintf(bufw, "LF:%d\nLH:%d\nend_of_record\n", len(sorted), hit)
+	}
+	return bufw.Flush()
+}
@@ -0,0 +1,131 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkgscript_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/andrewchambers/pkgscript/pkgscript"
+)
+
+func TestCoverage(t *testing.T) {
+	const src = `
+def check(x):
+    if x > 0:
+        return "positive"
+    else:
+        return "non-positive"
+
+def unused():
+    return 1
+
+check(1)
+`
+	if err := pkgscript.StartCoverage(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pkgscript.StartCoverage(); err == nil {
+		t.Error("second StartCoverage succeeded")
+	}
+	thread := new(pkgscript.Thread)
+	globals, err := pkgscript.ExecFile(thread, "policy.star", src, nil)
+	if err != nil {
+		pkgscript.StopCoverage()
+		t.Fatal(err)
+	}
+	// Counts are aggregated across calls.
+	for i := 0; i < 2; i++ {
+		if _, err := pkgscript.Call(thread, globals["check"], pkgscript.Tuple{pkgscript.MakeInt(5)}, nil); err != nil {
+			pkgscript.StopCoverage()
+			t.Fatal(err)
+		}
+	}
+	cov := pkgscript.StopCoverage()
+	if cov == nil {
+		t.Fatal("StopCoverage returned nil")
+	}
+	if pkgscript.StopCoverage() != nil {
+		t.Error("second StopCoverage returned non-nil")
+	}
+
+	var buf bytes.Buffer
+	if err := cov.WriteLCOV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// Line 6, in the untaken else branch, and line 9,
+	// in the uncalled function, are not covered.
+	const want = `TN:
+SF:policy.star
+DA:2,1
+DA:3,3
+DA:4,3
+DA:6,0
+DA:8,1
+DA:9,0
+DA:11,1
+LF:7
+LH:5
+end_of_record
+`
+	if got := buf.String(); got != want {
+		t.Errorf("WriteLCOV wrote:\n%s\nwant:\n%s", got, want)
+	}
+	if covered, total := cov.Covered(); covered != 5 || total != 7 {
+		t.Errorf("Covered() = %d, %d, want 5, 7", covered, total)
+	}
+	if lines := cov.Lines("policy.star"); lines[4] != 3 || lines[6] != 0 {
+		t.Errorf("Lines() = %v", lines)
+	}
+
+	// Execution after StopCoverage is not recorded.
+	if _, err := pkgscript.ExecFile(thread, "other.star", "x = 1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := cov.Files(); len(got) != 1 || got[0] != "policy.star" {
+		t.Errorf("Files() = %v", got)
+	}
+}
+
+// The body of an if statement whose condition is constant is
+// reported as not executed, even though the compiler would
+// otherwise prune it.
+func TestCoverageConstantCondition(t *testing.T) {
+	const src = `
+def f():
+    if False:
+        return 1
+    return 2
+
+f()
+`
+	if err := pkgscript.StartCoverage(); err != nil {
+		t.Fatal(err)
+	}
+	_, err := pkgscript.ExecFile(new(pkgscript.Thread), "const.star", src, nil)
+	cov := pkgscript.StopCoverage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := cov.WriteLCOV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	const want = `TN:
+SF:const.star
+DA:2,1
+DA:3,1
+DA:4,0
+DA:5,1
+DA:7,1
+LF:5
+LH:4
+end_of_record
+`
+	if got := buf.String(); got != want {
+		t.Errorf("WriteLCOV wrote:\n%s\nwant:\n%s", got, want)
+	}
+}
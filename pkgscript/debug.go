@@ -1,6 +1,11 @@
 package pkgscript
 
-import "github.com/andrewchambers/pkgscript/syntax"
+import (
+	"fmt"
+
+	"github.com/andrewchambers/pkgscript/internal/compile"
+	"github.com/andrewchambers/pkgscript/syntax"
+)
 
 // This file defines an experimental API for the debugging tools.
 // Some of these declarations expose details of internal packages.
@@ -19,6 +24,105 @@ import "github.com/andrewchambers/pkgscript/syntax"
 // THIS API IS EXPERIMENTAL AND MAY CHANGE WITHOUT NOTICE.
 func (fr *frame) Local(i int) Value { return fr.locals[i] }
 
+// LocalByName returns the value of the named local variable of the
+// frame's function, including its parameters and the variables of
+// enclosing functions that it uses. It reports false if the frame's
+// function has no such variable, or if the variable is not yet assigned.
+// If several of the function's variables have the name, as may
+// happen when a comprehension rebinds it, the first assigned one is
+// returned, parameters first.
+//
+// This function is provided only for debugging tools.
+//
+// THIS API IS EXPERIMENTAL AND MAY CHANGE WITHOUT NOTICE.
+func (fr *frame) LocalByName(name string) (Value, bool) {
+	fn, ok := fr.callable.(*Function)
+	if !ok || fr.locals == nil {
+		return nil, false
+	}
+	for i, local := range fn.funcode.Locals {
+		if local.Name == name {
+			if v := fr.locals[i]; v != nil {
+				if c, ok := v.(*cell); ok {
+					v = c.v
+				}
+				if v != nil {
+					return v, true
+				}
+			}
+		}
+	}
+	for i, free := range fn.funcode.Freevars {
+		if free.Name == name {
+			if v := fn.freevars[i].(*cell).v; v != nil {
+				return v, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// SetLocal sets the value of the named local variable of the frame's
+// function, which must be paused, as in a breakpoint callback. If
+// several of the function's variables have the name, it sets the one
+// that LocalByName would return, or the first if none is assigned.
+// A variable shared with a nested function is updated in place, so
+// the nested function observes the new value. The variables of an
+// enclosing function cannot be set, as they may be shared by other
+// calls, and perhaps by other threads.
+//
+// This function is provided only for debugging tools.
+//
+// THIS API IS EXPERIMENTAL AND MAY CHANGE WITHOUT NOTICE.
+func (fr *frame) SetLocal(name string, v Value) error {
+	fn, ok := fr.callable.(*Function)
+	if !ok || fr.locals == nil {
+		return fmt.Errorf("%s has no local variables", fr.callable.Name())
+	}
+	index := -1
+	for i, local := range fn.funcode.Locals {
+		if local.Name == name {
+			if index < 0 {
+				index = i
+			}
+			if x := fr.locals[i]; x != nil {
+				if c, ok := x.(*cell); !ok || c.v != nil {
+					index = i
+					break
+				}
+			}
+		}
+	}
+	if index < 0 {
+		for _, free := range fn.funcode.Freevars {
+			if free.Name == name {
+				return fmt.Errorf("cannot set %s, a variable of a function enclosing %s", name, fn.Name())
+			}
+		}
+		return fmt.Errorf("%s has no local variable %s", fn.Name(), name)
+	}
+	if c, ok := fr.locals[index].(*cell); ok {
+		c.v = v
+	} else {
+		fr.locals[index] = v
+	}
+	return nil
+}
+
+// Globals returns a new dictionary containing the global variables
+// of the module of the frame's function, or nil if the frame is that
+// of a built-in.
+//
+// This function is provided only for debugging tools.
+//
+// THIS API IS EXPERIMENTAL AND MAY CHANGE WITHOUT NOTICE.
+func (fr *frame) Globals() StringDict {
+	if fn, ok := fr.callable.(*Function); ok {
+		return fn.Globals()
+	}
+	return nil
+}
+
 // DebugFrame is the debugger API for a frame of the interpreter's call stack.
 //
 // Most applications have no need for this API; use CallFrame instead.
@@ -28,9 +132,12 @@ func (fr *frame) Local(i int) Value { return fr.locals[i] }
 // after a breakpoint as this may have unpredictable effects, including
 // but not limited to retention of object that would otherwise be garbage.
 type DebugFrame interface {
-	Callable() Callable        // returns the frame's function
-	Local(i int) Value         // returns the value of the (Starlark) frame's ith local variable
-	Position() syntax.Position // returns the current position of execution in this frame
+	Callable() Callable                    // returns the frame's function
+	Local(i int) Value                     // returns the value of the (Starlark) frame's ith local variable
+	LocalByName(name string) (Value, bool) // returns the value of the (Starlark) frame's named local variable
+	SetLocal(name string, v Value) error   // sets the value of the (Starlark) frame's named local variable
+	Globals() StringDict                   // returns the global variables of the (Starlark) frame's module
+	Position() syntax.Position             // returns the current position of execution in this frame
 }
 
 // DebugFrame returns the debugger interface for
@@ -40,3 +147,154 @@ type DebugFrame interface {
 // This function is intended for use in debugging tools.
 // Most applications should have no need for it; use CallFrame instead.
 func (thread *Thread) DebugFrame(depth int) DebugFrame { return thread.frameAt(depth) }
+
+// SetStepHook sets a function to be called by the thread before it
+// executes the first instruction of each source line, with the frame
+// of the function being executed and the position of the line.
+// The hook is called again for a line each time execution returns to
+// it from another line of the same function, as in a loop.
+// If the hook returns an error, execution fails with that error.
+// A nil hook, the default, disables stepping.
+//
+// This function is intended for use in debugging tools.
+//
+// THIS API IS EXPERIMENTAL AND MAY CHANGE WITHOUT NOTICE.
+func (thread *Thread) SetStepHook(hook func(frame DebugFrame, pos syntax.Position) error) {
+	thread.stepHook = hook
+}
+
+// EvalInFrame evaluates an expression within the environment of the
+// specified frame of the thread's call stack, in which the assigned
+// local variables of the frame's function shadow the globals and
+// predeclared names of its module. Frame numbering is as for
+// Thread.CallFrame; the frame must be that of a Starlark function.
+// The filename and src parameters are as for Eval. The expression is
+// resolved in the dialect of the frame's file, or that of the global
+// options if the file's program was read by CompiledProgram.
+//
+// This function is intended for use in debugging tools, such as
+// to evaluate an expression while execution is paused at a breakpoint.
+//
+// THIS API IS EXPERIMENTAL AND MAY CHANGE WITHOUT NOTICE.
+func (thread *Thread) EvalInFrame(depth int, filename string, src interface{}) (Value, error) {
+	fr := thread.frameAt(depth)
+	fn, ok := fr.callable.(*Function)
+	if !ok || fr.locals == nil {
+		return nil, fmt.Errorf("cannot evaluate in the frame of %s", fr.callable.Name())
+	}
+	env := make(StringDict)
+	for k, v := range fn.module.predeclared {
+		env[k] = v
+	}
+	for k, v := range fn.Globals() {
+		env[k] = v
+	}
+	for _, bind := range fn.funcode.Freevars {
+		if v, ok := fr.LocalByName(bind.Name); ok {
+			env[bind.Name] = v
+		}
+	}
+	for _, bind := range fn.funcode.Locals {
+		if v, ok := fr.LocalByName(bind.Name); ok {
+			env[bind.Name] = v
+		}
+	}
+	return EvalOptions(fn.module.options, thread, filename, src, env)
+}
+
+// A Breakpoint identifies a source line by file name and line number.
+type Breakpoint struct {
+	Filename string
+	Line     int32
+}
+
+// SetBreakpoints sets the lines of the thread's breakpoints, and the
+// function to be called before execution reaches each of them. It is
+// called with the thread, whose frames may be inspected by DebugFrame,
+// and the position of the line. If it returns an error, execution
+// fails with that error. As with SetStepHook, the callback is called
+// for a line each time execution enters it from another line, such as
+// once for each iteration of a loop whose body contains the line.
+//
+// Unlike a step hook, breakpoints add no cost to the execution of
+// functions that contain none of their lines. A nil or empty set of
+// breakpoints removes them.
+//
+// This function is intended for use in debugging tools.
+//
+// THIS API IS EXPERIMENTAL AND MAY CHANGE WITHOUT NOTICE.
+func (thread *Thread) SetBreakpoints(breakpoints map[Breakpoint]bool, callback func(thread *Thread, pos syntax.Position) error) {
+	if len(breakpoints) == 0 {
+		breakpoints, callback = nil, nil
+	}
+	thread.breakpoints = breakpoints
+	thread.onBreakpoint = callback
+	thread.breakpointPCs = nil
+}
+
+// breakpointsIn returns the set of program counters of fn at which
+// the lines of the thread's breakpoints start, or nil if none do.
+func (thread *Thread) breakpointsIn(fn *compile.Funcode) map[uint32]bool {
+	if pcs, ok := thread.breakpointPCs[fn]; ok {
+		return pcs
+	}
+	var pcs map[uint32]bool
+	var line int32
+	filename := fn.Pos.Filename()
+	for _, row := range fn.PCPositions() {
+		if row.Pos.Line != line {
+			line = row.Pos.Line
+			if thread.breakpoints[Breakpoint{filename, line}] {
+				if pcs == nil {
+					pcs = make(map[uint32]bool)
+				}
+				pcs[row.PC] = true
+			}
+		}
+	}
+	if thread.breakpointPCs == nil {
+		thread.breakpointPCs = make(map[*compile.Funcode]map[uint32]bool)
+	}
+	thread.breakpointPCs[fn] = pcs
+	return pcs
+}
+
+// A SourceMap maps the program counters of a compiled function to
+// positions in its source file.
+//
+// THIS API IS EXPERIMENTAL AND MAY CHANGE WITHOUT NOTICE.
+type SourceMap struct {
+	Function string          // name of the function, or "<toplevel>"
+	Pos      syntax.Position // position of the def or lambda token, or of the first statement of the file
+	Entries  []SourceMapEntry
+}
+
+// A SourceMapEntry gives the source position of the instructions
+// from its PC up to, but not including, the PC of the next entry.
+type SourceMapEntry struct {
+	PC  uint32
+	Pos syntax.Position
+}
+
+// SourceMap returns the source maps of the program's module
+// initialization code, followed by those of its functions in the
+// order of their declaration. The entries of each are in increasing
+// order of PC, the first being for PC 0 unless the function has no
+// code with a source position.
+//
+// This function is intended for use in debugging tools.
+//
+// THIS API IS EXPERIMENTAL AND MAY CHANGE WITHOUT NOTICE.
+func (prog *Program) SourceMap() []SourceMap {
+	funcs := append([]*compile.Funcode{prog.compiled.Toplevel}, prog.compiled.Functions...)
+	maps := make([]SourceMap, len(funcs))
+	for i, fn := range funcs {
+		rows := fn.PCPositions()
+		entries := make([]SourceMapEntry, len(rows))
+		for j, row := range rows {
+			entries[j] = SourceMapEntry{row.PC, row.Pos}
+		}
+		maps[i] = SourceMap{Function: fn.Name, Pos: fn.Pos, Entries: entries}
+	}
+	return maps
+}
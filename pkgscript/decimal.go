@@ -0,0 +1,192 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkgscript
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+
+	"github.com/andrewchambers/pkgscript/syntax"
+)
+
+// Decimal is the type of a Starlark decimal, an exact rational number.
+// Unlike a float, a decimal such as decimal("0.1") is represented
+// without rounding, so decimal arithmetic is free of binary
+// floating-point artifacts.
+//
+// Decimals support the operators + - * / // and comparison.
+// An int operand of a binary operator whose other operand
+// is a decimal is promoted to decimal.
+type Decimal struct {
+	rat *big.Rat // never modified after construction
+}
+
+// decimalPrecision is the number of digits after the point used to
+// print a decimal whose expansion does not terminate, such as 1/3.
+const decimalPrecision = 28
+
+// decimalLiteral matches the strings accepted by the decimal built-in:
+// an optionally signed decimal number with an optional exponent.
+// Unlike big.Rat.SetString, it rejects fractions such as "1/3",
+// prefixes such as "0x", and digit separators.
+var decimalLiteral = regexp.MustCompile(`^[+-]?([0-9]+\.?[0-9]*|\.[0-9]+)([eE][+-]?[0-9]+)?$`)
+
+var (
+	_ Comparable = Decimal{}
+	_ HasBinary  = Decimal{}
+	_ HasUnary   = Decimal{}
+)
+
+// MakeDecimal returns a Starlark decimal for the specified rational.
+// The caller must not subsequently modify x.
+func MakeDecimal(x *big.Rat) Decimal { return Decimal{x} }
+
+// Rat returns the value of the decimal.
+// The result must not be modified by the client.
+func (d Decimal) Rat() *big.Rat { return d.rat }
+
+func (d Decimal) String() string {
+	if d.rat.IsInt() {
+		return d.rat.Num().String()
+	}
+	if n, ok := fractionDigits(d.rat.Denom()); ok {
+		return d.rat.FloatString(n)
+	}
+	return d.rat.FloatString(decimalPrecision)
+}
+
+// fractionDigits returns the number of digits after the point in
+// the exact decimal expansion of a fraction with the given (reduced)
+// denominator, and false if the expansion does not terminate.
+func fractionDigits(denom *big.Int) (int, bool) {
+	// The expansion terminates iff denom = 2^a * 5^b,
+	// and then it has max(a, b) digits.
+	d := new(big.Int).Set(denom)
+	var m big.Int
+	count := func(p int64) int {
+		n := 0
+		for {
+			q, _ := new(big.Int).QuoRem(d, big.NewInt(p), &m)
+			if m.Sign() != 0 {
+				return n
+			}
+			d, n = q, n+1
+		}
+	}
+	twos, fives := count(2), count(5)
+	if d.Cmp(oneBig) != 0 {
+		return 0, false
+	}
+	if twos > fives {
+		return twos, true
+	}
+	return fives, true
+}
+
+func (d Decimal) Type() string { return "decimal" }
+func (d Decimal) Freeze()      {} // immutable
+func (d Decimal) Truth() Bool  { return d.rat.Sign() != 0 }
+func (d Decimal) Hash() (uint32, error) {
+	// Equal decimal and int values must yield the same hash.
+	if d.rat.IsInt() {
+		return MakeBigInt(d.rat.Num()).Hash()
+	}
+	return hashString(d.rat.String()), nil
+}
+
+func (x Decimal) CompareSameType(op syntax.Token, y_ Value, depth int) (bool, error) {
+	y := y_.(Decimal)
+	return threeway(op, x.rat.Cmp(y.rat)), nil
+}
+
+// Unary implements the operations +decimal and -decimal.
+func (d Decimal) Unary(op syntax.Token) (Value, error) {
+	switch op {
+	case syntax.MINUS:
+		return Decimal{new(big.Rat).Neg(d.rat)}, nil
+	case syntax.PLUS:
+		return d, nil
+	}
+	return nil, nil
+}
+
+// Binary implements the arithmetic operators on decimals,
+// promoting an int operand to decimal.
+func (d Decimal) Binary(op syntax.Token, y Value, side Side) (Value, error) {
+	var other *big.Rat
+	switch y := y.(type) {
+	case Decimal:
+		other = y.rat
+	case Int:
+		other = y.rational()
+	default:
+		return nil, nil // unhandled
+	}
+	x := d.rat
+	if side == Right {
+		x, other = other, x
+	}
+	z := new(big.Rat)
+	switch op {
+	case syntax.PLUS:
+		z.Add(x, other)
+	case syntax.MINUS:
+		z.Sub(x, other)
+	case syntax.STAR:
+		z.Mul(x, other)
+	case syntax.SLASH:
+		if other.Sign() == 0 {
+			return nil, fmt.Errorf("decimal division by zero")
+		}
+		z.Quo(x, other)
+	case syntax.SLASHSLASH:
+		if other.Sign() == 0 {
+			return nil, fmt.Errorf("floored division by zero")
+		}
+		z.SetInt(floorQuo(x, other))
+	default:
+		return nil, nil // unhandled
+	}
+	return Decimal{z}, nil
+}
+
+// floorQuo returns the greatest integer not exceeding x/y.
+func floorQuo(x, y *big.Rat) *big.Int {
+	n := new(big.Int).Mul(x.Num(), y.Denom())
+	m := new(big.Int).Mul(x.Denom(), y.Num())
+	q, r := n.QuoRem(n, m, new(big.Int))
+	if r.Sign() != 0 && r.Sign() != m.Sign() {
+		q.Sub(q, oneBig)
+	}
+	return q
+}
+
+// https://github.com/google/pkgscript-go/blob/master/doc/spec.md#decimal
+func decimal_(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	var x Value = zero
+	if err := UnpackPositionalArgs("decimal", args, kwargs, 0, &x); err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case Decimal:
+		return x, nil
+	case Int:
+		return Decimal{x.rational()}, nil
+	case String:
+		s := strings.TrimSpace(string(x))
+		if !decimalLiteral.MatchString(s) {
+			return nil, fmt.Errorf("decimal: invalid literal: %s", x)
+		}
+		r, ok := new(big.Rat).SetString(s)
+		if !ok {
+			return nil, fmt.Errorf("decimal: invalid literal: %s", x)
+		}
+		return Decimal{r}, nil
+	default:
+		return nil, fmt.Errorf("decimal: got %s, want int, string, or decimal", x.Type())
+	}
+}
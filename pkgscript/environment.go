@@ -0,0 +1,135 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkgscript
+
+import "github.com/andrewchambers/pkgscript/syntax"
+
+// An Environment is a builder for the set of names predeclared in
+// the modules of an application, including the built-ins of the
+// universe. Its methods modify the environment and return it, so
+// that calls may be chained:
+//
+//	env := pkgscript.NewEnvironment().
+//		WithSafeUniverse().
+//		Remove("setattr").
+//		Add("re", pkgscriptre.Module)
+//
+// Ordinarily, the universal built-ins are visible to every module
+// regardless of its predeclared names. The SourceProgram and ExecFile
+// methods of an Environment instead resolve only the names in the
+// environment, so a built-in removed from it is reported as undefined
+// when the file is resolved.
+type Environment struct {
+	names     StringDict
+	universal map[string]bool // names whose values are those of Universe
+}
+
+// NewEnvironment returns a minimal environment, which defines only
+// the constants None, True, and False.
+func NewEnvironment() *Environment {
+	env := &Environment{
+		names:     make(StringDict),
+		universal: make(map[string]bool),
+	}
+	for _, name := range []string{"None", "True", "False"} {
+		env.addUniversal(name)
+	}
+	return env
+}
+
+// SideEffectingBuiltins lists the names of the built-ins of the
+// universe whose behavior is observable outside the Starlark program,
+// or depends on the world outside it, such as by writing output or
+// reading input. WithSafeUniverse omits them.
+var SideEffectingBuiltins = []string{"input", "print"}
+
+// WithUniverse adds all the built-ins of the universe.
+func (env *Environment) WithUniverse() *Environment {
+	for name := range Universe {
+		env.addUniversal(name)
+	}
+	return env
+}
+
+// WithSafeUniverse adds the built-ins of the universe, except for
+// those in SideEffectingBuiltins. The result is suitable for
+// executing untrusted programs, so long as the application adds only
+// values that are themselves safe.
+func (env *Environment) WithSafeUniverse() *Environment {
+	env.WithUniverse()
+	return env.Remove(SideEffectingBuiltins...)
+}
+
+func (env *Environment) addUniversal(name string) {
+	env.names[name] = Universe[name]
+	env.universal[name] = true
+}
+
+// Add adds a name, such as that of a built-in function or a module,
+// to the environment, replacing any existing value of that name.
+func (env *Environment) Add(name string, v Value) *Environment {
+	env.names[name] = v
+	delete(env.universal, name)
+	return env
+}
+
+// AddAll adds all the names in dict to the environment.
+func (env *Environment) AddAll(dict StringDict) *Environment {
+	for name, v := range dict {
+		env.Add(name, v)
+	}
+	return env
+}
+
+// Remove removes the specified names from the environment.
+// It is not an error if a name is not present.
+func (env *Environment) Remove(names ...string) *Environment {
+	for _, name := range names {
+		delete(env.names, name)
+		delete(env.universal, name)
+	}
+	return env
+}
+
+// Has reports whether the environment defines the specified name.
+func (env *Environment) Has(name string) bool { return env.names.Has(name) }
+
+// Predeclared returns a new dictionary containing all the names of
+// the environment, for use as the predeclared names of a module.
+//
+// Passing the result to the ExecFile function does not hide the
+// built-ins of the universe that were removed from the environment;
+// use the ExecFile method for that.
+func (env *Environment) Predeclared() StringDict {
+	dict := make(StringDict, len(env.names))
+	for name, v := range env.names {
+		dict[name] = v
+	}
+	return dict
+}
+
+// SourceProgram is like the SourceProgram function, but resolves
+// only the names defined by the environment.
+func (env *Environment) SourceProgram(filename string, src interface{}) (*syntax.File, *Program, error) {
+	f, err := syntax.Parse(filename, src, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	isPredeclared := func(name string) bool { return env.Has(name) && !env.universal[name] }
+	isUniversal := func(name string) bool { return env.universal[name] }
+	prog, err := fileProgram(f, isPredeclared, isUniversal)
+	return f, prog, err
+}
+
+// ExecFile is like the ExecFile function, but resolves only the
+// names defined by the environment, and uses them as the
+// predeclared names of the module.
+func (env *Environment) ExecFile(thread *Thread, filename string, src interface{}) (StringDict, error) {
+	_, mod, err := env.SourceProgram(filename, src)
+	if err != nil {
+		return nil, err
+	}
+	return execProgram(thread, mod, env.Predeclared())
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkgscript_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/andrewchambers/pkgscript/pkgscript"
+)
+
+func TestEnvironment(t *testing.T) {
+	double := pkgscript.NewBuiltin("double", func(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+		var x int
+		if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &x); err != nil {
+			return nil, err
+		}
+		return pkgscript.MakeInt(2 * x), nil
+	})
+	env := pkgscript.NewEnvironment().
+		WithSafeUniverse().
+		Remove("setattr", "nosuch").
+		Add("double", double).
+		AddAll(pkgscript.StringDict{"limit": pkgscript.MakeInt(10)})
+
+	for _, name := range []string{"None", "len", "double", "limit"} {
+		if !env.Has(name) {
+			t.Errorf("environment lacks %s", name)
+		}
+	}
+	for _, name := range []string{"input", "print", "setattr"} {
+		if env.Has(name) {
+			t.Errorf("environment unexpectedly has %s", name)
+		}
+	}
+	if got := pkgscript.NewEnvironment().Predeclared().Keys(); len(got) != 3 {
+		t.Errorf("minimal environment has %q, want None, True, False", got)
+	}
+
+	for _, test := range []struct {
+		src, want string
+	}{
+		{`y = double(len("abc")) + limit`, "16"},
+		{`y = True if [] else None`, "None"},
+		// Removed built-ins are rejected when the file is resolved.
+		{`y = print("hello")`, `f.star:1:5: undefined: print`},
+		{`y = input()`, `f.star:1:5: undefined: input`},
+		{`def f(): setattr(x, "f", 1)`, `f.star:1:10: undefined: setattr`},
+	} {
+		globals, err := env.ExecFile(&pkgscript.Thread{}, "f.star", test.src)
+		var got string
+		if err != nil {
+			got = err.Error()
+		} else {
+			got = globals["y"].String()
+		}
+		if !strings.Contains(got, test.want) {
+			t.Errorf("%s: got %s, want %s", test.src, got, test.want)
+		}
+	}
+
+	// Names added to the environment shadow the universe.
+	env = pkgscript.NewEnvironment().WithUniverse().Add("len", double)
+	globals, err := env.ExecFile(&pkgscript.Thread{}, "f.star", `y = len(3)`)
+	if err != nil {
+		t.Fatal(err)
+	} else if got := globals["y"].String(); got != "6" {
+		t.Errorf("shadowed len(3) = %s, want 6", got)
+	}
+}
@@ -11,8 +11,12 @@ import (
 	"log"
 	"math"
 	"math/big"
+	"math/bits"
+	"os"
+	"regexp"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 	"unicode"
 	"unicode/utf8"
@@ -34,10 +38,25 @@ type Thread struct {
 	stack []*frame
 
 	// Print is the client-supplied implementation of the Starlark
-	// 'print' function. If nil, fmt.Fprintln(os.Stderr, msg) is
-	// used instead.
+	// 'print' function. It is expected to add its own newline, so
+	// msg omits the final newline of print's terminator, and Print
+	// cannot distinguish print(x) from print(x, end=""). Clients that
+	// need the exact terminator should use PrintRecord. If Print is
+	// nil, the message and terminator are written to os.Stderr.
 	Print func(thread *Thread, msg string)
 
+	// PrintRecord, if non-nil, is called by the Starlark 'print'
+	// function in place of Print, with its unformatted arguments
+	// and its separator and terminator strings, allowing clients
+	// to record structured output, such as one JSON object per call.
+	PrintRecord func(thread *Thread, args []Value, sep, end string)
+
+	// Input is the client-supplied implementation of the Starlark
+	// 'input' function, which returns a line of input after
+	// displaying the prompt. If nil, 'input' fails, so that
+	// programs have no access to input unless the client permits it.
+	Input func(prompt string) (string, error)
+
 	// Load is the client-supplied implementation of module loading.
 	// Repeated calls with the same module name must return the same
 	// module environment or error.
@@ -46,12 +65,245 @@ type Thread struct {
 	// See example_test.go for some example implementations of Load.
 	Load func(thread *Thread, module Value) (StringDict, error)
 
+	// OnBuiltinCall, if non-nil, is called before each call of a
+	// built-in function or method by this thread, with the name of
+	// the built-in and its arguments. The name of a method is
+	// qualified by the type of its receiver, as in "list.append".
+	// If OnBuiltinCall returns an error, the call fails with that
+	// error instead of calling the built-in. Clients may use it to
+	// trace calls or to forbid certain built-ins.
+	OnBuiltinCall func(name string, args Tuple, kwargs []Tuple) error
+
+	// Warn, if non-nil, is called to report a warning, such as a call
+	// of a deprecated built-in or unreachable code in a file being
+	// executed, at the specified position. If Warn is nil, warnings
+	// are reported through Print, or written to os.Stderr if Print is
+	// also nil.
+	//
+	// The use of each deprecated built-in is reported at most once
+	// per call site by each thread. The warnings of the resolver are
+	// reported each time a program is initialized.
+	Warn func(thread *Thread, pos syntax.Position, msg string)
+
+	// FreezeLoads, if set, causes each load statement executed by
+	// this thread to freeze the globals of the loaded module before
+	// binding them, as if by FreezeGlobals, so that no module can
+	// mutate the values it loads from another. ExecFile already
+	// freezes the globals it returns, but a Load function based on
+	// Program.Init may not.
+	FreezeLoads bool
+
 	// locals holds arbitrary "thread-local" Go values belonging to the client.
 	// They are accessible to the client but not to any Starlark program.
 	locals map[string]interface{}
 
 	// proftime holds the accumulated execution time since the last profile event.
 	proftime time.Duration
+
+	// loaded records the names of modules successfully loaded by this thread.
+	loaded    []string
+	loadedSet map[string]bool
+
+	// warned records the call sites of deprecated built-ins already reported.
+	warned map[warning]bool
+
+	// meter, if non-nil, accounts for the computation performed by
+	// this thread, and by any threads created from it by NewChild.
+	meter *meter
+
+	// maxCallDepth is the limit on the depth of the call stack;
+	// zero means DefaultMaxCallDepth.
+	maxCallDepth int
+
+	// floatFormat is the fmt verb for floats set by SetFloatFormat,
+	// or empty for the shortest round-trip form.
+	floatFormat string
+
+	// stepHook, if non-nil, is called before the execution of each
+	// source line. See SetStepHook.
+	stepHook func(frame DebugFrame, pos syntax.Position) error
+
+	// breakpoints, if non-nil, is the set of lines before which
+	// onBreakpoint is called. breakpointPCs caches, for each function,
+	// the program counters at which those lines start. See SetBreakpoints.
+	breakpoints   map[Breakpoint]bool
+	onBreakpoint  func(thread *Thread, pos syntax.Position) error
+	breakpointPCs map[*compile.Funcode]map[uint32]bool
+}
+
+// A meter accounts for the computation performed by a group of
+// threads, which may run concurrently, and enforces its limits.
+type meter struct {
+	steps     uint64 // number of instructions executed; accessed atomically
+	maxSteps  uint64 // limit on steps; zero means no limit
+	allocs    uint64 // approximate bytes allocated; accessed atomically
+	maxAllocs uint64 // limit on allocs; zero means no limit
+}
+
+// step records the execution of one instruction,
+// and reports an error if the step limit is exceeded.
+func (m *meter) step() error {
+	if n := atomic.AddUint64(&m.steps, 1); m.maxSteps != 0 && n > m.maxSteps {
+		return fmt.Errorf("too many steps: execution step limit (%d) exceeded", m.maxSteps)
+	}
+	return nil
+}
+
+// alloc records the allocation of approximately n bytes,
+// and reports an error if the allocation limit would be exceeded.
+func (m *meter) alloc(n uint64) error {
+	if m.maxAllocs == 0 {
+		atomic.AddUint64(&m.allocs, n)
+		return nil
+	}
+	for {
+		old := atomic.LoadUint64(&m.allocs)
+		if n > m.maxAllocs-old || old > m.maxAllocs {
+			return fmt.Errorf("too many allocations: allocation limit (%d bytes) exceeded", m.maxAllocs)
+		}
+		if atomic.CompareAndSwapUint64(&m.allocs, old, old+n) {
+			return nil
+		}
+	}
+}
+
+// SetMaxExecutionSteps sets a limit on the number of Starlark
+// computation steps that may be executed by this thread, together
+// with all threads created from it by NewChild. If the limit is
+// exceeded, execution fails with an error. Zero means no limit.
+//
+// Steps are counted only after the first call to SetMaxExecutionSteps,
+// SetMaxAllocs, or NewChild. SetMaxExecutionSteps must not be called while any of
+// the threads sharing the limit is executing.
+func (thread *Thread) SetMaxExecutionSteps(max uint64) {
+	if thread.meter == nil {
+		thread.meter = new(meter)
+	}
+	thread.meter.maxSteps = max
+}
+
+// ExecutionSteps returns the number of computation steps executed so
+// far by this thread and all threads that share its limits.
+func (thread *Thread) ExecutionSteps() uint64 {
+	if thread.meter == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&thread.meter.steps)
+}
+
+// SetMaxAllocs sets a limit on the approximate number of bytes of
+// memory that may be allocated for Starlark values by this thread,
+// together with all threads created from it by NewChild. An operation
+// that would exceed the limit fails with an error before allocating.
+// Zero means no limit.
+//
+// The accounting is approximate, and covers only the operations able
+// to allocate large values: x+y and x*n on strings, lists, and
+// tuples; arithmetic on ints too large to be small, and x<<n; the
+// growth of lists and dicts, whether by comprehensions, list.append,
+// list.extend, x += y, d[k] = v, dict.update, or the like; the list,
+// tuple, sorted, dict, enumerate, zip, and deepcopy built-ins; and the
+// string operations x%y, str.format, str.join, str.replace,
+// str.split, str.zfill, and str.expandtabs. The results of string formatting and splitting are
+// counted once they are built, so these operations may exceed the
+// limit by the size of one result before they fail. Like steps, allocations are counted only
+// after the first call to SetMaxAllocs, SetMaxExecutionSteps, or
+// NewChild.
+// SetMaxAllocs must not be called while any of the threads sharing
+// the limit is executing.
+func (thread *Thread) SetMaxAllocs(max uint64) {
+	if thread.meter == nil {
+		thread.meter = new(meter)
+	}
+	thread.meter.maxAllocs = max
+}
+
+// Allocs returns the approximate number of bytes allocated so far by
+// this thread and those that share its limits, as counted for
+// SetMaxAllocs.
+func (thread *Thread) Allocs() uint64 {
+	if thread.meter == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&thread.meter.allocs)
+}
+
+// AddAllocs records the allocation of approximately n bytes by the
+// thread, and reports an error if this would exceed the limit set by
+// SetMaxAllocs. Built-in functions that allocate large values should
+// call it before doing so.
+func (thread *Thread) AddAllocs(n uint64) error {
+	if thread.meter == nil {
+		return nil
+	}
+	return thread.meter.alloc(n)
+}
+
+// DefaultMaxCallDepth is the limit on the depth of the call stack of
+// a thread for which SetMaxCallDepth has not been called. It is low
+// enough that a runaway recursion fails before it exhausts the Go stack.
+const DefaultMaxCallDepth = 10000
+
+// SetMaxCallDepth sets a limit on the depth of the call stack of the
+// thread, counting calls of both Starlark and built-in functions.
+// A call that would exceed the limit fails with an error, so that
+// unbounded recursion, which is possible when resolve.AllowRecursion
+// is set, cannot overflow the Go stack. Zero means DefaultMaxCallDepth.
+// Threads created by NewChild inherit the limit.
+func (thread *Thread) SetMaxCallDepth(max int) {
+	thread.maxCallDepth = max
+}
+
+// NewChild returns a new thread that shares the resource limits of
+// this one, such as the step limit set by SetMaxExecutionSteps, so
+// that the work done by the parent and all its children is collectively
+// bounded. The child has its own call stack, and copies of the
+// parent's name, its Print, PrintRecord, Input, Load, OnBuiltinCall,
+// and Warn functions, its FreezeLoads setting, float format, call
+// depth limit, step hook, breakpoints, and thread-local values.
+// A thread-local value that implements ChildLocal is not shared; the
+// child instead receives the result of its ChildLocal method.
+//
+// The parent and its children may execute concurrently, but NewChild
+// must be called by the goroutine executing the parent.
+func (thread *Thread) NewChild() *Thread {
+	if thread.meter == nil {
+		thread.meter = new(meter)
+	}
+	child := &Thread{
+		Name:        thread.Name,
+		Print:       thread.Print,
+		PrintRecord: thread.PrintRecord,
+		Input:       thread.Input,
+		Load:        thread.Load,
+		meter:       thread.meter,
+
+		OnBuiltinCall: thread.OnBuiltinCall,
+		FreezeLoads:   thread.FreezeLoads,
+		Warn:          thread.Warn,
+
+		maxCallDepth: thread.maxCallDepth,
+		stepHook:     thread.stepHook,
+		breakpoints:  thread.breakpoints,
+		onBreakpoint: thread.onBreakpoint,
+		floatFormat:  thread.floatFormat,
+	}
+	for k, v := range thread.locals {
+		if v, ok := v.(ChildLocal); ok {
+			child.SetLocal(k, v.ChildLocal())
+			continue
+		}
+		child.SetLocal(k, v)
+	}
+	return child
+}
+
+// A ChildLocal is a thread-local value that is not shared with the
+// children of its thread. NewChild calls ChildLocal to obtain the
+// value of the same key for the child, such as an independent copy
+// of state that the parent and child would otherwise have to lock.
+type ChildLocal interface {
+	ChildLocal() interface{}
 }
 
 // SetLocal sets the thread-local value associated with the specified key.
@@ -68,6 +320,29 @@ func (thread *Thread) Local(key string) interface{} {
 	return thread.locals[key]
 }
 
+// floatVerb matches the formats accepted by SetFloatFormat.
+var floatVerb = regexp.MustCompile(`^%[-+# 0]*[0-9]*(\.[0-9]*)?[eEfFgG]$`)
+
+// SetFloatFormat sets the fmt verb, such as "%g" or "%.3f", with which
+// the thread formats floats, including those within other values,
+// in place of the default shortest round-trip form. It applies to the
+// 'str' and 'print' functions, the %s conversion of the % operator,
+// and the {} and {!s} fields of str.format, but not to repr, %r, {!r},
+// or the String method of a value. An empty format restores the
+// default. SetFloatFormat fails if format is not a single verb of
+// e, E, f, F, g, or G, with optional flags, width, and precision.
+// Threads created by NewChild inherit the format.
+func (thread *Thread) SetFloatFormat(format string) error {
+	if format != "" && !floatVerb.MatchString(format) {
+		return fmt.Errorf("invalid float format %q", format)
+	}
+	thread.floatFormat = format
+	return nil
+}
+
+// FloatFormat returns the format set by SetFloatFormat.
+func (thread *Thread) FloatFormat() string { return thread.floatFormat }
+
 // CallFrame returns a copy of the specified frame of the callstack.
 // It should only be used in built-ins called from Starlark code.
 // Depth 0 means the frame of the built-in itself, 1 is its caller, and so on.
@@ -93,6 +368,92 @@ func (thread *Thread) CallStack() CallStack {
 // CallStackDepth returns the number of frames in the current call stack.
 func (thread *Thread) CallStackDepth() int { return len(thread.stack) }
 
+// Predeclared returns the predeclared environment of the module whose
+// code is currently executing, or nil if no Starlark function is active.
+// The result must not be modified.
+//
+// A Load implementation may use it to derive the environment of the
+// module being loaded from that of the module that loads it, for
+// example to withhold sensitive built-ins from untrusted dependencies.
+// The loaded module is resolved against whatever environment Load
+// passes to ExecFile or Program.Init, so names missing from it are
+// reported as undefined.
+func (thread *Thread) Predeclared() StringDict {
+	for i := len(thread.stack) - 1; i >= 0; i-- {
+		if fn, ok := thread.stack[i].callable.(*Function); ok {
+			return fn.module.predeclared
+		}
+	}
+	return nil
+}
+
+// LoadedModules returns a new slice containing the names of the
+// modules successfully loaded by load statements executed by this
+// thread, each once, in the order in which they were first loaded.
+// A module operand that is not a string is named by its String form.
+//
+// Modules loaded indirectly, by other threads created within the
+// thread's Load function, are not included.
+func (thread *Thread) LoadedModules() []string {
+	return append([]string(nil), thread.loaded...)
+}
+
+// recordLoad records that the specified module was loaded.
+func (thread *Thread) recordLoad(module Value) {
+	name, ok := AsString(module)
+	if !ok {
+		name = module.String()
+	}
+	if !thread.loadedSet[name] {
+		if thread.loadedSet == nil {
+			thread.loadedSet = make(map[string]bool)
+		}
+		thread.loadedSet[name] = true
+		thread.loaded = append(thread.loaded, name)
+	}
+}
+
+// A warning identifies the call site of a deprecated built-in.
+type warning struct {
+	pos  syntax.Position
+	name string
+}
+
+// warnDeprecated reports the call of the deprecated built-in b,
+// unless the same call site has been reported before.
+func (thread *Thread) warnDeprecated(b *Builtin) {
+	// The top frame belongs to b itself, if it was called by Call.
+	var pos syntax.Position
+	if n := len(thread.stack); n >= 2 {
+		pos = thread.stack[n-2].Position()
+	}
+	name := b.qualifiedName()
+	key := warning{pos, name}
+	if thread.warned[key] {
+		return
+	}
+	if thread.warned == nil {
+		thread.warned = make(map[warning]bool)
+	}
+	thread.warned[key] = true
+
+	thread.warn(pos, fmt.Sprintf("%s is deprecated: %s", name, b.deprecation))
+}
+
+// warn reports a warning at pos through Warn, Print, or os.Stderr.
+func (thread *Thread) warn(pos syntax.Position, msg string) {
+	if thread.Warn != nil {
+		thread.Warn(thread, pos, msg)
+		return
+	}
+	msg = fmt.Sprintf("%s: warning: %s", pos, msg)
+	if thread.Print != nil {
+		thread.Print(thread, msg)
+	} else {
+		fmt.Fprintln(os.Stderr, msg)
+	}
+}
+
 // A StringDict is a mapping from names to values, and represents
 // an environment such as the global variables of a module.
 // It is not a true pkgscript.Value.
@@ -129,6 +490,14 @@ func (d StringDict) Freeze() {
 	}
 }
 
+// FreezeGlobals freezes all the values of a module's globals,
+// so that they may be safely shared with other modules,
+// and returns globals.
+func FreezeGlobals(globals StringDict) StringDict {
+	globals.Freeze()
+	return globals
+}
+
 // Has reports whether the dictionary contains the specified key.
 func (d StringDict) Has(key string) bool { _, ok := d[key]; return ok }
 
@@ -190,6 +559,7 @@ func (stack CallStack) String() string {
 type EvalError struct {
 	Msg       string
 	CallStack CallStack
+	cause     error
 }
 
 // A CallFrame represents the function name and current
@@ -210,17 +580,45 @@ func (thread *Thread) evalError(err error) *EvalError {
 	return &EvalError{
 		Msg:       err.Error(),
 		CallStack: thread.CallStack(),
+		cause:     err,
 	}
 }
 
 func (e *EvalError) Error() string { return e.Msg }
 
+// Unwrap returns the error from which the EvalError was created,
+// such as the error returned by a failing built-in function,
+// so that errors.Is and errors.As may inspect it.
+func (e *EvalError) Unwrap() error { return e.cause }
+
 // Backtrace returns a user-friendly error message describing the stack
 // of calls that led to this error.
 func (e *EvalError) Backtrace() string {
 	return fmt.Sprintf("%sError: %s", e.CallStack, e.Msg)
 }
 
+// A Frame describes one call frame in the stack of an EvalError.
+type Frame struct {
+	Function string          // name of the function or built-in
+	Pos      syntax.Position // current position of execution within Function
+	Builtin  bool            // Function is a built-in, and Pos is not in a source file
+}
+
+// Stack returns the frames of the stack of calls that led to
+// this error, outermost first. Clients may use it to present
+// the backtrace in a form other than that of Backtrace.
+func (e *EvalError) Stack() []Frame {
+	frames := make([]Frame, len(e.CallStack))
+	for i, fr := range e.CallStack {
+		frames[i] = Frame{
+			Function: fr.Name,
+			Pos:      fr.Pos,
+			Builtin:  fr.Pos.Filename() == builtinFilename,
+		}
+	}
+	return frames
+}
+
 // A Program is a compiled Starlark program.
 //
 // Programs are immutable, and contain no Values.
@@ -228,6 +626,8 @@ func (e *EvalError) Backtrace() string {
 // or by loading a previously saved compiled program (see CompiledProgram).
 type Program struct {
 	compiled *compile.Program
+	options  *syntax.FileOptions // dialect of the file, or nil if unknown
+	warnings []resolve.Error     // warnings of the resolver, reported by init
 }
 
 // CompilerVersion is the version number of the protocol for compiled
@@ -241,6 +641,54 @@ func (prog *Program) Filename() string { return prog.compiled.Toplevel.Pos.Filen
 
 func (prog *Program) String() string { return prog.Filename() }
 
+// Predeclared returns the sorted names of the predeclared variables
+// that the program uses, each of which must be provided to Init.
+// The names are recorded by Program.Write, so a client may validate
+// its environment before initializing a program from CompiledProgram.
+func (prog *Program) Predeclared() []string {
+	return append([]string(nil), prog.compiled.Predeclared...)
+}
+
+// NumFunctions returns the number of functions, that is, def statements
+// and lambda expressions, in the program, not counting the implicit
+// function that initializes the module.
+func (prog *Program) NumFunctions() int { return len(prog.compiled.Functions) }
+
+// NumConstants returns the number of distinct literal constants in the program.
+func (prog *Program) NumConstants() int { return len(prog.compiled.Constants) }
+
+// NumInstructions returns the total number of bytecode instructions
+// in the program, including those of module initialization.
+// Along with NumFunctions, NumConstants, and CodeSize, it may be used
+// to reject programs that exceed a complexity budget before running them.
+func (prog *Program) NumInstructions() int {
+	n := prog.compiled.Toplevel.NumInstructions()
+	for _, fn := range prog.compiled.Functions {
+		n += fn.NumInstructions()
+	}
+	return n
+}
+
+// CodeSize returns the total size in bytes of the program's bytecode,
+// including that of module initialization.
+func (prog *Program) CodeSize() int {
+	n := len(prog.compiled.Toplevel.Code)
+	for _, fn := range prog.compiled.Functions {
+		n += len(fn.Code)
+	}
+	return n
+}
+
+// InternStrings enables or disables the interning of string constants.
+//
+// When enabled, each literal string of a program compiled or loaded
+// thereafter shares its storage with the equal literals of every
+// other such program, reducing the memory used by an application
+// that loads many similar files. Interning does not affect the
+// meaning of a program. The intern table is bounded in size, and
+// disabling interning discards it.
+func InternStrings(enable bool) { compile.InternStrings(enable) }
+
 // WriteTo writes the compiled module to the specified output stream.
 func (prog *Program) Write(out io.Writer) error {
 	data := prog.compiled.Encode()
@@ -265,16 +713,337 @@ func (prog *Program) Write(out io.Writer) error {
 //
 // If ExecFile fails during evaluation, it returns an *EvalError
 // containing a backtrace.
+//
+// If the file defines the global __all__, only the names it lists
+// are returned; see Exports.
 func ExecFile(thread *Thread, filename string, src interface{}, predeclared StringDict) (StringDict, error) {
+	return ExecFileOptions(nil, thread, filename, src, predeclared)
+}
+
+// ExecFileOptions is a variant of ExecFile that uses the specified
+// dialect, or that of the global options of the resolve package if
+// opts is nil.
+func ExecFileOptions(opts *syntax.FileOptions, thread *Thread, filename string, src interface{}, predeclared StringDict) (StringDict, error) {
 	// Parse, resolve, and compile a Starlark source file.
-	_, mod, err := SourceProgram(filename, src, predeclared.Has)
+	_, mod, err := SourceProgramOptions(opts, filename, src, predeclared.Has)
 	if err != nil {
 		return nil, err
 	}
+	return execProgram(thread, mod, predeclared)
+}
 
-	g, err := mod.Init(thread, predeclared)
+// execProgram executes the program and returns its frozen exports.
+func execProgram(thread *Thread, mod *Program, predeclared StringDict) (StringDict, error) {
+	g, err := mod.init(thread, predeclared, nil)
 	g.Freeze()
-	return g, err
+	if err != nil {
+		return g, err
+	}
+	return Exports(g)
+}
+
+// Exports returns the public globals of a module, given all its globals.
+//
+// If the module defines the global __all__, which must be a list or
+// tuple of strings, the result contains only the globals it names,
+// and it is an error if any of them is undefined. Otherwise, the
+// result is globals. The result never contains __all__ itself.
+//
+// Since load rejects the names of globals that are absent from
+// the dictionary of a module, a program may not load a global that
+// is not listed in __all__.
+func Exports(globals StringDict) (StringDict, error) {
+	all, ok := globals["__all__"]
+	if !ok {
+		return globals, nil
+	}
+	switch all.(type) {
+	case *List, Tuple:
+	default:
+		return nil, fmt.Errorf("__all__: got %s, want list or tuple of strings", all.Type())
+	}
+	iter := Iterate(all)
+	defer iter.Done()
+	exports := make(StringDict)
+	var x Value
+	for i := 0; iter.Next(&x); i++ {
+		name, ok := AsString(x)
+		if !ok {
+			return nil, fmt.Errorf("__all__: element %d: got %s, want string", i, x.Type())
+		}
+		v, ok := globals[name]
+		if !ok || name == "__all__" {
+			return nil, fmt.Errorf("__all__: undefined name %s", name)
+		}
+		exports[name] = v
+	}
+	return exports, nil
+}
+
+// ExecReaderStatements parses, resolves, and executes a Starlark file
+// one top-level statement at a time, reading it incrementally from r.
+// Because it retains only the syntax tree of the current statement, it
+// is suited to very large files, such as those generated by programs.
+// Its parameters and results are otherwise as for ExecFile.
+//
+// Each statement is executed once it has been read, so a function may
+// refer to a global defined by a later statement, as in ExecFile, but a
+// reference to a name that no statement defines is reported only when
+// the end of the file is reached, after the preceding statements have
+// been executed. As in ExecFile, the result of such a resolve error,
+// or of a syntax error, is nil.
+//
+// Unlike ExecFile, which resolves the whole file before executing
+// any of it, ExecReaderStatements rejects a statement that defines a
+// global with the name of a predeclared or universal value, such as
+// len, used by an earlier statement, since the earlier statement has
+// already been resolved, and perhaps executed, using that value.
+// With AllowGlobalReassign, uses at top level, outside any function or
+// comprehension, are exempt, since ExecFile too resolves them to the
+// predeclared value.
+func ExecReaderStatements(thread *Thread, filename string, r io.Reader, predeclared StringDict) (StringDict, error) {
+	return ExecReaderStatementsOptions(nil, thread, filename, r, predeclared)
+}
+
+// ExecReaderStatementsOptions is a variant of ExecReaderStatements that
+// uses the specified dialect, or that of the global options of the
+// resolve package if opts is nil.
+func ExecReaderStatementsOptions(opts *syntax.FileOptions, thread *Thread, filename string, r io.Reader, predeclared StringDict) (StringDict, error) {
+	if opts == nil {
+		opts = resolve.GlobalOptions()
+	}
+	globals := make(StringDict)
+	declared := make(map[string]syntax.Position) // position of each global's first binding
+	forward := make(map[string]syntax.Position)  // position of each forward reference to a global
+	builtins := make(map[string]syntax.Position) // position of the first use of each predeclared or universal name
+
+	// env holds the predeclared names and the globals defined so far.
+	// Each statement is resolved with the names of env as predeclared,
+	// and any other name that is not universal as a forward reference
+	// to a global, whose value in env is unboundGlobal until defined.
+	env := make(StringDict, len(predeclared))
+	for k, v := range predeclared {
+		env[k] = v
+	}
+	isPredeclared := func(name string) bool { return env.Has(name) || !Universe.Has(name) }
+	if permitted := opts.PermittedPredeclared; permitted != nil {
+		// The allowlist restricts only true predeclared and universal
+		// names, not globals that the file has already defined.
+		o := *opts
+		o.PermittedPredeclared = func(name string) bool {
+			if _, ok := declared[name]; ok {
+				return true
+			}
+			return !predeclared.Has(name) && !Universe.Has(name) || permitted(name)
+		}
+		opts = &o
+	}
+	declare := func(name string, pos syntax.Position) error {
+		if prev, ok := builtins[name]; ok {
+			return resolve.ErrorList{{Pos: pos, Msg: fmt.Sprintf("cannot define global %s: the predeclared %s is used at %s", name, name, prev)}}
+		}
+		if prev, ok := declared[name]; ok && !opts.AllowGlobalReassign {
+			return resolve.ErrorList{{Pos: pos, Msg: fmt.Sprintf("cannot reassign global %s declared at %s", name, prev)}}
+		}
+		declared[name] = pos
+		return nil
+	}
+	define := func(name string, pos syntax.Position, v Value) error {
+		if err := declare(name, pos); err != nil {
+			return err
+		}
+		if v != nil {
+			globals[name] = v
+			env[name] = v
+		} else if _, ok := globals[name]; ok {
+			// The statement deleted the global.
+			delete(globals, name)
+			if _, ok := forward[name]; ok {
+				env[name] = unboundGlobal
+			} else {
+				delete(env, name)
+			}
+		}
+		return nil
+	}
+
+	// recordUses returns a function for syntax.Walk that records the
+	// forward references and the uses of predeclared and universal
+	// names in a statement. With AllowGlobalReassign, a use at top
+	// level, outside any function or comprehension, refers to the
+	// predeclared value even in ExecFile, so it is not recorded.
+	var recordUses func(nested bool) func(syntax.Node) bool
+	recordUses = func(nested bool) func(syntax.Node) bool {
+		return func(n syntax.Node) bool {
+			switch n := n.(type) {
+			case *syntax.DefStmt, *syntax.LambdaExpr, *syntax.Comprehension:
+				if !nested {
+					syntax.Walk(n, recordUses(true))
+					return false
+				}
+			case *syntax.Ident:
+				b, ok := n.Binding.(*resolve.Binding)
+				if !ok {
+					break
+				}
+				_, global := declared[n.Name]
+				var uses map[string]syntax.Position
+				switch {
+				case b.Scope == resolve.Predeclared && !env.Has(n.Name):
+					uses = forward
+				case nested || !opts.AllowGlobalReassign:
+					if b.Scope == resolve.Universal || b.Scope == resolve.Predeclared && predeclared.Has(n.Name) && !global {
+						uses = builtins
+					}
+				}
+				if uses != nil {
+					if _, ok := uses[n.Name]; !ok {
+						uses[n.Name] = n.NamePos
+					}
+				}
+			}
+			return true
+		}
+	}
+
+	// After an execution error, the rest of the file is read only to
+	// learn which globals it defines, so that a reference to a name
+	// that is never defined is reported as ExecFile would report it.
+	var execErr error
+	err := syntax.ParseStmts(filename, r, func(f *syntax.File) error {
+		for _, stmt := range f.Stmts {
+			if load, ok := stmt.(*syntax.LoadStmt); ok {
+				if execErr == nil {
+					execErr = execLoadStmt(thread, opts, load, env, define)
+					if _, ok := execErr.(resolve.ErrorList); ok {
+						return execErr
+					}
+				} else {
+					for _, to := range load.To {
+						if err := declare(to.Name, to.NamePos); err != nil {
+							return err
+						}
+					}
+				}
+				continue
+			}
+
+			prog, err := FileProgram(&syntax.File{Path: f.Path, Stmts: []syntax.Stmt{stmt}, Options: opts}, isPredeclared)
+			if err != nil {
+				return err
+			}
+			syntax.Walk(stmt, recordUses(false))
+			for name := range forward {
+				if !env.Has(name) {
+					env[name] = unboundGlobal
+				}
+			}
+			if execErr != nil {
+				for _, b := range prog.compiled.Globals {
+					if err := declare(b.Name, b.Pos); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			g, err := prog.init(thread, env, globals)
+			for _, b := range prog.compiled.Globals {
+				if err := define(b.Name, b.Pos, g[b.Name]); err != nil {
+					return err
+				}
+			}
+			execErr = err
+		}
+		return nil
+	})
+	globals.Freeze()
+	if err != nil {
+		return nil, err
+	}
+	var undefined resolve.ErrorList
+	for name, pos := range forward {
+		if _, ok := declared[name]; !ok {
+			msg := "undefined: " + name
+			if n := spell.Nearest(name, globals.Keys()); n != "" {
+				msg += fmt.Sprintf(" (did you mean %s?)", n)
+			}
+			undefined = append(undefined, resolve.Error{Pos: pos, Msg: msg})
+		}
+	}
+	if undefined != nil {
+		sort.Slice(undefined, func(i, j int) bool {
+			x, y := undefined[i].Pos, undefined[j].Pos
+			return x.Line < y.Line || x.Line == y.Line && x.Col < y.Col
+		})
+		return nil, undefined
+	}
+	if execErr != nil {
+		return globals, execErr
+	}
+	return Exports(globals)
+}
+
+// unbound is the type of unboundGlobal.
+type unbound struct{}
+
+func (unbound) String() string        { return "<unbound>" }
+func (unbound) Type() string          { return "unbound" }
+func (unbound) Freeze()               {}
+func (unbound) Truth() Bool           { return False }
+func (unbound) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable: unbound") }
+
+// unboundGlobal is the value, in the predeclared environment of the
+// statements executed by ExecReaderStatements, of a global that is
+// referenced before any statement defines it.
+var unboundGlobal Value = unbound{}
+
+// execLoadStmt executes a load statement on behalf of
+// ExecReaderStatements, defining each loaded name as a global.
+// It performs the same checks as the resolver and interpreter.
+func execLoadStmt(thread *Thread, opts *syntax.FileOptions, load *syntax.LoadStmt, env StringDict, define func(string, syntax.Position, Value) error) error {
+	for _, from := range load.From {
+		if from.Name == "" {
+			return resolve.ErrorList{{Pos: from.NamePos, Msg: "load: empty identifier"}}
+		}
+		if from.Name[0] == '_' {
+			return resolve.ErrorList{{Pos: from.NamePos, Msg: fmt.Sprintf("load: names with leading underscores are not exported: %s", from.Name)}}
+		}
+	}
+
+	module, err := EvalExprOptions(opts, thread, load.Module, env)
+	if err != nil {
+		return err
+	}
+
+	fail := func(err error) error {
+		return &EvalError{
+			Msg:       err.Error(),
+			CallStack: CallStack{{Name: "<toplevel>", Pos: load.Load}},
+			cause:     err,
+		}
+	}
+	if thread.Load == nil {
+		return fail(fmt.Errorf("load not implemented by this application"))
+	}
+	dict, err := thread.Load(thread, module)
+	if err != nil {
+		return fail(fmt.Errorf("cannot load %s: %v", module, err))
+	}
+	thread.recordLoad(module)
+	for i, from := range load.From {
+		v, ok := dict[from.Name]
+		if !ok {
+			err := fmt.Errorf("load: name %s not found in module %s", from.Name, module)
+			if n := spell.Nearest(from.Name, dict.Keys()); n != "" {
+				err = fmt.Errorf("%s (did you mean %s?)", err, n)
+			}
+			return fail(err)
+		}
+		if err := define(load.To[i].Name, load.To[i].NamePos, v); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // SourceProgram produces a new program by parsing, resolving,
@@ -287,7 +1056,14 @@ func ExecFile(thread *Thread, filename string, src interface{}, predeclared Stri
 // Its typical value is predeclared.Has,
 // where predeclared is a StringDict of pre-declared values.
 func SourceProgram(filename string, src interface{}, isPredeclared func(string) bool) (*syntax.File, *Program, error) {
-	f, err := syntax.Parse(filename, src, 0)
+	return SourceProgramOptions(nil, filename, src, isPredeclared)
+}
+
+// SourceProgramOptions is a variant of SourceProgram that uses the
+// specified dialect, or that of the global options of the resolve
+// package if opts is nil.
+func SourceProgramOptions(opts *syntax.FileOptions, filename string, src interface{}, isPredeclared func(string) bool) (*syntax.File, *Program, error) {
+	f, err := opts.Parse(filename, src, 0)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -307,7 +1083,11 @@ func SourceProgram(filename string, src interface{}, isPredeclared func(string)
 // Its typical value is predeclared.Has,
 // where predeclared is a StringDict of pre-declared values.
 func FileProgram(f *syntax.File, isPredeclared func(string) bool) (*Program, error) {
-	if err := resolve.File(f, isPredeclared, Universe.Has); err != nil {
+	return fileProgram(f, isPredeclared, Universe.Has)
+}
+
+func fileProgram(f *syntax.File, isPredeclared, isUniversal func(string) bool) (*Program, error) {
+	if err := resolve.File(f, isPredeclared, isUniversal); err != nil {
 		return nil, err
 	}
 
@@ -320,12 +1100,16 @@ func FileProgram(f *syntax.File, isPredeclared func(string) bool) (*Program, err
 
 	module := f.Module.(*resolve.Module)
 	compiled := compile.File(f.Stmts, pos, "<toplevel>", module.Locals, module.Globals)
+	compiled.Recursion = module.Options.AllowRecursion
+	compiled.Float = module.Options.AllowFloat
 
-	return &Program{compiled}, nil
+	return &Program{compiled, module.Options, module.Warnings}, nil
 }
 
 // CompiledProgram produces a new program from the representation
 // of a compiled program previously saved by Program.Write.
+// It returns an error if the program was compiled by a version
+// of the compiler other than CompilerVersion.
 func CompiledProgram(in io.Reader) (*Program, error) {
 	data, err := ioutil.ReadAll(in)
 	if err != nil {
@@ -335,14 +1119,47 @@ func CompiledProgram(in io.Reader) (*Program, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Program{compiled}, nil
+	return &Program{compiled, nil, nil}, nil
 }
 
 // Init creates a set of global variables for the program,
 // executes the toplevel code of the specified program,
 // and returns a new, unfrozen dictionary of the globals.
+//
+// Init returns an error without executing any code if predeclared
+// lacks any of the names reported by Program.Predeclared.
+//
+// Before executing the code, Init reports the warnings of the
+// resolver, such as unreachable code, to the thread; see Thread.Warn.
+// A program obtained from CompiledProgram has no warnings.
+//
+// If execution succeeds and the program defines the global __all__,
+// only the names it lists are returned; see Exports.
 func (prog *Program) Init(thread *Thread, predeclared StringDict) (StringDict, error) {
-	toplevel := makeToplevelFunction(prog.compiled, predeclared)
+	g, err := prog.init(thread, predeclared, nil)
+	if err != nil {
+		return g, err
+	}
+	return Exports(g)
+}
+
+// init is like Init but returns all the globals of the program.
+// Each global named in prior starts with the value given there,
+// rather than unbound.
+func (prog *Program) init(thread *Thread, predeclared, prior StringDict) (StringDict, error) {
+	for _, name := range prog.compiled.Predeclared {
+		if predeclared[name] == nil {
+			return nil, fmt.Errorf("%s: undefined: %s", prog.Filename(), name)
+		}
+	}
+	for _, w := range prog.warnings {
+		thread.warn(w.Pos, w.Msg)
+	}
+
+	toplevel := makeToplevelFunction(prog.compiled, prog.options, predeclared)
+	for i, b := range prog.compiled.Globals {
+		toplevel.module.globals[i] = prior[b.Name]
+	}
 
 	_, err := Call(thread, toplevel, nil, nil)
 
@@ -351,7 +1168,7 @@ func (prog *Program) Init(thread *Thread, predeclared StringDict) (StringDict, e
 	return toplevel.Globals(), err
 }
 
-func makeToplevelFunction(prog *compile.Program, predeclared StringDict) *Function {
+func makeToplevelFunction(prog *compile.Program, opts *syntax.FileOptions, predeclared StringDict) *Function {
 	// Create the Starlark value denoted by each program constant c.
 	constants := make([]Value, len(prog.Constants))
 	for i, c := range prog.Constants {
@@ -375,6 +1192,7 @@ func makeToplevelFunction(prog *compile.Program, predeclared StringDict) *Functi
 		funcode: prog.Toplevel,
 		module: &module{
 			program:     prog,
+			options:     opts,
 			predeclared: predeclared,
 			globals:     make([]Value, len(prog.Globals)),
 			constants:   constants,
@@ -393,11 +1211,17 @@ func makeToplevelFunction(prog *compile.Program, predeclared StringDict) *Functi
 // If Eval fails during evaluation, it returns an *EvalError
 // containing a backtrace.
 func Eval(thread *Thread, filename string, src interface{}, env StringDict) (Value, error) {
+	return EvalOptions(nil, thread, filename, src, env)
+}
+
+// EvalOptions is a variant of Eval that uses the specified dialect,
+// or that of the global options of the resolve package if opts is nil.
+func EvalOptions(opts *syntax.FileOptions, thread *Thread, filename string, src interface{}, env StringDict) (Value, error) {
 	expr, err := syntax.ParseExpr(filename, src, 0)
 	if err != nil {
 		return nil, err
 	}
-	f, err := makeExprFunc(expr, env)
+	f, err := makeExprFunc(opts, expr, env)
 	if err != nil {
 		return nil, err
 	}
@@ -417,7 +1241,14 @@ func Eval(thread *Thread, filename string, src interface{}, env StringDict) (Val
 // If Eval fails during evaluation, it returns an *EvalError
 // containing a backtrace.
 func EvalExpr(thread *Thread, expr syntax.Expr, env StringDict) (Value, error) {
-	fn, err := makeExprFunc(expr, env)
+	return EvalExprOptions(nil, thread, expr, env)
+}
+
+// EvalExprOptions is a variant of EvalExpr that uses the specified
+// dialect, or that of the global options of the resolve package if
+// opts is nil.
+func EvalExprOptions(opts *syntax.FileOptions, thread *Thread, expr syntax.Expr, env StringDict) (Value, error) {
+	fn, err := makeExprFunc(opts, expr, env)
 	if err != nil {
 		return nil, err
 	}
@@ -427,38 +1258,66 @@ func EvalExpr(thread *Thread, expr syntax.Expr, env StringDict) (Value, error) {
 // ExprFunc returns a no-argument function
 // that evaluates the expression whose source is src.
 func ExprFunc(filename string, src interface{}, env StringDict) (*Function, error) {
+	return ExprFuncOptions(nil, filename, src, env)
+}
+
+// ExprFuncOptions is a variant of ExprFunc that uses the specified
+// dialect, or that of the global options of the resolve package if
+// opts is nil.
+func ExprFuncOptions(opts *syntax.FileOptions, filename string, src interface{}, env StringDict) (*Function, error) {
 	expr, err := syntax.ParseExpr(filename, src, 0)
 	if err != nil {
 		return nil, err
 	}
-	return makeExprFunc(expr, env)
+	return makeExprFunc(opts, expr, env)
 }
 
-// makeExprFunc returns a no-argument function whose body is expr.
-func makeExprFunc(expr syntax.Expr, env StringDict) (*Function, error) {
-	locals, err := resolve.Expr(expr, env.Has, Universe.Has)
+// makeExprFunc returns a no-argument function whose body is expr,
+// in the dialect specified by opts, or by the global options if nil.
+func makeExprFunc(opts *syntax.FileOptions, expr syntax.Expr, env StringDict) (*Function, error) {
+	if opts == nil {
+		opts = resolve.GlobalOptions()
+	}
+	locals, err := resolve.ExprOptions(opts, expr, env.Has, Universe.Has)
 	if err != nil {
 		return nil, err
 	}
 
-	return makeToplevelFunction(compile.Expr(expr, "<expr>", locals), env), nil
+	compiled := compile.Expr(expr, "<expr>", locals)
+	compiled.Recursion = opts.AllowRecursion
+	compiled.Float = opts.AllowFloat
+	return makeToplevelFunction(compiled, opts, env), nil
 }
 
 // The following functions are primitive operations of the byte code interpreter.
 
 // list += iterable
-func listExtend(x *List, y Iterable) {
+func listExtend(thread *Thread, x *List, y Iterable) error {
+	// Charge for the new elements up front if their number is known,
+	// or else one at a time.
+	n := Len(y)
+	if n > 0 {
+		if err := thread.AddAllocs(uint64(n) * valueSize); err != nil {
+			return err
+		}
+	}
 	if ylist, ok := y.(*List); ok {
 		// fast path: list += list
 		x.elems = append(x.elems, ylist.elems...)
-	} else {
-		iter := y.Iterate()
-		defer iter.Done()
-		var z Value
-		for iter.Next(&z) {
-			x.elems = append(x.elems, z)
+		return nil
+	}
+	iter := IterateThread(thread, y)
+	defer iter.Done()
+	var z Value
+	for iter.Next(&z) {
+		if n < 0 {
+			if err := thread.AddAllocs(valueSize); err != nil {
+				return err
+			}
 		}
+		x.elems = append(x.elems, z)
 	}
+	return IterErr(iter)
 }
 
 // getAttr implements x.dot.
@@ -506,6 +1365,26 @@ func setField(x Value, name string, y Value) error {
 	return fmt.Errorf("can't assign to .%s field of %s", name, x.Type())
 }
 
+// delField implements del x.name.
+func delField(x Value, name string) error {
+	if x, ok := x.(HasDelField); ok {
+		err := x.DelField(name)
+		if _, ok := err.(NoSuchAttrError); ok {
+			// No such field: check spelling.
+			if n := spell.Nearest(name, x.AttrNames()); n != "" {
+				err = fmt.Errorf("%s (did you mean .%s?)", err, n)
+			}
+		}
+		return err
+	}
+
+	return fmt.Errorf("can't delete .%s field of %s", name, x.Type())
+}
+
+// GetIndex returns the result of the index expression x[y],
+// reporting the same errors as the expression would.
+func GetIndex(x, y Value) (Value, error) { return getIndex(x, y) }
+
 // getIndex implements x[y].
 func getIndex(x, y Value) (Value, error) {
 	switch x := x.(type) {
@@ -574,6 +1453,32 @@ func setIndex(x, y, z Value) error {
 	return nil
 }
 
+// delIndex implements del x[y].
+func delIndex(x, y Value) error {
+	switch x := x.(type) {
+	case HasDelKey:
+		return x.DelKey(y)
+
+	case HasDelIndex:
+		n := x.Len()
+		i, err := AsInt32(y)
+		if err != nil {
+			return fmt.Errorf("%s index: %s", x.Type(), err)
+		}
+		origI := i
+		if i < 0 {
+			i += n
+		}
+		if i < 0 || i >= n {
+			return outOfRange(origI, n, x)
+		}
+		return x.DelIndex(i)
+
+	default:
+		return fmt.Errorf("%s value does not support item deletion", x.Type())
+	}
+}
+
 // Unary applies a unary operator (+, -, ~, not) to its operand.
 func Unary(op syntax.Token, x Value) (Value, error) {
 	// The NOT operator is not customizable.
@@ -595,7 +1500,32 @@ func Unary(op syntax.Token, x Value) (Value, error) {
 
 // Binary applies a strict binary operator (not AND or OR) to its operands.
 // For equality tests or ordered comparisons, use Compare instead.
+// Because Binary has no thread, the %s conversion of the % operator
+// formats floats in the default form, as if by a thread with no
+// float format; see Thread.SetFloatFormat.
 func Binary(op syntax.Token, x, y Value) (Value, error) {
+	return evalBinary(nil, op, x, y)
+}
+
+// evalBinary implements Binary on behalf of thread, which may be nil.
+// The %s conversion of the % operator honors the thread's float format.
+func evalBinary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
+	// An arithmetic operation on an int and a float converts the int
+	// to a float first, as if by float(int), so the result is a float.
+	switch op {
+	case syntax.PLUS, syntax.MINUS, syntax.STAR, syntax.SLASH, syntax.SLASHSLASH, syntax.PERCENT:
+		switch xi := x.(type) {
+		case Int:
+			if _, ok := y.(Float); ok {
+				x = xi.Float()
+			}
+		case Float:
+			if yi, ok := y.(Int); ok {
+				y = yi.Float()
+			}
+		}
+	}
+
 	switch op {
 	case syntax.PLUS:
 		switch x := x.(type) {
@@ -607,15 +1537,11 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 			switch y := y.(type) {
 			case Int:
 				return x.Add(y), nil
-			case Float:
-				return x.Float() + y, nil
 			}
 		case Float:
 			switch y := y.(type) {
 			case Float:
 				return x + y, nil
-			case Int:
-				return x + y.Float(), nil
 			}
 		case *List:
 			if y, ok := y.(*List); ok {
@@ -639,15 +1565,11 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 			switch y := y.(type) {
 			case Int:
 				return x.Sub(y), nil
-			case Float:
-				return x.Float() - y, nil
 			}
 		case Float:
 			switch y := y.(type) {
 			case Float:
 				return x - y, nil
-			case Int:
-				return x - y.Float(), nil
 			}
 		}
 
@@ -657,8 +1579,6 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 			switch y := y.(type) {
 			case Int:
 				return x.Mul(y), nil
-			case Float:
-				return x.Float() * y, nil
 			case String:
 				return stringRepeat(y, x)
 			case *List:
@@ -674,8 +1594,6 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 			switch y := y.(type) {
 			case Float:
 				return x * y, nil
-			case Int:
-				return x * y.Float(), nil
 			}
 		case String:
 			if y, ok := y.(Int); ok {
@@ -701,16 +1619,10 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 		case Int:
 			switch y := y.(type) {
 			case Int:
-				yf := y.Float()
-				if yf == 0.0 {
-					return nil, fmt.Errorf("real division by zero")
-				}
-				return x.Float() / yf, nil
-			case Float:
-				if y == 0.0 {
+				if y.Sign() == 0 {
 					return nil, fmt.Errorf("real division by zero")
 				}
-				return x.Float() / y, nil
+				return x.TrueDiv(y), nil
 			}
 		case Float:
 			switch y := y.(type) {
@@ -719,12 +1631,6 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 					return nil, fmt.Errorf("real division by zero")
 				}
 				return x / y, nil
-			case Int:
-				yf := y.Float()
-				if yf == 0.0 {
-					return nil, fmt.Errorf("real division by zero")
-				}
-				return x / yf, nil
 			}
 		}
 
@@ -737,11 +1643,6 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 					return nil, fmt.Errorf("floored division by zero")
 				}
 				return x.Div(y), nil
-			case Float:
-				if y == 0.0 {
-					return nil, fmt.Errorf("floored division by zero")
-				}
-				return floor((x.Float() / y)), nil
 			}
 		case Float:
 			switch y := y.(type) {
@@ -749,13 +1650,7 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 				if y == 0.0 {
 					return nil, fmt.Errorf("floored division by zero")
 				}
-				return floor(x / y), nil
-			case Int:
-				yf := y.Float()
-				if yf == 0.0 {
-					return nil, fmt.Errorf("floored division by zero")
-				}
-				return floor(x / yf), nil
+				return floorDiv(x, y), nil
 			}
 		}
 
@@ -768,11 +1663,6 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 					return nil, fmt.Errorf("integer modulo by zero")
 				}
 				return x.Mod(y), nil
-			case Float:
-				if y == 0 {
-					return nil, fmt.Errorf("float modulo by zero")
-				}
-				return x.Float().Mod(y), nil
 			}
 		case Float:
 			switch y := y.(type) {
@@ -780,15 +1670,14 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 				if y == 0.0 {
 					return nil, fmt.Errorf("float modulo by zero")
 				}
-				return Float(math.Mod(float64(x), float64(y))), nil
-			case Int:
-				if y.Sign() == 0 {
-					return nil, fmt.Errorf("float modulo by zero")
-				}
-				return x.Mod(y.Float()), nil
+				return x.Mod(y), nil
 			}
 		case String:
-			return interpolate(string(x), y)
+			var floatFormat string
+			if thread != nil {
+				floatFormat = thread.floatFormat
+			}
+			return interpolate(string(x), y, floatFormat)
 		}
 
 	case syntax.NOT_IN:
@@ -823,7 +1712,7 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 			// errors (value cycle, type error) from "key not found".
 			_, found, _ := y.Get(x)
 			return Bool(found), nil
-		case *Set:
+		case setValue:
 			ok, err := y.Has(x)
 			return Bool(ok), err
 		case String:
@@ -847,7 +1736,13 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 				return x.Or(y), nil
 			}
 		case *Set: // union
-			if y, ok := y.(*Set); ok {
+			if y, ok := y.(setValue); ok {
+				iter := Iterate(y)
+				defer iter.Done()
+				return x.Union(iter)
+			}
+		case *FrozenSet: // union
+			if y, ok := y.(setValue); ok {
 				iter := Iterate(y)
 				defer iter.Done()
 				return x.Union(iter)
@@ -860,19 +1755,17 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 			if y, ok := y.(Int); ok {
 				return x.And(y), nil
 			}
-		case *Set: // intersection
-			if y, ok := y.(*Set); ok {
+		case setValue: // intersection
+			if y, ok := y.(setValue); ok {
+				// Elements appear in the order of the left operand.
 				set := new(Set)
-				if x.Len() > y.Len() {
-					x, y = y, x // opt: range over smaller set
-				}
 				for _, xelem := range x.elems() {
 					// Has, Insert cannot fail here.
 					if found, _ := y.Has(xelem); found {
 						set.Insert(xelem)
 					}
 				}
-				return set, nil
+				return makeSetOp(x, set), nil
 			}
 		}
 
@@ -882,8 +1775,8 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 			if y, ok := y.(Int); ok {
 				return x.Xor(y), nil
 			}
-		case *Set: // symmetric difference
-			if y, ok := y.(*Set); ok {
+		case setValue: // symmetric difference
+			if y, ok := y.(setValue); ok {
 				set := new(Set)
 				for _, xelem := range x.elems() {
 					if found, _ := y.Has(xelem); !found {
@@ -895,26 +1788,32 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 						set.Insert(yelem)
 					}
 				}
-				return set, nil
+				return makeSetOp(x, set), nil
 			}
 		}
 
 	case syntax.LTLT, syntax.GTGT:
 		if x, ok := x.(Int); ok {
-			y, err := AsInt32(y)
-			if err != nil {
-				return nil, err
+			count, ok := y.(Int)
+			if !ok {
+				return nil, fmt.Errorf("got %s, want int", y.Type())
 			}
-			if y < 0 {
-				return nil, fmt.Errorf("negative shift count: %v", y)
+			if count.Sign() < 0 {
+				return nil, fmt.Errorf("negative shift count: %v", count)
 			}
+			// A count outside the int32 range is large
+			// enough to shift out every bit of x.
+			n, err := AsInt32(count)
 			if op == syntax.LTLT {
-				if y >= 512 {
-					return nil, fmt.Errorf("shift count too large: %v", y)
+				if err != nil || n >= 512 {
+					return nil, fmt.Errorf("shift count too large: %v", count)
 				}
-				return x.Lsh(uint(y)), nil
+				return x.Lsh(uint(n)), nil
 			} else {
-				return x.Rsh(uint(y)), nil
+				if err != nil {
+					return x.Rsh(math.MaxInt32), nil // -1 or 0
+				}
+				return x.Rsh(uint(n)), nil
 			}
 		}
 
@@ -938,15 +1837,177 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 		}
 	}
 
+	// x in y for any other Indexable or Iterable y
+	if op == syntax.IN {
+		if z, err := contains(thread, y, x); z != nil || err != nil {
+			return z, err
+		}
+	}
+
 	// unsupported operand types
 unknown:
 	return nil, fmt.Errorf("unknown binary op: %s %s %s", x.Type(), op, y.Type())
 }
 
+// contains reports whether some element of y equals x,
+// or returns (nil, nil) if y is neither Indexable nor Iterable.
+// Any functions that compute the elements of y are called on thread.
+func contains(thread *Thread, y, x Value) (Value, error) {
+	switch y := y.(type) {
+	case Indexable:
+		for i, n := 0, y.Len(); i < n; i++ {
+			if eq, err := Equal(y.Index(i), x); err != nil {
+				return nil, err
+			} else if eq {
+				return True, nil
+			}
+		}
+		return False, nil
+	case Iterable:
+		iter := IterateThread(thread, y)
+		defer iter.Done()
+		var elem Value
+		for iter.Next(&elem) {
+			if eq, err := Equal(elem, x); err != nil {
+				return nil, err
+			} else if eq {
+				return True, nil
+			}
+		}
+		if err := IterErr(iter); err != nil {
+			return nil, err
+		}
+		return False, nil
+	}
+	return nil, nil
+}
+
+// Approximate sizes, in bytes, of values for the purpose of SetMaxAllocs.
+const (
+	valueSize     = 16 // a Value interface, such as a list element
+	dictEntrySize = 48 // a dict entry: hash, key, and value
+)
+
+// binaryAllocs returns the approximate number of bytes that
+// Binary(op, x, y) would allocate, for the operations whose
+// result may be much larger than their operands.
+func binaryAllocs(op syntax.Token, x, y Value) uint64 {
+	if x, ok := x.(Int); ok {
+		if y, ok := y.(Int); ok {
+			return intAllocs(op, x, y)
+		}
+	}
+	switch op {
+	case syntax.PLUS:
+		switch x := x.(type) {
+		case String:
+			if y, ok := y.(String); ok {
+				return uint64(len(x)) + uint64(len(y))
+			}
+		case *List:
+			if y, ok := y.(*List); ok {
+				return uint64(x.Len()+y.Len()) * valueSize
+			}
+		case Tuple:
+			if y, ok := y.(Tuple); ok {
+				return uint64(len(x)+len(y)) * valueSize
+			}
+		}
+
+	case syntax.STAR:
+		if _, ok := x.(Int); ok {
+			x, y = y, x
+		}
+		n, ok := y.(Int)
+		if !ok || n.Sign() <= 0 {
+			return 0
+		}
+		var size uint64
+		switch x := x.(type) {
+		case String:
+			size = uint64(len(x))
+		case *List:
+			size = uint64(x.Len()) * valueSize
+		case Tuple:
+			size = uint64(len(x)) * valueSize
+		default:
+			return 0
+		}
+		count, ok := n.Uint64()
+		if !ok {
+			count = math.MaxUint64
+		}
+		hi, lo := bits.Mul64(size, count)
+		if hi != 0 {
+			return math.MaxUint64
+		}
+		return lo
+
+	}
+	return 0
+}
+
+// intAllocs returns the approximate number of bytes that x op y
+// would allocate for its result.
+func intAllocs(op syntax.Token, x, y Int) uint64 {
+	if op == syntax.LTLT {
+		if shift, ok := y.Uint64(); ok {
+			return (uint64(intBitLen(x)) + shift + 7) / 8
+		}
+		return 0
+	}
+
+	// Other operations allocate only if the result may be too
+	// large for a small int.
+	xbits, ybits := intBitLen(x), intBitLen(y)
+	wider := xbits
+	if ybits > wider {
+		wider = ybits
+	}
+	var bits int
+	switch op {
+	case syntax.PLUS, syntax.MINUS:
+		bits = wider + 1
+	case syntax.STAR:
+		bits = xbits + ybits
+	case syntax.SLASHSLASH:
+		bits = xbits
+	case syntax.PERCENT:
+		bits = ybits
+	case syntax.PIPE, syntax.AMP, syntax.CIRCUMFLEX:
+		bits = wider
+	}
+	if bits < 32 {
+		return 0
+	}
+	return uint64(bits+7) / 8
+}
+
+// intBitLen returns the length in bits of the absolute value of x.
+func intBitLen(x Int) int {
+	if x.big != nil {
+		return x.big.BitLen()
+	}
+	if x.small < 0 {
+		return bits.Len64(uint64(-x.small))
+	}
+	return bits.Len64(uint64(x.small))
+}
+
 // It's always possible to overeat in small bites but we'll
 // try to stop someone swallowing the world in one gulp.
 const maxAlloc = 1 << 30
 
+// repeatSize returns the length of n > 0 repetitions of a sequence
+// of length size > 0, or an error if it is not less than maxAlloc.
+func repeatSize(size, n int) (int, error) {
+	// Compare n with the rounded-up quotient, as the product may overflow.
+	if int64(n) >= (maxAlloc+int64(size)-1)/int64(size) {
+		return 0, fmt.Errorf("excessive repeat (%d elements)", int64(size)*int64(n))
+	}
+	return size * n, nil
+}
+
 func tupleRepeat(elems Tuple, n Int) (Tuple, error) {
 	if len(elems) == 0 {
 		return nil, nil
@@ -959,9 +2020,9 @@ func tupleRepeat(elems Tuple, n Int) (Tuple, error) {
 		return nil, nil
 	}
 	// Inv: i > 0, len > 0
-	sz := len(elems) * i
-	if sz < 0 || sz >= maxAlloc { // sz < 0 => overflow
-		return nil, fmt.Errorf("excessive repeat (%d elements)", sz)
+	sz, err := repeatSize(len(elems), i)
+	if err != nil {
+		return nil, err
 	}
 	res := make([]Value, sz)
 	// copy elems into res, doubling each time
@@ -985,9 +2046,8 @@ func stringRepeat(s String, n Int) (String, error) {
 		return "", nil
 	}
 	// Inv: i > 0, len > 0
-	sz := len(s) * i
-	if sz < 0 || sz >= maxAlloc { // sz < 0 => overflow
-		return "", fmt.Errorf("excessive repeat (%d elements)", sz)
+	if _, err := repeatSize(len(s), i); err != nil {
+		return "", err
 	}
 	return String(strings.Repeat(string(s), i)), nil
 }
@@ -999,6 +2059,14 @@ func Call(thread *Thread, fn Value, args Tuple, kwargs []Tuple) (Value, error) {
 		return nil, fmt.Errorf("invalid call of non-function (%s)", fn.Type())
 	}
 
+	max := thread.maxCallDepth
+	if max <= 0 {
+		max = DefaultMaxCallDepth
+	}
+	if len(thread.stack) >= max {
+		return nil, thread.evalError(fmt.Errorf("maximum recursion depth exceeded (%d)", max))
+	}
+
 	// Allocate and push a new frame.
 	var fr *frame
 	// Optimization: use slack portion of thread.stack
@@ -1294,7 +2362,8 @@ func findParam(params []compile.Binding, name string) int {
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string-interpolation
-func interpolate(format string, x Value) (Value, error) {
+// The %s conversion formats floats by the fmt verb floatFormat, if non-empty.
+func interpolate(format string, x Value, floatFormat string) (Value, error) {
 	buf := new(strings.Builder)
 	index := 0
 	nargs := 1
@@ -1356,12 +2425,14 @@ func interpolate(format string, x Value) (Value, error) {
 			return nil, fmt.Errorf("incomplete format")
 		}
 		switch c := format[0]; c {
-		case 's', 'r':
-			if str, ok := AsString(arg); ok && c == 's' {
+		case 's':
+			if str, ok := AsString(arg); ok {
 				buf.WriteString(str)
 			} else {
-				writeValue(buf, arg, nil)
+				writeValueMode(buf, arg, nil, floatFormat)
 			}
+		case 'r':
+			writeValue(buf, arg, nil)
 		case 'd', 'i', 'o', 'x', 'X':
 			i, err := NumberToInt(arg)
 			if err != nil {
@@ -6,11 +6,14 @@ package pkgscript_test
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"math"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/andrewchambers/pkgscript/internal/chunkedfile"
@@ -110,7 +113,9 @@ func TestExecFile(t *testing.T) {
 		"testdata/bool.star",
 		"testdata/builtins.star",
 		"testdata/control.star",
+		"testdata/decimal.star",
 		"testdata/dict.star",
+		"testdata/fixedint.star",
 		"testdata/float.star",
 		"testdata/function.star",
 		"testdata/int.star",
@@ -176,8 +181,74 @@ func (it *fibIterator) Next(p *pkgscript.Value) bool {
 }
 func (it *fibIterator) Done() {}
 
+// TestAnyAllShortCircuit ensures that any and all stop iterating
+// at the first element that decides the result.
+func TestAnyAllShortCircuit(t *testing.T) {
+	for _, test := range []struct {
+		src   string
+		elems []pkgscript.Value
+		want  pkgscript.Bool
+		pulls int
+	}{
+		{"any(x)", []pkgscript.Value{pkgscript.False, pkgscript.MakeInt(0), pkgscript.String("a"), pkgscript.True}, true, 3},
+		{"any(x)", []pkgscript.Value{pkgscript.False, pkgscript.None}, false, 2},
+		{"all(x)", []pkgscript.Value{pkgscript.True, pkgscript.String(""), pkgscript.True}, false, 2},
+		{"all(x)", []pkgscript.Value{pkgscript.True, pkgscript.MakeInt(1)}, true, 2},
+	} {
+		x := &countingIterable{elems: test.elems}
+		thread := new(pkgscript.Thread)
+		v, err := pkgscript.Eval(thread, "<expr>", test.src, pkgscript.StringDict{"x": x})
+		if err != nil {
+			t.Errorf("%s: %v", test.src, err)
+			continue
+		}
+		if v != test.want {
+			t.Errorf("%s over %v = %v, want %v", test.src, test.elems, v, test.want)
+		}
+		if x.pulls != test.pulls {
+			t.Errorf("%s over %v pulled %d elements, want %d", test.src, test.elems, x.pulls, test.pulls)
+		}
+	}
+}
+
+// A countingIterable is an iterable that records how many elements
+// its iterators have yielded.
+type countingIterable struct {
+	elems []pkgscript.Value
+	pulls int
+}
+
+func (c *countingIterable) Freeze()               {}
+func (c *countingIterable) String() string        { return "countingIterable" }
+func (c *countingIterable) Type() string          { return "countingIterable" }
+func (c *countingIterable) Truth() pkgscript.Bool { return true }
+func (c *countingIterable) Hash() (uint32, error) {
+	return 0, fmt.Errorf("countingIterable is unhashable")
+}
+func (c *countingIterable) Iterate() pkgscript.Iterator { return &countingIterator{c, 0} }
+
+type countingIterator struct {
+	c *countingIterable
+	i int
+}
+
+func (it *countingIterator) Next(p *pkgscript.Value) bool {
+	if it.i == len(it.c.elems) {
+		return false
+	}
+	*p = it.c.elems[it.i]
+	it.i++
+	it.c.pulls++
+	return true
+}
+func (it *countingIterator) Done() {}
+
 // load implements the 'load' operation as used in the evaluator tests.
-func load(thread *pkgscript.Thread, module string) (pkgscript.StringDict, error) {
+func load(thread *pkgscript.Thread, modval pkgscript.Value) (pkgscript.StringDict, error) {
+	module, ok := pkgscript.AsString(modval)
+	if !ok {
+		return nil, fmt.Errorf("module not a string")
+	}
 	if module == "assert.star" {
 		return pkgscripttest.LoadAssertModule()
 	}
@@ -204,8 +275,9 @@ type hasfields struct {
 }
 
 var (
-	_ pkgscript.HasAttrs  = (*hasfields)(nil)
-	_ pkgscript.HasBinary = (*hasfields)(nil)
+	_ pkgscript.HasAttrs    = (*hasfields)(nil)
+	_ pkgscript.HasBinary   = (*hasfields)(nil)
+	_ pkgscript.HasDelField = (*hasfields)(nil)
 )
 
 func (hf *hasfields) String() string        { return "hasfields" }
@@ -235,6 +307,17 @@ func (hf *hasfields) SetField(name string, val pkgscript.Value) error {
 	return nil
 }
 
+func (hf *hasfields) DelField(name string) error {
+	if hf.frozen {
+		return fmt.Errorf("cannot delete field of a frozen hasfields")
+	}
+	if _, ok := hf.attrs[name]; !ok {
+		return pkgscript.NoSuchAttrError(fmt.Sprintf("no .%s field", name))
+	}
+	delete(hf.attrs, name)
+	return nil
+}
+
 func (hf *hasfields) AttrNames() []string {
 	names := make([]string, 0, len(hf.attrs))
 	for key := range hf.attrs {
@@ -518,6 +601,67 @@ Error: floored division by zero`
 		t.Errorf("error was %s, want %s", got, want)
 	}
 
+	// The same frames are available as data.
+	var frames []string
+	for _, fr := range err.(*pkgscript.EvalError).Stack() {
+		frames = append(frames, fmt.Sprintf("%s:%d:%t", fr.Function, fr.Pos.Line, fr.Builtin))
+	}
+	if got, want := strings.Join(frames, " "), "<toplevel>:6:false i:5:false h:4:false min:0:true g:3:false f:2:false"; got != want {
+		t.Errorf("Stack() = %s, want %s", got, want)
+	}
+
+	// Errors in a case called by switch include the switch frame.
+	const src3 = `
+def f(): return 1//0
+switch(0, {0: f})
+`
+	_, err = pkgscript.ExecFile(thread, "crash.star", src3, nil)
+	const want3 = `Traceback (most recent call last):
+  crash.star:3:7: in <toplevel>
+  <builtin>: in switch
+  crash.star:2:18: in f
+Error: floored division by zero`
+	if got := getBacktrace(err); got != want3 {
+		t.Errorf("error was %s, want %s", got, want3)
+	}
+
+	// Errors in a function called by a lazy iterable are reported
+	// by the operation that iterates over it.
+	const src4 = `
+def f(x, y): return x//y
+acc = accumulate([1, 0], f)
+def g(): return list(acc)
+g()
+`
+	_, err = pkgscript.ExecFile(thread, "crash.star", src4, nil)
+	const want4 = `Traceback (most recent call last):
+  crash.star:5:2: in <toplevel>
+  crash.star:4:21: in g
+  <builtin>: in list
+  crash.star:2:22: in f
+Error: floored division by zero`
+	if got := getBacktrace(err); got != want4 {
+		t.Errorf("error was %s, want %s", got, want4)
+	}
+
+	// Likewise for map and filter.
+	const src5 = `
+def f(x): return 1//x
+m = filter(None, map(f, [1, 0]))
+def g(): return sorted(m)
+g()
+`
+	_, err = pkgscript.ExecFile(thread, "crash.star", src5, nil)
+	const want5 = `Traceback (most recent call last):
+  crash.star:5:2: in <toplevel>
+  crash.star:4:23: in g
+  <builtin>: in sorted
+  crash.star:2:19: in f
+Error: floored division by zero`
+	if got := getBacktrace(err); got != want5 {
+		t.Errorf("error was %s, want %s", got, want5)
+	}
+
 	// Additionally, ensure that errors originating in
 	// Starlark and/or Go each have an accurate frame.
 	//
@@ -546,160 +690,1615 @@ Error: join: in list, want string, got int`,
 	}
 }
 
-// TestRepeatedExec parses and resolves a file syntax tree once then
-// executes it repeatedly with different values of its predeclared variables.
-func TestRepeatedExec(t *testing.T) {
-	predeclared := pkgscript.StringDict{"x": pkgscript.None}
-	_, prog, err := pkgscript.SourceProgram("repeat.star", "y = 2 * x", predeclared.Has)
+// TestLazyIterableThread ensures that the functions called by a lazy
+// iterable run on the thread that consumes it, not the one that
+// created it.
+func TestLazyIterableThread(t *testing.T) {
+	const lib = `
+def f(x):
+    for i in range(1000):
+        pass
+    return x
+m = map(f, range(100))
+`
+	globals, err := pkgscript.ExecFile(new(pkgscript.Thread), "lib.star", lib, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	for _, test := range []struct {
-		x, want pkgscript.Value
-	}{
-		{x: pkgscript.MakeInt(42), want: pkgscript.MakeInt(84)},
-		{x: pkgscript.String("mur"), want: pkgscript.String("murmur")},
-		{x: pkgscript.Tuple{pkgscript.None}, want: pkgscript.Tuple{pkgscript.None, pkgscript.None}},
-	} {
-		predeclared["x"] = test.x // update the values in dictionary
-		thread := new(pkgscript.Thread)
-		if globals, err := prog.Init(thread, predeclared); err != nil {
-			t.Errorf("x=%v: %v", test.x, err) // exec error
-		} else if eq, err := pkgscript.Equal(globals["y"], test.want); err != nil {
-			t.Errorf("x=%v: %v", test.x, err) // comparison error
-		} else if !eq {
-			t.Errorf("x=%v: got y=%v, want %v", test.x, globals["y"], test.want)
-		}
+	parent := new(pkgscript.Thread)
+	parent.SetMaxExecutionSteps(5000)
+	child := parent.NewChild()
+	_, err = pkgscript.ExecFile(child, "use.star", "def g(): return list(m)\ng()\n", globals)
+	if err == nil {
+		t.Fatal("consuming map on a limited thread succeeded unexpectedly")
+	}
+	const want = `Traceback (most recent call last):
+  use.star:2:2: in <toplevel>
+  use.star:1:21: in g
+  <builtin>: in list
+  lib.star:3:5: in f
+Error: too many steps: execution step limit (5000) exceeded`
+	if got := err.(*pkgscript.EvalError).Backtrace(); got != want {
+		t.Errorf("error was %s, want %s", got, want)
+	}
+
+	// Without a thread, the function cannot be called.
+	iter := globals["m"].(pkgscript.Iterable).Iterate()
+	defer iter.Done()
+	var x pkgscript.Value
+	if iter.Next(&x) {
+		t.Errorf("Iterate without a thread yielded %v", x)
+	} else if err := pkgscript.IterErr(iter); err == nil || !strings.Contains(err.Error(), "without a thread") {
+		t.Errorf("Iterate without a thread: got error %v", err)
 	}
 }
 
-// TestEmptyFilePosition ensures that even Programs
-// from empty files have a valid position.
-func TestEmptyPosition(t *testing.T) {
-	var predeclared pkgscript.StringDict
-	for _, content := range []string{"", "empty = False"} {
-		_, prog, err := pkgscript.SourceProgram("hello.star", content, predeclared.Has)
-		if err != nil {
-			t.Fatal(err)
-		}
-		if got, want := prog.Filename(), "hello.star"; got != want {
-			t.Errorf("Program.Filename() = %q, want %q", got, want)
-		}
+// TestEvalErrorUnwrap ensures that the error returned by a failing
+// built-in is accessible through the EvalError, via any number of
+// Starlark call frames.
+func TestEvalErrorUnwrap(t *testing.T) {
+	sentinel := errors.New("quota exceeded")
+	spend := pkgscript.NewBuiltin("spend", func(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+		return nil, fmt.Errorf("%s: %w", b.Name(), sentinel)
+	})
+	const src = `
+def f(): spend()
+def g(): f()
+g()
+`
+	thread := new(pkgscript.Thread)
+	_, err := pkgscript.ExecFile(thread, "spend.star", src, pkgscript.StringDict{"spend": spend})
+	if _, ok := err.(*pkgscript.EvalError); !ok {
+		t.Fatalf("ExecFile returned %v, want *EvalError", err)
+	}
+	if !errors.Is(err, sentinel) {
+		t.Errorf("errors.Is(%v, sentinel) = false", err)
+	}
+	if got, want := err.Error(), "spend: quota exceeded"; got != want {
+		t.Errorf("error was %q, want %q", got, want)
 	}
 }
 
-// TestUnpackUserDefined tests that user-defined
-// implementations of pkgscript.Value may be unpacked.
-func TestUnpackUserDefined(t *testing.T) {
-	// success
-	want := new(hasfields)
-	var x *hasfields
-	if err := pkgscript.UnpackArgs("unpack", pkgscript.Tuple{want}, nil, "x", &x); err != nil {
-		t.Errorf("UnpackArgs failed: %v", err)
+func TestFail(t *testing.T) {
+	const src = `
+def check(name):
+    if name != "ok":
+        fail("bad config:", name)
+check("foo")
+`
+	_, err := pkgscript.ExecFile(new(pkgscript.Thread), "fail.star", src, nil)
+	var failErr *pkgscript.FailError
+	if !errors.As(err, &failErr) {
+		t.Fatalf("ExecFile returned %v, want error caused by fail", err)
 	}
-	if x != want {
-		t.Errorf("for x, got %v, want %v", x, want)
+	if got, want := failErr.Msg, "bad config: foo"; got != want {
+		t.Errorf("FailError.Msg = %q, want %q", got, want)
+	}
+	if got, want := err.(*pkgscript.EvalError).Backtrace(), `Traceback (most recent call last):
+  fail.star:5:6: in <toplevel>
+  fail.star:4:13: in check
+  <builtin>: in fail
+Error: bad config: foo`; got != want {
+		t.Errorf("Backtrace() = %s, want %s", got, want)
 	}
+}
 
-	// failure
-	err := pkgscript.UnpackArgs("unpack", pkgscript.Tuple{pkgscript.MakeInt(42)}, nil, "x", &x)
-	if want := "unpack: for parameter x: got int, want hasfields"; fmt.Sprint(err) != want {
-		t.Errorf("unpack args error = %q, want %q", err, want)
+func TestOnBuiltinCall(t *testing.T) {
+	var calls []string
+	thread := &pkgscript.Thread{
+		OnBuiltinCall: func(name string, args pkgscript.Tuple, kwargs []pkgscript.Tuple) error {
+			calls = append(calls, fmt.Sprintf("%s/%d/%d", name, len(args), len(kwargs)))
+			if name == "open" {
+				return fmt.Errorf("%s is not permitted", name)
+			}
+			return nil
+		},
+	}
+	opened := false
+	open := pkgscript.NewBuiltin("open", func(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+		opened = true
+		return pkgscript.None, nil
+	})
+	const src = `
+x = []
+x.append(len("abc"))
+y = sorted(x, reverse=True)
+open("secret")
+z = 1 // 0 # unreachable
+`
+	_, err := pkgscript.ExecFile(thread, "hook.star", src, pkgscript.StringDict{"open": open})
+	if err == nil || err.Error() != "open is not permitted" {
+		t.Errorf("ExecFile error = %v, want open is not permitted", err)
+	}
+	if opened {
+		t.Error("denied built-in was called")
+	}
+	if got, want := strings.Join(calls, " "), "len/1/0 list.append/1/0 sorted/1/1 open/1/0"; got != want {
+		t.Errorf("calls = %s, want %s", got, want)
 	}
 }
 
-func TestDocstring(t *testing.T) {
-	globals, _ := pkgscript.ExecFile(&pkgscript.Thread{}, "doc.star", `
-def somefunc():
-	"somefunc doc"
-	return 0
-`, nil)
+func TestDeprecatedBuiltin(t *testing.T) {
+	old := pkgscript.NewBuiltin("old", func(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+		return pkgscript.MakeInt(1), nil
+	}).WithDeprecation("use new instead")
+	if got, want := old.Deprecation(), "use new instead"; got != want {
+		t.Errorf("Deprecation() = %q, want %q", got, want)
+	}
+	predeclared := pkgscript.StringDict{"old": old}
 
-	if globals["somefunc"].(*pkgscript.Function).Doc() != "somefunc doc" {
-		t.Fatal("docstring not found")
+	const src = `
+def f():
+    return old()
+
+x = [f() for _ in range(3)]
+y = old()
+`
+	var warnings []string
+	thread := &pkgscript.Thread{
+		Warn: func(thread *pkgscript.Thread, pos syntax.Position, msg string) {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", pos, msg))
+		},
+	}
+	globals, err := pkgscript.ExecFile(thread, "dep.star", src, predeclared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := globals["x"].String(), "[1, 1, 1]"; got != want {
+		t.Errorf("x = %s, want %s", got, want)
+	}
+	// Each call site is reported once.
+	got := strings.Join(warnings, "\n")
+	want := `dep.star:3:15: old is deprecated: use new instead
+dep.star:6:8: old is deprecated: use new instead`
+	if got != want {
+		t.Errorf("warnings:\n%s\nwant:\n%s", got, want)
 	}
-}
 
-func TestFrameLocals(t *testing.T) {
-	// trace prints a nice stack trace including argument
-	// values of calls to Starlark functions.
-	trace := func(thread *pkgscript.Thread) string {
-		buf := new(bytes.Buffer)
-		for i := 0; i < thread.CallStackDepth(); i++ {
-			fr := thread.DebugFrame(i)
-			fmt.Fprintf(buf, "%s(", fr.Callable().Name())
-			if fn, ok := fr.Callable().(*pkgscript.Function); ok {
-				for i := 0; i < fn.NumParams(); i++ {
-					if i > 0 {
-						buf.WriteString(", ")
-					}
-					name, _ := fn.Param(i)
-					fmt.Fprintf(buf, "%s=%s", name, fr.Local(i))
-				}
-			} else {
-				buf.WriteString("...") // a built-in function
-			}
-			buf.WriteString(")\n")
-		}
-		return buf.String()
+	// Without Warn, warnings are reported through Print.
+	var printed []string
+	thread = &pkgscript.Thread{
+		Print: func(thread *pkgscript.Thread, msg string) { printed = append(printed, msg) },
+	}
+	if _, err := pkgscript.ExecFile(thread, "dep.star", `old()`, predeclared); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(printed), "[dep.star:1:4: warning: old is deprecated: use new instead]"; got != want {
+		t.Errorf("printed %s, want %s", got, want)
 	}
+}
 
-	var got string
-	builtin := func(thread *pkgscript.Thread, _ *pkgscript.Builtin, _ pkgscript.Tuple, _ []pkgscript.Tuple) (pkgscript.Value, error) {
-		got = trace(thread)
-		return pkgscript.None, nil
+func TestUnreachableWarning(t *testing.T) {
+	const src = `
+def f():
+    return 1
+    print("unreachable")
+
+x = f()
+`
+	var warnings []string
+	thread := &pkgscript.Thread{
+		Warn: func(thread *pkgscript.Thread, pos syntax.Position, msg string) {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", pos, msg))
+		},
 	}
-	predeclared := pkgscript.StringDict{
-		"builtin": pkgscript.NewBuiltin("builtin", builtin),
+	const want = "[unreachable.star:4:5: unreachable code after return]"
+	if _, err := pkgscript.ExecFile(thread, "unreachable.star", src, nil); err != nil {
+		t.Fatal(err)
 	}
-	_, err := pkgscript.ExecFile(&pkgscript.Thread{}, "foo.star", `
-def f(x, y): builtin()
-def g(z): f(z, z*z)
-g(7)
-`, predeclared)
+	if got := fmt.Sprint(warnings); got != want {
+		t.Errorf("ExecFile warnings = %s, want %s", got, want)
+	}
+
+	// Program.Init reports them too.
+	_, prog, err := pkgscript.SourceProgram("unreachable.star", src, pkgscript.StringDict(nil).Has)
 	if err != nil {
-		t.Errorf("ExecFile failed: %v", err)
+		t.Fatal(err)
 	}
+	warnings = nil
+	if _, err := prog.Init(thread, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(warnings); got != want {
+		t.Errorf("Init warnings = %s, want %s", got, want)
+	}
+}
 
-	var want = `
-builtin(...)
-f(x=7, y=49)
-g(z=7)
-<toplevel>()
-`[1:]
+func TestPrintRecord(t *testing.T) {
+	var records []string
+	thread := &pkgscript.Thread{
+		PrintRecord: func(thread *pkgscript.Thread, args []pkgscript.Value, sep, end string) {
+			records = append(records, fmt.Sprintf("%v %q %q", args, sep, end))
+		},
+	}
+	const src = `print("a", 1, sep="|"); print(); print([2], end="")`
+	if _, err := pkgscript.ExecFile(thread, "print.star", src, nil); err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(records, "\n")
+	want := `["a" 1] "|" "\n"
+[] " " "\n"
+[[2]] " " ""`
 	if got != want {
-		t.Errorf("got <<%s>>, want <<%s>>", got, want)
+		t.Errorf("records:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Without PrintRecord, print falls back to Print.
+	var printed []string
+	thread = &pkgscript.Thread{
+		Print: func(thread *pkgscript.Thread, msg string) { printed = append(printed, msg) },
+	}
+	if _, err := pkgscript.ExecFile(thread, "print.star", src, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprintf("%q", printed), `["a|1" "" "[2]"]`; got != want {
+		t.Errorf("printed %s, want %s", got, want)
 	}
 }
 
-type badType string
+func TestInput(t *testing.T) {
+	var prompts []string
+	replies := []string{"Ada", "42"}
+	thread := &pkgscript.Thread{
+		Input: func(prompt string) (string, error) {
+			prompts = append(prompts, prompt)
+			if len(replies) == 0 {
+				return "", errors.New("end of input")
+			}
+			reply := replies[0]
+			replies = replies[1:]
+			return reply, nil
+		},
+	}
+	const src = `
+name = input("name? ")
+age = int(input())
+`
+	globals, err := pkgscript.ExecFile(thread, "input.star", src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprintf("%q", prompts), `["name? " ""]`; got != want {
+		t.Errorf("prompts = %s, want %s", got, want)
+	}
+	if got, want := fmt.Sprintf("%v %v", globals["name"], globals["age"]), `"Ada" 42`; got != want {
+		t.Errorf("globals = %s, want %s", got, want)
+	}
 
-func (b *badType) String() string        { return "badType" }
-func (b *badType) Type() string          { return "badType:" + string(*b) } // panics if b==nil
-func (b *badType) Truth() pkgscript.Bool  { return true }
-func (b *badType) Hash() (uint32, error) { return 0, nil }
-func (b *badType) Freeze()               {}
+	// Errors from the hook are reported.
+	_, err = pkgscript.Eval(thread, "input.star", `input()`, nil)
+	if err == nil || err.Error() != "input: end of input" {
+		t.Errorf("input with no replies: got error %v", err)
+	}
 
-var _ pkgscript.Value = new(badType)
+	// Without a hook, input fails.
+	_, err = pkgscript.Eval(new(pkgscript.Thread), "input.star", `input("x")`, nil)
+	if err == nil || err.Error() != "input: not supported by this application" {
+		t.Errorf("input without hook: got error %v", err)
+	}
+}
 
-// TestUnpackErrorBadType verifies that the Unpack functions fail
-// gracefully when a parameter's default value's Type method panics.
-func TestUnpackErrorBadType(t *testing.T) {
+func TestTailCall(t *testing.T) {
+	defer setOptions("")
+	setOptions("option:recursion")
+
+	// A tail-recursive function runs in constant stack space.
+	const src = `
+def sum(n, acc = 0):
+    if n == 0:
+        return acc
+    return sum(n - 1, acc = acc + n)
+
+def sumlist(elems, i = 0, acc = 0):
+    for x in elems[i:i + 1]:
+        return sumlist(elems, i + 1, acc + x)
+    return acc
+
+x = sum(1000000)
+y = sumlist(list(range(1000000)))
+`
+	thread := new(pkgscript.Thread)
+	globals, err := pkgscript.ExecFile(thread, "tail.star", src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := globals["x"].String(), "500000500000"; got != want {
+		t.Errorf("sum(1000000) = %s, want %s", got, want)
+	}
+	if got, want := globals["y"].String(), "499999500000"; got != want {
+		t.Errorf("sumlist(range(1000000)) = %s, want %s", got, want)
+	}
+
+	// Calls not in tail position still push a frame each.
+	const src2 = `
+def f(n):
+    if n == 0:
+        return 1//0
+    return f(n - 1)
+
+def g(n):
+    return 1 + f(n) if n else g(n + 1)
+
+g(0)
+`
+	_, err = pkgscript.ExecFile(thread, "tail.star", src2, nil)
+	evalErr, ok := err.(*pkgscript.EvalError)
+	if !ok {
+		t.Fatalf("ExecFile returned %v, want *EvalError", err)
+	}
+	const want = `Traceback (most recent call last):
+  tail.star:10:2: in <toplevel>
+  tail.star:8:17: in g
+  tail.star:4:17: in f
+Error: floored division by zero`
+	if got := evalErr.Backtrace(); got != want {
+		t.Errorf("error was %s, want %s", got, want)
+	}
+}
+
+func TestMaxCallDepth(t *testing.T) {
+	defer setOptions("")
+	setOptions("option:recursion option:lambda")
+
+	const src = `
+def f(n):
+    return 0 if n == 0 else 1 + f(n - 1)
+`
 	for _, test := range []struct {
-		x    *badType
+		max  int
+		expr string
 		want string
 	}{
-		{new(badType), "got NoneType, want badType"},       // Starlark type name
-		{nil, "got NoneType, want *pkgscript_test.badType"}, // Go type name
+		{10, "f(5)", ""},
+		{10, "f(8)", ""}, // <toplevel> and 9 calls of f
+		{10, "f(9)", "maximum recursion depth exceeded (10)"},
+		{0, "f(1000)", ""},
+		{0, "f(-1)", fmt.Sprintf("maximum recursion depth exceeded (%d)", pkgscript.DefaultMaxCallDepth)},
+		{100, "sorted([1, 2], key = lambda x: f(x + 200))", "maximum recursion depth exceeded (100)"},
 	} {
-		err := pkgscript.UnpackArgs("f", pkgscript.Tuple{pkgscript.None}, nil, "x", &test.x)
-		if err == nil {
-			t.Errorf("UnpackArgs succeeded unexpectedly")
-			continue
+		thread := new(pkgscript.Thread)
+		thread.SetMaxCallDepth(test.max)
+		_, err := pkgscript.ExecFile(thread, "rec.star", src+"x = "+test.expr, nil)
+		if test.want == "" {
+			if err != nil {
+				t.Errorf("max=%d: %s: unexpected error: %v", test.max, test.expr, err)
+			}
+		} else if err == nil {
+			t.Errorf("max=%d: %s: got no error, want %q", test.max, test.expr, test.want)
+		} else if _, ok := err.(*pkgscript.EvalError); !ok {
+			t.Errorf("max=%d: %s: got %T, want *EvalError", test.max, test.expr, err)
+		} else if got := err.Error(); got != test.want {
+			t.Errorf("max=%d: %s: got error %q, want %q", test.max, test.expr, got, test.want)
+		}
+	}
+
+	parent := new(pkgscript.Thread)
+	parent.SetMaxCallDepth(3)
+	if _, err := pkgscript.ExecFile(parent.NewChild(), "rec.star", src+"x = f(5)", nil); err == nil {
+		t.Errorf("NewChild did not inherit the call depth limit")
+	}
+}
+
+func TestMaxAllocs(t *testing.T) {
+	const budget = 256 << 20
+	for _, test := range []struct{ src, want string }{
+		{`x = [0] * 100000000`, "too many allocations"},
+		{`x = ("ab" * (1 << 20)) * 200`, "too many allocations"},
+		{`x = list(range(1 << 30))`, "list: too many allocations"},
+		{`x = 1 << (1 << 31)`, "too many allocations"},
+		{`def f():
+    x = [0] * 1000
+    x += x
+    return {i: i for i in range(100)}
+f()`, ""},
+	} {
+		thread := new(pkgscript.Thread)
+		thread.SetMaxAllocs(budget)
+		_, err := pkgscript.ExecFile(thread, "alloc.star", test.src, nil)
+		if test.want == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.src, err)
+			} else if n := thread.Allocs(); n < 32000 || n > budget {
+				t.Errorf("%s: Allocs() = %d, want about 32000", test.src, n)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want %s", test.src, err, test.want)
+		}
+		if n := thread.Allocs(); n > budget {
+			t.Errorf("%s: Allocs() = %d exceeds limit", test.src, n)
+		}
+	}
+
+	// Values that grow a little at a time are counted too.
+	const small = 1 << 20
+	for _, test := range []struct{ src, want string }{
+		{`def f():
+    x = []
+    for i in range(100000):
+        x.append(i)
+f()`, "append: too many allocations"},
+		{`x = [0] * 60000
+x.extend(x)`, "too many allocations"},
+		{`x = [i for i in range(100000)]`, "too many allocations"},
+		{`def f():
+    d = {}
+    for i in range(100000):
+        d[i] = i
+f()`, "too many allocations"},
+		{`d = {}
+d.update({i: i for i in range(20000)})`, "update: too many allocations"},
+		{`x = "ab" * 1000000`, "too many allocations"},
+		{`x = ",".join(["ab" * 1000] * 1000)`, "join: too many allocations"},
+		{`x = ("a" * 1000).replace("a", "b" * 2000)`, "replace: too many allocations"},
+		{`x = "%s%s" % ("a" * 400000, "b" * 400000)`, "too many allocations"},
+		{`x = "{}{}".format("a" * 400000, "b" * 400000)`, "format: too many allocations"},
+		{`def f():
+    y = 3
+    for _ in range(22):
+        y = y * y
+f()`, "too many allocations"},
+		{`def f():
+    y = 3
+    for _ in range(18):
+        y = y * y
+    for _ in range(100):
+        y = y + y
+f()`, "too many allocations"},
+		{`def f():
+    y = 3
+    for _ in range(18):
+        y = y * y
+    for _ in range(100):
+        z = y - 1
+f()`, "too many allocations"},
+		{`def f():
+    y = 3
+    for _ in range(18):
+        y = y * y
+    for _ in range(100):
+        z = y // 3
+f()`, "too many allocations"},
+		{`def f():
+    y = 3
+    for _ in range(18):
+        y = y * y
+    for _ in range(100):
+        z = (y + 1) % y
+f()`, "too many allocations"},
+		{`x = ("a," * 100000).split(",")`, "split: too many allocations"},
+		{`x = "x".zfill(100000000)`, "zfill: too many allocations"},
+		{`x = "\t".expandtabs(100000000)`, "expandtabs: too many allocations"},
+		{`x = enumerate(range(30000))`, "enumerate: too many allocations"},
+		{`x = zip(range(30000), range(30000))`, "zip: too many allocations"},
+		{`x = [0] * 40000
+y = deepcopy([x, x])`, "deepcopy: too many allocations"},
+	} {
+		thread := new(pkgscript.Thread)
+		thread.SetMaxAllocs(small)
+		_, err := pkgscript.ExecFile(thread, "alloc.star", test.src, nil)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want %s", test.src, err, test.want)
+		}
+		if n := thread.Allocs(); n > small {
+			t.Errorf("%s: Allocs() = %d exceeds limit", test.src, n)
+		}
+	}
+
+	// The size of a shifted int is rounded up to a whole byte, so small
+	// shifts are not free, and 1 << (1 << 31) above needs one byte more
+	// than the budget.
+	thread := new(pkgscript.Thread)
+	thread.SetMaxAllocs(budget)
+	if _, err := pkgscript.ExecFile(thread, "shift.star", "n = 1\nx = n << 1\ny = n << 8", nil); err != nil {
+		t.Fatal(err)
+	} else if got, want := thread.Allocs(), uint64(3); got != want {
+		t.Errorf("Allocs() after small shifts = %d, want %d", got, want)
+	}
+}
+
+func TestExecReaderStatements(t *testing.T) {
+	const src = `
+load("lib.star", "square", sq2="square")
+x = square(3)
+def f(y):
+    return x + y
+z = f(1) + sq2(2)
+`
+	thread := &pkgscript.Thread{
+		Load: func(thread *pkgscript.Thread, module pkgscript.Value) (pkgscript.StringDict, error) {
+			return pkgscript.ExecFile(thread, "lib.star", "def square(x): return x * x", nil)
+		},
+	}
+	predeclared := pkgscript.StringDict{"base": pkgscript.MakeInt(100)}
+	globals, err := pkgscript.ExecReaderStatements(thread, "gen.star", strings.NewReader(src), predeclared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(globals.Keys()), "[f sq2 square x z]"; got != want {
+		t.Errorf("globals = %s, want %s", got, want)
+	}
+	if got, want := globals["z"], pkgscript.MakeInt(14); got != want {
+		t.Errorf("z = %v, want %v", got, want)
+	}
+	if _, ok := predeclared["x"]; ok {
+		t.Error("ExecReaderStatements modified predeclared")
+	}
+
+	// Errors are reported at the position of the failing statement.
+	// After an execution error, the globals defined so far are
+	// returned, but after a resolve or syntax error, none are, as in
+	// ExecFile.
+	for _, test := range []struct{ src, want string }{
+		{"a = 1\nb = a // 0\n", `Traceback (most recent call last):
+  gen.star:2:7: in <toplevel>
+Error: floored division by zero`},
+		{"a = 1\nb = c\n", "gen.star:2:5: undefined: c"},
+		{"a = 1\nb = (\n", "gen.star:3:1: got end of file, want primary expression"},
+		{"a = 1\na = 2\n", "gen.star:2:1: cannot reassign global a declared at gen.star:1:1"},
+		{"a = 1\nload('lib.star', a='square')\n", "gen.star:2:18: cannot reassign global a declared at gen.star:1:1"},
+		{"a = 1\nload('lib.star', '_x')\n", "gen.star:2:19: load: names with leading underscores are not exported: _x"},
+		{"a = 1\nload('lib.star', 'squar')\n", `Traceback (most recent call last):
+  gen.star:2:1: in <toplevel>
+Error: load: name squar not found in module "lib.star" (did you mean square?)`},
+	} {
+		globals, err := pkgscript.ExecReaderStatements(thread, "gen.star", strings.NewReader(test.src), nil)
+		if err == nil {
+			t.Errorf("%q: unexpected success", test.src)
+			continue
+		}
+		got := err.Error()
+		if err, ok := err.(*pkgscript.EvalError); ok {
+			got = err.Backtrace()
+		}
+		if got != test.want {
+			t.Errorf("%q: got error %s, want %s", test.src, got, test.want)
+		}
+		if _, ok := err.(*pkgscript.EvalError); ok {
+			if globals["a"] != pkgscript.MakeInt(1) {
+				t.Errorf("%q: globals = %v, want a = 1", test.src, globals)
+			}
+		} else if globals != nil {
+			t.Errorf("%q: globals = %v, want none", test.src, globals)
+		}
+	}
+
+	// A function may refer to a global defined by a later statement,
+	// and the file is rejected if the global is never defined, as in
+	// ExecFile.
+	for _, test := range []struct{ src, want string }{
+		{"def f(): return g()\ndef g(): return 1\nx = f()\n", "[f g x]"},
+		{"def f(): return g()\nx = f()\ndef g(): return 1\n", `Traceback (most recent call last):
+  gen.star:2:6: in <toplevel>
+  gen.star:1:17: in f
+Error: global variable g referenced before assignment`},
+		{"x = 1\ndef f(): return h()\n", "gen.star:2:17: undefined: h"},
+		{"x = 1\ndef f(): return h()\ny = 1 // 0\n", "gen.star:2:17: undefined: h"},
+		{"len = 3\nx = len + 1\n", "[len x]"},
+	} {
+		var got, want string
+		for i, exec := range []func() (pkgscript.StringDict, error){
+			func() (pkgscript.StringDict, error) {
+				return pkgscript.ExecReaderStatements(thread, "gen.star", strings.NewReader(test.src), nil)
+			},
+			func() (pkgscript.StringDict, error) {
+				return pkgscript.ExecFile(thread, "gen.star", test.src, nil)
+			},
+		} {
+			globals, err := exec()
+			var result string
+			if err != nil && globals != nil {
+				result = fmt.Sprintf("globals %v after error: ", globals)
+			}
+			if err == nil {
+				result = fmt.Sprint(globals.Keys())
+			} else if evalErr, ok := err.(*pkgscript.EvalError); ok {
+				result = evalErr.Backtrace()
+			} else {
+				result += err.Error()
+			}
+			if i == 0 {
+				got = result
+			} else {
+				want = result
+			}
+		}
+		if got != test.want {
+			t.Errorf("%q: got %s, want %s", test.src, got, test.want)
+		}
+		if got != want {
+			t.Errorf("%q: got %s, but ExecFile got %s", test.src, got, want)
+		}
+	}
+
+	// With global reassignment, each statement sees the current values
+	// of the globals, and a deleted global is removed, as in ExecFile.
+	opts := &syntax.FileOptions{AllowGlobalReassign: true}
+	const src2 = `
+x = 1
+x += 1
+if False:
+    x = 5
+y = x
+del x
+`
+	want, err := pkgscript.ExecFileOptions(opts, thread, "gen.star", src2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := pkgscript.ExecReaderStatementsOptions(opts, thread, "gen.star", strings.NewReader(src2), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != want.String() {
+		t.Errorf("ExecReaderStatements globals = %v, want %v (as ExecFile)", got, want)
+	}
+
+	// An allowlist of predeclared names does not apply to globals,
+	// even those that shadow a universal name, as in ExecFile.
+	opts = &syntax.FileOptions{PermittedPredeclared: func(name string) bool { return name == "len" }}
+	for _, src := range []string{
+		"def f(): return len(g())\ndef g(): return []\nx = f()\n",
+		"str = 3\nx = str + 1\n",
+	} {
+		if _, err := pkgscript.ExecFileOptions(opts, thread, "gen.star", src, nil); err != nil {
+			t.Errorf("ExecFile: %q: %v", src, err)
+		}
+		if _, err := pkgscript.ExecReaderStatementsOptions(opts, thread, "gen.star", strings.NewReader(src), nil); err != nil {
+			t.Errorf("ExecReaderStatements: %q: %v", src, err)
+		}
+	}
+	if _, err := pkgscript.ExecReaderStatementsOptions(opts, thread, "gen.star", strings.NewReader("x = str(1)\n"), nil); err == nil {
+		t.Error("use of str succeeded despite the allowlist")
+	}
+
+	// A global may not be defined with the name of a predeclared or
+	// universal value used by an earlier statement, which ExecFile,
+	// having resolved the whole file first, would have taken to refer
+	// to the global. With AllowGlobalReassign, only uses within
+	// functions are affected, as ExecFile resolves the others at once.
+	for _, test := range []struct {
+		reassign            bool
+		src, want, execFile string
+	}{
+		{false, "def f(): return len\nlen = 3\nx = f()\n",
+			"gen.star:2:1: cannot define global len: the predeclared len is used at gen.star:1:17",
+			"[f len x]"},
+		{false, "y = base\nbase = 1\n",
+			"gen.star:2:1: cannot define global base: the predeclared base is used at gen.star:1:5",
+			"gen.star:1:5: global variable base referenced before assignment"},
+		{false, "x = len([])\n1 // 0\nload('lib.star', len='square')\n",
+			"gen.star:3:18: cannot define global len: the predeclared len is used at gen.star:1:5",
+			"gen.star:1:5: local variable len referenced before assignment"},
+		{true, "def f(): return len\nlen = 3\nx = f()\n",
+			"gen.star:2:1: cannot define global len: the predeclared len is used at gen.star:1:17",
+			"[f len x]"},
+		{true, "y = [base for _ in [1]]\nbase = 1\n",
+			"gen.star:2:1: cannot define global base: the predeclared base is used at gen.star:1:6",
+			"gen.star:1:6: global variable base referenced before assignment"},
+		{true, "y = base\nbase = 1\n", "[base y]", "[base y]"},
+	} {
+		opts := &syntax.FileOptions{AllowGlobalReassign: test.reassign}
+		predeclared := pkgscript.StringDict{"base": pkgscript.MakeInt(100)}
+		var got, execFile string
+		for i, exec := range []func() (pkgscript.StringDict, error){
+			func() (pkgscript.StringDict, error) {
+				return pkgscript.ExecReaderStatementsOptions(opts, thread, "gen.star", strings.NewReader(test.src), predeclared)
+			},
+			func() (pkgscript.StringDict, error) {
+				return pkgscript.ExecFileOptions(opts, thread, "gen.star", test.src, predeclared)
+			},
+		} {
+			globals, err := exec()
+			result := fmt.Sprint(globals.Keys())
+			if evalErr, ok := err.(*pkgscript.EvalError); ok {
+				result = evalErr.CallStack.At(0).Pos.String() + ": " + evalErr.Msg
+			} else if err != nil {
+				result = err.Error()
+				if globals != nil {
+					result = fmt.Sprintf("globals %v after error: %s", globals, result)
+				}
+			}
+			if i == 0 {
+				got = result
+			} else {
+				execFile = result
+			}
+		}
+		if got != test.want {
+			t.Errorf("%q: got %s, want %s", test.src, got, test.want)
+		}
+		if execFile != test.execFile {
+			t.Errorf("ExecFile: %q: got %s, want %s", test.src, execFile, test.execFile)
+		}
+	}
+}
+
+// TestRepeatedExec parses and resolves a file syntax tree once then
+// executes it repeatedly with different values of its predeclared variables.
+func TestRepeatedExec(t *testing.T) {
+	predeclared := pkgscript.StringDict{"x": pkgscript.None}
+	_, prog, err := pkgscript.SourceProgram("repeat.star", "y = 2 * x", predeclared.Has)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		x, want pkgscript.Value
+	}{
+		{x: pkgscript.MakeInt(42), want: pkgscript.MakeInt(84)},
+		{x: pkgscript.String("mur"), want: pkgscript.String("murmur")},
+		{x: pkgscript.Tuple{pkgscript.None}, want: pkgscript.Tuple{pkgscript.None, pkgscript.None}},
+	} {
+		predeclared["x"] = test.x // update the values in dictionary
+		thread := new(pkgscript.Thread)
+		if globals, err := prog.Init(thread, predeclared); err != nil {
+			t.Errorf("x=%v: %v", test.x, err) // exec error
+		} else if eq, err := pkgscript.Equal(globals["y"], test.want); err != nil {
+			t.Errorf("x=%v: %v", test.x, err) // comparison error
+		} else if !eq {
+			t.Errorf("x=%v: got y=%v, want %v", test.x, globals["y"], test.want)
+		}
+	}
+}
+
+// TestLoadRestrictedPredeclared checks that a Load implementation can
+// execute a dependency in a narrower environment than its parent's.
+func TestLoadRestrictedPredeclared(t *testing.T) {
+	secret := pkgscript.NewBuiltin("secret", func(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+		return pkgscript.String("s3cr3t"), nil
+	})
+	predeclared := pkgscript.StringDict{
+		"secret":   secret,
+		"greeting": pkgscript.String("hello"),
+	}
+	modules := map[string]string{
+		"safe.star":   `x = greeting + "!"`,
+		"unsafe.star": `x = secret()`,
+	}
+
+	load := func(thread *pkgscript.Thread, modval pkgscript.Value) (pkgscript.StringDict, error) {
+		module, _ := pkgscript.AsString(modval)
+
+		// Dependencies get the parent's environment minus secret.
+		restricted := make(pkgscript.StringDict)
+		for name, v := range thread.Predeclared() {
+			if name != "secret" {
+				restricted[name] = v
+			}
+		}
+		child := &pkgscript.Thread{Name: "exec " + module}
+		return pkgscript.ExecFile(child, module, modules[module], restricted)
+	}
+
+	for _, test := range []struct {
+		src, want string
+	}{
+		{`load("safe.star", "x"); y = x + secret()`, `"hello!s3cr3t"`},
+		{`load("unsafe.star", "x"); y = x`, `cannot load "unsafe.star": unsafe.star:1:5: undefined: secret`},
+	} {
+		thread := &pkgscript.Thread{Load: load}
+		globals, err := pkgscript.ExecFile(thread, "main.star", test.src, predeclared)
+		var got string
+		if err != nil {
+			got = err.Error()
+		} else {
+			got = globals["y"].String()
+		}
+		if got != test.want {
+			t.Errorf("%s: got %s, want %s", test.src, got, test.want)
+		}
+	}
+}
+
+func TestLoadedModules(t *testing.T) {
+	modules := map[string]string{
+		"a.star": `a = 1`,
+		"b.star": `b = 2`,
+		"c.star": `c = undefined`,
+	}
+	load := func(thread *pkgscript.Thread, modval pkgscript.Value) (pkgscript.StringDict, error) {
+		module, _ := pkgscript.AsString(modval)
+		child := &pkgscript.Thread{Name: "exec " + module}
+		return pkgscript.ExecFile(child, module, modules[module], nil)
+	}
+
+	thread := &pkgscript.Thread{Load: load}
+	if got := thread.LoadedModules(); len(got) != 0 {
+		t.Errorf("LoadedModules before execution = %q, want none", got)
+	}
+	const src = `
+load("b.star", "b")
+load("a.star", "a")
+load("b.star", b2 = "b")
+`
+	if _, err := pkgscript.ExecFile(thread, "main.star", src, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(thread.LoadedModules()), "[b.star a.star]"; got != want {
+		t.Errorf("LoadedModules = %s, want %s", got, want)
+	}
+
+	// Failed loads are not recorded.
+	if _, err := pkgscript.ExecFile(thread, "main2.star", `load("c.star", "c")`, nil); err == nil {
+		t.Fatal("load of c.star succeeded unexpectedly")
+	}
+	if got, want := fmt.Sprint(thread.LoadedModules()), "[b.star a.star]"; got != want {
+		t.Errorf("LoadedModules after failed load = %s, want %s", got, want)
+	}
+}
+
+func TestExports(t *testing.T) {
+	const lib = `
+def _helper(): return 1
+def internal(): return 2
+def api(): return _helper() + internal()
+__all__ = ["api"]
+`
+	globals, err := pkgscript.ExecFile(&pkgscript.Thread{}, "lib.star", lib, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(globals.Keys()), "[api]"; got != want {
+		t.Errorf("ExecFile globals = %s, want %s", got, want)
+	}
+
+	// Every way of executing a file honors __all__.
+	_, prog, err := pkgscript.SourceProgram("lib.star", lib, pkgscript.StringDict(nil).Has)
+	if err != nil {
+		t.Fatal(err)
+	}
+	globals, err = prog.Init(&pkgscript.Thread{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(globals.Keys()), "[api]"; got != want {
+		t.Errorf("Program.Init globals = %s, want %s", got, want)
+	}
+	globals, err = pkgscript.ExecReaderStatements(&pkgscript.Thread{}, "lib.star", strings.NewReader(lib), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(globals.Keys()), "[api]"; got != want {
+		t.Errorf("ExecReaderStatements globals = %s, want %s", got, want)
+	}
+
+	modules := map[string]string{
+		"lib.star":   lib,
+		"bad.star":   `__all__ = ["api"]`,
+		"tuple.star": `__all__ = ("x",); x, y = 1, 2`,
+		"int.star":   `__all__ = 1`,
+	}
+	load := func(thread *pkgscript.Thread, modval pkgscript.Value) (pkgscript.StringDict, error) {
+		module, _ := pkgscript.AsString(modval)
+		child := &pkgscript.Thread{Name: "exec " + module}
+		return pkgscript.ExecFile(child, module, modules[module], nil)
+	}
+	for _, test := range []struct {
+		src, want string
+	}{
+		{`load("lib.star", "api"); y = api()`, `3`},
+		{`load("lib.star", "internal")`, `load: name internal not found in module "lib.star"`},
+		{`load("bad.star", "api")`, `cannot load "bad.star": __all__: undefined name api`},
+		{`load("tuple.star", "x"); y = x`, `1`},
+		{`load("int.star", "x")`, `cannot load "int.star": __all__: got int, want list or tuple of strings`},
+	} {
+		thread := &pkgscript.Thread{Load: load}
+		globals, err := pkgscript.ExecFile(thread, "main.star", test.src, nil)
+		var got string
+		if err != nil {
+			got = err.Error()
+		} else {
+			got = globals["y"].String()
+		}
+		if !strings.Contains(got, test.want) {
+			t.Errorf("%s: got %s, want %s", test.src, got, test.want)
+		}
+	}
+}
+
+func TestFreezeLoads(t *testing.T) {
+	// Unlike ExecFile, Program.Init does not freeze the module's globals.
+	load := func(thread *pkgscript.Thread, modval pkgscript.Value) (pkgscript.StringDict, error) {
+		module, _ := pkgscript.AsString(modval)
+		_, prog, err := pkgscript.SourceProgram(module, `x = []`, nil)
+		if err != nil {
+			return nil, err
+		}
+		return prog.Init(&pkgscript.Thread{Name: "exec " + module}, nil)
+	}
+	const src = `
+load("lib.star", "x")
+x.append(1)
+`
+	thread := &pkgscript.Thread{Load: load}
+	if _, err := pkgscript.ExecFile(thread, "main.star", src, nil); err != nil {
+		t.Fatalf("without FreezeLoads: %v", err)
+	}
+
+	thread = &pkgscript.Thread{Load: load, FreezeLoads: true}
+	_, err := pkgscript.ExecFile(thread, "main.star", src, nil)
+	if err == nil {
+		t.Fatal("with FreezeLoads: append succeeded unexpectedly")
+	} else if got, want := err.Error(), "cannot append to frozen list"; !strings.Contains(got, want) {
+		t.Errorf("with FreezeLoads: got %q, want %q", got, want)
+	}
+	if !thread.NewChild().FreezeLoads {
+		t.Errorf("NewChild did not inherit FreezeLoads")
+	}
+}
+
+func TestProgramSize(t *testing.T) {
+	compile := func(src string) *pkgscript.Program {
+		_, prog, err := pkgscript.SourceProgram("size.star", src, func(string) bool { return false })
+		if err != nil {
+			t.Fatal(err)
+		}
+		return prog
+	}
+
+	// x = 1; return None
+	prog := compile(`x = 1`)
+	if got, want := prog.NumInstructions(), 4; got != want {
+		t.Errorf("trivial program: NumInstructions = %d, want %d", got, want)
+	}
+	if got := prog.NumFunctions(); got != 0 {
+		t.Errorf("trivial program: NumFunctions = %d, want 0", got)
+	}
+	if got := prog.NumConstants(); got != 1 {
+		t.Errorf("trivial program: NumConstants = %d, want 1", got)
+	}
+
+	// A program that defines many functions exceeds a modest budget.
+	var src strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&src, "def f%d(x):\n    return x + %d * \"s%d\"\n", i, i, i)
+	}
+	src.WriteString("def g():\n    return 0\n")
+	prog = compile(src.String())
+	if got, want := prog.NumFunctions(), 101; got != want {
+		t.Errorf("large program: NumFunctions = %d, want %d", got, want)
+	}
+	if got, want := prog.NumConstants(), 200; got != want {
+		t.Errorf("large program: NumConstants = %d, want %d", got, want)
+	}
+	const budget = 500
+	if n := prog.NumInstructions(); n <= budget {
+		t.Errorf("large program: NumInstructions = %d, want more than %d", n, budget)
+	}
+	if n, size := prog.NumInstructions(), prog.CodeSize(); size < n {
+		t.Errorf("large program: CodeSize = %d, less than NumInstructions = %d", size, n)
+	}
+}
+
+func TestStepHook(t *testing.T) {
+	const src = `
+def f(n):
+    total = 0
+    for i in range(n):
+        total += i
+    return total
+
+x = f(2)
+y = x
+`
+	var lines []string
+	thread := new(pkgscript.Thread)
+	thread.SetStepHook(func(fr pkgscript.DebugFrame, pos syntax.Position) error {
+		lines = append(lines, fmt.Sprintf("%s:%d", fr.Callable().Name(), pos.Line))
+		return nil
+	})
+	if _, err := pkgscript.ExecFile(thread, "step.star", src, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := "<toplevel>:2 <toplevel>:8 f:3 f:4 f:5 f:4 f:5 f:4 f:6 <toplevel>:9"
+	if got := strings.Join(lines, " "); got != want {
+		t.Errorf("stepped lines: %s, want %s", got, want)
+	}
+
+	// An error from the hook aborts execution.
+	lines = nil
+	thread.SetStepHook(func(fr pkgscript.DebugFrame, pos syntax.Position) error {
+		lines = append(lines, fmt.Sprint(pos.Line))
+		if pos.Line == 5 {
+			return fmt.Errorf("stop")
+		}
+		return nil
+	})
+	_, err := pkgscript.ExecFile(thread, "step.star", src, nil)
+	if err == nil || err.Error() != "stop" {
+		t.Errorf("got error %v, want stop", err)
+	}
+	if got, want := strings.Join(lines, " "), "2 8 3 4 5"; got != want {
+		t.Errorf("stepped lines: %s, want %s", got, want)
+	}
+}
+
+func TestBreakpoints(t *testing.T) {
+	const src = `
+def f(n):
+    total = 0
+    for i in range(n):
+        total += i
+        total += 0
+    return total
+
+def g():
+    return f(3)
+
+x = g()
+`
+	var hits []string
+	thread := new(pkgscript.Thread)
+	thread.SetBreakpoints(map[pkgscript.Breakpoint]bool{{"bp.star", 5}: true, {"other.star", 4}: true},
+		func(thread *pkgscript.Thread, pos syntax.Position) error {
+			fr := thread.DebugFrame(0)
+			hits = append(hits, fmt.Sprintf("%s:%d:%s:i=%v:depth=%d",
+				fr.Callable().Name(), pos.Line, pos.Filename(), fr.Local(2), thread.CallStackDepth()))
+			return nil
+		})
+	globals, err := pkgscript.ExecFile(thread, "bp.star", src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if globals["x"] != pkgscript.MakeInt(3) {
+		t.Errorf("x = %v, want 3", globals["x"])
+	}
+	want := "f:5:bp.star:i=0:depth=3 f:5:bp.star:i=1:depth=3 f:5:bp.star:i=2:depth=3"
+	if got := strings.Join(hits, " "); got != want {
+		t.Errorf("breakpoint hits: %s, want %s", got, want)
+	}
+
+	// An error from the callback aborts execution.
+	thread.SetBreakpoints(map[pkgscript.Breakpoint]bool{{"bp.star", 10}: true},
+		func(thread *pkgscript.Thread, pos syntax.Position) error {
+			return fmt.Errorf("break at line %d", pos.Line)
+		})
+	if _, err := pkgscript.ExecFile(thread, "bp.star", src, nil); err == nil || err.Error() != "break at line 10" {
+		t.Errorf("got error %v, want break at line 10", err)
+	}
+
+	// Breakpoints may be removed.
+	thread.SetBreakpoints(nil, nil)
+	if _, err := pkgscript.ExecFile(thread, "bp.star", src, nil); err != nil {
+		t.Errorf("unexpected error after removing breakpoints: %v", err)
+	}
+}
+
+func TestDebugFrameLocalByName(t *testing.T) {
+	defer setOptions("")
+	setOptions("option:nesteddef")
+
+	const src = `
+limit = 10
+
+def f(x, y):
+    z = x + y
+    def g():
+        return z
+    return g()
+
+f(1, 2)
+`
+	var got []string
+	thread := new(pkgscript.Thread)
+	thread.SetBreakpoints(map[pkgscript.Breakpoint]bool{{"locals.star", 7}: true, {"locals.star", 8}: true},
+		func(thread *pkgscript.Thread, pos syntax.Position) error {
+			fr := thread.DebugFrame(0)
+			for _, name := range []string{"x", "y", "z", "g", "w"} {
+				if v, ok := fr.LocalByName(name); ok {
+					got = append(got, fmt.Sprintf("%s:%s=%v", fr.Callable().Name(), name, v))
+				}
+			}
+			got = append(got, fmt.Sprintf("limit=%v", fr.Globals()["limit"]))
+			return nil
+		})
+	if _, err := pkgscript.ExecFile(thread, "locals.star", src, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Line 8 (return g()) precedes line 7 (return z) in execution.
+	want := `f:x=1 f:y=2 f:z=3 f:g=<function g> limit=10 g:z=3 limit=10`
+	if strings.Join(got, " ") != want {
+		t.Errorf("got %s, want %s", strings.Join(got, " "), want)
+	}
+}
+
+func TestDebugFrameSetLocal(t *testing.T) {
+	defer setOptions("")
+	setOptions("option:nesteddef")
+
+	const src = `
+scale = 100
+
+def f(x):
+    y = x
+    def g():
+        return y * scale
+    return x + g()
+
+z = f(1)
+`
+	thread := new(pkgscript.Thread)
+	var evals []string
+	thread.SetBreakpoints(map[pkgscript.Breakpoint]bool{{"set.star", 8}: true},
+		func(thread *pkgscript.Thread, pos syntax.Position) error {
+			fr := thread.DebugFrame(0)
+			if err := fr.SetLocal("x", pkgscript.MakeInt(10)); err != nil {
+				return err
+			}
+			if err := fr.SetLocal("y", pkgscript.MakeInt(2)); err != nil { // a cell shared with g
+				return err
+			}
+			if err := fr.SetLocal("w", pkgscript.None); err == nil || err.Error() != "f has no local variable w" {
+				t.Errorf("SetLocal(w) returned %v", err)
+			}
+			v, err := thread.EvalInFrame(0, "<debug>", "x + y * scale")
+			if err != nil {
+				return err
+			}
+			evals = append(evals, v.String())
+			return nil
+		})
+	globals, err := pkgscript.ExecFile(thread, "set.star", src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := globals["z"].String(), "210"; got != want {
+		t.Errorf("z = %s, want %s", got, want)
+	}
+	if got, want := strings.Join(evals, " "), "210"; got != want {
+		t.Errorf("EvalInFrame returned %s, want %s", got, want)
+	}
+
+	// The variables of an enclosing function cannot be set.
+	thread.SetBreakpoints(map[pkgscript.Breakpoint]bool{{"set.star", 7}: true},
+		func(thread *pkgscript.Thread, pos syntax.Position) error {
+			return thread.DebugFrame(0).SetLocal("y", pkgscript.None)
+		})
+	const want = "cannot set y, a variable of a function enclosing g"
+	if _, err := pkgscript.ExecFile(thread, "set.star", src, nil); err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+func TestEvalOptions(t *testing.T) {
+	// The global options disallow lambda, but the file's options allow it.
+	defer setOptions("")
+	setOptions("")
+	opts := &syntax.FileOptions{AllowLambda: true}
+
+	const src = `
+def f(x):
+    return x
+
+f(1)
+`
+	thread := new(pkgscript.Thread)
+	var got string
+	thread.SetBreakpoints(map[pkgscript.Breakpoint]bool{{"opts.star", 3}: true},
+		func(thread *pkgscript.Thread, pos syntax.Position) error {
+			v, err := thread.EvalInFrame(0, "<debug>", "(lambda: x + 1)()")
+			if err != nil {
+				return err
+			}
+			got = v.String()
+			return nil
+		})
+	if _, err := pkgscript.ExecFileOptions(opts, thread, "opts.star", src, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got != "2" {
+		t.Errorf("EvalInFrame returned %q, want 2", got)
+	}
+
+	const expr = "(lambda: 1)()"
+	if _, err := pkgscript.ExprFunc("<expr>", expr, nil); err == nil {
+		t.Errorf("ExprFunc accepted lambda despite the global options")
+	}
+	fn, err := pkgscript.ExprFuncOptions(opts, "<expr>", expr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := pkgscript.Call(new(pkgscript.Thread), fn, nil, nil); err != nil || v.String() != "1" {
+		t.Errorf("ExprFuncOptions function returned %v, %v, want 1", v, err)
+	}
+	e, err := syntax.ParseExpr("<expr>", expr, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := pkgscript.EvalExprOptions(opts, new(pkgscript.Thread), e, nil); err != nil || v.String() != "1" {
+		t.Errorf("EvalExprOptions returned %v, %v, want 1", v, err)
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	const src = `
+def f(x):
+    y = x + 1
+    return y
+
+z = f(1)
+`
+	_, prog, err := pkgscript.SourceProgram("map.star", src, func(string) bool { return false })
+	if err != nil {
+		t.Fatal(err)
+	}
+	maps := prog.SourceMap()
+	if len(maps) != 2 || maps[0].Function != "<toplevel>" || maps[1].Function != "f" {
+		t.Fatalf("SourceMap returned %d maps, want <toplevel> and f", len(maps))
+	}
+	f := maps[1]
+	if got, want := f.Pos.String(), "map.star:2:1"; got != want {
+		t.Errorf("position of f = %s, want %s", got, want)
+	}
+	var got []string
+	for _, entry := range f.Entries {
+		got = append(got, fmt.Sprintf("%d:%d:%d", entry.PC, entry.Pos.Line, entry.Pos.Col))
+	}
+	// The first instruction of f is that of its first statement.
+	if want := "0:3:9 4:3:11 7:4:12"; strings.Join(got, " ") != want {
+		t.Errorf("entries of f = %s, want %s", strings.Join(got, " "), want)
+	}
+	for _, entry := range append(maps[0].Entries, f.Entries...) {
+		if entry.Pos.Filename() != "map.star" {
+			t.Errorf("entry %d has filename %q", entry.PC, entry.Pos.Filename())
+		}
+	}
+}
+
+// TestEmptyFilePosition ensures that even Programs
+// from empty files have a valid position.
+func TestEmptyPosition(t *testing.T) {
+	var predeclared pkgscript.StringDict
+	for _, content := range []string{"", "empty = False"} {
+		_, prog, err := pkgscript.SourceProgram("hello.star", content, predeclared.Has)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := prog.Filename(), "hello.star"; got != want {
+			t.Errorf("Program.Filename() = %q, want %q", got, want)
+		}
+	}
+}
+
+// TestUnpackUserDefined tests that user-defined
+// implementations of pkgscript.Value may be unpacked.
+func TestUnpackUserDefined(t *testing.T) {
+	// success
+	want := new(hasfields)
+	var x *hasfields
+	if err := pkgscript.UnpackArgs("unpack", pkgscript.Tuple{want}, nil, "x", &x); err != nil {
+		t.Errorf("UnpackArgs failed: %v", err)
+	}
+	if x != want {
+		t.Errorf("for x, got %v, want %v", x, want)
+	}
+
+	// failure
+	err := pkgscript.UnpackArgs("unpack", pkgscript.Tuple{pkgscript.MakeInt(42)}, nil, "x", &x)
+	if want := "unpack: for parameter x: got int, want hasfields"; fmt.Sprint(err) != want {
+		t.Errorf("unpack args error = %q, want %q", err, want)
+	}
+}
+
+// TestUnpackSlicesAndMaps tests the conversion of lists, tuples,
+// and dicts to Go slices and maps.
+func TestUnpackSlicesAndMaps(t *testing.T) {
+	strs := pkgscript.NewList([]pkgscript.Value{pkgscript.String("a"), pkgscript.String("b")})
+	ints := pkgscript.Tuple{pkgscript.MakeInt(1), pkgscript.MakeInt(2)}
+	env := pkgscript.NewDict(1)
+	env.SetKey(pkgscript.String("k"), pkgscript.String("v"))
+
+	var (
+		names []string
+		nums  []int
+		m     map[string]string
+		opt   []string
+	)
+	err := pkgscript.UnpackArgs("f", pkgscript.Tuple{strs, ints}, []pkgscript.Tuple{{pkgscript.String("env"), env}},
+		"names", &names, "nums", &nums, "env", &m, "opt?", &opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(names, nums, m, opt == nil); got != "[a b] [1 2] map[k:v] true" {
+		t.Errorf("got %s", got)
+	}
+
+	// Errors identify the offending element.
+	bad := pkgscript.NewDict(1)
+	bad.SetKey(pkgscript.String("k"), pkgscript.MakeInt(1))
+	badKey := pkgscript.NewDict(1)
+	badKey.SetKey(pkgscript.MakeInt(1), pkgscript.String("v"))
+	for _, test := range []struct {
+		arg  pkgscript.Value
+		ptr  interface{}
+		want string
+	}{
+		{pkgscript.NewList([]pkgscript.Value{pkgscript.MakeInt(1)}), &names, "f: for parameter 1: element 0: got int, want string"},
+		{pkgscript.Tuple{pkgscript.MakeInt(1), pkgscript.String("x")}, &nums, "f: for parameter 1: element 1: got string, want int"},
+		{pkgscript.String("ab"), &names, "f: for parameter 1: got string, want list of string"},
+		{pkgscript.None, &nums, "f: for parameter 1: got NoneType, want list of int"},
+		{strs, &m, "f: for parameter 1: got list, want dict of string to string"},
+		{bad, &m, `f: for parameter 1: key "k": got int, want string`},
+		{badKey, &m, "f: for parameter 1: key 1: got int, want string"},
+	} {
+		err := pkgscript.UnpackPositionalArgs("f", pkgscript.Tuple{test.arg}, nil, 1, test.ptr)
+		if fmt.Sprint(err) != test.want {
+			t.Errorf("unpack %s: got error %v, want %s", test.arg, err, test.want)
+		}
+	}
+	if fmt.Sprint(names, nums, m) != "[a b] [1 2] map[k:v]" {
+		t.Errorf("failed unpacking clobbered variables: %v %v %v", names, nums, m)
+	}
+}
+
+// A byteSize is a custom Unpacker that accepts an int or a string
+// such as "4k".
+type byteSize int
+
+func (b *byteSize) Unpack(v pkgscript.Value) error {
+	if s, ok := pkgscript.AsString(v); ok && strings.HasSuffix(s, "k") {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil {
+			return fmt.Errorf("invalid size %q", s)
+		}
+		*b = byteSize(n * 1024)
+		return nil
+	}
+	n, err := pkgscript.AsInt32(v)
+	if err != nil {
+		return fmt.Errorf("got %s, want size", v.Type())
+	}
+	*b = byteSize(n)
+	return nil
+}
+
+func TestUnpacker(t *testing.T) {
+	// copy accepts a path or a list of paths, and a size.
+	copy := func(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+		var path string
+		var paths []string
+		var size byteSize
+		if err := pkgscript.UnpackArgs(b.Name(), args, kwargs, "src", pkgscript.OneOf(&path, &paths), "size?", &size); err != nil {
+			return nil, err
+		}
+		if paths == nil {
+			paths = []string{path}
+		}
+		return pkgscript.String(fmt.Sprintf("%s %d", strings.Join(paths, ","), size)), nil
+	}
+	predeclared := pkgscript.StringDict{"copy": pkgscript.NewBuiltin("copy", copy)}
+	for _, test := range []struct{ src, want string }{
+		{`copy("a")`, `"a 0"`},
+		{`copy(["a", "b"], size=3)`, `"a,b 3"`},
+		{`copy(("a",), "2k")`, `"a 2048"`},
+		{`copy(1)`, "copy: for parameter src: got int, want string or list of string"},
+		{`copy(["a", 1])`, "copy: for parameter src: element 1: got int, want string"},
+		{`copy("a", "xk")`, `copy: for parameter size: invalid size "xk"`},
+		{`copy("a", None)`, "copy: for parameter size: got NoneType, want size"},
+	} {
+		v, err := pkgscript.Eval(new(pkgscript.Thread), "<expr>", test.src, predeclared)
+		var got string
+		if err != nil {
+			got = err.(*pkgscript.EvalError).Msg
+		} else {
+			got = v.String()
+		}
+		if got != test.want {
+			t.Errorf("%s = %s, want %s", test.src, got, test.want)
+		}
+	}
+}
+
+func TestDocstring(t *testing.T) {
+	globals, _ := pkgscript.ExecFile(&pkgscript.Thread{}, "doc.star", `
+def somefunc():
+	"somefunc doc"
+	return 0
+`, nil)
+
+	if globals["somefunc"].(*pkgscript.Function).Doc() != "somefunc doc" {
+		t.Fatal("docstring not found")
+	}
+}
+
+func TestFrameLocals(t *testing.T) {
+	// trace prints a nice stack trace including argument
+	// values of calls to Starlark functions.
+	trace := func(thread *pkgscript.Thread) string {
+		buf := new(bytes.Buffer)
+		for i := 0; i < thread.CallStackDepth(); i++ {
+			fr := thread.DebugFrame(i)
+			fmt.Fprintf(buf, "%s(", fr.Callable().Name())
+			if fn, ok := fr.Callable().(*pkgscript.Function); ok {
+				for i := 0; i < fn.NumParams(); i++ {
+					if i > 0 {
+						buf.WriteString(", ")
+					}
+					name, _ := fn.Param(i)
+					fmt.Fprintf(buf, "%s=%s", name, fr.Local(i))
+				}
+			} else {
+				buf.WriteString("...") // a built-in function
+			}
+			buf.WriteString(")\n")
+		}
+		return buf.String()
+	}
+
+	var got string
+	builtin := func(thread *pkgscript.Thread, _ *pkgscript.Builtin, _ pkgscript.Tuple, _ []pkgscript.Tuple) (pkgscript.Value, error) {
+		got = trace(thread)
+		return pkgscript.None, nil
+	}
+	predeclared := pkgscript.StringDict{
+		"builtin": pkgscript.NewBuiltin("builtin", builtin),
+	}
+	_, err := pkgscript.ExecFile(&pkgscript.Thread{}, "foo.star", `
+def f(x, y): builtin()
+def g(z): f(z, z*z)
+g(7)
+`, predeclared)
+	if err != nil {
+		t.Errorf("ExecFile failed: %v", err)
+	}
+
+	var want = `
+builtin(...)
+f(x=7, y=49)
+g(z=7)
+<toplevel>()
+`[1:]
+	if got != want {
+		t.Errorf("got <<%s>>, want <<%s>>", got, want)
+	}
+}
+
+type badType string
+
+func (b *badType) String() string        { return "badType" }
+func (b *badType) Type() string          { return "badType:" + string(*b) } // panics if b==nil
+func (b *badType) Truth() pkgscript.Bool  { return true }
+func (b *badType) Hash() (uint32, error) { return 0, nil }
+func (b *badType) Freeze()               {}
+
+var _ pkgscript.Value = new(badType)
+
+// TestUnpackErrorBadType verifies that the Unpack functions fail
+// gracefully when a parameter's default value's Type method panics.
+func TestUnpackErrorBadType(t *testing.T) {
+	for _, test := range []struct {
+		x    *badType
+		want string
+	}{
+		{new(badType), "got NoneType, want badType"},       // Starlark type name
+		{nil, "got NoneType, want *pkgscript_test.badType"}, // Go type name
+	} {
+		err := pkgscript.UnpackArgs("f", pkgscript.Tuple{pkgscript.None}, nil, "x", &test.x)
+		if err == nil {
+			t.Errorf("UnpackArgs succeeded unexpectedly")
+			continue
 		}
 		if !strings.Contains(err.Error(), test.want) {
 			t.Errorf("UnpackArgs error %q does not contain %q", err, test.want)
 		}
 	}
 }
+
+// TestConcurrentDialects checks that files of different dialects
+// may be compiled and executed concurrently.
+func TestConcurrentDialects(t *testing.T) {
+	run := func(opts *syntax.FileOptions) (pkgscript.Value, error) {
+		f, err := syntax.Parse("dialect.star", "x = 3 / 2", 0)
+		if err != nil {
+			return nil, err
+		}
+		f.Options = opts
+		prog, err := pkgscript.FileProgram(f, pkgscript.StringDict{}.Has)
+		if err != nil {
+			return nil, err
+		}
+		globals, err := prog.Init(new(pkgscript.Thread), nil)
+		return globals["x"], err
+	}
+
+	const n = 100
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*n)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			x, err := run(&syntax.FileOptions{AllowFloat: true})
+			if err != nil {
+				errs <- err
+			} else if x != pkgscript.Float(1.5) {
+				errs <- fmt.Errorf("with float: x = %v, want 1.5", x)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			_, err := run(&syntax.FileOptions{AllowFloat: false})
+			if err == nil || !strings.Contains(err.Error(), "floating point not enabled") {
+				errs <- fmt.Errorf("without float: got error %v, want floating point not enabled", err)
+			}
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+// TestFileOptions checks that files of different dialects may be
+// used in the same process without changing the global options.
+func TestFileOptions(t *testing.T) {
+	const src = "f = lambda x: x * 2\ny = f(21)\n"
+	thread := new(pkgscript.Thread)
+
+	globals, err := pkgscript.ExecFileOptions(&syntax.FileOptions{AllowLambda: true}, thread, "lambda.star", src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := globals["y"], pkgscript.MakeInt(42); got != want {
+		t.Errorf("y = %v, want %v", got, want)
+	}
+
+	_, err = pkgscript.ExecFileOptions(&syntax.FileOptions{}, thread, "nolambda.star", src, nil)
+	if err == nil || err.Error() != "nolambda.star:1:5: this Starlark dialect does not support lambda" {
+		t.Errorf("without lambda: got error %v", err)
+	}
+
+	// A nil *FileOptions denotes the global options.
+	f, err := (*syntax.FileOptions)(nil).Parse("global.star", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pkgscript.FileProgram(f, pkgscript.StringDict{}.Has); err == nil {
+		t.Errorf("with global options: got no error, want lambda error")
+	}
+
+	v, err := pkgscript.EvalOptions(&syntax.FileOptions{AllowLambda: true}, thread, "expr", "(lambda: 1)()", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != pkgscript.MakeInt(1) {
+		t.Errorf("EvalOptions returned %v, want 1", v)
+	}
+	if resolve.AllowLambda {
+		t.Errorf("global option AllowLambda was modified")
+	}
+}
+
+// squares is an Indexable that is not Iterable.
+type squares int
+
+func (n squares) Freeze()                     {}
+func (n squares) String() string              { return "squares" }
+func (n squares) Type() string                { return "squares" }
+func (n squares) Truth() pkgscript.Bool       { return n > 0 }
+func (n squares) Hash() (uint32, error)       { return 0, fmt.Errorf("squares is unhashable") }
+func (n squares) Len() int                    { return int(n) }
+func (n squares) Index(i int) pkgscript.Value { return pkgscript.MakeInt(i * i) }
+
+func TestInIndexable(t *testing.T) {
+	for _, test := range []struct {
+		x    int
+		want pkgscript.Bool
+	}{
+		{9, true},
+		{16, true},
+		{25, false},
+		{2, false},
+	} {
+		got, err := pkgscript.Binary(syntax.IN, pkgscript.MakeInt(test.x), squares(5))
+		if err != nil {
+			t.Errorf("%d in squares: %v", test.x, err)
+		} else if got != test.want {
+			t.Errorf("%d in squares = %v, want %v", test.x, got, test.want)
+		}
+	}
+}
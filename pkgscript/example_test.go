@@ -92,8 +92,9 @@ func ExampleThread_Load_sequential() {
 
 	cache := make(map[string]*entry)
 
-	var load func(_ *pkgscript.Thread, module string) (pkgscript.StringDict, error)
-	load = func(_ *pkgscript.Thread, module string) (pkgscript.StringDict, error) {
+	var load func(_ *pkgscript.Thread, module pkgscript.Value) (pkgscript.StringDict, error)
+	load = func(_ *pkgscript.Thread, modval pkgscript.Value) (pkgscript.StringDict, error) {
+		module, _ := pkgscript.AsString(modval)
 		e, ok := cache[module]
 		if e == nil {
 			if ok {
@@ -117,7 +118,7 @@ func ExampleThread_Load_sequential() {
 	}
 
 	thread := &pkgscript.Thread{Name: "exec c.star", Load: load}
-	globals, err := load(thread, "c.star")
+	globals, err := load(thread, pkgscript.String("c.star"))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -164,6 +165,59 @@ func ExampleThread_Load_parallel() {
 	// c = 2
 }
 
+// ExampleThread_NewChild demonstrates how threads created by NewChild
+// share a single step budget while running concurrently.
+func ExampleThread_NewChild() {
+	const work = `
+def count():
+    n = 0
+    for i in range(1000):
+        n += i
+    return n
+
+total = count()
+`
+	// Measure the cost of the work in isolation.
+	solo := new(pkgscript.Thread)
+	solo.SetMaxExecutionSteps(0) // no limit, but count steps
+	if _, err := pkgscript.ExecFile(solo, "work.star", work, nil); err != nil {
+		log.Fatal(err)
+	}
+	cost := solo.ExecutionSteps()
+
+	// Allow enough steps for one run, but not for two.
+	parent := &pkgscript.Thread{Name: "parent"}
+	parent.SetMaxExecutionSteps(cost * 3 / 2)
+
+	var wg sync.WaitGroup
+	errors := make([]error, 2)
+	for i := range errors {
+		child := parent.NewChild()
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errors[i] = pkgscript.ExecFile(child, "work.star", work, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	failed := false
+	for _, err := range errors {
+		if err != nil {
+			failed = true
+			fmt.Println(strings.Contains(err.Error(), "too many steps"))
+			break
+		}
+	}
+	fmt.Println("combined work exceeded the limit:", failed)
+	fmt.Println("steps recorded:", parent.ExecutionSteps() > cost)
+
+	// Output:
+	// true
+	// combined work exceeded the limit: true
+	// steps recorded: true
+}
+
 // TestThread_Load_parallelCycle demonstrates detection
 // of cycles during parallel loading.
 func TestThreadLoad_ParallelCycle(t *testing.T) {
@@ -194,15 +248,15 @@ func TestThreadLoad_ParallelCycle(t *testing.T) {
 	// b loads a, and a then fails to load c because it forms a cycle.
 	// The errors observed by the two goroutines are:
 	want1 := []string{
-		"cannot load a.star: cannot load c.star: cycle in load graph",                     // from b
-		"cannot load b.star: cannot load a.star: cannot load c.star: cycle in load graph", // from c
+		`cannot load "a.star": cannot load "c.star": cycle in load graph`,                       // from b
+		`cannot load "b.star": cannot load "a.star": cannot load "c.star": cycle in load graph`, // from c
 	}
 	// But if the c goroutine is slow to start, b loads a,
 	// and a loads c; then c fails to load b because it forms a cycle.
 	// The errors this time are:
 	want2 := []string{
-		"cannot load a.star: cannot load c.star: cannot load b.star: cycle in load graph", // from b
-		"cannot load b.star: cycle in load graph",                                         // from c
+		`cannot load "a.star": cannot load "c.star": cannot load "b.star": cycle in load graph`, // from b
+		`cannot load "b.star": cycle in load graph`,                                             // from c
 	}
 	if !reflect.DeepEqual(got, want1) && !reflect.DeepEqual(got, want2) {
 		t.Error(got)
@@ -268,8 +322,9 @@ func (c *cache) doLoad(cc *cycleChecker, module string) (pkgscript.StringDict, e
 	thread := &pkgscript.Thread{
 		Name:  "exec " + module,
 		Print: func(_ *pkgscript.Thread, msg string) { fmt.Println(msg) },
-		Load: func(_ *pkgscript.Thread, module string) (pkgscript.StringDict, error) {
+		Load: func(_ *pkgscript.Thread, modval pkgscript.Value) (pkgscript.StringDict, error) {
 			// Tunnel the cycle-checker state for this "thread of loading".
+			module, _ := pkgscript.AsString(modval)
 			return c.get(cc, module)
 		},
 	}
@@ -0,0 +1,284 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkgscript
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/andrewchambers/pkgscript/syntax"
+)
+
+// FixedInt is the type of a Starlark fixed-width integer, such as an
+// unsigned 32-bit integer, whose arithmetic wraps around modulo 2^bits,
+// like that of the machine registers it models.
+//
+// Fixed-width integers support the arithmetic operators + - * // %,
+// the bitwise operators & | ^ ~ << >>, and comparison.
+// Both operands of a binary operator must have the same width and
+// signedness, except that an int operand is first converted to the
+// type of the other operand, wrapping if necessary, and the count of
+// a shift may be any int or fixed-width integer.
+type FixedInt struct {
+	v      uint64 // the bits of the value; those above the width are zero
+	bits   uint8  // width in bits, 1 to 64
+	signed bool   // whether v is interpreted in two's complement
+}
+
+var (
+	_ Comparable = FixedInt{}
+	_ HasBinary  = FixedInt{}
+	_ HasUnary   = FixedInt{}
+	_ HasAttrs   = FixedInt{}
+)
+
+// MakeFixedInt returns a fixed-width integer of the specified width
+// and signedness whose bits are the low bits of x.
+// It panics unless 1 <= bits <= 64.
+func MakeFixedInt(x uint64, bits int, signed bool) FixedInt {
+	if bits < 1 || bits > 64 {
+		panic(fmt.Sprintf("MakeFixedInt: invalid width %d", bits))
+	}
+	return FixedInt{bits: uint8(bits), signed: signed}.wrap(x)
+}
+
+// wrap returns a value of the same type as f whose bits are the low bits of x.
+func (f FixedInt) wrap(x uint64) FixedInt {
+	f.v = x & (^uint64(0) >> (64 - f.bits))
+	return f
+}
+
+// Bits returns the width of f in bits.
+func (f FixedInt) Bits() int { return int(f.bits) }
+
+// Signed reports whether f is a signed integer.
+func (f FixedInt) Signed() bool { return f.signed }
+
+// Int64 returns the value of a signed fixed-width integer.
+func (f FixedInt) Int64() int64 {
+	shift := 64 - f.bits
+	return int64(f.v<<shift) >> shift // sign extend
+}
+
+// Uint64 returns the bits of f, zero-extended.
+func (f FixedInt) Uint64() uint64 { return f.v }
+
+// Int returns the value of f as an int.
+func (f FixedInt) Int() Int {
+	if f.signed {
+		return MakeInt64(f.Int64())
+	}
+	return MakeUint64(f.v)
+}
+
+// typeName returns a name for the type of f, such as "uint32".
+func (f FixedInt) typeName() string {
+	if f.signed {
+		return fmt.Sprintf("int%d", f.bits)
+	}
+	return fmt.Sprintf("uint%d", f.bits)
+}
+
+func (f FixedInt) String() string {
+	signed := "False"
+	if f.signed {
+		signed = "True"
+	}
+	return fmt.Sprintf("fixedint(%s, %d, %s)", f.Int(), f.bits, signed)
+}
+func (f FixedInt) Type() string { return "fixedint" }
+func (f FixedInt) Freeze()      {} // immutable
+func (f FixedInt) Truth() Bool  { return f.v != 0 }
+func (f FixedInt) Hash() (uint32, error) {
+	// Equal fixed-width and int values must yield the same hash.
+	return f.Int().Hash()
+}
+
+func (x FixedInt) CompareSameType(op syntax.Token, y_ Value, depth int) (bool, error) {
+	y := y_.(FixedInt)
+	return threeway(op, x.cmp(y.Int())), nil
+}
+
+// cmp compares the values of f and y.
+func (f FixedInt) cmp(y Int) int {
+	x := f.Int()
+	if x.big != nil || y.big != nil {
+		return x.BigInt().Cmp(y.BigInt())
+	}
+	return signum64(x.small - y.small)
+}
+
+func (f FixedInt) Attr(name string) (Value, error) {
+	switch name {
+	case "bits":
+		return MakeInt(int(f.bits)), nil
+	case "signed":
+		return Bool(f.signed), nil
+	case "value":
+		return f.Int(), nil
+	}
+	return nil, nil
+}
+
+func (f FixedInt) AttrNames() []string { return []string{"bits", "signed", "value"} }
+
+// Unary implements the operations +x, -x, and ~x.
+func (f FixedInt) Unary(op syntax.Token) (Value, error) {
+	switch op {
+	case syntax.MINUS:
+		return f.wrap(-f.v), nil
+	case syntax.PLUS:
+		return f, nil
+	case syntax.TILDE:
+		return f.wrap(^f.v), nil
+	}
+	return nil, nil
+}
+
+// Binary implements the arithmetic and bitwise operators on
+// fixed-width integers, converting an int operand to the type of f.
+func (f FixedInt) Binary(op syntax.Token, y_ Value, side Side) (Value, error) {
+	if op == syntax.LTLT || op == syntax.GTGT {
+		if side == Right {
+			return nil, nil // x << f, where x is not a fixedint
+		}
+		return f.shift(op, y_)
+	}
+
+	var y FixedInt
+	switch y_ := y_.(type) {
+	case FixedInt:
+		if y_.bits != f.bits || y_.signed != f.signed {
+			if side == Right {
+				return nil, fmt.Errorf("mismatched fixedint types: %s %s %s", y_.typeName(), op, f.typeName())
+			}
+			return nil, fmt.Errorf("mismatched fixedint types: %s %s %s", f.typeName(), op, y_.typeName())
+		}
+		y = y_
+	case Int:
+		y = f.wrap(lowBits(y_))
+	default:
+		return nil, nil // unhandled
+	}
+	x := f
+	if side == Right {
+		x, y = y, x
+	}
+
+	switch op {
+	case syntax.PLUS:
+		return x.wrap(x.v + y.v), nil
+	case syntax.MINUS:
+		return x.wrap(x.v - y.v), nil
+	case syntax.STAR:
+		return x.wrap(x.v * y.v), nil
+	case syntax.AMP:
+		return x.wrap(x.v & y.v), nil
+	case syntax.PIPE:
+		return x.wrap(x.v | y.v), nil
+	case syntax.CIRCUMFLEX:
+		return x.wrap(x.v ^ y.v), nil
+	case syntax.SLASHSLASH, syntax.PERCENT:
+		if y.v == 0 {
+			if op == syntax.SLASHSLASH {
+				return nil, fmt.Errorf("floored division by zero")
+			}
+			return nil, fmt.Errorf("integer modulo by zero")
+		}
+		if !x.signed {
+			if op == syntax.SLASHSLASH {
+				return x.wrap(x.v / y.v), nil
+			}
+			return x.wrap(x.v % y.v), nil
+		}
+		// Signed division rounds toward negative infinity,
+		// as for int. The most negative value divided by -1
+		// wraps around to itself.
+		a, b := x.Int64(), y.Int64()
+		q, r := a/b, a%b
+		if r != 0 && (r < 0) != (b < 0) {
+			q, r = q-1, r+b
+		}
+		if op == syntax.SLASHSLASH {
+			return x.wrap(uint64(q)), nil
+		}
+		return x.wrap(uint64(r)), nil
+	}
+	return nil, nil // unhandled
+}
+
+// shift implements f << y and f >> y.
+// Bits shifted beyond the width of f are discarded, and
+// a right shift of a signed integer replicates its sign bit.
+func (f FixedInt) shift(op syntax.Token, y Value) (Value, error) {
+	var n Int
+	switch y := y.(type) {
+	case Int:
+		n = y
+	case FixedInt:
+		n = y.Int()
+	default:
+		return nil, nil // unhandled
+	}
+	if n.Sign() < 0 {
+		return nil, fmt.Errorf("negative shift count: %v", n)
+	}
+	count := uint64(64)
+	if c, ok := n.Uint64(); ok && c < 64 {
+		count = c
+	}
+	if op == syntax.LTLT {
+		if count >= 64 {
+			return f.wrap(0), nil
+		}
+		return f.wrap(f.v << count), nil
+	}
+	if count >= 64 {
+		count = 63
+	}
+	if f.signed {
+		return f.wrap(uint64(f.Int64() >> count)), nil
+	}
+	if count >= uint64(f.bits) {
+		return f.wrap(0), nil
+	}
+	return f.wrap(f.v >> count), nil
+}
+
+// lowBits returns the low 64 bits of the two's complement
+// representation of x.
+func lowBits(x Int) uint64 {
+	if i, ok := x.Int64(); ok {
+		return uint64(i)
+	}
+	var low big.Int
+	low.And(x.BigInt(), maxUint64Big)
+	return low.Uint64()
+}
+
+var maxUint64Big = new(big.Int).SetUint64(^uint64(0))
+
+// https://github.com/google/pkgscript-go/blob/master/doc/spec.md#fixedint
+func fixedint(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	var x Value
+	var bits int
+	signed := true
+	if err := UnpackArgs(b.Name(), args, kwargs, "value", &x, "bits", &bits, "signed?", &signed); err != nil {
+		return nil, err
+	}
+	if bits < 1 || bits > 64 {
+		return nil, fmt.Errorf("%s: bits must be between 1 and 64, got %d", b.Name(), bits)
+	}
+	var v uint64
+	switch x := x.(type) {
+	case Int:
+		v = lowBits(x)
+	case FixedInt:
+		v = lowBits(x.Int())
+	default:
+		return nil, fmt.Errorf("%s: got %s, want int or fixedint", b.Name(), x.Type())
+	}
+	return MakeFixedInt(v, bits, signed), nil
+}
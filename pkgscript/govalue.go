@@ -0,0 +1,168 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkgscript
+
+// This file defines conversions between Starlark data values and the
+// Go values used by packages such as encoding/json.
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+)
+
+// maxGoValueDepth bounds the nesting of values converted by
+// AsGoValue and FromGoValue, so that cyclic values are rejected.
+const maxGoValueDepth = 1000
+
+// AsGoValue converts the Starlark data value v to a Go value
+// of the kind produced by encoding/json:
+//
+//      None                    -- nil
+//      bool                    -- bool
+//      int                     -- int64, or *big.Int if too large
+//      float                   -- float64
+//      string                  -- string
+//      list, tuple             -- []interface{}
+//      dict                    -- map[string]interface{}
+//
+// It returns an error if v is or contains a value of any other type,
+// a dict with a key that is not a string, or itself.
+func AsGoValue(v Value) (interface{}, error) {
+	return asGoValue(v, 0)
+}
+
+func asGoValue(v Value, depth int) (interface{}, error) {
+	if depth > maxGoValueDepth {
+		return nil, fmt.Errorf("value nested too deeply (possibly cyclic)")
+	}
+	switch v := v.(type) {
+	case NoneType:
+		return nil, nil
+	case Bool:
+		return bool(v), nil
+	case Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		return v.BigInt(), nil
+	case Float:
+		return float64(v), nil
+	case String:
+		return string(v), nil
+	case *List:
+		return asGoSlice(v.elems, depth)
+	case Tuple:
+		return asGoSlice(v, depth)
+	case *Dict:
+		m := make(map[string]interface{}, v.Len())
+		for _, item := range v.Items() {
+			k, ok := item[0].(String)
+			if !ok {
+				return nil, fmt.Errorf("dict key: got %s, want string", item[0].Type())
+			}
+			x, err := asGoValue(item[1], depth+1)
+			if err != nil {
+				return nil, err
+			}
+			m[string(k)] = x
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("cannot convert %s to a Go value", v.Type())
+}
+
+func asGoSlice(elems []Value, depth int) ([]interface{}, error) {
+	s := make([]interface{}, len(elems))
+	for i, elem := range elems {
+		x, err := asGoValue(elem, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		s[i] = x
+	}
+	return s, nil
+}
+
+// FromGoValue converts a Go value to a new Starlark value.
+// It is the inverse of AsGoValue, but it also accepts Go values of
+// any integer or floating-point type, any slice or array type,
+// and any map type whose key type is string.
+// Maps are converted to dicts whose keys are in sorted order.
+// A Starlark Value is returned unchanged.
+// It returns an error if x is or contains a value of any other type.
+func FromGoValue(x interface{}) (Value, error) {
+	return fromGoValue(reflect.ValueOf(x), 0)
+}
+
+var bigIntType = reflect.TypeOf((*big.Int)(nil))
+
+func fromGoValue(x reflect.Value, depth int) (Value, error) {
+	if depth > maxGoValueDepth {
+		return nil, fmt.Errorf("value nested too deeply (possibly cyclic)")
+	}
+	if !x.IsValid() {
+		return None, nil // nil interface
+	}
+	if x.Kind() == reflect.Interface {
+		return fromGoValue(x.Elem(), depth)
+	}
+	if x.CanInterface() {
+		if v, ok := x.Interface().(Value); ok {
+			return v, nil
+		}
+	}
+	if x.Type() == bigIntType {
+		if x.IsNil() {
+			return None, nil
+		}
+		return MakeBigInt(x.Interface().(*big.Int)), nil
+	}
+	switch x.Kind() {
+	case reflect.Bool:
+		return Bool(x.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return MakeInt64(x.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return MakeUint64(x.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return Float(x.Float()), nil
+	case reflect.String:
+		return String(x.String()), nil
+	case reflect.Slice, reflect.Array:
+		if x.Kind() == reflect.Slice && x.IsNil() {
+			return None, nil
+		}
+		elems := make([]Value, x.Len())
+		for i := range elems {
+			elem, err := fromGoValue(x.Index(i), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			elems[i] = elem
+		}
+		return NewList(elems), nil
+	case reflect.Map:
+		if x.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("cannot convert %s to a Starlark value: key type is not string", x.Type())
+		}
+		if x.IsNil() {
+			return None, nil
+		}
+		keys := x.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		dict := NewDict(len(keys))
+		for _, k := range keys {
+			v, err := fromGoValue(x.MapIndex(k), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			dict.SetKey(String(k.String()), v) // can't fail: strings are hashable
+		}
+		return dict, nil
+	}
+	return nil, fmt.Errorf("cannot convert %s to a Starlark value", x.Type())
+}
@@ -0,0 +1,125 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkgscript_test
+
+import (
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/andrewchambers/pkgscript/pkgscript"
+)
+
+func TestAsGoValue(t *testing.T) {
+	globals, err := pkgscript.ExecFile(new(pkgscript.Thread), "config.star", `
+config = {
+    "name": "pkg",
+    "version": [1, (2, 3)],
+    "enabled": True,
+    "size": 1 << 70,
+    "deps": {"a": None, "b": {"c": -1}},
+}
+`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := pkgscript.AsGoValue(globals["config"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"name":    "pkg",
+		"version": []interface{}{int64(1), []interface{}{int64(2), int64(3)}},
+		"enabled": true,
+		"size":    new(big.Int).Lsh(big.NewInt(1), 70),
+		"deps":    map[string]interface{}{"a": nil, "b": map[string]interface{}{"c": int64(-1)}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AsGoValue = %#v, want %#v", got, want)
+	}
+	data, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const wantJSON = `{"deps":{"a":null,"b":{"c":-1}},"enabled":true,"name":"pkg","size":1180591620717411303424,"version":[1,[2,3]]}`
+	if string(data) != wantJSON {
+		t.Errorf("json.Marshal(AsGoValue) = %s, want %s", data, wantJSON)
+	}
+
+	// Round trip through JSON and back to Starlark.
+	var x interface{}
+	if err := json.Unmarshal(data, &x); err != nil {
+		t.Fatal(err)
+	}
+	v, err := pkgscript.FromGoValue(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const wantStarlark = `{"deps": {"a": None, "b": {"c": -1.0}}, "enabled": True, "name": "pkg", "size": 1.1805916207174113e+21, "version": [1.0, [2.0, 3.0]]}`
+	if v.String() != wantStarlark {
+		t.Errorf("FromGoValue = %s, want %s", v, wantStarlark)
+	}
+}
+
+func TestAsGoValueErrors(t *testing.T) {
+	cyclic := pkgscript.NewList(nil)
+	cyclic.Append(cyclic)
+	dict := pkgscript.NewDict(1)
+	dict.SetKey(pkgscript.MakeInt(1), pkgscript.None)
+	for _, test := range []struct {
+		v    pkgscript.Value
+		want string
+	}{
+		{dict, "dict key: got int, want string"},
+		{pkgscript.Tuple{pkgscript.NewSet(0)}, "cannot convert set to a Go value"},
+		{cyclic, "value nested too deeply (possibly cyclic)"},
+	} {
+		if _, err := pkgscript.AsGoValue(test.v); err == nil {
+			t.Errorf("AsGoValue(%s) succeeded unexpectedly", test.v)
+		} else if err.Error() != test.want {
+			t.Errorf("AsGoValue(%s) failed with %q, want %q", test.v, err, test.want)
+		}
+	}
+}
+
+func TestFromGoValue(t *testing.T) {
+	for _, test := range []struct {
+		x    interface{}
+		want string
+	}{
+		{nil, "None"},
+		{uint8(7), "7"},
+		{uint64(1 << 63), "9223372036854775808"},
+		{float32(0.5), "0.5"},
+		{[]string{"a", "b"}, `["a", "b"]`},
+		{[2]bool{true, false}, "[True, False]"},
+		{map[string]int{"z": 1, "a": 2}, `{"a": 2, "z": 1}`},
+		{[]interface{}{pkgscript.Tuple{pkgscript.MakeInt(1)}}, "[(1,)]"},
+		{big.NewInt(-5), "-5"},
+	} {
+		v, err := pkgscript.FromGoValue(test.x)
+		if err != nil {
+			t.Errorf("FromGoValue(%#v): %v", test.x, err)
+		} else if v.String() != test.want {
+			t.Errorf("FromGoValue(%#v) = %s, want %s", test.x, v, test.want)
+		}
+	}
+
+	for _, test := range []struct {
+		x    interface{}
+		want string
+	}{
+		{map[int]string{}, "cannot convert map[int]string to a Starlark value: key type is not string"},
+		{[]interface{}{struct{}{}}, "cannot convert struct {} to a Starlark value"},
+		{make(chan int), "cannot convert chan int to a Starlark value"},
+	} {
+		if _, err := pkgscript.FromGoValue(test.x); err == nil {
+			t.Errorf("FromGoValue(%#v) succeeded unexpectedly", test.x)
+		} else if err.Error() != test.want {
+			t.Errorf("FromGoValue(%#v) failed with %q, want %q", test.x, err, test.want)
+		}
+	}
+}
@@ -173,6 +173,12 @@ func (ht *hashtable) grow() {
 }
 
 func (ht *hashtable) lookup(k Value) (v Value, found bool, err error) {
+	return ht.lookupDepth(k, maxdepth)
+}
+
+// lookupDepth is like lookup, but compares keys using EqualDepth
+// with the specified depth.
+func (ht *hashtable) lookupDepth(k Value, depth int) (v Value, found bool, err error) {
 	h, err := k.Hash()
 	if err != nil {
 		return nil, false, err // unhashable
@@ -189,7 +195,7 @@ func (ht *hashtable) lookup(k Value) (v Value, found bool, err error) {
 		for i := range p.entries {
 			e := &p.entries[i]
 			if e.hash == h {
-				if eq, err := Equal(k, e.key); err != nil {
+				if eq, err := EqualDepth(k, e.key, depth); err != nil {
 					return nil, false, err // e.g. excessively recursive tuple
 				} else if eq {
 					return e.value, true, nil // found
@@ -229,6 +235,14 @@ func (ht *hashtable) keys() []Value {
 	return keys
 }
 
+func (ht *hashtable) values() []Value {
+	values := make([]Value, 0, ht.len)
+	for e := ht.head; e != nil; e = e.next {
+		values = append(values, e.value)
+	}
+	return values
+}
+
 func (ht *hashtable) delete(k Value) (v Value, found bool, err error) {
 	if ht.frozen {
 		return nil, false, fmt.Errorf("cannot delete from frozen hash table")
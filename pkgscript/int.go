@@ -296,6 +296,18 @@ func (x Int) Mod(y Int) Int {
 	return Int{small: rem}
 }
 
+// TrueDiv returns the float nearest to the exact quotient x / y,
+// even if x and y are too large to be represented as floats.
+// y must not be zero.
+func (x Int) TrueDiv(y Int) Float {
+	const maxExact = 1 << 53 // ints of smaller magnitude convert to float exactly
+	if x.big == nil && y.big == nil && -maxExact <= x.small && x.small <= maxExact && -maxExact <= y.small && y.small <= maxExact {
+		return Float(x.small) / Float(y.small)
+	}
+	f, _ := new(big.Rat).SetFrac(x.BigInt(), y.BigInt()).Float64()
+	return Float(f)
+}
+
 func (i Int) rational() *big.Rat {
 	if i.big != nil {
 		return new(big.Rat).SetInt(i.big)
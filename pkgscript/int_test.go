@@ -70,3 +70,30 @@ func TestIntOpts(t *testing.T) {
 		}
 	}
 }
+
+// TestRepeatSize checks the limit on the size of repeated sequences at its boundary.
+func TestRepeatSize(t *testing.T) {
+	for _, test := range []struct {
+		size, n int
+		want    string
+	}{
+		{1, maxAlloc - 1, "1073741823"},
+		{1, maxAlloc, "excessive repeat (1073741824 elements)"},
+		{2, maxAlloc/2 - 1, "1073741822"},
+		{2, maxAlloc / 2, "excessive repeat (1073741824 elements)"},
+		{3, maxAlloc / 3, "1073741823"}, // maxAlloc is not a multiple of 3
+		{3, maxAlloc/3 + 1, "excessive repeat (1073741826 elements)"},
+		{maxAlloc, 1, "excessive repeat (1073741824 elements)"},
+		{math.MaxInt32, math.MaxInt32, "excessive repeat (4611686014132420609 elements)"},
+	} {
+		var got string
+		if sz, err := repeatSize(test.size, test.n); err != nil {
+			got = err.Error()
+		} else {
+			got = fmt.Sprint(sz)
+		}
+		if got != test.want {
+			t.Errorf("repeatSize(%d, %d) = %s, want %s", test.size, test.n, got, test.want)
+		}
+	}
+}
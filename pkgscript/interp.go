@@ -5,10 +5,10 @@ package pkgscript
 import (
 	"fmt"
 	"os"
+	"sync/atomic"
 
 	"github.com/andrewchambers/pkgscript/internal/compile"
 	"github.com/andrewchambers/pkgscript/internal/spell"
-	"github.com/andrewchambers/pkgscript/resolve"
 	"github.com/andrewchambers/pkgscript/syntax"
 )
 
@@ -19,7 +19,7 @@ const vmdebug = false // TODO(adonovan): use a bitfield of specific kinds of err
 // - opt: record MaxIterStack during compilation and preallocate the stack.
 
 func (fn *Function) CallInternal(thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
-	if !resolve.AllowRecursion {
+	if !fn.module.program.Recursion {
 		// detect recursion
 		for _, fr := range thread.stack[:len(thread.stack)-1] {
 			// We look for the same function code,
@@ -76,14 +76,54 @@ func (fn *Function) CallInternal(thread *Thread, args Tuple, kwargs []Tuple) (Va
 
 	var iterstack []Iterator // stack of active iterators
 
+	counters := coverageCounters(f) // non-nil => coverage enabled
+
+	var breakpoints map[uint32]bool // non-nil => f contains breakpoints
+	if thread.breakpoints != nil {
+		breakpoints = thread.breakpointsIn(f)
+	}
+
 	sp := 0
 	var pc uint32
 	var result Value
+	var line int32 // line most recently reported to the step hook
 	code := f.Code
 loop:
 	for {
+		if hook := thread.stepHook; hook != nil {
+			// Report each statement, and the head of a loop
+			// when a backward jump returns to it.
+			pos, ok := f.ExactPosition(pc)
+			if !ok && pc < fr.pc {
+				pos, ok = f.Position(pc), true
+			}
+			if ok && pos.Line != line {
+				line = pos.Line
+				fr.pc = pc
+				if err = hook(fr, pos); err != nil {
+					break loop
+				}
+			}
+		}
+
 		fr.pc = pc
 
+		if breakpoints != nil && breakpoints[pc] {
+			if err = thread.onBreakpoint(thread, f.Position(pc)); err != nil {
+				break loop
+			}
+		}
+
+		if counters != nil {
+			atomic.AddUint32(&counters[pc], 1)
+		}
+
+		if m := thread.meter; m != nil {
+			if err = m.step(); err != nil {
+				break loop
+			}
+		}
+
 		op := compile.Opcode(code[pc])
 		pc++
 		var arg uint32
@@ -155,11 +195,29 @@ loop:
 			y := stack[sp-1]
 			x := stack[sp-2]
 			sp -= 2
-			z, err2 := Binary(binop, x, y)
+			if m := thread.meter; m != nil {
+				if err = m.alloc(binaryAllocs(binop, x, y)); err != nil {
+					break loop
+				}
+			}
+			z, err2 := evalBinary(thread, binop, x, y)
 			if err2 != nil {
 				err = err2
 				break loop
 			}
+			if _, ok := z.(Float); ok && binop == syntax.SLASH && !f.Prog.Float {
+				// Dividing decimals is always allowed; only a float result is gated.
+				err = fmt.Errorf("floating point not enabled (use // for floored division)")
+				break loop
+			}
+			if m := thread.meter; m != nil && binop == syntax.PERCENT {
+				// The size of a formatted string is known only now.
+				if s, ok := z.(String); ok {
+					if err = m.alloc(uint64(len(s))); err != nil {
+						break loop
+					}
+				}
+			}
 			stack[sp] = z
 			sp++
 
@@ -192,11 +250,18 @@ loop:
 					if err = xlist.checkMutable("apply += to"); err != nil {
 						break loop
 					}
-					listExtend(xlist, yiter)
+					if err = listExtend(thread, xlist, yiter); err != nil {
+						break loop
+					}
 					z = xlist
 				}
 			}
 			if z == nil {
+				if m := thread.meter; m != nil {
+					if err = m.alloc(binaryAllocs(syntax.PLUS, x, y)); err != nil {
+						break loop
+					}
+				}
 				z, err = Binary(syntax.PLUS, x, y)
 				if err != nil {
 					break loop
@@ -282,7 +347,7 @@ loop:
 			}
 			if args != nil {
 				// Add elements from *args sequence.
-				iter := Iterate(args)
+				iter := IterateThread(thread, args)
 				if iter == nil {
 					err = fmt.Errorf("argument after * must be iterable, not %s", args.Type())
 					break loop
@@ -292,6 +357,9 @@ loop:
 					positional = append(positional, elem)
 				}
 				iter.Done()
+				if err = IterErr(iter); err != nil {
+					break loop
+				}
 			}
 
 			function := stack[sp-1]
@@ -301,6 +369,35 @@ loop:
 					function, positional, kvpairs, f.Position(fr.pc))
 			}
 
+			// Optimization: a recursive call in tail position
+			// reuses the current frame, so that tail recursion
+			// runs in constant space. Only calls of the same
+			// function code are affected, so the backtraces of
+			// ordinary calls are unchanged.
+			if f.Prog.Recursion && compile.Opcode(code[pc]) == compile.RETURN {
+				if callee, ok := function.(*Function); ok && callee.funcode == f {
+					for _, iter := range iterstack {
+						iter.Done()
+					}
+					iterstack = iterstack[:0]
+					for i := range space {
+						space[i] = nil
+					}
+					if err = setArgs(locals, callee, positional, kvpairs); err != nil {
+						break loop
+					}
+					for _, index := range f.Cells {
+						locals[index] = &cell{locals[index]}
+					}
+					fn = callee
+					fr.callable = callee
+					sp = 0
+					pc = 0
+					line = 0
+					continue loop
+				}
+			}
+
 			thread.endProfSpan()
 			z, err2 := Call(thread, function, positional, kvpairs)
 			thread.beginProfSpan()
@@ -316,7 +413,7 @@ loop:
 		case compile.ITERPUSH:
 			x := stack[sp-1]
 			sp--
-			iter := Iterate(x)
+			iter := IterateThread(thread, x)
 			if iter == nil {
 				err = fmt.Errorf("%s value is not iterable", x.Type())
 				break loop
@@ -327,6 +424,8 @@ loop:
 			iter := iterstack[len(iterstack)-1]
 			if iter.Next(&stack[sp]) {
 				sp++
+			} else if err = IterErr(iter); err != nil {
+				break loop
 			} else {
 				pc = arg
 			}
@@ -348,11 +447,27 @@ loop:
 			y := stack[sp-2]
 			x := stack[sp-3]
 			sp -= 3
+			if m := thread.meter; m != nil {
+				if _, ok := x.(*Dict); ok {
+					if err = m.alloc(dictEntrySize); err != nil {
+						break loop
+					}
+				}
+			}
 			err = setIndex(x, y, z)
 			if err != nil {
 				break loop
 			}
 
+		case compile.DELINDEX:
+			y := stack[sp-1]
+			x := stack[sp-2]
+			sp -= 2
+			err = delIndex(x, y)
+			if err != nil {
+				break loop
+			}
+
 		case compile.INDEX:
 			y := stack[sp-1]
 			x := stack[sp-2]
@@ -385,6 +500,15 @@ loop:
 				break loop
 			}
 
+		case compile.DELFIELD:
+			x := stack[sp-1]
+			sp--
+			name := f.Prog.Names[arg]
+			if err2 := delField(x, name); err2 != nil {
+				err = err2
+				break loop
+			}
+
 		case compile.MAKEDICT:
 			stack[sp] = new(Dict)
 			sp++
@@ -394,6 +518,11 @@ loop:
 			k := stack[sp-2]
 			v := stack[sp-1]
 			sp -= 3
+			if m := thread.meter; m != nil {
+				if err = m.alloc(dictEntrySize); err != nil {
+					break loop
+				}
+			}
 			oldlen := dict.Len()
 			if err2 := dict.SetKey(k, v); err2 != nil {
 				err = err2
@@ -408,6 +537,11 @@ loop:
 			elem := stack[sp-1]
 			list := stack[sp-2].(*List)
 			sp -= 2
+			if m := thread.meter; m != nil {
+				if err = m.alloc(valueSize); err != nil {
+					break loop
+				}
+			}
 			list.elems = append(list.elems, elem)
 
 		case compile.SLICE:
@@ -428,7 +562,7 @@ loop:
 			n := int(arg)
 			iterable := stack[sp-1]
 			sp--
-			iter := Iterate(iterable)
+			iter := IterateThread(thread, iterable)
 			if iter == nil {
 				err = fmt.Errorf("got %s in sequence assignment", iterable.Type())
 				break loop
@@ -439,12 +573,15 @@ loop:
 				i++
 			}
 			var dummy Value
-			if iter.Next(&dummy) {
+			if i == n && iter.Next(&dummy) {
 				// NB: Len may return -1 here in obscure cases.
 				err = fmt.Errorf("too many values to unpack (got %d, want %d)", Len(iterable), n)
 				break loop
 			}
 			iter.Done()
+			if err = IterErr(iter); err != nil {
+				break loop
+			}
 			if i < n {
 				err = fmt.Errorf("too few values to unpack (got %d, want %d)", i, n)
 				break loop
@@ -506,6 +643,10 @@ loop:
 				err = fmt.Errorf("cannot load %s: %v", module, err2)
 				break loop
 			}
+			thread.recordLoad(module)
+			if thread.FreezeLoads {
+				FreezeGlobals(dict)
+			}
 
 			for i := 0; i < n; i++ {
 				from := string(stack[sp-1-i].(String))
@@ -534,6 +675,26 @@ loop:
 			fn.module.globals[arg] = stack[sp-1]
 			sp--
 
+		case compile.DELLOCAL:
+			switch locals[arg].(type) {
+			case nil:
+				err = fmt.Errorf("local variable %s referenced before assignment", f.Locals[arg].Name)
+			case *cell:
+				err = fmt.Errorf("cannot delete variable %s used by a nested function", f.Locals[arg].Name)
+			default:
+				locals[arg] = nil
+			}
+			if err != nil {
+				break loop
+			}
+
+		case compile.DELGLOBAL:
+			if fn.module.globals[arg] == nil {
+				err = fmt.Errorf("global variable %s referenced before assignment", f.Prog.Globals[arg].Name)
+				break loop
+			}
+			fn.module.globals[arg] = nil
+
 		case compile.LOCAL:
 			x := locals[arg]
 			if x == nil {
@@ -566,6 +727,9 @@ loop:
 			if x == nil {
 				err = fmt.Errorf("internal error: predeclared variable %s is uninitialized", name)
 				break loop
+			} else if x == unboundGlobal {
+				err = fmt.Errorf("global variable %s referenced before assignment", name)
+				break loop
 			}
 			stack[sp] = x
 			sp++
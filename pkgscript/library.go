@@ -10,8 +10,8 @@ package pkgscript
 // mutable types such as lists and dicts.
 
 import (
-	"errors"
 	"fmt"
+	"math"
 	"math/big"
 	"os"
 	"sort"
@@ -35,41 +35,56 @@ var Universe StringDict
 func init() {
 	// https://github.com/google/pkgscript-go/blob/master/doc/spec.md#built-in-constants-and-functions
 	Universe = StringDict{
-		"None":      None,
-		"True":      True,
-		"False":     False,
-		"any":       NewBuiltin("any", any),
-		"all":       NewBuiltin("all", all),
-		"bool":      NewBuiltin("bool", bool_),
-		"chr":       NewBuiltin("chr", chr),
-		"dict":      NewBuiltin("dict", dict),
-		"dir":       NewBuiltin("dir", dir),
-		"enumerate": NewBuiltin("enumerate", enumerate),
-		"fail":      NewBuiltin("fail", fail),
-		"float":     NewBuiltin("float", float), // requires resolve.AllowFloat
-		"getattr":   NewBuiltin("getattr", getattr),
-		"hasattr":   NewBuiltin("hasattr", hasattr),
-		"hash":      NewBuiltin("hash", hash),
-		"int":       NewBuiltin("int", int_),
-		"len":       NewBuiltin("len", len_),
-		"list":      NewBuiltin("list", list),
-		"max":       NewBuiltin("max", minmax),
-		"min":       NewBuiltin("min", minmax),
-		"ord":       NewBuiltin("ord", ord),
-		"print":     NewBuiltin("print", print),
-		"range":     NewBuiltin("range", range_),
-		"repr":      NewBuiltin("repr", repr),
-		"reversed":  NewBuiltin("reversed", reversed),
-		"set":       NewBuiltin("set", set), // requires resolve.AllowSet
-		"sorted":    NewBuiltin("sorted", sorted),
-		"str":       NewBuiltin("str", str),
-		"tuple":     NewBuiltin("tuple", tuple),
-		"type":      NewBuiltin("type", type_),
-		"zip":       NewBuiltin("zip", zip),
-	}
-}
-
-type builtinMethod func(b *Builtin, args Tuple, kwargs []Tuple) (Value, error)
+		"None":       None,
+		"True":       True,
+		"False":      False,
+		"abs":        NewBuiltin("abs", abs),
+		"accumulate": NewBuiltin("accumulate", accumulate),
+		"any":        NewBuiltin("any", any),
+		"all":        NewBuiltin("all", all),
+		"assert":     NewBuiltin("assert", assert_),
+		"bool":       NewBuiltin("bool", bool_),
+		"chr":        NewBuiltin("chr", chr),
+		"decimal":    NewBuiltin("decimal", decimal_),
+		"deepcopy":   NewBuiltin("deepcopy", deepcopy),
+		"dict":       NewBuiltin("dict", dict),
+		"dir":        NewBuiltin("dir", dir),
+		"divmod":     NewBuiltin("divmod", divmod),
+		"enumerate":  NewBuiltin("enumerate", enumerate),
+		"fail":       NewBuiltin("fail", fail),
+		"filter":     NewBuiltin("filter", filter),
+		"fixedint":   NewBuiltin("fixedint", fixedint),
+		"float":      NewBuiltin("float", float),         // requires resolve.AllowFloat
+		"frozenset":  NewBuiltin("frozenset", frozenset), // requires resolve.AllowSet
+		"getattr":    NewBuiltin("getattr", getattr),
+		"hasattr":    NewBuiltin("hasattr", hasattr),
+		"hash":       NewBuiltin("hash", hash),
+		"input":      NewBuiltin("input", input),
+		"int":        NewBuiltin("int", int_),
+		"len":        NewBuiltin("len", len_),
+		"list":       NewBuiltin("list", list),
+		"map":        NewBuiltin("map", map_),
+		"max":        NewBuiltin("max", minmax),
+		"min":        NewBuiltin("min", minmax),
+		"ord":        NewBuiltin("ord", ord),
+		"print":      NewBuiltin("print", print),
+		"range":      NewBuiltin("range", range_),
+		"reduce":     NewBuiltin("reduce", reduce),
+		"repr":       NewBuiltin("repr", repr),
+		"reversed":   NewBuiltin("reversed", reversed),
+		"round":      NewBuiltin("round", round),
+		"set":        NewBuiltin("set", set), // requires resolve.AllowSet
+		"setattr":    NewBuiltin("setattr", setattr),
+		"sorted":     NewBuiltin("sorted", sorted),
+		"str":        NewBuiltin("str", str),
+		"switch":     NewBuiltin("switch", switch_),
+		"tuple":      NewBuiltin("tuple", tuple),
+		"type":       NewBuiltin("type", type_),
+		"zip":        NewBuiltin("zip", zip),
+	}
+}
+
+type builtinMethod func(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 
 // methods of built-in types
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#built-in-methods
@@ -89,6 +104,7 @@ var (
 	listMethods = map[string]builtinMethod{
 		"append": list_append,
 		"clear":  list_clear,
+		"count":  list_count,
 		"extend": list_extend,
 		"index":  list_index,
 		"insert": list_insert,
@@ -96,6 +112,11 @@ var (
 		"remove": list_remove,
 	}
 
+	tupleMethods = map[string]builtinMethod{
+		"count": tuple_count,
+		"index": tuple_index,
+	}
+
 	stringMethods = map[string]builtinMethod{
 		"capitalize":     string_capitalize,
 		"codepoint_ords": string_iterable,
@@ -104,8 +125,10 @@ var (
 		"elem_ords":      string_iterable,
 		"elems":          string_iterable,   // sic
 		"endswith":       string_startswith, // sic
+		"expandtabs":     string_expandtabs,
 		"find":           string_find,
 		"format":         string_format,
+		"format_map":     string_format_map,
 		"index":          string_index,
 		"isalnum":        string_isalnum,
 		"isalpha":        string_isalpha,
@@ -118,6 +141,8 @@ var (
 		"lower":          string_lower,
 		"lstrip":         string_strip, // sic
 		"partition":      string_partition,
+		"removeprefix":   string_removefix,
+		"removesuffix":   string_removefix, // sic
 		"replace":        string_replace,
 		"rfind":          string_rfind,
 		"rindex":         string_rindex,
@@ -130,11 +155,16 @@ var (
 		"strip":          string_strip,
 		"title":          string_title,
 		"upper":          string_upper,
+		"zfill":          string_zfill,
 	}
 
 	setMethods = map[string]builtinMethod{
 		"union": set_union,
 	}
+
+	frozensetMethods = map[string]builtinMethod{
+		"union": set_union,
+	}
 )
 
 func builtinAttr(recv Value, name string, methods map[string]builtinMethod) (Value, error) {
@@ -143,11 +173,7 @@ func builtinAttr(recv Value, name string, methods map[string]builtinMethod) (Val
 		return nil, nil // no such method
 	}
 
-	// Allocate a closure over 'method'.
-	impl := func(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
-		return method(b, args, kwargs)
-	}
-	return NewBuiltin(name, impl).BindReceiver(recv), nil
+	return NewBuiltin(name, method).BindReceiver(recv), nil
 }
 
 func builtinAttrNames(methods map[string]builtinMethod) []string {
@@ -161,13 +187,132 @@ func builtinAttrNames(methods map[string]builtinMethod) []string {
 
 // ---- built-in functions ----
 
+// https://github.com/google/pkgscript-go/blob/master/doc/spec.md#abs
+func abs(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	var x Value
+	if err := UnpackPositionalArgs("abs", args, kwargs, 1, &x); err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case Int:
+		if x.Sign() >= 0 {
+			return x, nil
+		}
+		return zero.Sub(x), nil
+	case Float:
+		return Float(math.Abs(float64(x))), nil
+	}
+	return nil, fmt.Errorf("abs: got %s, want int or float", x.Type())
+}
+
+// https://github.com/google/pkgscript-go/blob/master/doc/spec.md#accumulate
+func accumulate(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	var iterable Iterable
+	var fn Value = None
+	if err := UnpackArgs(b.Name(), args, kwargs, "iterable", &iterable, "fn?", &fn); err != nil {
+		return nil, err
+	}
+	if _, ok := fn.(Callable); !ok && fn != None {
+		return nil, fmt.Errorf("%s: for parameter fn: got %s, want callable", b.Name(), fn.Type())
+	}
+	return &lazyIterable{
+		name:     "accumulate",
+		operands: Tuple{iterable, fn},
+		iterate: func(thread *Thread) Iterator {
+			iter := IterateThread(thread, iterable)
+			var acc Value // nil before the first element
+			it := &lazyIterator{done: iter.Done}
+			it.next = func(p *Value) bool {
+				var x Value
+				if it.err != nil || !iter.Next(&x) {
+					if it.err == nil {
+						it.err = IterErr(iter)
+					}
+					return false
+				}
+				if acc == nil {
+					acc = x
+				} else if fn == None {
+					z, err := Binary(syntax.PLUS, acc, x)
+					if err != nil {
+						it.err = nameErr(b, err)
+						return false
+					}
+					acc = z
+				} else {
+					z, err := lazyCall(thread, b, fn, Tuple{acc, x})
+					if err != nil {
+						it.err = err // to preserve backtrace, don't modify error
+						return false
+					}
+					acc = z
+				}
+				*p = acc
+				return true
+			}
+			return it
+		},
+	}, nil
+}
+
+// A lazyIterable is the result of a built-in such as accumulate,
+// which computes its elements only as they are needed.
+// Each call of Iterate starts a new computation.
+//
+// The computation calls functions on the thread that consumes the
+// iterator, not the one that created the lazyIterable, as the two
+// may differ.
+type lazyIterable struct {
+	name     string                        // name of the built-in
+	operands Tuple                         // frozen by Freeze
+	iterate  func(thread *Thread) Iterator // returns a new lazyIterator
+}
+
+var _ ThreadIterable = (*lazyIterable)(nil)
+
+func (it *lazyIterable) String() string    { return "<" + it.name + ">" }
+func (it *lazyIterable) Type() string      { return it.name }
+func (it *lazyIterable) Freeze()           { it.operands.Freeze() }
+func (it *lazyIterable) Truth() Bool       { return True }
+func (it *lazyIterable) Iterate() Iterator { return it.iterate(nil) }
+func (it *lazyIterable) IterateThread(thread *Thread) Iterator {
+	return it.iterate(thread)
+}
+func (it *lazyIterable) Hash() (uint32, error) {
+	return 0, fmt.Errorf("unhashable type: %s", it.name)
+}
+
+// A lazyIterator is an iterator over a lazyIterable.
+// Its next function records any failure in err.
+type lazyIterator struct {
+	next func(p *Value) bool
+	done func()
+	err  error
+}
+
+var _ FallibleIterator = (*lazyIterator)(nil)
+
+func (it *lazyIterator) Next(p *Value) bool { return it.next(p) }
+func (it *lazyIterator) Done()              { it.done() }
+func (it *lazyIterator) Err() error         { return it.err }
+
+// lazyCall calls fn on behalf of the lazy result of built-in b.
+// The thread is nil if the iterator was obtained by Iterate
+// rather than IterateThread.
+func lazyCall(thread *Thread, b *Builtin, fn Value, args Tuple) (Value, error) {
+	if thread == nil {
+		return nil, fmt.Errorf("%s: cannot call %s without a thread", b.Name(), fn)
+	}
+	return Call(thread, fn, args, nil)
+}
+
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#all
 func all(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	var iterable Iterable
 	if err := UnpackPositionalArgs("all", args, kwargs, 1, &iterable); err != nil {
 		return nil, err
 	}
-	iter := iterable.Iterate()
+	iter := IterateThread(thread, iterable)
 	defer iter.Done()
 	var x Value
 	for iter.Next(&x) {
@@ -175,6 +320,9 @@ func all(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 			return False, nil
 		}
 	}
+	if err := IterErr(iter); err != nil {
+		return nil, err
+	}
 	return True, nil
 }
 
@@ -184,7 +332,7 @@ func any(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 	if err := UnpackPositionalArgs("any", args, kwargs, 1, &iterable); err != nil {
 		return nil, err
 	}
-	iter := iterable.Iterate()
+	iter := IterateThread(thread, iterable)
 	defer iter.Done()
 	var x Value
 	for iter.Next(&x) {
@@ -192,9 +340,30 @@ func any(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 			return True, nil
 		}
 	}
+	if err := IterErr(iter); err != nil {
+		return nil, err
+	}
 	return False, nil
 }
 
+// https://github.com/google/pkgscript-go/blob/master/doc/spec.md#assert
+func assert_(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	if len(kwargs) == 0 && (len(args) == 1 || len(args) == 2) && args[0].Truth() {
+		return None, nil // fast path
+	}
+	var cond, msg Value
+	if err := UnpackPositionalArgs("assert", args, kwargs, 1, &cond, &msg); err != nil {
+		return nil, err
+	}
+	if msg == nil {
+		return nil, &FailError{Msg: "assertion failed"}
+	}
+	if s, ok := AsString(msg); ok {
+		return nil, &FailError{Msg: s}
+	}
+	return nil, &FailError{Msg: msg.String()}
+}
+
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#bool
 func bool_(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	var x Value = False
@@ -222,7 +391,20 @@ func chr(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 	if i > unicode.MaxRune {
 		return nil, fmt.Errorf("chr: Unicode code point U+%X out of range (>0x10FFFF)", i)
 	}
-	return String(string(i)), nil
+	return String(string(rune(i))), nil
+}
+
+// https://github.com/google/pkgscript-go/blob/master/doc/spec.md#deepcopy
+func deepcopy(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	var x Value
+	if err := UnpackPositionalArgs("deepcopy", args, kwargs, 1, &x); err != nil {
+		return nil, err
+	}
+	c, err := deepCopy(x, true, thread.AddAllocs)
+	if err != nil {
+		return nil, fmt.Errorf("deepcopy: %v", err)
+	}
+	return c, nil
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#dict
@@ -231,7 +413,7 @@ func dict(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 		return nil, fmt.Errorf("dict: got %d arguments, want at most 1", len(args))
 	}
 	dict := new(Dict)
-	if err := updateDict(dict, args, kwargs); err != nil {
+	if err := updateDict(thread, dict, args, kwargs); err != nil {
 		return nil, fmt.Errorf("dict: %v", err)
 	}
 	return dict, nil
@@ -242,12 +424,14 @@ func dir(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 	if len(kwargs) > 0 {
 		return nil, fmt.Errorf("dir does not accept keyword arguments")
 	}
-	if len(args) != 1 {
-		return nil, fmt.Errorf("dir: got %d arguments, want 1", len(args))
+	if len(args) > 1 {
+		return nil, fmt.Errorf("dir: got %d arguments, want at most 1", len(args))
 	}
 
 	var names []string
-	if x, ok := args[0].(HasAttrs); ok {
+	if len(args) == 0 {
+		names = callerNames(thread)
+	} else if x, ok := args[0].(HasAttrs); ok {
 		names = x.AttrNames()
 	}
 	sort.Strings(names)
@@ -258,6 +442,67 @@ func dir(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 	return NewList(elems), nil
 }
 
+// callerNames returns the names of the variables bound in the
+// Starlark function that called the current built-in: its local
+// variables, or the module's globals if called at top level.
+func callerNames(thread *Thread) []string {
+	if len(thread.stack) < 2 {
+		return nil // called directly from Go
+	}
+	fr := thread.frameAt(1)
+	fn, ok := fr.callable.(*Function)
+	if !ok {
+		return nil
+	}
+	if fn.funcode == fn.module.program.Toplevel {
+		return fn.Globals().Keys()
+	}
+	var names []string
+	for i, local := range fn.funcode.Locals {
+		v := fr.locals[i]
+		if c, ok := v.(*cell); ok {
+			v = c.v
+		}
+		if v != nil {
+			names = append(names, local.Name)
+		}
+	}
+	return names
+}
+
+// https://github.com/google/pkgscript-go/blob/master/doc/spec.md#divmod
+func divmod(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	var x, y Value
+	if err := UnpackPositionalArgs("divmod", args, kwargs, 2, &x, &y); err != nil {
+		return nil, err
+	}
+	for _, v := range [2]Value{x, y} {
+		switch v.(type) {
+		case Int, Float:
+		default:
+			return nil, fmt.Errorf("divmod: got %s, want int or float", v.Type())
+		}
+	}
+	if x, ok := x.(Int); ok {
+		if y, ok := y.(Int); ok {
+			// Exact, for ints of any size.
+			if y.Sign() == 0 {
+				return nil, nameErr(b, "integer division by zero")
+			}
+			return Tuple{x.Div(y), x.Mod(y)}, nil
+		}
+	}
+	q, err := Binary(syntax.SLASHSLASH, x, y)
+	if err != nil {
+		return nil, nameErr(b, err)
+	}
+	r, err := Binary(syntax.PERCENT, x, y)
+	if err != nil {
+		return nil, nameErr(b, err)
+	}
+	return Tuple{q, r}, nil
+}
+
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#enumerate
 func enumerate(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	var iterable Iterable
@@ -266,7 +511,7 @@ func enumerate(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, e
 		return nil, err
 	}
 
-	iter := iterable.Iterate()
+	iter := IterateThread(thread, iterable)
 	if iter == nil {
 		return nil, fmt.Errorf("enumerate: got %s, want iterable", iterable.Type())
 	}
@@ -277,6 +522,9 @@ func enumerate(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, e
 
 	if n := Len(iterable); n >= 0 {
 		// common case: known length
+		if err := thread.AddAllocs(uint64(n) * 3 * valueSize); err != nil {
+			return nil, fmt.Errorf("enumerate: %v", err)
+		}
 		pairs = make([]Value, 0, n)
 		array := make(Tuple, 2*n) // allocate a single backing array
 		for i := 0; iter.Next(&x); i++ {
@@ -289,14 +537,32 @@ func enumerate(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, e
 	} else {
 		// non-sequence (unknown length)
 		for i := 0; iter.Next(&x); i++ {
+			if err := thread.AddAllocs(3 * valueSize); err != nil {
+				return nil, fmt.Errorf("enumerate: %v", err)
+			}
 			pair := Tuple{MakeInt(start + i), x}
 			pairs = append(pairs, pair)
 		}
 	}
+	if err := IterErr(iter); err != nil {
+		return nil, err
+	}
 
 	return NewList(pairs), nil
 }
 
+// A FailError is the error returned by the built-in fail function,
+// and by the built-in assert function when its condition is false.
+// Its message is the text requested by the script, without a prefix,
+// so that an EvalError caused by a FailError, whose Msg is the same,
+// reads naturally when reported to the user.
+// Use errors.As to determine whether an EvalError was caused by fail.
+type FailError struct {
+	Msg string
+}
+
+func (e *FailError) Error() string { return e.Msg }
+
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#fail
 func fail(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	sep := " "
@@ -304,7 +570,6 @@ func fail(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 		return nil, err
 	}
 	buf := new(strings.Builder)
-	buf.WriteString("fail: ")
 	for i, v := range args {
 		if i > 0 {
 			buf.WriteString(sep)
@@ -316,7 +581,50 @@ func fail(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 		}
 	}
 
-	return nil, errors.New(buf.String())
+	return nil, &FailError{Msg: buf.String()}
+}
+
+// https://github.com/google/pkgscript-go/blob/master/doc/spec.md#filter
+func filter(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	var fn Value
+	var iterable Iterable
+	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 2, &fn, &iterable); err != nil {
+		return nil, err
+	}
+	if _, ok := fn.(Callable); !ok && fn != None {
+		return nil, fmt.Errorf("%s: for parameter 1: got %s, want callable or None", b.Name(), fn.Type())
+	}
+	return &lazyIterable{
+		name:     "filter",
+		operands: Tuple{fn, iterable},
+		iterate: func(thread *Thread) Iterator {
+			iter := IterateThread(thread, iterable)
+			it := &lazyIterator{done: iter.Done}
+			it.next = func(p *Value) bool {
+				var x Value
+				for it.err == nil && iter.Next(&x) {
+					ok := x.Truth()
+					if fn != None {
+						y, err := lazyCall(thread, b, fn, Tuple{x})
+						if err != nil {
+							it.err = err // to preserve backtrace, don't modify error
+							return false
+						}
+						ok = y.Truth()
+					}
+					if ok {
+						*p = x
+						return true
+					}
+				}
+				if it.err == nil {
+					it.err = IterErr(iter)
+				}
+				return false
+			}
+			return it
+		},
+	}, nil
 }
 
 func float(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
@@ -343,7 +651,8 @@ func float(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error
 	case String:
 		f, err := strconv.ParseFloat(string(x), 64)
 		if err != nil {
-			return nil, nameErr(b, err)
+			// e.g. "float: invalid syntax: "1.1abc""
+			return nil, fmt.Errorf("%s: %v: %q", b.Name(), err.(*strconv.NumError).Err, string(x))
 		}
 		return Float(f), nil
 	default:
@@ -358,25 +667,22 @@ func getattr(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, err
 	if err := UnpackPositionalArgs("getattr", args, kwargs, 2, &object, &name, &dflt); err != nil {
 		return nil, err
 	}
-	if object, ok := object.(HasAttrs); ok {
-		v, err := object.Attr(name)
+	if dflt == nil {
+		// Fail just as the dot operator would.
+		v, err := getAttr(object, name)
 		if err != nil {
-			// An error could mean the field doesn't exist,
-			// or it exists but could not be computed.
-			if dflt != nil {
-				return dflt, nil
-			}
 			return nil, nameErr(b, err)
 		}
-		if v != nil {
+		return v, nil
+	}
+	if object, ok := object.(HasAttrs); ok {
+		// An error could mean the field doesn't exist,
+		// or it exists but could not be computed.
+		if v, err := object.Attr(name); err == nil && v != nil {
 			return v, nil
 		}
-		// (nil, nil) => no such field
-	}
-	if dflt != nil {
-		return dflt, nil
 	}
-	return nil, fmt.Errorf("getattr: %s has no .%s field or method", object.Type(), name)
+	return dflt, nil
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#hasattr
@@ -406,20 +712,81 @@ func hasattr(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, err
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#hash
-func hash(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
-	var s string
-	if err := UnpackPositionalArgs("hash", args, kwargs, 1, &s); err != nil {
+func hash(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	var x Value
+	if err := UnpackPositionalArgs("hash", args, kwargs, 1, &x); err != nil {
 		return nil, err
 	}
+	h, err := x.Hash()
+	if err != nil {
+		return nil, nameErr(b, err)
+	}
+	return MakeUint64(uint64(h)), nil
+}
 
-	// The Starlark spec requires that the hash function be
-	// deterministic across all runs, motivated by the need
-	// for reproducibility of builds. Thus we cannot call
-	// String.Hash, which uses the fastest implementation
-	// available, because as varies across process restarts,
-	// and may evolve with the implementation.
+// StableHash returns a hash of x that, unlike x.Hash, is the same in
+// every execution. Strings, including the names of functions and
+// built-ins, are hashed by javaStringHash, tuples and frozensets by
+// combining the stable hashes of their elements, and values that
+// implement HasStableHash by their StableHash methods. Other values
+// are hashed by their Hash methods, which for numbers, bools, and None
+// do not depend on the execution, but for an application-defined type
+// that does not implement HasStableHash may vary from run to run.
+// StableHash fails only if x is unhashable.
+//
+// Like x.Hash, StableHash yields equal hashes for equal values, but
+// the two hashes of a value generally differ.
+func StableHash(x Value) (uint32, error) {
+	switch x := x.(type) {
+	case String:
+		return uint32(javaStringHash(string(x))), nil
 
-	return MakeInt(int(javaStringHash(s))), nil
+	case Tuple:
+		// Same algorithm as Tuple.Hash.
+		var h, mult uint32 = 0x345678, 1000003
+		for _, elem := range x {
+			y, err := StableHash(elem)
+			if err != nil {
+				return 0, err
+			}
+			h = h ^ y*mult
+			mult += 82520 + uint32(len(x)+len(x))
+		}
+		return h, nil
+
+	case *FrozenSet:
+		// Same algorithm as freezeSet.
+		h := uint32(x.Len()) * 1927868237
+		for _, elem := range x.elems() {
+			eh, err := StableHash(elem)
+			if err != nil {
+				return 0, err
+			}
+			h ^= (eh ^ (eh << 16) ^ 89869747) * 3644798167
+		}
+		return h, nil
+
+	case Decimal:
+		if !x.rat.IsInt() {
+			return uint32(javaStringHash(x.rat.String())), nil
+		}
+		return x.Hash()
+
+	case *Function:
+		return uint32(javaStringHash(x.funcode.Name)), nil
+
+	case *Builtin:
+		// Same algorithm as Builtin.Hash.
+		h := uint32(javaStringHash(x.name))
+		if x.recv != nil {
+			h ^= 5521
+		}
+		return h, nil
+
+	case HasStableHash:
+		return x.StableHash()
+	}
+	return x.Hash()
 }
 
 // javaStringHash returns the same hash as would be produced by
@@ -439,6 +806,22 @@ func javaStringHash(s string) (h int32) {
 	return h
 }
 
+// https://github.com/google/pkgscript-go/blob/master/doc/spec.md#input
+func input(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	prompt := ""
+	if err := UnpackPositionalArgs("input", args, kwargs, 0, &prompt); err != nil {
+		return nil, err
+	}
+	if thread.Input == nil {
+		return nil, fmt.Errorf("input: not supported by this application")
+	}
+	line, err := thread.Input(prompt)
+	if err != nil {
+		return nil, fmt.Errorf("input: %v", err)
+	}
+	return String(line), nil
+}
+
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#int
 func int_(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	var x Value = zero
@@ -565,7 +948,7 @@ func len_(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 	}
 	len := Len(x)
 	if len < 0 {
-		return nil, fmt.Errorf("len: value of type %s has no len", x.Type())
+		return nil, fmt.Errorf("object of type '%s' has no len()", x.Type())
 	}
 	return MakeInt(len), nil
 }
@@ -578,28 +961,102 @@ func list(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 	}
 	var elems []Value
 	if iterable != nil {
-		iter := iterable.Iterate()
+		iter := IterateThread(thread, iterable)
 		defer iter.Done()
 		if n := Len(iterable); n > 0 {
+			if err := thread.AddAllocs(uint64(n) * valueSize); err != nil {
+				return nil, fmt.Errorf("list: %v", err)
+			}
 			elems = make([]Value, 0, n) // preallocate if length known
 		}
 		var x Value
 		for iter.Next(&x) {
 			elems = append(elems, x)
 		}
+		if err := IterErr(iter); err != nil {
+			return nil, err
+		}
 	}
 	return NewList(elems), nil
 }
 
+// https://github.com/google/pkgscript-go/blob/master/doc/spec.md#map
+func map_(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("%s: got %d arguments, want at least 2", b.Name(), len(args))
+	}
+	if err := UnpackPositionalArgs(b.Name(), nil, kwargs, 0); err != nil {
+		return nil, err
+	}
+	fn, ok := args[0].(Callable)
+	if !ok {
+		return nil, fmt.Errorf("%s: for parameter 1: got %s, want callable", b.Name(), args[0].Type())
+	}
+	iterables := make([]Iterable, len(args)-1)
+	for i, arg := range args[1:] {
+		iterable, ok := arg.(Iterable)
+		if !ok {
+			return nil, fmt.Errorf("%s: for parameter %d: got %s, want iterable", b.Name(), i+2, arg.Type())
+		}
+		iterables[i] = iterable
+	}
+	return &lazyIterable{
+		name:     "map",
+		operands: append(Tuple(nil), args...),
+		iterate: func(thread *Thread) Iterator {
+			iters := make([]Iterator, len(iterables))
+			for i, iterable := range iterables {
+				iters[i] = IterateThread(thread, iterable)
+			}
+			it := &lazyIterator{done: func() {
+				for _, iter := range iters {
+					iter.Done()
+				}
+			}}
+			// The iteration stops when the shortest iterable is exhausted.
+			stopped := false
+			it.next = func(p *Value) bool {
+				if stopped {
+					return false
+				}
+				fnargs := make(Tuple, len(iters))
+				for i, iter := range iters {
+					if !iter.Next(&fnargs[i]) {
+						it.err = IterErr(iter)
+						stopped = true
+						return false
+					}
+				}
+				y, err := lazyCall(thread, b, fn, fnargs)
+				if err != nil {
+					it.err = err // to preserve backtrace, don't modify error
+					stopped = true
+					return false
+				}
+				*p = y
+				return true
+			}
+			return it
+		},
+	}, nil
+}
+
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#min
 func minmax(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if len(args) == 0 {
 		return nil, fmt.Errorf("%s requires at least one positional argument", b.Name())
 	}
-	var keyFunc Callable
-	if err := UnpackArgs(b.Name(), nil, kwargs, "key?", &keyFunc); err != nil {
+	var keyFunc, cmpFunc Callable
+	var dflt Value
+	if err := UnpackArgs(b.Name(), nil, kwargs, "key?", &keyFunc, "default?", &dflt, "cmp?", &cmpFunc); err != nil {
 		return nil, err
 	}
+	if keyFunc != nil && cmpFunc != nil {
+		return nil, fmt.Errorf("%s: cannot specify both key and cmp", b.Name())
+	}
+	if dflt != nil && len(args) > 1 {
+		return nil, fmt.Errorf("%s: cannot specify a default with multiple positional arguments", b.Name())
+	}
 	var op syntax.Token
 	if b.Name() == "max" {
 		op = syntax.GT
@@ -612,13 +1069,19 @@ func minmax(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, erro
 	} else {
 		iterable = args
 	}
-	iter := Iterate(iterable)
+	iter := IterateThread(thread, iterable)
 	if iter == nil {
 		return nil, fmt.Errorf("%s: %s value is not iterable", b.Name(), iterable.Type())
 	}
 	defer iter.Done()
 	var extremum Value
 	if !iter.Next(&extremum) {
+		if err := IterErr(iter); err != nil {
+			return nil, err
+		}
+		if dflt != nil {
+			return dflt, nil
+		}
 		return nil, nameErr(b, "argument is an empty sequence")
 	}
 
@@ -649,16 +1112,42 @@ func minmax(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, erro
 			key = res
 		}
 
-		if ok, err := Compare(op, key, extremeKey); err != nil {
+		if cmpFunc != nil {
+			cmp, err := callCmp(thread, b, cmpFunc, key, extremeKey)
+			if err != nil {
+				return nil, err
+			}
+			if op == syntax.GT && cmp > 0 || op == syntax.LT && cmp < 0 {
+				extremum = x
+				extremeKey = key
+			}
+		} else if ok, err := Compare(op, key, extremeKey); err != nil {
 			return nil, nameErr(b, err)
 		} else if ok {
 			extremum = x
 			extremeKey = key
 		}
 	}
+	if err := IterErr(iter); err != nil {
+		return nil, err
+	}
 	return extremum, nil
 }
 
+// callCmp calls the comparison function cmp, as provided to the
+// built-in b, and returns the sign of its result.
+func callCmp(thread *Thread, b *Builtin, cmp Callable, x, y Value) (int, error) {
+	res, err := Call(thread, cmp, Tuple{x, y}, nil)
+	if err != nil {
+		return 0, err // to preserve backtrace, don't modify error
+	}
+	i, ok := res.(Int)
+	if !ok {
+		return 0, fmt.Errorf("%s: cmp returned %s, want int", b.Name(), res.Type())
+	}
+	return i.Sign(), nil
+}
+
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#ord
 func ord(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if len(kwargs) > 0 {
@@ -681,10 +1170,14 @@ func ord(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#print
 func print(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
-	sep := " "
-	if err := UnpackArgs("print", nil, kwargs, "sep?", &sep); err != nil {
+	sep, end := " ", "\n"
+	if err := UnpackArgs("print", nil, kwargs, "sep?", &sep, "end?", &end); err != nil {
 		return nil, err
 	}
+	if thread.PrintRecord != nil {
+		thread.PrintRecord(thread, args, sep, end)
+		return None, nil
+	}
 	buf := new(strings.Builder)
 	for i, v := range args {
 		if i > 0 {
@@ -693,26 +1186,43 @@ func print(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error
 		if s, ok := AsString(v); ok {
 			buf.WriteString(s)
 		} else {
-			writeValue(buf, v, nil)
+			writeValueMode(buf, v, nil, thread.floatFormat)
 		}
 	}
 
-	s := buf.String()
 	if thread.Print != nil {
-		thread.Print(thread, s)
+		// Print adds its own newline.
+		buf.WriteString(strings.TrimSuffix(end, "\n"))
+		thread.Print(thread, buf.String())
 	} else {
-		fmt.Fprintln(os.Stderr, s)
+		buf.WriteString(end)
+		fmt.Fprint(os.Stderr, buf.String())
 	}
 	return None, nil
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#range
 func range_(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
-	var start, stop, step int
-	step = 1
-	if err := UnpackPositionalArgs("range", args, kwargs, 1, &start, &stop, &step); err != nil {
+	var params [3]Value
+	if err := UnpackPositionalArgs("range", args, kwargs, 1, &params[0], &params[1], &params[2]); err != nil {
 		return nil, err
 	}
+	ints := [3]int{0, 0, 1}
+	for i, v := range params {
+		if v == nil {
+			continue
+		}
+		if _, ok := v.(Int); !ok {
+			// In particular, range does not accept float arguments.
+			return nil, fmt.Errorf("range() arg must be int, got %s", v.Type())
+		}
+		n, err := AsInt32(v)
+		if err != nil {
+			return nil, nameErr(b, err)
+		}
+		ints[i] = n
+	}
+	start, stop, step := ints[0], ints[1], ints[2]
 
 	// TODO(adonovan): analyze overflow/underflows cases for 32-bit implementations.
 	if len(args) == 1 {
@@ -721,7 +1231,7 @@ func range_(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, erro
 	}
 	if step == 0 {
 		// we were given range(start, stop, 0)
-		return nil, nameErr(b, "step argument must not be zero")
+		return nil, fmt.Errorf("range() arg 3 must not be zero")
 	}
 
 	return rangeValue{start: start, stop: stop, step: step, len: rangeLen(start, stop, step)}, nil
@@ -838,6 +1348,38 @@ func (it *rangeIterator) Next(p *Value) bool {
 }
 func (*rangeIterator) Done() {}
 
+// https://github.com/google/pkgscript-go/blob/master/doc/spec.md#reduce
+func reduce(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	var fn Callable
+	var iterable Iterable
+	var initial Value
+	if err := UnpackArgs(b.Name(), args, kwargs, "fn", &fn, "iterable", &iterable, "initial?", &initial); err != nil {
+		return nil, err
+	}
+	iter := IterateThread(thread, iterable)
+	defer iter.Done()
+	acc := initial
+	var x Value
+	for iter.Next(&x) {
+		if acc == nil {
+			acc = x
+			continue
+		}
+		z, err := Call(thread, fn, Tuple{acc, x}, nil)
+		if err != nil {
+			return nil, err // to preserve backtrace, don't modify error
+		}
+		acc = z
+	}
+	if err := IterErr(iter); err != nil {
+		return nil, err
+	}
+	if acc == nil {
+		return nil, nameErr(b, "empty iterable and no initial value")
+	}
+	return acc, nil
+}
+
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#repr
 func repr(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	var x Value
@@ -853,7 +1395,7 @@ func reversed(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, er
 	if err := UnpackPositionalArgs("reversed", args, kwargs, 1, &iterable); err != nil {
 		return nil, err
 	}
-	iter := iterable.Iterate()
+	iter := IterateThread(thread, iterable)
 	defer iter.Done()
 	var elems []Value
 	if n := Len(args[0]); n >= 0 {
@@ -863,6 +1405,9 @@ func reversed(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, er
 	for iter.Next(&x) {
 		elems = append(elems, x)
 	}
+	if err := IterErr(iter); err != nil {
+		return nil, err
+	}
 	n := len(elems)
 	for i := 0; i < n>>1; i++ {
 		elems[i], elems[n-1-i] = elems[n-1-i], elems[i]
@@ -870,6 +1415,88 @@ func reversed(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, er
 	return NewList(elems), nil
 }
 
+// https://github.com/google/pkgscript-go/blob/master/doc/spec.md#round
+func round(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	var x Value
+	var ndigits Value = None
+	if err := UnpackArgs("round", args, kwargs, "x", &x, "ndigits?", &ndigits); err != nil {
+		return nil, err
+	}
+
+	if ndigits == None {
+		// Round to the nearest int, ties to even.
+		switch x := x.(type) {
+		case Int:
+			return x, nil
+		case Float:
+			i, err := NumberToInt(Float(math.RoundToEven(float64(x))))
+			if err != nil {
+				return nil, nameErr(b, err)
+			}
+			return i, nil
+		}
+		return nil, fmt.Errorf("round: got %s, want int or float", x.Type())
+	}
+
+	nd, ok := ndigits.(Int)
+	if !ok {
+		return nil, fmt.Errorf("round: for parameter ndigits: got %s, want int", ndigits.Type())
+	}
+	n, ok := nd.Int64()
+	if !ok || n < -1e6 || n > 1e6 {
+		n = int64(nd.Sign()) * 1e6 // big enough to be equivalent
+	}
+
+	// Round to a multiple of 10**-n, ties to even,
+	// preserving the type of x.
+	switch x := x.(type) {
+	case Int:
+		if n >= 0 {
+			return x, nil
+		}
+		pow := new(big.Int).Exp(big.NewInt(10), big.NewInt(-n), nil)
+		q := roundHalfEven(x.BigInt(), pow)
+		return MakeBigInt(q.Mul(q, pow)), nil
+	case Float:
+		f := float64(x)
+		if !isFinite(f) || f == 0 {
+			return x, nil
+		}
+		if n >= 0 {
+			if n > 400 {
+				return x, nil // exceeds the precision of any float
+			}
+			// FormatFloat rounds the exact binary value correctly,
+			// ties to even, as Python does.
+			r, _ := strconv.ParseFloat(strconv.FormatFloat(f, 'f', int(n), 64), 64)
+			return Float(r), nil
+		}
+		if n < -400 {
+			return Float(math.Copysign(0, f)), nil
+		}
+		rat := x.rational()
+		pow := new(big.Int).Exp(big.NewInt(10), big.NewInt(-n), nil)
+		q := roundHalfEven(rat.Num(), new(big.Int).Mul(rat.Denom(), pow))
+		return MakeBigInt(q.Mul(q, pow)).Float(), nil
+	}
+	return nil, fmt.Errorf("round: got %s, want int or float", x.Type())
+}
+
+// roundHalfEven returns the quotient x/y rounded to the nearest
+// integer, ties to even. y must be positive.
+func roundHalfEven(x, y *big.Int) *big.Int {
+	q, m := new(big.Int).DivMod(x, y, new(big.Int)) // floored, since y > 0
+	switch m.Lsh(m, 1).Cmp(y) {
+	case +1:
+		q.Add(q, oneBig)
+	case 0:
+		if q.Bit(0) == 1 {
+			q.Add(q, oneBig)
+		}
+	}
+	return q
+}
+
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#set
 func set(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	var iterable Iterable
@@ -878,7 +1505,7 @@ func set(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 	}
 	set := new(Set)
 	if iterable != nil {
-		iter := iterable.Iterate()
+		iter := IterateThread(thread, iterable)
 		defer iter.Done()
 		var x Value
 		for iter.Next(&x) {
@@ -886,34 +1513,86 @@ func set(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 				return nil, nameErr(b, err)
 			}
 		}
+		if err := IterErr(iter); err != nil {
+			return nil, err
+		}
 	}
 	return set, nil
 }
 
+// https://github.com/google/pkgscript-go/blob/master/doc/spec.md#frozenset
+func frozenset(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	var iterable Iterable
+	if err := UnpackPositionalArgs("frozenset", args, kwargs, 0, &iterable); err != nil {
+		return nil, err
+	}
+	if s, ok := iterable.(*FrozenSet); ok {
+		return s, nil // already immutable
+	}
+	set := new(Set)
+	if iterable != nil {
+		iter := IterateThread(thread, iterable)
+		defer iter.Done()
+		var x Value
+		for iter.Next(&x) {
+			if err := set.Insert(x); err != nil {
+				return nil, nameErr(b, err)
+			}
+		}
+		if err := IterErr(iter); err != nil {
+			return nil, err
+		}
+	}
+	return freezeSet(set), nil
+}
+
+// https://github.com/google/pkgscript-go/blob/master/doc/spec.md#setattr
+func setattr(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	var object, value Value
+	var name string
+	if err := UnpackPositionalArgs("setattr", args, kwargs, 3, &object, &name, &value); err != nil {
+		return nil, err
+	}
+	if err := setField(object, name, value); err != nil {
+		return nil, nameErr(b, err)
+	}
+	return None, nil
+}
+
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#sorted
-func sorted(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func sorted(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	// Oddly, Python's sorted permits all arguments to be positional, thus so do we.
 	var iterable Iterable
-	var key Callable
+	var key, cmp Callable
 	var reverse bool
 	if err := UnpackArgs("sorted", args, kwargs,
 		"iterable", &iterable,
 		"key?", &key,
 		"reverse?", &reverse,
+		"cmp?", &cmp,
 	); err != nil {
 		return nil, err
 	}
+	if key != nil && cmp != nil {
+		return nil, fmt.Errorf("sorted: cannot specify both key and cmp")
+	}
 
-	iter := iterable.Iterate()
+	iter := IterateThread(thread, iterable)
 	defer iter.Done()
 	var values []Value
 	if n := Len(iterable); n > 0 {
+		if err := thread.AddAllocs(uint64(n) * valueSize); err != nil {
+			return nil, fmt.Errorf("sorted: %v", err)
+		}
 		values = make(Tuple, 0, n) // preallocate if length is known
 	}
 	var x Value
 	for iter.Next(&x) {
 		values = append(values, x)
 	}
+	if err := IterErr(iter); err != nil {
+		return nil, err
+	}
 
 	// Derive keys from values by applying key function.
 	var keys []Value
@@ -929,6 +1608,9 @@ func sorted(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, erro
 	}
 
 	slice := &sortSlice{keys: keys, values: values}
+	if cmp != nil {
+		slice.cmp = func(x, y Value) (int, error) { return callCmp(thread, b, cmp, x, y) }
+	}
 	if reverse {
 		sort.Stable(sort.Reverse(slice))
 	} else {
@@ -940,6 +1622,7 @@ func sorted(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, erro
 type sortSlice struct {
 	keys   []Value // nil => values[i] is key
 	values []Value
+	cmp    func(x, y Value) (int, error) // nil => compare keys using <
 	err    error
 }
 
@@ -949,6 +1632,16 @@ func (s *sortSlice) Less(i, j int) bool {
 	if s.keys == nil {
 		keys = s.values
 	}
+	if s.cmp != nil {
+		if s.err != nil {
+			return false // don't call cmp after a failure
+		}
+		cmp, err := s.cmp(keys[i], keys[j])
+		if err != nil {
+			s.err = err
+		}
+		return cmp < 0
+	}
 	ok, err := Compare(syntax.LT, keys[i], keys[j])
 	if err != nil {
 		s.err = err
@@ -972,11 +1665,41 @@ func str(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 	}
 	x := args[0]
 	if _, ok := AsString(x); !ok {
-		x = String(x.String())
+		x = String(Format(x, thread.floatFormat))
 	}
 	return x, nil
 }
 
+// https://github.com/google/pkgscript-go/blob/master/doc/spec.md#switch
+func switch_(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	var value, default_ Value
+	var cases *Dict
+	if err := UnpackArgs("switch", args, kwargs,
+		"value", &value,
+		"cases", &cases,
+		"default?", &default_,
+	); err != nil {
+		return nil, err
+	}
+	fn, found, err := cases.Get(value)
+	if err != nil {
+		return nil, fmt.Errorf("switch: %v", err)
+	}
+	if !found {
+		if default_ == nil || default_ == None {
+			return None, nil
+		}
+		fn = default_
+	}
+	if _, ok := fn.(Callable); !ok {
+		if found {
+			return nil, fmt.Errorf("switch: case %s is not callable (got %s)", value, fn.Type())
+		}
+		return nil, fmt.Errorf("switch: default is not callable (got %s)", fn.Type())
+	}
+	return Call(thread, fn, nil, nil)
+}
+
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#tuple
 func tuple(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	var iterable Iterable
@@ -986,16 +1709,22 @@ func tuple(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error
 	if len(args) == 0 {
 		return Tuple(nil), nil
 	}
-	iter := iterable.Iterate()
+	iter := IterateThread(thread, iterable)
 	defer iter.Done()
 	var elems Tuple
 	if n := Len(iterable); n > 0 {
+		if err := thread.AddAllocs(uint64(n) * valueSize); err != nil {
+			return nil, fmt.Errorf("tuple: %v", err)
+		}
 		elems = make(Tuple, 0, n) // preallocate if length is known
 	}
 	var x Value
 	for iter.Next(&x) {
 		elems = append(elems, x)
 	}
+	if err := IterErr(iter); err != nil {
+		return nil, err
+	}
 	return elems, nil
 }
 
@@ -1025,7 +1754,7 @@ func zip(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 		}
 	}()
 	for i, seq := range args {
-		it := Iterate(seq)
+		it := IterateThread(thread, seq)
 		if it == nil {
 			return nil, fmt.Errorf("zip: argument #%d is not iterable: %s", i+1, seq.Type())
 		}
@@ -1038,6 +1767,9 @@ func zip(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 	var result []Value
 	if rows >= 0 {
 		// length known
+		if err := thread.AddAllocs(uint64(rows) * uint64(1+cols) * valueSize); err != nil {
+			return nil, fmt.Errorf("zip: %v", err)
+		}
 		result = make([]Value, rows)
 		array := make(Tuple, cols*rows) // allocate a single backing array
 		for i := 0; i < rows; i++ {
@@ -1052,9 +1784,15 @@ func zip(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 		// length not known
 	outer:
 		for {
+			if err := thread.AddAllocs(uint64(1+cols) * valueSize); err != nil {
+				return nil, fmt.Errorf("zip: %v", err)
+			}
 			tuple := make(Tuple, cols)
 			for i, iter := range iters {
 				if !iter.Next(&tuple[i]) {
+					if err := IterErr(iter); err != nil {
+						return nil, err
+					}
 					break outer
 				}
 			}
@@ -1067,7 +1805,7 @@ func zip(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 // ---- methods of built-in types ---
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#dict·get
-func dict_get(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_get(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	var key, dflt Value
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &key, &dflt); err != nil {
 		return nil, err
@@ -1083,7 +1821,7 @@ func dict_get(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#dict·clear
-func dict_clear(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_clear(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1091,7 +1829,7 @@ func dict_clear(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#dict·items
-func dict_items(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_items(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1104,7 +1842,7 @@ func dict_items(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#dict·keys
-func dict_keys(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_keys(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1112,7 +1850,7 @@ func dict_keys(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#dict·pop
-func dict_pop(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_pop(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	var k, d Value
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &k, &d); err != nil {
 		return nil, err
@@ -1128,7 +1866,7 @@ func dict_pop(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#dict·popitem
-func dict_popitem(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_popitem(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1145,7 +1883,7 @@ func dict_popitem(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#dict·setdefault
-func dict_setdefault(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_setdefault(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	var key, dflt Value = nil, None
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &key, &dflt); err != nil {
 		return nil, err
@@ -1155,6 +1893,8 @@ func dict_setdefault(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 		return nil, nameErr(b, err)
 	} else if ok {
 		return v, nil
+	} else if err := thread.AddAllocs(dictEntrySize); err != nil {
+		return nil, nameErr(b, err)
 	} else if err := dict.SetKey(key, dflt); err != nil {
 		return nil, nameErr(b, err)
 	} else {
@@ -1163,31 +1903,26 @@ func dict_setdefault(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#dict·update
-func dict_update(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_update(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if len(args) > 1 {
 		return nil, fmt.Errorf("update: got %d arguments, want at most 1", len(args))
 	}
-	if err := updateDict(b.Receiver().(*Dict), args, kwargs); err != nil {
+	if err := updateDict(thread, b.Receiver().(*Dict), args, kwargs); err != nil {
 		return nil, fmt.Errorf("update: %v", err)
 	}
 	return None, nil
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#dict·update
-func dict_values(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_values(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
-	items := b.Receiver().(*Dict).Items()
-	res := make([]Value, len(items))
-	for i, item := range items {
-		res[i] = item[1]
-	}
-	return NewList(res), nil
+	return NewList(b.Receiver().(*Dict).Values()), nil
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#list·append
-func list_append(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func list_append(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	var object Value
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &object); err != nil {
 		return nil, err
@@ -1196,12 +1931,15 @@ func list_append(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := recv.checkMutable("append to"); err != nil {
 		return nil, nameErr(b, err)
 	}
+	if err := thread.AddAllocs(valueSize); err != nil {
+		return nil, nameErr(b, err)
+	}
 	recv.elems = append(recv.elems, object)
 	return None, nil
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#list·clear
-func list_clear(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func list_clear(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1211,8 +1949,13 @@ func list_clear(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	return None, nil
 }
 
+// https://github.com/google/pkgscript-go/blob/master/doc/spec.md#list·count
+func list_count(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	return sequenceCount(b, args, kwargs, b.Receiver().(*List).elems)
+}
+
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#list·extend
-func list_extend(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func list_extend(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	recv := b.Receiver().(*List)
 	var iterable Iterable
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &iterable); err != nil {
@@ -1221,35 +1964,19 @@ func list_extend(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := recv.checkMutable("extend"); err != nil {
 		return nil, nameErr(b, err)
 	}
-	listExtend(recv, iterable)
+	if err := listExtend(thread, recv, iterable); err != nil {
+		return nil, err
+	}
 	return None, nil
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#list·index
-func list_index(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
-	var value, start_, end_ Value
-	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &value, &start_, &end_); err != nil {
-		return nil, err
-	}
-
-	recv := b.Receiver().(*List)
-	start, end, err := indices(start_, end_, recv.Len())
-	if err != nil {
-		return nil, nameErr(b, err)
-	}
-
-	for i := start; i < end; i++ {
-		if eq, err := Equal(recv.elems[i], value); err != nil {
-			return nil, nameErr(b, err)
-		} else if eq {
-			return MakeInt(i), nil
-		}
-	}
-	return nil, nameErr(b, "value not in list")
+func list_index(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	return sequenceIndex(b, args, kwargs, b.Receiver().(*List).elems)
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#list·insert
-func list_insert(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func list_insert(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	recv := b.Receiver().(*List)
 	var index int
 	var object Value
@@ -1259,6 +1986,9 @@ func list_insert(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := recv.checkMutable("insert into"); err != nil {
 		return nil, nameErr(b, err)
 	}
+	if err := thread.AddAllocs(valueSize); err != nil {
+		return nil, nameErr(b, err)
+	}
 
 	if index < 0 {
 		index += recv.Len()
@@ -1279,7 +2009,7 @@ func list_insert(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#list·remove
-func list_remove(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func list_remove(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	recv := b.Receiver().(*List)
 	var value Value
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &value); err != nil {
@@ -1300,7 +2030,7 @@ func list_remove(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#list·pop
-func list_pop(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func list_pop(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	recv := b.Receiver()
 	list := recv.(*List)
 	n := list.Len()
@@ -1323,8 +2053,60 @@ func list_pop(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	return res, nil
 }
 
+// https://github.com/google/pkgscript-go/blob/master/doc/spec.md#tuple·count
+func tuple_count(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	return sequenceCount(b, args, kwargs, b.Receiver().(Tuple))
+}
+
+// https://github.com/google/pkgscript-go/blob/master/doc/spec.md#tuple·index
+func tuple_index(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	return sequenceIndex(b, args, kwargs, b.Receiver().(Tuple))
+}
+
+// sequenceCount implements the count method of lists and tuples,
+// whose receiver has the specified elements.
+func sequenceCount(b *Builtin, args Tuple, kwargs []Tuple, elems []Value) (Value, error) {
+	var value Value
+	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &value); err != nil {
+		return nil, err
+	}
+
+	n := 0
+	for _, elem := range elems {
+		if eq, err := Equal(elem, value); err != nil {
+			return nil, nameErr(b, err)
+		} else if eq {
+			n++
+		}
+	}
+	return MakeInt(n), nil
+}
+
+// sequenceIndex implements the index method of lists and tuples,
+// whose receiver has the specified elements.
+func sequenceIndex(b *Builtin, args Tuple, kwargs []Tuple, elems []Value) (Value, error) {
+	var value, start_, end_ Value
+	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &value, &start_, &end_); err != nil {
+		return nil, err
+	}
+
+	start, end, err := indices(start_, end_, len(elems))
+	if err != nil {
+		return nil, nameErr(b, err)
+	}
+
+	for i := start; i < end; i++ {
+		if eq, err := Equal(elems[i], value); err != nil {
+			return nil, nameErr(b, err)
+		} else if eq {
+			return MakeInt(i), nil
+		}
+	}
+	return nil, nameErr(b, fmt.Sprintf("value not in %s", b.Receiver().Type()))
+}
+
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·capitalize
-func string_capitalize(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_capitalize(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1347,7 +2129,7 @@ func string_capitalize(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 // - codepoints: successive substrings that encode a single Unicode code point.
 // - elem_ords: numeric values of successive bytes
 // - codepoint_ords: numeric values of successive Unicode code points
-func string_iterable(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_iterable(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1359,7 +2141,7 @@ func string_iterable(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·count
-func string_count(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_count(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	var sub string
 	var start_, end_ Value
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &sub, &start_, &end_); err != nil {
@@ -1380,7 +2162,7 @@ func string_count(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·isalnum
-func string_isalnum(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_isalnum(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1394,7 +2176,7 @@ func string_isalnum(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·isalpha
-func string_isalpha(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_isalpha(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1408,7 +2190,7 @@ func string_isalpha(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·isdigit
-func string_isdigit(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_isdigit(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1422,7 +2204,7 @@ func string_isdigit(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·islower
-func string_islower(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_islower(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1447,7 +2229,7 @@ func isCasedRune(r rune) bool {
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·isspace
-func string_isspace(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_isspace(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1461,7 +2243,7 @@ func string_isspace(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·istitle
-func string_istitle(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_istitle(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1494,7 +2276,7 @@ func string_istitle(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·isupper
-func string_isupper(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_isupper(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1502,14 +2284,108 @@ func string_isupper(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	return Bool(isCasedString(recv) && recv == strings.ToUpper(recv)), nil
 }
 
+// https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·expandtabs
+func string_expandtabs(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	tabsize := 8
+	if err := UnpackArgs(b.Name(), args, kwargs, "tabsize?", &tabsize); err != nil {
+		return nil, err
+	}
+	recv := string(b.Receiver().(String))
+	if !strings.Contains(recv, "\t") {
+		return String(recv), nil
+	}
+	if err := thread.AddAllocs(uint64(len(recv))); err != nil {
+		return nil, nameErr(b, err)
+	}
+	res := new(strings.Builder)
+	res.Grow(len(recv))
+	col := 0 // column in code points since the start of the line
+	for _, r := range recv {
+		switch r {
+		case '\t':
+			if tabsize > 0 {
+				n := tabsize - col%tabsize
+				if n > maxAlloc-res.Len() {
+					return nil, nameErr(b, "excessive tab size")
+				}
+				if err := thread.AddAllocs(uint64(n)); err != nil {
+					return nil, nameErr(b, err)
+				}
+				res.WriteString(strings.Repeat(" ", n))
+				col += n
+			}
+		case '\n', '\r':
+			res.WriteRune(r)
+			col = 0
+		default:
+			res.WriteRune(r)
+			col++
+		}
+	}
+	return String(res.String()), nil
+}
+
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·find
-func string_find(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_find(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	return string_find_impl(b, args, kwargs, true, false)
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·format
-func string_format(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_format(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	format := string(b.Receiver().(String))
+	keyword := func(name string) (Value, error) {
+		for _, kv := range kwargs {
+			if string(kv[0].(String)) == name {
+				return kv[1], nil
+			}
+		}
+		return nil, nil
+	}
+	res, err := formatFields("format", format, thread.floatFormat, true, args, keyword)
+	if err != nil {
+		return nil, err
+	}
+	if err := thread.AddAllocs(uint64(len(res.(String)))); err != nil {
+		return nil, nameErr(b, err)
+	}
+	return res, nil
+}
+
+// https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·format_map
+func string_format_map(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	var x Value
+	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &x); err != nil {
+		return nil, err
+	}
+	mapping, ok := x.(Mapping)
+	if !ok {
+		return nil, fmt.Errorf("format_map: got %s, want mapping", x.Type())
+	}
 	format := string(b.Receiver().(String))
+	keyword := func(name string) (Value, error) {
+		v, found, err := mapping.Get(String(name))
+		if err != nil || !found {
+			return nil, err
+		}
+		return v, nil
+	}
+	res, err := formatFields("format_map", format, thread.floatFormat, false, nil, keyword)
+	if err != nil {
+		return nil, err
+	}
+	if err := thread.AddAllocs(uint64(len(res.(String)))); err != nil {
+		return nil, nameErr(b, err)
+	}
+	return res, nil
+}
+
+// formatFields implements str.format and str.format_map.
+// The replacement fields of the format string are resolved using the
+// positional arguments args, if positional fields are permitted,
+// and the keyword function, which returns the value of a named field,
+// or nil if there is none. The {} and {!s} fields format floats by
+// the fmt verb floatFormat, if non-empty.
+func formatFields(fname, format, floatFormat string, positional bool, args Tuple, keyword func(name string) (Value, error)) (Value, error) {
 	var auto, manual bool // kinds of positional indexing used
 	buf := new(strings.Builder)
 	index := 0
@@ -1528,7 +2404,7 @@ func string_format(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 				break
 			}
 			if len(literal) == j+1 || literal[j+1] != '}' {
-				return nil, fmt.Errorf("format: single '}' in format")
+				return nil, fmt.Errorf("%s: single '}' in format", fname)
 			}
 			buf.WriteString(literal[:j+1])
 			literal = literal[j+2:]
@@ -1548,7 +2424,7 @@ func string_format(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 		format = format[i+1:]
 		i = strings.IndexByte(format, '}')
 		if i < 0 {
-			return nil, fmt.Errorf("format: unmatched '{' in format")
+			return nil, fmt.Errorf("%s: unmatched '{' in format", fname)
 		}
 
 		var arg Value
@@ -1580,49 +2456,52 @@ func string_format(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 			}
 		}
 
+		if _, ok := decimal(name); ok && !positional {
+			return nil, fmt.Errorf("%s: format string contains positional fields", fname) // "{}" or "{0}"
+		}
+
 		if name == "" {
 			// "{}": automatic indexing
 			if manual {
-				return nil, fmt.Errorf("format: cannot switch from manual field specification to automatic field numbering")
+				return nil, fmt.Errorf("%s: cannot switch from manual field specification to automatic field numbering", fname)
 			}
 			auto = true
 			if index >= len(args) {
-				return nil, fmt.Errorf("format: tuple index out of range")
+				return nil, fmt.Errorf("%s: tuple index out of range", fname)
 			}
 			arg = args[index]
 			index++
 		} else if num, ok := decimal(name); ok {
 			// positional argument
 			if auto {
-				return nil, fmt.Errorf("format: cannot switch from automatic field numbering to manual field specification")
+				return nil, fmt.Errorf("%s: cannot switch from automatic field numbering to manual field specification", fname)
 			}
 			manual = true
 			if num >= len(args) {
-				return nil, fmt.Errorf("format: tuple index out of range")
+				return nil, fmt.Errorf("%s: tuple index out of range", fname)
 			} else {
 				arg = args[num]
 			}
 		} else {
 			// keyword argument
-			for _, kv := range kwargs {
-				if string(kv[0].(String)) == name {
-					arg = kv[1]
-					break
-				}
+			v, err := keyword(name)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", fname, err)
 			}
+			arg = v
 			if arg == nil {
 				// Starlark does not support Python's x.y or a[i] syntaxes,
 				// or nested use of {...}.
 				if strings.Contains(name, ".") {
-					return nil, fmt.Errorf("format: attribute syntax x.y is not supported in replacement fields: %s", name)
+					return nil, fmt.Errorf("%s: attribute syntax x.y is not supported in replacement fields: %s", fname, name)
 				}
 				if strings.Contains(name, "[") {
-					return nil, fmt.Errorf("format: element syntax a[i] is not supported in replacement fields: %s", name)
+					return nil, fmt.Errorf("%s: element syntax a[i] is not supported in replacement fields: %s", fname, name)
 				}
 				if strings.Contains(name, "{") {
-					return nil, fmt.Errorf("format: nested replacement fields not supported")
+					return nil, fmt.Errorf("%s: nested replacement fields not supported", fname)
 				}
-				return nil, fmt.Errorf("format: keyword %s not found", name)
+				return nil, fmt.Errorf("%s: keyword %s not found", fname, name)
 			}
 		}
 
@@ -1636,12 +2515,12 @@ func string_format(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 			if str, ok := AsString(arg); ok {
 				buf.WriteString(str)
 			} else {
-				writeValue(buf, arg, nil)
+				writeValueMode(buf, arg, nil, floatFormat)
 			}
 		case "r":
 			writeValue(buf, arg, nil)
 		default:
-			return nil, fmt.Errorf("format: unknown conversion %q", conv)
+			return nil, fmt.Errorf("%s: unknown conversion %q", fname, conv)
 		}
 	}
 	return String(buf.String()), nil
@@ -1664,36 +2543,46 @@ func decimal(s string) (x int, ok bool) {
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·index
-func string_index(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_index(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	return string_find_impl(b, args, kwargs, false, false)
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·join
-func string_join(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_join(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	recv := string(b.Receiver().(String))
 	var iterable Iterable
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &iterable); err != nil {
 		return nil, err
 	}
-	iter := iterable.Iterate()
+	iter := IterateThread(thread, iterable)
 	defer iter.Done()
 	buf := new(strings.Builder)
 	var x Value
 	for i := 0; iter.Next(&x); i++ {
-		if i > 0 {
-			buf.WriteString(recv)
-		}
 		s, ok := AsString(x)
 		if !ok {
 			return nil, fmt.Errorf("join: in list, want string, got %s", x.Type())
 		}
+		n := len(s)
+		if i > 0 {
+			n += len(recv)
+		}
+		if err := thread.AddAllocs(uint64(n)); err != nil {
+			return nil, nameErr(b, err)
+		}
+		if i > 0 {
+			buf.WriteString(recv)
+		}
 		buf.WriteString(s)
 	}
+	if err := IterErr(iter); err != nil {
+		return nil, err
+	}
 	return String(buf.String()), nil
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·lower
-func string_lower(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_lower(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1701,7 +2590,7 @@ func string_lower(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·partition
-func string_partition(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_partition(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	recv := string(b.Receiver().(String))
 	var sep string
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &sep); err != nil {
@@ -1730,29 +2619,54 @@ func string_partition(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·replace
-func string_replace(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_replace(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	recv := string(b.Receiver().(String))
 	var old, new string
 	count := -1
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 2, &old, &new, &count); err != nil {
 		return nil, err
 	}
+	if len(new) > len(old) {
+		n := strings.Count(recv, old)
+		if count >= 0 && count < n {
+			n = count
+		}
+		if err := thread.AddAllocs(uint64(len(recv)) + uint64(n)*uint64(len(new)-len(old))); err != nil {
+			return nil, nameErr(b, err)
+		}
+	}
 	return String(strings.Replace(recv, old, new, count)), nil
 }
 
+// https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·removeprefix
+// https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·removesuffix
+func string_removefix(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	recv := string(b.Receiver().(String))
+	var fix string
+	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &fix); err != nil {
+		return nil, err
+	}
+	if b.Name()[len("remove")] == 'p' {
+		recv = strings.TrimPrefix(recv, fix) // removeprefix
+	} else {
+		recv = strings.TrimSuffix(recv, fix) // removesuffix
+	}
+	return String(recv), nil
+}
+
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·rfind
-func string_rfind(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_rfind(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	return string_find_impl(b, args, kwargs, true, true)
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·rindex
-func string_rindex(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_rindex(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	return string_find_impl(b, args, kwargs, false, true)
 }
 
 // https://github.com/google/pkgscript-go/pkgscript/blob/master/doc/spec.md#string·startswith
 // https://github.com/google/pkgscript-go/pkgscript/blob/master/doc/spec.md#string·endswith
-func string_startswith(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_startswith(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	var x Value
 	var start, end Value = None, None
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &x, &start, &end); err != nil {
@@ -1797,7 +2711,7 @@ func string_startswith(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·strip
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·lstrip
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·rstrip
-func string_strip(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_strip(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	var chars string
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0, &chars); err != nil {
 		return nil, err
@@ -1828,7 +2742,7 @@ func string_strip(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·title
-func string_title(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_title(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1854,16 +2768,39 @@ func string_title(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·upper
-func string_upper(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_upper(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
 	return String(strings.ToUpper(string(b.Receiver().(String)))), nil
 }
 
+// https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·zfill
+func string_zfill(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	var width int
+	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &width); err != nil {
+		return nil, err
+	}
+	recv := string(b.Receiver().(String))
+	if width <= len(recv) {
+		return String(recv), nil
+	}
+	if width > maxAlloc {
+		return nil, nameErr(b, fmt.Sprintf("excessive width (%d)", width))
+	}
+	if err := thread.AddAllocs(uint64(width)); err != nil {
+		return nil, nameErr(b, err)
+	}
+	sign := ""
+	if recv != "" && (recv[0] == '+' || recv[0] == '-') {
+		sign, recv = recv[:1], recv[1:]
+	}
+	return String(sign + strings.Repeat("0", width-len(sign)-len(recv)) + recv), nil
+}
+
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·split
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·rsplit
-func string_split(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_split(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	recv := string(b.Receiver().(String))
 	var sep_ Value
 	maxsplit := -1
@@ -1904,6 +2841,9 @@ func string_split(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 		return nil, fmt.Errorf("split: got %s for separator, want string", sep_.Type())
 	}
 
+	if err := thread.AddAllocs(uint64(len(res)) * valueSize); err != nil {
+		return nil, nameErr(b, err)
+	}
 	list := make([]Value, len(res))
 	for i, x := range res {
 		list[i] = String(x)
@@ -1966,39 +2906,56 @@ func splitspace(s string, max int) []string {
 }
 
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#string·splitlines
-func string_splitlines(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_splitlines(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	var keepends bool
-	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0, &keepends); err != nil {
+	if err := UnpackArgs(b.Name(), args, kwargs, "keepends?", &keepends); err != nil {
 		return nil, err
 	}
-	var lines []string
-	if s := string(b.Receiver().(String)); s != "" {
-		// TODO(adonovan): handle CRLF correctly.
-		if keepends {
-			lines = strings.SplitAfter(s, "\n")
-		} else {
-			lines = strings.Split(s, "\n")
+	var list []Value
+	s := string(b.Receiver().(String))
+	for s != "" {
+		// Find the end of the line, and of its terminator.
+		end, next := len(s), len(s)
+		for i, r := range s {
+			if isLineBreak(r) {
+				end, next = i, i+utf8.RuneLen(r)
+				if r == '\r' && strings.HasPrefix(s[next:], "\n") {
+					next++
+				}
+				break
+			}
 		}
-		if strings.HasSuffix(s, "\n") {
-			lines = lines[:len(lines)-1]
+		if keepends {
+			end = next
 		}
-	}
-	list := make([]Value, len(lines))
-	for i, x := range lines {
-		list[i] = String(x)
+		list = append(list, String(s[:end]))
+		s = s[next:]
 	}
 	return NewList(list), nil
 }
 
+// isLineBreak reports whether r is one of the line boundaries
+// recognized by Python's str.splitlines.
+func isLineBreak(r rune) bool {
+	switch r {
+	case '\n', '\r', '\v', '\f', '\x1c', '\x1d', '\x1e', '\u0085', '\u2028', '\u2029':
+		return true
+	}
+	return false
+}
+
 // https://github.com/google/pkgscript-go/blob/master/doc/spec.md#set·union.
-func set_union(b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+// It is also the union method of frozenset.
+func set_union(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	var iterable Iterable
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0, &iterable); err != nil {
 		return nil, err
 	}
-	iter := iterable.Iterate()
+	iter := IterateThread(thread, iterable)
 	defer iter.Done()
-	union, err := b.Receiver().(*Set).Union(iter)
+	union, err := b.Receiver().(interface {
+		Union(Iterator) (Value, error)
+	}).Union(iter)
 	if err != nil {
 		return nil, nameErr(b, err)
 	}
@@ -2040,19 +2997,23 @@ func string_find_impl(b *Builtin, args Tuple, kwargs []Tuple, allowError, last b
 
 // Common implementation of builtin dict function and dict.update method.
 // Precondition: len(updates) == 0 or 1.
-func updateDict(dict *Dict, updates Tuple, kwargs []Tuple) error {
+func updateDict(thread *Thread, dict *Dict, updates Tuple, kwargs []Tuple) error {
 	if len(updates) == 1 {
 		switch updates := updates[0].(type) {
 		case IterableMapping:
 			// Iterate over dict's key/value pairs, not just keys.
-			for _, item := range updates.Items() {
+			items := updates.Items()
+			if err := thread.AddAllocs(uint64(len(items)) * dictEntrySize); err != nil {
+				return err
+			}
+			for _, item := range items {
 				if err := dict.SetKey(item[0], item[1]); err != nil {
 					return err // dict is frozen
 				}
 			}
 		default:
 			// all other sequences
-			iter := Iterate(updates)
+			iter := IterateThread(thread, updates)
 			if iter == nil {
 				return fmt.Errorf("got %s, want iterable", updates.Type())
 			}
@@ -2074,14 +3035,23 @@ func updateDict(dict *Dict, updates Tuple, kwargs []Tuple) error {
 				var k, v Value
 				iter2.Next(&k)
 				iter2.Next(&v)
+				if err := thread.AddAllocs(dictEntrySize); err != nil {
+					return err
+				}
 				if err := dict.SetKey(k, v); err != nil {
 					return err
 				}
 			}
+			if err := IterErr(iter); err != nil {
+				return err
+			}
 		}
 	}
 
 	// Then add the kwargs.
+	if err := thread.AddAllocs(uint64(len(kwargs)) * dictEntrySize); err != nil {
+		return err
+	}
 	before := dict.Len()
 	for _, pair := range kwargs {
 		if err := dict.SetKey(pair[0], pair[1]); err != nil {
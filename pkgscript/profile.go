@@ -79,6 +79,11 @@ import (
 // It must be followed by a call to StopProfiler to stop
 // the profiler and finalize the profile.
 //
+// The profile is a gzip-compressed protocol message in the format
+// read by "go tool pprof": each sample is a call stack of Starlark
+// and built-in functions, annotated with their source positions,
+// whose value is the wall time spent with the innermost frame active.
+//
 // StartProfile returns an error if profiling was already enabled.
 //
 // StartProfile must not be called concurrently with Starlark execution.
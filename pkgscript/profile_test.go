@@ -6,6 +6,8 @@ package pkgscript_test
 
 import (
 	"bytes"
+	"compress/gzip"
+	"encoding/binary"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -81,3 +83,197 @@ fibonacci(100000)
 		t.Logf("stdout=%v", cmd.Stdout)
 	}
 }
+
+// TestPprofProfile decodes the profile written by StartProfile
+// and checks that its samples refer to the Starlark functions and
+// source lines that were executing.
+func TestPprofProfile(t *testing.T) {
+	// The profiler samples the running threads periodically, so a
+	// short run may yield no samples. Increase the work until it does.
+	var prof *pprofProfile
+	for n := 100000; prof == nil || len(prof.samples) == 0; n *= 4 {
+		if n > 100000*4*4*4*4 {
+			t.Skip("profiler took no samples")
+		}
+		prof = decodePprof(t, profileFibonacci(t, n))
+	}
+
+	sawFibonacci := false
+	for _, stack := range prof.samples {
+		for _, loc := range stack {
+			lines, ok := prof.locations[loc]
+			if !ok || len(lines) == 0 {
+				t.Fatalf("sample refers to undefined location %d", loc)
+			}
+			fn, ok := prof.functions[lines[0].function]
+			if !ok {
+				t.Fatalf("location refers to undefined function %d", lines[0].function)
+			}
+			if prof.str(t, fn.name) == "fibonacci" {
+				sawFibonacci = true
+				if file := prof.str(t, fn.filename); file != "foo.star" {
+					t.Errorf("fibonacci has filename %q, want foo.star", file)
+				}
+				if l := lines[0].line; l < 2 || l > 6 {
+					t.Errorf("fibonacci sample at line %d, want 2-6", l)
+				}
+			}
+		}
+	}
+	if !sawFibonacci {
+		t.Errorf("no sample attributed to fibonacci")
+	}
+}
+
+// profileFibonacci returns the uncompressed profile of a program
+// that computes the first n Fibonacci numbers.
+func profileFibonacci(t *testing.T, n int) []byte {
+	var buf bytes.Buffer
+	if err := pkgscript.StartProfile(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	const src = `
+def fibonacci(n):
+	res = list(range(n))
+	for i in res[2:]:
+		res[i] = res[i-2] + res[i-1]
+	return res
+
+fibonacci(n)
+`
+
+	thread := new(pkgscript.Thread)
+	predeclared := pkgscript.StringDict{"n": pkgscript.MakeInt(n)}
+	if _, err := pkgscript.ExecFile(thread, "foo.star", src, predeclared); err != nil {
+		_ = pkgscript.StopProfile()
+		t.Fatal(err)
+	}
+	if err := pkgscript.StopProfile(); err != nil {
+		t.Fatal(err)
+	}
+
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// A pprofProfile holds the fields of a Profile message that the test needs.
+type pprofProfile struct {
+	stringTable []string
+	functions   map[uint64]pprofFunction
+	locations   map[uint64][]pprofLine
+	samples     [][]uint64 // location IDs, leaf first
+}
+
+type pprofFunction struct{ name, filename int64 }
+type pprofLine struct{ function, line uint64 }
+
+func (p *pprofProfile) str(t *testing.T, i int64) string {
+	if i < 0 || i >= int64(len(p.stringTable)) {
+		t.Fatalf("invalid string index %d", i)
+	}
+	return p.stringTable[i]
+}
+
+// decodePprof decodes an uncompressed Profile message.
+func decodePprof(t *testing.T, data []byte) *pprofProfile {
+	p := &pprofProfile{
+		functions: make(map[uint64]pprofFunction),
+		locations: make(map[uint64][]pprofLine),
+	}
+	for _, f := range decodeProto(t, data) {
+		switch f.num {
+		case 2: // sample
+			var stack []uint64
+			for _, f := range decodeProto(t, f.bytes) {
+				if f.num == 1 {
+					stack = append(stack, f.int)
+				}
+			}
+			p.samples = append(p.samples, stack)
+		case 4: // location
+			var id uint64
+			var lines []pprofLine
+			for _, f := range decodeProto(t, f.bytes) {
+				switch f.num {
+				case 1:
+					id = f.int
+				case 4:
+					var l pprofLine
+					for _, f := range decodeProto(t, f.bytes) {
+						switch f.num {
+						case 1:
+							l.function = f.int
+						case 2:
+							l.line = f.int
+						}
+					}
+					lines = append(lines, l)
+				}
+			}
+			p.locations[id] = lines
+		case 5: // function
+			var id uint64
+			var fn pprofFunction
+			for _, f := range decodeProto(t, f.bytes) {
+				switch f.num {
+				case 1:
+					id = f.int
+				case 2:
+					fn.name = int64(f.int)
+				case 4:
+					fn.filename = int64(f.int)
+				}
+			}
+			p.functions[id] = fn
+		case 6: // string_table
+			p.stringTable = append(p.stringTable, string(f.bytes))
+		}
+	}
+	return p
+}
+
+type protoField struct {
+	num   uint64
+	int   uint64 // varint fields
+	bytes []byte // length-delimited fields
+}
+
+// decodeProto decodes the varint and length-delimited fields of a
+// protocol message, which are the only wire types used by the profiler.
+func decodeProto(t *testing.T, data []byte) []protoField {
+	var fields []protoField
+	uvarint := func() uint64 {
+		x, n := binary.Uvarint(data)
+		if n <= 0 {
+			t.Fatal("invalid varint in profile")
+		}
+		data = data[n:]
+		return x
+	}
+	for len(data) > 0 {
+		key := uvarint()
+		f := protoField{num: key >> 3}
+		switch key & 7 {
+		case 0:
+			f.int = uvarint()
+		case 2:
+			n := uvarint()
+			if n > uint64(len(data)) {
+				t.Fatal("truncated field in profile")
+			}
+			f.bytes, data = data[:n], data[n:]
+		default:
+			t.Fatalf("unexpected wire type %d in profile", key&7)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
@@ -18,14 +18,24 @@ import (
 // Iterable, or user-defined implementation of Value,
 // UnpackArgs performs the appropriate type check.
 // An int uses the AsInt32 check.
+// A []string or []int variable accepts a list or tuple, and a
+// map[string]string variable accepts a dict; each element is checked
+// and converted as for a string or int variable, and an error
+// identifies the offending element by its index or key.
 // If the parameter name ends with "?",
 // it and all following parameters are optional.
 //
+// If the variable implements Unpacker, UnpackArgs calls its Unpack
+// method with the argument, and reports any error it returns.
+// This allows parameters of application-defined types, and of
+// union types such as those constructed by OneOf.
+//
 // If the variable implements Value, UnpackArgs may call
 // its Type() method while constructing the error message.
 //
 // Beware: an optional *List, *Dict, Callable, Iterable, or Value variable that is
-// not assigned is not a valid Starlark Value, so the caller must
+// not assigned is not a valid Starlark Value, and an optional slice or
+// map variable that is not assigned is nil, so the caller must
 // explicitly handle such cases by interpreting nil as None or some
 // computed default.
 func UnpackArgs(fnname string, args Tuple, kwargs []Tuple, pairs ...interface{}) error {
@@ -124,9 +134,71 @@ func UnpackPositionalArgs(fnname string, args Tuple, kwargs []Tuple, min int, va
 	return nil
 }
 
+// An Unpacker defines custom argument unpacking behavior.
+// See UnpackArgs.
+//
+// The Unpack method is called with the argument value.
+// It should store the result of any conversion in the Unpacker
+// only if it succeeds, and return an error describing the problem
+// otherwise. By convention, an error for an argument of the wrong
+// type has the form "got T, want U", where T is the type of the
+// argument and U describes the acceptable values; UnpackArgs adds the
+// function and parameter names.
+type Unpacker interface {
+	Unpack(v Value) error
+}
+
+// OneOf returns an Unpacker that unpacks an argument into the first
+// of the specified variables that accepts it, leaving the others
+// unchanged. Each element of ptrs is a pointer to a variable of any
+// type accepted by UnpackArgs.
+//
+// For example, a parameter that is either a string or a list of
+// strings may be unpacked like this:
+//
+//	var path string
+//	var paths []string
+//	err := UnpackArgs("f", args, kwargs, "path", OneOf(&path, &paths))
+//
+// after which paths is nil if the argument was a string.
+//
+// If no variable accepts the argument because of its type, the error
+// lists the acceptable types, as in "got int, want string or list of
+// string". If a variable accepts the argument's type but not its
+// value, as with a list containing a non-string, the error is that
+// variable's error.
+func OneOf(ptrs ...interface{}) Unpacker {
+	return oneOf(ptrs)
+}
+
+type oneOf []interface{}
+
+func (ptrs oneOf) Unpack(v Value) error {
+	var wants []string
+	var valueErr error
+	prefix := fmt.Sprintf("got %s, want ", v.Type())
+	for _, ptr := range ptrs {
+		err := unpackOneArg(v, ptr)
+		if err == nil {
+			return nil
+		}
+		if msg := err.Error(); strings.HasPrefix(msg, prefix) {
+			wants = append(wants, msg[len(prefix):])
+		} else if valueErr == nil {
+			valueErr = err
+		}
+	}
+	if valueErr != nil {
+		return valueErr
+	}
+	return fmt.Errorf("%s%s", prefix, strings.Join(wants, " or "))
+}
+
 func unpackOneArg(v Value, ptr interface{}) error {
 	// On failure, don't clobber *ptr.
 	switch ptr := ptr.(type) {
+	case Unpacker:
+		return ptr.Unpack(v)
 	case *Value:
 		*ptr = v
 	case *string:
@@ -171,6 +243,48 @@ func unpackOneArg(v Value, ptr interface{}) error {
 			return fmt.Errorf("got %s, want iterable", v.Type())
 		}
 		*ptr = it
+	case *[]string:
+		elems, err := unpackSequence(v, "string")
+		if err != nil {
+			return err
+		}
+		strs := make([]string, len(elems))
+		for i, elem := range elems {
+			if err := unpackOneArg(elem, &strs[i]); err != nil {
+				return fmt.Errorf("element %d: %v", i, err)
+			}
+		}
+		*ptr = strs
+	case *[]int:
+		elems, err := unpackSequence(v, "int")
+		if err != nil {
+			return err
+		}
+		ints := make([]int, len(elems))
+		for i, elem := range elems {
+			if err := unpackOneArg(elem, &ints[i]); err != nil {
+				return fmt.Errorf("element %d: %v", i, err)
+			}
+		}
+		*ptr = ints
+	case *map[string]string:
+		dict, ok := v.(*Dict)
+		if !ok {
+			return fmt.Errorf("got %s, want dict of string to string", v.Type())
+		}
+		m := make(map[string]string, dict.Len())
+		for _, item := range dict.Items() {
+			k, ok := AsString(item[0])
+			if !ok {
+				return fmt.Errorf("key %s: got %s, want string", item[0], item[0].Type())
+			}
+			var s string
+			if err := unpackOneArg(item[1], &s); err != nil {
+				return fmt.Errorf("key %q: %v", k, err)
+			}
+			m[k] = s
+		}
+		*ptr = m
 	default:
 		// v must have type *V, where V is some subtype of pkgscript.Value.
 		ptrv := reflect.ValueOf(ptr)
@@ -214,6 +328,251 @@ func unpackOneArg(v Value, ptr interface{}) error {
 	return nil
 }
 
+// unpackSequence returns the elements of v, which must be a list or
+// tuple. elemType names the element type for the error message.
+func unpackSequence(v Value, elemType string) ([]Value, error) {
+	switch v := v.(type) {
+	case *List:
+		return v.elems, nil
+	case Tuple:
+		return v, nil
+	}
+	return nil, fmt.Errorf("got %s, want list of %s", v.Type(), elemType)
+}
+
+// UnpackStruct populates the fields of the Go struct pointed to by ptr
+// from v, which must be a dict with string keys or a value with
+// attributes, such as a struct.
+//
+// Each exported field is populated from the dict entry or attribute
+// named by the field's `starlark:"name"` tag, or by the field's own
+// name if it has no tag. Fields tagged `starlark:"-"` are ignored.
+// A field whose tag has the "optional" option, as in
+// `starlark:"name,optional"`, is left unchanged if the entry is absent
+// or None; any other field that is absent is an error.
+//
+// Values are converted according to the type of the field.
+// A bool or string field requires a bool or string.
+// An integer field requires an int within the range of the field's type.
+// A floating-point field accepts an int or a float.
+// A slice field accepts any iterable, and a map field with string keys
+// accepts a dict; their elements are converted in turn.
+// A struct field, or a pointer to a struct, is populated recursively;
+// a nil pointer is allocated as needed, and None yields a nil pointer.
+// A field of type Value, or of some subtype of Value, accepts any value
+// assignable to it.
+//
+// UnpackStruct panics if ptr is not a non-nil pointer to a struct, or
+// if the struct has a field of a type not listed above.
+// On failure, the struct may have been partially populated.
+func UnpackStruct(v Value, ptr interface{}) error {
+	ptrv := reflect.ValueOf(ptr)
+	if ptrv.Kind() != reflect.Ptr || ptrv.IsNil() || ptrv.Elem().Kind() != reflect.Struct {
+		log.Panicf("UnpackStruct: not a non-nil pointer to a struct: %T", ptr)
+	}
+	return unpackStruct(v, ptrv.Elem(), "")
+}
+
+var valueType = reflect.TypeOf((*Value)(nil)).Elem()
+
+// unpackStruct populates the struct dst from v.
+// path is the dotted name of dst within the outermost value, for errors.
+func unpackStruct(v Value, dst reflect.Value, path string) error {
+	var get func(name string) (Value, error)
+	switch v := v.(type) {
+	case *Dict:
+		get = func(name string) (Value, error) {
+			x, found, err := v.Get(String(name))
+			if !found {
+				return nil, err
+			}
+			return x, err
+		}
+	case *List, Tuple, String, *Set, *FrozenSet:
+		// Their attributes are methods, not data.
+		return unpackMismatch(v, "dict or struct", path)
+	case HasAttrs:
+		// Some implementations of Attr report an error for a
+		// missing attribute, so consult AttrNames first.
+		get = func(name string) (Value, error) {
+			for _, attr := range v.AttrNames() {
+				if attr == name {
+					return v.Attr(name)
+				}
+			}
+			return nil, nil
+		}
+	default:
+		return unpackMismatch(v, "dict or struct", path)
+	}
+
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue // unexported
+		}
+		name, optional := field.Name, false
+		if tag, ok := field.Tag.Lookup("starlark"); ok {
+			if tag == "-" {
+				continue
+			}
+			opts := strings.Split(tag, ",")
+			if opts[0] != "" {
+				name = opts[0]
+			}
+			for _, opt := range opts[1:] {
+				if opt == "optional" {
+					optional = true
+				}
+			}
+		}
+		fpath := name
+		if path != "" {
+			fpath = path + "." + name
+		}
+
+		x, err := get(name)
+		if err != nil {
+			return fmt.Errorf("field %s: %v", fpath, err)
+		}
+		if optional && (x == nil || x == None) {
+			continue
+		}
+		if x == nil {
+			return fmt.Errorf("missing field %s", fpath)
+		}
+		if err := unpackValue(x, dst.Field(i), fpath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unpackValue converts v to the type of dst and stores it there.
+func unpackValue(v Value, dst reflect.Value, path string) error {
+	t := dst.Type()
+	if t.Kind() == reflect.Interface || t.Implements(valueType) {
+		if !reflect.TypeOf(v).AssignableTo(t) {
+			return unpackMismatch(v, t.String(), path)
+		}
+		dst.Set(reflect.ValueOf(v))
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		b, ok := v.(Bool)
+		if !ok {
+			return unpackMismatch(v, "bool", path)
+		}
+		dst.SetBool(bool(b))
+
+	case reflect.String:
+		s, ok := AsString(v)
+		if !ok {
+			return unpackMismatch(v, "string", path)
+		}
+		dst.SetString(s)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := v.(Int)
+		if !ok {
+			return unpackMismatch(v, "int", path)
+		}
+		x, ok := i.Int64()
+		if !ok || dst.OverflowInt(x) {
+			return fmt.Errorf("field %s: %s out of range for %s", path, i, t)
+		}
+		dst.SetInt(x)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, ok := v.(Int)
+		if !ok {
+			return unpackMismatch(v, "int", path)
+		}
+		x, ok := i.Uint64()
+		if !ok || dst.OverflowUint(x) {
+			return fmt.Errorf("field %s: %s out of range for %s", path, i, t)
+		}
+		dst.SetUint(x)
+
+	case reflect.Float32, reflect.Float64:
+		f, ok := AsFloat(v)
+		if !ok {
+			return unpackMismatch(v, "float", path)
+		}
+		dst.SetFloat(f)
+
+	case reflect.Slice:
+		iterable, ok := v.(Iterable)
+		if !ok {
+			return unpackMismatch(v, "iterable", path)
+		}
+		slice := reflect.MakeSlice(t, 0, 0)
+		iter := iterable.Iterate()
+		defer iter.Done()
+		var x Value
+		for i := 0; iter.Next(&x); i++ {
+			elem := reflect.New(t.Elem()).Elem()
+			if err := unpackValue(x, elem, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+			slice = reflect.Append(slice, elem)
+		}
+		if err := IterErr(iter); err != nil {
+			return err
+		}
+		dst.Set(slice)
+
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			log.Panicf("UnpackStruct: unsupported map key type %s", t.Key())
+		}
+		dict, ok := v.(*Dict)
+		if !ok {
+			return unpackMismatch(v, "dict", path)
+		}
+		m := reflect.MakeMapWithSize(t, dict.Len())
+		for _, item := range dict.Items() {
+			k, ok := AsString(item[0])
+			if !ok {
+				return fmt.Errorf("field %s: got %s key, want string", path, item[0].Type())
+			}
+			elem := reflect.New(t.Elem()).Elem()
+			if err := unpackValue(item[1], elem, fmt.Sprintf("%s[%q]", path, k)); err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(k).Convert(t.Key()), elem)
+		}
+		dst.Set(m)
+
+	case reflect.Struct:
+		return unpackStruct(v, dst, path)
+
+	case reflect.Ptr:
+		if v == None {
+			dst.Set(reflect.Zero(t))
+			return nil
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.New(t.Elem()))
+		}
+		return unpackValue(v, dst.Elem(), path)
+
+	default:
+		log.Panicf("UnpackStruct: unsupported field type %s", t)
+	}
+	return nil
+}
+
+func unpackMismatch(v Value, want, path string) error {
+	if path == "" {
+		return fmt.Errorf("got %s, want %s", v.Type(), want)
+	}
+	return fmt.Errorf("field %s: got %s, want %s", path, v.Type(), want)
+}
+
 type intset struct {
 	small uint64       // bitset, used if n < 64
 	large map[int]bool //    set, used if n >= 64
@@ -11,11 +11,13 @@
 //      Bool            -- bool
 //      Int             -- int
 //      Float           -- float
+//      Decimal         -- decimal
 //      String          -- string
 //      *List           -- list
 //      Tuple           -- tuple
 //      *Dict           -- dict
 //      *Set            -- set
+//      *FrozenSet      -- frozenset
 //      *Function       -- function (implemented in Starlark)
 //      *Builtin        -- builtin_function_or_method (function or method implemented in Go)
 //
@@ -27,15 +29,28 @@
 //      Comparable      -- value defines its own comparison operations
 //      Iterable        -- value is iterable using 'for' loops
 //      Sequence        -- value is iterable sequence of known length
+//      HasLen          -- value has a length, len(x)
 //      Indexable       -- value is sequence with efficient random access
+//      Sliceable       -- value is indexable and supports slicing x[i:j:k]
 //      Mapping         -- value maps from keys to values, like a dictionary
+//      IterableMapping -- value is a mapping whose keys may be enumerated
 //      HasBinary       -- value defines binary operations such as * and +
 //      HasAttrs        -- value has readable fields or methods x.f
 //      HasSetField     -- value has settable fields x.f
 //      HasSetIndex     -- value supports element update using x[i]=y
 //      HasSetKey       -- value supports map update using x[k]=v
+//      HasDelField     -- value has deletable fields, del x.f
+//      HasDelIndex     -- value supports element deletion using del x[i]
+//      HasDelKey       -- value supports map deletion using del x[k]
 //      HasUnary        -- value defines unary operations such as + and -
 //
+// The evaluator chooses among these interfaces as follows.
+// len(x) requires HasLen (which includes Sequence and Indexable).
+// x[i] calls Index if x is Indexable, or else Get if x is a Mapping.
+// x in y calls Get if y is a Mapping, and otherwise compares x with
+// each element of y if it is Indexable or Iterable.
+// A for loop, and any built-in that accepts an iterable, calls Iterate.
+//
 // Client applications may also define domain-specific functions in Go
 // and make them available to Starlark programs.  Use NewBuiltin to
 // construct a built-in value that wraps a Go function.  The
@@ -62,6 +77,14 @@
 // returns an EvalError from which the application may obtain a
 // backtrace of active Starlark calls.
 //
+// Independent programs may be compiled and executed concurrently, each
+// in its own Thread. The dialect of a file is fixed when it is resolved,
+// by its syntax.File.Options or, if they are nil, by the global options
+// of the resolve package (resolve.AllowFloat, etc.). Those variables,
+// along with compile.Disassemble and the Universe dictionary, are
+// process-wide and must not be modified while any program is being
+// compiled or executed.
+//
 package pkgscript // import "github.com/andrewchambers/pkgscript/pkgscript"
 
 // This file defines the data types of Starlark and their basic operations.
@@ -140,6 +163,7 @@ var (
 	_ Comparable = (*List)(nil)
 	_ Comparable = Tuple(nil)
 	_ Comparable = (*Set)(nil)
+	_ Comparable = (*FrozenSet)(nil)
 )
 
 // A Callable value f may be the operand of a function call, f(x).
@@ -180,6 +204,21 @@ type Sequence interface {
 var (
 	_ Sequence = (*Dict)(nil)
 	_ Sequence = (*Set)(nil)
+	_ Sequence = (*FrozenSet)(nil)
+)
+
+// A HasLen value has a length, as reported by the len built-in.
+// Every Sequence has a length, but a value with a length
+// need not be iterable.
+type HasLen interface {
+	Value
+	Len() int
+}
+
+var (
+	_ HasLen = String("")
+	_ HasLen = (*List)(nil)
+	_ HasLen = rangeValue{}
 )
 
 // An Indexable is a sequence of known length that supports efficient random access.
@@ -212,8 +251,18 @@ type HasSetIndex interface {
 	SetIndex(index int, v Value) error
 }
 
+// A HasDelIndex is an Indexable value whose elements may be deleted (del x[i]).
+//
+// As with SetIndex, the evaluator adds Len to a negative index
+// and checks that the index is in range before the call.
+type HasDelIndex interface {
+	Indexable
+	DelIndex(index int) error
+}
+
 var (
 	_ HasSetIndex = (*List)(nil)
+	_ HasDelIndex = (*List)(nil)
 	_ Indexable   = Tuple(nil)
 	_ Indexable   = String("")
 	_ Sliceable   = Tuple(nil)
@@ -243,6 +292,41 @@ type Iterator interface {
 	Done()
 }
 
+// A FallibleIterator is an Iterator whose iteration may fail, for
+// example because computing each element calls a Starlark function.
+// When Next returns false, the iterator has either been exhausted or
+// failed, and Err returns the error of the failure, or nil.
+//
+// Clients that iterate over arbitrary values should use IterErr after
+// the iteration to report any failure.
+type FallibleIterator interface {
+	Iterator
+	Err() error
+}
+
+// IterErr returns the error that caused iter to stop,
+// if it is a FallibleIterator, and nil otherwise.
+func IterErr(iter Iterator) error {
+	if iter, ok := iter.(FallibleIterator); ok {
+		return iter.Err()
+	}
+	return nil
+}
+
+// A ThreadIterable is an Iterable whose elements are computed by
+// calling Starlark functions, such as the result of map or filter.
+// IterateThread returns an iterator that makes those calls on thread,
+// which should be the thread consuming the iterator, so that its
+// limits, cancellation, and call stack apply to them.
+//
+// Iterate, which has no thread, returns an iterator that fails if it
+// needs to call a function. Clients that have a thread should iterate
+// over arbitrary values with IterateThread.
+type ThreadIterable interface {
+	Iterable
+	IterateThread(thread *Thread) Iterator
+}
+
 // A Mapping is a mapping from keys to values, such as a dictionary.
 //
 // If a type satisfies both Mapping and Iterable, the iterator yields
@@ -274,6 +358,16 @@ type HasSetKey interface {
 
 var _ HasSetKey = (*Dict)(nil)
 
+// A HasDelKey supports map deletion using del x[k] syntax, like a dictionary.
+//
+// DelKey should return an error if the key is not present.
+type HasDelKey interface {
+	Mapping
+	DelKey(k Value) error
+}
+
+var _ HasDelKey = (*Dict)(nil)
+
 // A HasBinary value may be used as either operand of these binary operators:
 //     +   -   *   /   //   %   in   not in   |   &   ^   <<   >>
 //
@@ -322,8 +416,27 @@ var (
 	_ HasAttrs = new(List)
 	_ HasAttrs = new(Dict)
 	_ HasAttrs = new(Set)
+	_ HasAttrs = new(FrozenSet)
 )
 
+// A HasDeepCopy value may be copied by DeepCopy and Snapshot. Its
+// DeepCopy method returns a copy of the value whose elements are
+// copied by calling copy, which handles values that contain themselves.
+// DeepCopy freezes the copy; Snapshot does not.
+type HasDeepCopy interface {
+	Value
+	DeepCopy(copy func(Value) (Value, error)) (Value, error)
+}
+
+// A HasStableHash value has a hash, reported by StableHash,
+// that is the same in every execution. Its StableHash method must
+// return equal hashes for equal values, and may call StableHash to
+// hash the value's elements.
+type HasStableHash interface {
+	Value
+	StableHash() (uint32, error)
+}
+
 // A HasSetField value has fields that may be written by a dot expression (x.f = y).
 //
 // An implementation of SetField may return a NoSuchAttrError,
@@ -334,8 +447,17 @@ type HasSetField interface {
 	SetField(name string, val Value) error
 }
 
+// A HasDelField value has fields that may be deleted by a del statement (del x.f).
+//
+// An implementation of DelField may return a NoSuchAttrError,
+// as for SetField.
+type HasDelField interface {
+	HasAttrs
+	DelField(name string) error
+}
+
 // A NoSuchAttrError may be returned by an implementation of
-// HasAttrs.Attr or HasSetField.SetField to indicate that no such field
+// HasAttrs.Attr, HasSetField.SetField, or HasDelField.DelField to indicate that no such field
 // exists. In that case the runtime may augment the error message to
 // warn of possible misspelling.
 type NoSuchAttrError string
@@ -384,7 +506,35 @@ func (x Bool) CompareSameType(op syntax.Token, y_ Value, depth int) (bool, error
 // Float is the type of a Starlark float.
 type Float float64
 
-func (f Float) String() string { return strconv.FormatFloat(float64(f), 'g', 6, 64) }
+// String returns the shortest decimal form of f that reads back as
+// the same value, as Python's repr does: 0.1 prints as "0.1", 1.0 as
+// "1.0", and 1e16 as "1e+16". See Format and Thread.SetFloatFormat for
+// other representations.
+func (f Float) String() string { return formatFloatRepr(float64(f)) }
+
+// formatFloatRepr returns the shortest round-trip representation of f,
+// following Python's choice between fixed and exponential notation.
+func formatFloatRepr(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "nan"
+	case math.IsInf(f, +1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	s := strconv.FormatFloat(f, 'e', -1, 64)
+	exp, _ := strconv.Atoi(s[strings.IndexByte(s, 'e')+1:])
+	if exp < -4 || exp >= 16 {
+		return s
+	}
+	s = strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
 func (f Float) Type() string   { return "float" }
 func (f Float) Freeze()        {} // immutable
 func (f Float) Truth() Bool    { return f != 0.0 }
@@ -398,8 +548,6 @@ func (f Float) Hash() (uint32, error) {
 	return 1618033, nil // NaN, +/-Inf
 }
 
-func floor(f Float) Float { return Float(math.Floor(float64(f))) }
-
 // isFinite reports whether f represents a finite rational value.
 // It is equivalent to !math.IsNan(f) && !math.IsInf(f, 0).
 func isFinite(f float64) bool {
@@ -439,7 +587,38 @@ func AsFloat(x Value) (f float64, ok bool) {
 	return 0, false
 }
 
-func (x Float) Mod(y Float) Float { return Float(math.Mod(float64(x), float64(y))) }
+// Mod returns the remainder of the floored division x // y,
+// which has the sign of y, or is zero. y must not be zero.
+func (x Float) Mod(y Float) Float {
+	z := Float(math.Mod(float64(x), float64(y)))
+	if z != 0 && (z < 0) != (y < 0) {
+		z += y
+	}
+	if z == 0 {
+		z = Float(math.Copysign(0, float64(y)))
+	}
+	return z
+}
+
+// floorDiv returns floor(x / y), computed so that
+// x == floorDiv(x, y) * y + x.Mod(y) as nearly as possible.
+// y must not be zero.
+func floorDiv(x, y Float) Float {
+	// This follows the algorithm of CPython's float_floor_div.
+	mod := math.Mod(float64(x), float64(y))
+	div := (float64(x) - mod) / float64(y)
+	if mod != 0 && (mod < 0) != (y < 0) {
+		div -= 1
+	}
+	if div == 0 {
+		return Float(math.Copysign(0, float64(x/y)))
+	}
+	q := math.Floor(div)
+	if div-q > 0.5 {
+		q++
+	}
+	return Float(q)
+}
 
 // Unary implements the operations +float and -float.
 func (f Float) Unary(op syntax.Token) (Value, error) {
@@ -579,6 +758,7 @@ type Function struct {
 // All functions in the same program share a module.
 type module struct {
 	program     *compile.Program
+	options     *syntax.FileOptions // dialect of the file, or nil if unknown
 	predeclared StringDict
 	globals     []Value
 	constants   []Value
@@ -628,9 +808,10 @@ func (fn *Function) HasKwargs() bool  { return fn.funcode.HasKwargs }
 
 // A Builtin is a function implemented in Go.
 type Builtin struct {
-	name string
-	fn   func(thread *Thread, fn *Builtin, args Tuple, kwargs []Tuple) (Value, error)
-	recv Value // for bound methods (e.g. "".startswith)
+	name        string
+	fn          func(thread *Thread, fn *Builtin, args Tuple, kwargs []Tuple) (Value, error)
+	recv        Value  // for bound methods (e.g. "".startswith)
+	deprecation string // if non-empty, the built-in is deprecated
 }
 
 func (b *Builtin) Name() string { return b.name }
@@ -650,8 +831,26 @@ func (b *Builtin) Receiver() Value { return b.recv }
 func (b *Builtin) String() string  { return toString(b) }
 func (b *Builtin) Type() string    { return "builtin_function_or_method" }
 func (b *Builtin) CallInternal(thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	if thread != nil && thread.OnBuiltinCall != nil {
+		if err := thread.OnBuiltinCall(b.qualifiedName(), args, kwargs); err != nil {
+			return nil, err
+		}
+	}
+	if thread != nil && b.deprecation != "" {
+		thread.warnDeprecated(b)
+	}
 	return b.fn(thread, b, args, kwargs)
 }
+
+// qualifiedName returns the name of the built-in,
+// qualified by the type of its receiver, if any.
+func (b *Builtin) qualifiedName() string {
+	if b.recv != nil {
+		return b.recv.Type() + "." + b.name
+	}
+	return b.name
+}
+
 func (b *Builtin) Truth() Bool { return true }
 
 // NewBuiltin returns a new 'builtin_function_or_method' value with the specified name
@@ -674,13 +873,28 @@ func NewBuiltin(name string, fn func(thread *Thread, fn *Builtin, args Tuple, kw
 //     "abc".index("a")
 //
 func (b *Builtin) BindReceiver(recv Value) *Builtin {
-	return &Builtin{name: b.name, fn: b.fn, recv: recv}
+	return &Builtin{name: b.name, fn: b.fn, recv: recv, deprecation: b.deprecation}
+}
+
+// WithDeprecation returns a copy of the built-in that is marked as
+// deprecated. Each call of the result reports a warning containing
+// the message, such as "use f2 instead", through the thread's Warn
+// function; see Thread.Warn. The call itself proceeds normally.
+func (b *Builtin) WithDeprecation(msg string) *Builtin {
+	return &Builtin{name: b.name, fn: b.fn, recv: b.recv, deprecation: msg}
 }
 
+// Deprecation returns the deprecation message of the built-in,
+// or "" if it is not deprecated.
+func (b *Builtin) Deprecation() string { return b.deprecation }
+
 // A *Dict represents a Starlark dictionary.
 // The zero value of Dict is a valid empty dictionary.
 // If you know the exact final number of entries,
 // it is more efficient to call NewDict.
+//
+// The Items, Keys, and Values methods return new slices
+// of the dictionary's entries, in iteration (insertion) order.
 type Dict struct {
 	ht hashtable
 }
@@ -693,11 +907,29 @@ func NewDict(size int) *Dict {
 	return dict
 }
 
+// NewDictFromItems returns a new dictionary containing the specified
+// key/value pairs, in order. Each element of items must be a pair.
+// Later pairs override earlier ones with equal keys, as in dict(items).
+// It returns an error if a key is not hashable.
+func NewDictFromItems(items []Tuple) (*Dict, error) {
+	dict := NewDict(len(items))
+	for i, item := range items {
+		if len(item) != 2 {
+			return nil, fmt.Errorf("dictionary item #%d has length %d, want 2", i, len(item))
+		}
+		if err := dict.SetKey(item[0], item[1]); err != nil {
+			return nil, err
+		}
+	}
+	return dict, nil
+}
+
 func (d *Dict) Clear() error                                    { return d.ht.clear() }
 func (d *Dict) Delete(k Value) (v Value, found bool, err error) { return d.ht.delete(k) }
 func (d *Dict) Get(k Value) (v Value, found bool, err error)    { return d.ht.lookup(k) }
 func (d *Dict) Items() []Tuple                                  { return d.ht.items() }
 func (d *Dict) Keys() []Value                                   { return d.ht.keys() }
+func (d *Dict) Values() []Value                                 { return d.ht.values() }
 func (d *Dict) Len() int                                        { return int(d.ht.len) }
 func (d *Dict) Iterate() Iterator                               { return d.ht.iterate() }
 func (d *Dict) SetKey(k, v Value) error                         { return d.ht.insert(k, v) }
@@ -710,6 +942,15 @@ func (d *Dict) Hash() (uint32, error)                           { return 0, fmt.
 func (d *Dict) Attr(name string) (Value, error) { return builtinAttr(d, name, dictMethods) }
 func (d *Dict) AttrNames() []string             { return builtinAttrNames(dictMethods) }
 
+func (d *Dict) DelKey(k Value) error {
+	if _, found, err := d.ht.delete(k); err != nil {
+		return err // dict is frozen or key is unhashable
+	} else if !found {
+		return fmt.Errorf("key %v not in dict", k)
+	}
+	return nil
+}
+
 func (x *Dict) CompareSameType(op syntax.Token, y_ Value, depth int) (bool, error) {
 	y := y_.(*Dict)
 	switch op {
@@ -865,6 +1106,16 @@ func (l *List) SetIndex(i int, v Value) error {
 	return nil
 }
 
+func (l *List) DelIndex(i int) error {
+	if err := l.checkMutable("delete element of"); err != nil {
+		return err
+	}
+	n := copy(l.elems[i:], l.elems[i+1:])
+	l.elems[i+n] = nil // aid GC
+	l.elems = l.elems[:i+n]
+	return nil
+}
+
 func (l *List) Append(v Value) error {
 	if err := l.checkMutable("append to"); err != nil {
 		return err
@@ -913,6 +1164,9 @@ func (t Tuple) String() string { return toString(t) }
 func (t Tuple) Type() string   { return "tuple" }
 func (t Tuple) Truth() Bool    { return len(t) > 0 }
 
+func (t Tuple) Attr(name string) (Value, error) { return builtinAttr(t, name, tupleMethods) }
+func (t Tuple) AttrNames() []string             { return builtinAttrNames(tupleMethods) }
+
 func (x Tuple) CompareSameType(op syntax.Token, y_ Value, depth int) (bool, error) {
 	y := y_.(Tuple)
 	return sliceCompare(op, x, y, depth)
@@ -947,6 +1201,12 @@ func (it *tupleIterator) Done() {}
 
 // A Set represents a Starlark set value.
 // The zero value of Set is a valid empty set.
+//
+// Like Dict, a Set preserves insertion order: iteration, String, and
+// the results of set operations yield elements in the order in which
+// they were first inserted. Deleting an element does not change the
+// relative order of the others, and an element that is deleted and
+// inserted again moves to the end.
 // If you know the exact final number of elements,
 // it is more efficient to call NewSet.
 type Set struct {
@@ -970,6 +1230,7 @@ func (s *Set) Iterate() Iterator                      { return s.ht.iterate() }
 func (s *Set) String() string                         { return toString(s) }
 func (s *Set) Type() string                           { return "set" }
 func (s *Set) elems() []Value                         { return s.ht.keys() }
+func (s *Set) table() *hashtable                      { return &s.ht }
 func (s *Set) Freeze()                                { s.ht.freeze() }
 func (s *Set) Hash() (uint32, error)                  { return 0, fmt.Errorf("unhashable type: set") }
 func (s *Set) Truth() Bool                            { return s.Len() > 0 }
@@ -977,26 +1238,18 @@ func (s *Set) Truth() Bool                            { return s.Len() > 0 }
 func (s *Set) Attr(name string) (Value, error) { return builtinAttr(s, name, setMethods) }
 func (s *Set) AttrNames() []string             { return builtinAttrNames(setMethods) }
 
-func (x *Set) CompareSameType(op syntax.Token, y_ Value, depth int) (bool, error) {
-	y := y_.(*Set)
-	switch op {
-	case syntax.EQL:
-		ok, err := setsEqual(x, y, depth)
-		return ok, err
-	case syntax.NEQ:
-		ok, err := setsEqual(x, y, depth)
-		return !ok, err
-	default:
-		return false, fmt.Errorf("%s %s %s not implemented", x.Type(), op, y.Type())
-	}
+func (x *Set) CompareSameType(op syntax.Token, y Value, depth int) (bool, error) {
+	return compareSets(op, x, y.(*Set), depth)
 }
 
-func setsEqual(x, y *Set, depth int) (bool, error) {
+func setsEqual(x, y setValue, depth int) (bool, error) {
 	if x.Len() != y.Len() {
 		return false, nil
 	}
 	for _, elem := range x.elems() {
-		if found, _ := y.Has(elem); !found {
+		if _, found, err := y.table().lookupDepth(elem, depth-1); err != nil {
+			return false, err
+		} else if !found {
 			return false, nil
 		}
 	}
@@ -1014,9 +1267,109 @@ func (s *Set) Union(iter Iterator) (Value, error) {
 			return nil, err
 		}
 	}
+	if err := IterErr(iter); err != nil {
+		return nil, err
+	}
 	return set, nil
 }
 
+// A FrozenSet represents a Starlark frozenset value: an immutable set
+// whose elements are fixed when it is created. Unlike a Set, a
+// FrozenSet is hashable, so it may be used as a dict key or set
+// element. Its hash depends only on its elements, and it is equal to
+// any Set or FrozenSet with the same elements.
+// Like Set, it preserves insertion order.
+type FrozenSet struct {
+	set  *Set
+	hash uint32
+}
+
+// NewFrozenSet returns a frozenset containing the specified elements,
+// in order, ignoring duplicates. It fails if any element is unhashable.
+func NewFrozenSet(elems []Value) (*FrozenSet, error) {
+	set := NewSet(len(elems))
+	for _, elem := range elems {
+		if err := set.Insert(elem); err != nil {
+			return nil, err
+		}
+	}
+	return freezeSet(set), nil
+}
+
+// freezeSet returns a FrozenSet with the elements of set,
+// which must not be used again by the caller.
+func freezeSet(set *Set) *FrozenSet {
+	set.Freeze()
+	// The hash combines the hashes of the elements in a way that
+	// does not depend on their order. Each hash is scrambled first
+	// so that similar sets do not have similar hashes.
+	h := uint32(set.Len()) * 1927868237
+	for _, elem := range set.elems() {
+		eh, _ := elem.Hash() // can't fail: elements are hashable
+		h ^= (eh ^ (eh << 16) ^ 89869747) * 3644798167
+	}
+	return &FrozenSet{set: set, hash: h}
+}
+
+func (s *FrozenSet) Has(k Value) (found bool, err error) { return s.set.Has(k) }
+func (s *FrozenSet) Len() int                            { return s.set.Len() }
+func (s *FrozenSet) Iterate() Iterator                   { return s.set.Iterate() }
+func (s *FrozenSet) String() string                      { return toString(s) }
+func (s *FrozenSet) Type() string                        { return "frozenset" }
+func (s *FrozenSet) elems() []Value                      { return s.set.elems() }
+func (s *FrozenSet) table() *hashtable                   { return &s.set.ht }
+func (s *FrozenSet) Freeze()                             {} // immutable
+func (s *FrozenSet) Hash() (uint32, error)               { return s.hash, nil }
+func (s *FrozenSet) Truth() Bool                         { return s.Len() > 0 }
+
+func (s *FrozenSet) Attr(name string) (Value, error) { return builtinAttr(s, name, frozensetMethods) }
+func (s *FrozenSet) AttrNames() []string             { return builtinAttrNames(frozensetMethods) }
+
+func (x *FrozenSet) CompareSameType(op syntax.Token, y Value, depth int) (bool, error) {
+	return compareSets(op, x, y.(*FrozenSet), depth)
+}
+
+// Union returns a new frozenset containing the elements of s
+// followed by those of iter.
+func (s *FrozenSet) Union(iter Iterator) (Value, error) {
+	union, err := s.set.Union(iter)
+	if err != nil {
+		return nil, err
+	}
+	return freezeSet(union.(*Set)), nil
+}
+
+// A setValue is a *Set or *FrozenSet.
+type setValue interface {
+	Value
+	Len() int
+	Has(k Value) (bool, error)
+	elems() []Value
+	table() *hashtable
+}
+
+// compareSets compares two sets or frozensets for equality.
+func compareSets(op syntax.Token, x, y setValue, depth int) (bool, error) {
+	switch op {
+	case syntax.EQL:
+		return setsEqual(x, y, depth)
+	case syntax.NEQ:
+		eq, err := setsEqual(x, y, depth)
+		return !eq, err
+	default:
+		return false, fmt.Errorf("%s %s %s not implemented", x.Type(), op, y.Type())
+	}
+}
+
+// makeSetOp returns the result of a set operation whose left operand
+// is x, which is a frozenset if x is a frozenset.
+func makeSetOp(x setValue, result *Set) Value {
+	if _, ok := x.(*FrozenSet); ok {
+		return freezeSet(result)
+	}
+	return result
+}
+
 // toString returns the string form of value v.
 // It may be more efficient than v.String() for larger values.
 func toString(v Value) string {
@@ -1033,6 +1386,23 @@ func toString(v Value) string {
 // Callers should generally pass nil for path.
 // It is safe to re-use the same path slice for multiple calls.
 func writeValue(out *strings.Builder, x Value, path []Value) {
+	writeValueMode(out, x, path, "")
+}
+
+// Format returns the representation of x, as x.String() does, except
+// that floats, including those within other values, are formatted by
+// the fmt verb floatFormat, such as "%g" or "%.3f", which should be a
+// format accepted by Thread.SetFloatFormat. If floatFormat is empty,
+// Format is equivalent to x.String().
+func Format(x Value, floatFormat string) string {
+	buf := new(strings.Builder)
+	writeValueMode(buf, x, nil, floatFormat)
+	return buf.String()
+}
+
+// writeValueMode writes x to out, in the manner of writeValue.
+// Floats are formatted by the fmt verb floatFormat, if non-empty.
+func writeValueMode(out *strings.Builder, x Value, path []Value, floatFormat string) {
 	switch x := x.(type) {
 	case nil:
 		out.WriteString("<nil>") // indicates a bug
@@ -1043,6 +1413,13 @@ func writeValue(out *strings.Builder, x Value, path []Value) {
 	case Int:
 		out.WriteString(x.String())
 
+	case Float:
+		if floatFormat != "" {
+			fmt.Fprintf(out, floatFormat, float64(x))
+		} else {
+			out.WriteString(x.String())
+		}
+
 	case Bool:
 		if x {
 			out.WriteString("True")
@@ -1062,7 +1439,7 @@ func writeValue(out *strings.Builder, x Value, path []Value) {
 				if i > 0 {
 					out.WriteString(", ")
 				}
-				writeValue(out, elem, append(path, x))
+				writeValueMode(out, elem, append(path, x), floatFormat)
 			}
 		}
 		out.WriteByte(']')
@@ -1073,7 +1450,7 @@ func writeValue(out *strings.Builder, x Value, path []Value) {
 			if i > 0 {
 				out.WriteString(", ")
 			}
-			writeValue(out, elem, path)
+			writeValueMode(out, elem, path, floatFormat)
 		}
 		if len(x) == 1 {
 			out.WriteByte(',')
@@ -1099,21 +1476,22 @@ func writeValue(out *strings.Builder, x Value, path []Value) {
 			for _, item := range x.Items() {
 				k, v := item[0], item[1]
 				out.WriteString(sep)
-				writeValue(out, k, path)
+				writeValueMode(out, k, path, floatFormat)
 				out.WriteString(": ")
-				writeValue(out, v, append(path, x)) // cycle check
+				writeValueMode(out, v, append(path, x), floatFormat) // cycle check
 				sep = ", "
 			}
 		}
 		out.WriteByte('}')
 
-	case *Set:
-		out.WriteString("set([")
+	case setValue:
+		out.WriteString(x.Type())
+		out.WriteString("([")
 		for i, elem := range x.elems() {
 			if i > 0 {
 				out.WriteString(", ")
 			}
-			writeValue(out, elem, path)
+			writeValueMode(out, elem, path, floatFormat)
 		}
 		out.WriteString("])")
 
@@ -1160,6 +1538,23 @@ func Compare(op syntax.Token, x, y Value) (bool, error) {
 	return CompareDepth(op, x, y, maxdepth)
 }
 
+// Less reports whether x < y, according to the same rules as the
+// Starlark < operator, including those for comparing ints and floats.
+// It returns an error if the values are not ordered.
+// Less is convenient for sorting values in Go.
+func Less(x, y Value) (bool, error) {
+	return Compare(syntax.LT, x, y)
+}
+
+// ValuesEqual reports whether x == y, according to the same rules as
+// the Starlark == operator, so that for example 1 and 1.0 are equal.
+// Unlike Equal, it reports false instead of returning an error if the
+// comparison fails, for example because the values are cyclic.
+func ValuesEqual(x, y Value) bool {
+	eq, err := Equal(x, y)
+	return err == nil && eq
+}
+
 // CompareDepth compares two Starlark values.
 // The comparison operation must be one of EQL, NEQ, LT, LE, GT, or GE.
 // CompareDepth returns an error if an ordered comparison was
@@ -1188,7 +1583,14 @@ func CompareDepth(op syntax.Token, x, y Value, depth int) (bool, error) {
 
 	// different types
 
-	// int/float ordered comparisons
+	// set/frozenset equality
+	if x, ok := x.(setValue); ok {
+		if y, ok := y.(setValue); ok {
+			return compareSets(op, x, y, depth)
+		}
+	}
+
+	// int/float, int/decimal, and int/fixedint ordered comparisons
 	switch x := x.(type) {
 	case Int:
 		if y, ok := y.(Float); ok {
@@ -1205,6 +1607,20 @@ func CompareDepth(op syntax.Token, x, y Value, depth int) (bool, error) {
 			}
 			return threeway(op, cmp), nil
 		}
+		if y, ok := y.(Decimal); ok {
+			return threeway(op, x.rational().Cmp(y.rat)), nil
+		}
+		if y, ok := y.(FixedInt); ok {
+			return threeway(op, -y.cmp(x)), nil
+		}
+	case FixedInt:
+		if y, ok := y.(Int); ok {
+			return threeway(op, x.cmp(y)), nil
+		}
+	case Decimal:
+		if y, ok := y.(Int); ok {
+			return threeway(op, x.rat.Cmp(y.rational())), nil
+		}
 	case Float:
 		if y, ok := y.(Int); ok {
 			if x != x {
@@ -1214,9 +1630,9 @@ func CompareDepth(op syntax.Token, x, y Value, depth int) (bool, error) {
 			if !math.IsInf(float64(x), 0) {
 				cmp = x.rational().Cmp(y.rational()) // x is finite
 			} else if x > 0 {
-				cmp = -1 // x is +Inf
+				cmp = +1 // x is +Inf
 			} else {
-				cmp = +1 // x is -Inf
+				cmp = -1 // x is -Inf
 			}
 			return threeway(op, cmp), nil
 		}
@@ -1264,16 +1680,13 @@ func b2i(b bool) int {
 	}
 }
 
-// Len returns the length of a string or sequence value,
-// and -1 for all others.
+// Len returns the length of a value that implements HasLen, such as
+// a string or sequence, and -1 for all others.
 //
 // Warning: Len(x) >= 0 does not imply Iterate(x) != nil.
 // A string has a known length but is not directly iterable.
 func Len(x Value) int {
-	switch x := x.(type) {
-	case String:
-		return x.Len()
-	case Sequence:
+	if x, ok := x.(HasLen); ok {
 		return x.Len()
 	}
 	return -1
@@ -1290,3 +1703,183 @@ func Iterate(x Value) Iterator {
 	}
 	return nil
 }
+
+// IterateThread is like Iterate, but if x is a ThreadIterable, the
+// iterator calls any functions on thread. A nil thread is equivalent
+// to calling Iterate.
+func IterateThread(thread *Thread, x Value) Iterator {
+	if x, ok := x.(ThreadIterable); ok && thread != nil {
+		return x.IterateThread(thread)
+	}
+	return Iterate(x)
+}
+
+// maxFlattenDepth bounds the nesting depth of values accepted by Flatten.
+const maxFlattenDepth = 1000
+
+// Flatten returns the leaves of v, a possibly nested structure of lists
+// and tuples, in depth-first order. All other values, strings included,
+// are leaves; a non-sequence v yields a single-element result.
+//
+// Flatten fails if a list contains itself, directly or indirectly,
+// or if the structure is nested more deeply than maxFlattenDepth.
+func Flatten(v Value) ([]Value, error) {
+	var leaves []Value
+	if err := flatten(&leaves, v, nil); err != nil {
+		return nil, err
+	}
+	return leaves, nil
+}
+
+// flatten appends the leaves of x to *leaves.
+// path holds the enclosing lists and tuples, for cycle detection.
+func flatten(leaves *[]Value, x Value, path []Value) error {
+	var elems []Value
+	switch x := x.(type) {
+	case *List:
+		if pathContains(path, x) {
+			return fmt.Errorf("flatten: cycle in list")
+		}
+		elems = x.elems
+	case Tuple:
+		elems = x
+	default:
+		*leaves = append(*leaves, x)
+		return nil
+	}
+	if len(path) >= maxFlattenDepth {
+		return fmt.Errorf("flatten: nesting depth exceeds %d", maxFlattenDepth)
+	}
+	path = append(path, x)
+	for _, elem := range elems {
+		if err := flatten(leaves, elem, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Snapshot returns a deep copy of v, and a function that reports
+// whether v is still equal to the copy. Clients that cache a value
+// may use verify to detect whether it was subsequently modified.
+//
+// The copy is made as by DeepCopy, except that it is not frozen.
+// The verify function reports false if the comparison fails, as it
+// does for values that contain themselves.
+func Snapshot(v Value) (snapshot Value, verify func() bool, err error) {
+	snapshot, err = deepCopy(v, false, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	verify = func() bool {
+		eq, err := Equal(v, snapshot)
+		return err == nil && eq
+	}
+	return snapshot, verify, nil
+}
+
+// DeepCopy returns a frozen deep copy of v, leaving v unchanged.
+//
+// Lists, tuples, dicts, and sets are copied recursively, as are
+// values that implement HasDeepCopy, such as structs. A value that
+// contains itself yields a copy that contains itself. Dict keys and
+// set elements, which are hashable, are shared by v and the copy, as
+// are values of all other types, which are neither copied nor frozen.
+func DeepCopy(v Value) (Value, error) { return deepCopy(v, true, nil) }
+
+// deepCopy returns a deep copy of v, which is frozen if freeze is set.
+// If alloc is non-nil, it is called with the approximate size of each
+// list, tuple, dict, and set before it is copied, and may fail.
+func deepCopy(v Value, freeze bool, alloc func(n uint64) error) (Value, error) {
+	if alloc == nil {
+		alloc = func(uint64) error { return nil }
+	}
+	copies := make(map[Value]Value)
+	var copy func(x Value) (Value, error)
+	copy = func(x Value) (Value, error) {
+		switch x := x.(type) {
+		case *List:
+			if c, ok := copies[x]; ok {
+				return c, nil
+			}
+			if err := alloc(uint64(len(x.elems)) * valueSize); err != nil {
+				return nil, err
+			}
+			c := &List{elems: make([]Value, len(x.elems)), frozen: freeze}
+			copies[x] = c
+			for i, elem := range x.elems {
+				elem, err := copy(elem)
+				if err != nil {
+					return nil, err
+				}
+				c.elems[i] = elem
+			}
+			return c, nil
+		case Tuple:
+			if err := alloc(uint64(len(x)) * valueSize); err != nil {
+				return nil, err
+			}
+			c := make(Tuple, len(x))
+			for i, elem := range x {
+				elem, err := copy(elem)
+				if err != nil {
+					return nil, err
+				}
+				c[i] = elem
+			}
+			return c, nil
+		case *Dict:
+			if c, ok := copies[x]; ok {
+				return c, nil
+			}
+			if err := alloc(uint64(x.Len()) * dictEntrySize); err != nil {
+				return nil, err
+			}
+			c := NewDict(x.Len())
+			copies[x] = c
+			for _, item := range x.Items() {
+				v, err := copy(item[1])
+				if err != nil {
+					return nil, err
+				}
+				c.SetKey(item[0], v) // can't fail
+			}
+			c.ht.frozen = freeze
+			return c, nil
+		case *Set:
+			if c, ok := copies[x]; ok {
+				return c, nil
+			}
+			if err := alloc(uint64(x.Len()) * dictEntrySize); err != nil {
+				return nil, err
+			}
+			c := NewSet(x.Len())
+			copies[x] = c
+			for _, elem := range x.elems() {
+				c.Insert(elem) // can't fail: elements are immutable
+			}
+			c.ht.frozen = freeze
+			return c, nil
+		case HasDeepCopy:
+			comparable := reflect.TypeOf(x).Comparable()
+			if comparable {
+				if c, ok := copies[x]; ok {
+					return c, nil
+				}
+			}
+			c, err := x.DeepCopy(copy)
+			if err != nil {
+				return nil, err
+			}
+			if freeze {
+				c.Freeze()
+			}
+			if comparable {
+				copies[x] = c
+			}
+			return c, nil
+		}
+		return x, nil
+	}
+	return copy(v)
+}
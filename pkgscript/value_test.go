@@ -8,9 +8,12 @@ package pkgscript_test
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/andrewchambers/pkgscript/pkgscript"
+	"github.com/andrewchambers/pkgscript/pkgscriptstruct"
+	"github.com/andrewchambers/pkgscript/syntax"
 )
 
 func TestStringMethod(t *testing.T) {
@@ -44,3 +47,508 @@ func TestListAppend(t *testing.T) {
 		t.Errorf("failed list.Append() got: %+v, want: hello", res)
 	}
 }
+
+func TestFlatten(t *testing.T) {
+	one, two, three, four, five := pkgscript.MakeInt(1), pkgscript.MakeInt(2), pkgscript.MakeInt(3), pkgscript.MakeInt(4), pkgscript.MakeInt(5)
+	inner := pkgscript.Tuple{three, four}
+	nested := pkgscript.NewList([]pkgscript.Value{one, pkgscript.NewList([]pkgscript.Value{two, inner}), five, pkgscript.String("ab")})
+	got, err := pkgscript.Flatten(nested)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := pkgscript.Tuple(got).String(), `(1, 2, 3, 4, 5, "ab")`; got != want {
+		t.Errorf("Flatten: got %s, want %s", got, want)
+	}
+
+	cyclic := pkgscript.NewList([]pkgscript.Value{one})
+	cyclic.Append(pkgscript.Tuple{cyclic})
+	if _, err := pkgscript.Flatten(cyclic); err == nil {
+		t.Errorf("Flatten of cyclic list succeeded unexpectedly")
+	} else if got, want := err.Error(), "flatten: cycle in list"; got != want {
+		t.Errorf("Flatten of cyclic list: got error %q, want %q", got, want)
+	}
+}
+
+func TestFloatFormat(t *testing.T) {
+	for _, test := range []struct {
+		format string
+		f      float64
+		want   string
+	}{
+		{"", 1.0, "1.0"},
+		{"", 0.1, "0.1"},
+		{"", 1e100, "1e+100"},
+		{"%g", 1.0, "1"},
+		{"%.3f", 2.0 / 3, "0.667"},
+		{"%e", 1234.5, "1.234500e+03"},
+	} {
+		if got := pkgscript.Format(pkgscript.Float(test.f), test.format); got != test.want {
+			t.Errorf("Format(%v, %q) = %s, want %s", test.f, test.format, got, test.want)
+		}
+	}
+
+	// SetFloatFormat accepts only a single floating-point verb.
+	thread := new(pkgscript.Thread)
+	for _, format := range []string{"%d", "%v", "%.3f%%", "x=%g", "%"} {
+		if err := thread.SetFloatFormat(format); err == nil {
+			t.Errorf("SetFloatFormat(%q) succeeded unexpectedly", format)
+		}
+	}
+	for _, format := range []string{"", "%g", "%.3f", "%+08.2e"} {
+		if err := thread.SetFloatFormat(format); err != nil {
+			t.Errorf("SetFloatFormat(%q): %v", format, err)
+		}
+	}
+
+	// The thread's format applies to str, print, %s, and str.format,
+	// but not to repr, %r, {!r}, or the String method.
+	var printed string
+	thread = &pkgscript.Thread{
+		Print: func(_ *pkgscript.Thread, msg string) { printed = msg },
+	}
+	if err := thread.SetFloatFormat("%.2f"); err != nil {
+		t.Fatal(err)
+	}
+	env := pkgscript.StringDict{
+		"x": pkgscript.NewList([]pkgscript.Value{pkgscript.Float(0.125), pkgscript.String("a\nb")}),
+		"f": pkgscript.Float(0.125),
+		"h": pkgscript.Float(0.5),
+	}
+	for _, test := range []struct{ expr, want string }{
+		{`str(x)`, `"[0.12, \"a\\nb\"]"`},
+		{`str(h)`, `"0.50"`},
+		{`repr(x)`, `"[0.125, \"a\\nb\"]"`},
+		{`"%s" % f`, `"0.12"`},
+		{`"%s %r" % (x, f)`, `"[0.12, \"a\\nb\"] 0.125"`},
+		{`"{} {!s} {!r}".format(f, h, f)`, `"0.12 0.50 0.125"`},
+		{`"{v}".format_map({"v": h})`, `"0.50"`},
+	} {
+		v, err := pkgscript.Eval(thread, "<expr>", test.expr, env)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+		} else if got := v.String(); got != test.want {
+			t.Errorf("%s = %s, want %s", test.expr, got, test.want)
+		}
+	}
+	if _, err := pkgscript.Eval(thread, "<expr>", `print(x, h)`, env); err != nil {
+		t.Fatal(err)
+	}
+	if want := `[0.12, "a\nb"] 0.50`; printed != want {
+		t.Errorf("print(x, h) printed %s, want %s", printed, want)
+	}
+	if got, want := env["x"].String(), `[0.125, "a\nb"]`; got != want {
+		t.Errorf("String() = %s, want %s", got, want)
+	}
+	if got := thread.NewChild().FloatFormat(); got != "%.2f" {
+		t.Errorf("child FloatFormat() = %q, want %q", got, "%.2f")
+	}
+
+	// Binary has no thread, so its %s uses the default form.
+	z, err := pkgscript.Binary(syntax.PERCENT, pkgscript.String("%s"), pkgscript.Float(0.125))
+	if err != nil {
+		t.Fatal(err)
+	} else if got, want := z.String(), `"0.125"`; got != want {
+		t.Errorf("Binary(%%) = %s, want %s", got, want)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	inner := pkgscript.NewList([]pkgscript.Value{pkgscript.MakeInt(2)})
+	dict := pkgscript.NewDict(1)
+	dict.SetKey(pkgscript.String("k"), inner)
+	list := pkgscript.NewList([]pkgscript.Value{pkgscript.MakeInt(1), pkgscript.Tuple{inner}, dict})
+
+	snap, verify, err := pkgscript.Snapshot(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := snap.String(), list.String(); got != want {
+		t.Errorf("Snapshot = %s, want %s", got, want)
+	}
+	if !verify() {
+		t.Fatal("verify() = false before mutation")
+	}
+
+	// Mutate a nested element of the original.
+	if err := inner.Append(pkgscript.MakeInt(3)); err != nil {
+		t.Fatal(err)
+	}
+	if verify() {
+		t.Error("verify() = true after mutation")
+	}
+	if got, want := snap.String(), `[1, ([2],), {"k": [2]}]`; got != want {
+		t.Errorf("snapshot changed by mutation of original: got %s, want %s", got, want)
+	}
+
+	// Snapshots of self-referential values are still copies.
+	cyclic := pkgscript.NewList(nil)
+	cyclic.Append(cyclic)
+	snap, _, _ = pkgscript.Snapshot(cyclic)
+	if got, want := snap.String(), "[[...]]"; got != want {
+		t.Errorf("Snapshot(cyclic) = %s, want %s", got, want)
+	}
+
+	// Values that implement HasDeepCopy are copied by their own method.
+	s := pkgscriptstruct.FromStringDict(pkgscriptstruct.Default, pkgscript.StringDict{"x": inner})
+	snap, verify, err = pkgscript.Snapshot(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inner.Append(pkgscript.MakeInt(4)); err != nil {
+		t.Fatal(err)
+	}
+	if verify() {
+		t.Error("verify() = true after mutation of struct field")
+	}
+	if got, want := snap.String(), "struct(x = [2, 3])"; got != want {
+		t.Errorf("snapshot of struct changed by mutation of original: got %s, want %s", got, want)
+	}
+	// Unlike DeepCopy, Snapshot does not freeze the copy.
+	x, _ := snap.(*pkgscriptstruct.Struct).Attr("x")
+	if err := x.(*pkgscript.List).Append(pkgscript.None); err != nil {
+		t.Errorf("append to snapshot: %v", err)
+	}
+}
+
+// sized is a custom value with a length but no elements.
+type sized int
+
+func (n sized) String() string        { return "sized" }
+func (n sized) Type() string          { return "sized" }
+func (n sized) Freeze()               {}
+func (n sized) Truth() pkgscript.Bool { return n > 0 }
+func (n sized) Hash() (uint32, error) { return uint32(n), nil }
+func (n sized) Len() int              { return int(n) }
+
+func TestLenCustom(t *testing.T) {
+	predeclared := pkgscript.StringDict{"custom": sized(7)}
+	v, err := pkgscript.Eval(new(pkgscript.Thread), "<expr>", "len(custom) == 7", predeclared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != pkgscript.True {
+		t.Errorf("len(custom) == 7 is %s", v)
+	}
+	if got := pkgscript.Len(sized(7)); got != 7 {
+		t.Errorf("Len(sized(7)) = %d, want 7", got)
+	}
+}
+
+func TestUnpackStruct(t *testing.T) {
+	type dep struct {
+		Name    string `starlark:"name"`
+		Version []int  `starlark:"version,optional"`
+	}
+	type config struct {
+		Name    string            `starlark:"name"`
+		Jobs    int               `starlark:"jobs,optional"`
+		Ratio   float64           `starlark:"ratio"`
+		Deps    []dep             `starlark:"deps"`
+		Env     map[string]string `starlark:"env,optional"`
+		Extra   pkgscript.Value   `starlark:"extra"`
+		Ignored string            `starlark:"-"`
+	}
+
+	thread := new(pkgscript.Thread)
+	v, err := pkgscript.Eval(thread, "<expr>", `{
+		"name": "pkg",
+		"ratio": 1,
+		"deps": [{"name": "a", "version": (1, 2)}, {"name": "b"}],
+		"env": None,
+		"extra": [True],
+		"-": "x",
+	}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config{Jobs: 4, Ignored: "keep"}
+	if err := pkgscript.UnpackStruct(v, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(cfg), `{pkg 4 1 [{a [1 2]} {b []}] map[] [True] keep}`; got != want {
+		t.Errorf("UnpackStruct = %s, want %s", got, want)
+	}
+
+	for _, test := range []struct{ src, want string }{
+		{`{"ratio": 2, "deps": [], "extra": None}`,
+			`missing field name`},
+		{`{"name": 1, "ratio": 2, "deps": [], "extra": None}`,
+			`field name: got int, want string`},
+		{`{"name": "pkg", "ratio": 2, "deps": [{"name": "a", "version": [1, "2"]}], "extra": None}`,
+			`field deps[0].version[1]: got string, want int`},
+		{`{"name": "pkg", "jobs": 1 << 70, "ratio": 2, "deps": [], "extra": None}`,
+			`field jobs: 1180591620717411303424 out of range for int`},
+		{`{"name": "pkg", "ratio": 2, "deps": [], "env": {"k": 1}, "extra": None}`,
+			`field env["k"]: got int, want string`},
+		{`[]`,
+			`got list, want dict or struct`},
+	} {
+		v, err := pkgscript.Eval(thread, "<expr>", test.src, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cfg config
+		if err := pkgscript.UnpackStruct(v, &cfg); err == nil {
+			t.Errorf("UnpackStruct(%s) succeeded, want error %q", test.src, test.want)
+		} else if err.Error() != test.want {
+			t.Errorf("UnpackStruct(%s) error = %q, want %q", test.src, err, test.want)
+		}
+	}
+}
+
+func TestDeepCopy(t *testing.T) {
+	inner := pkgscript.NewList([]pkgscript.Value{pkgscript.MakeInt(1)})
+	dict := pkgscript.NewDict(1)
+	dict.SetKey(pkgscript.String("k"), pkgscript.Tuple{inner})
+
+	copy, err := pkgscript.DeepCopy(dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inner.Append(pkgscript.MakeInt(2)); err != nil {
+		t.Fatalf("original was frozen: %v", err)
+	}
+	if got, want := copy.String(), `{"k": ([1],)}`; got != want {
+		t.Errorf("DeepCopy = %s, want %s", got, want)
+	}
+	if err := copy.(*pkgscript.Dict).SetKey(pkgscript.String("k"), pkgscript.None); err == nil {
+		t.Error("copy is not frozen")
+	}
+}
+
+func TestCompareMixed(t *testing.T) {
+	one, onehalf := pkgscript.MakeInt(1), pkgscript.Float(1.5)
+	if lt, err := pkgscript.Compare(syntax.LT, one, onehalf); err != nil || !lt {
+		t.Errorf("Compare(<, 1, 1.5) = %t, %v", lt, err)
+	}
+	if lt, err := pkgscript.Less(onehalf, one); err != nil || lt {
+		t.Errorf("Less(1.5, 1) = %t, %v", lt, err)
+	}
+	if _, err := pkgscript.Less(one, pkgscript.String("1")); err == nil {
+		t.Errorf("Less(1, \"1\") succeeded, want error")
+	}
+	if !pkgscript.ValuesEqual(one, pkgscript.Float(1.0)) {
+		t.Errorf("ValuesEqual(1, 1.0) = false")
+	}
+	if pkgscript.ValuesEqual(one, pkgscript.String("1")) {
+		t.Errorf("ValuesEqual(1, \"1\") = true")
+	}
+}
+
+func TestDictItemsKeysValues(t *testing.T) {
+	thread := new(pkgscript.Thread)
+	globals, err := pkgscript.ExecFile(thread, "config.star", `
+config = {"name": "pkg", "version": 3}
+config["deps"] = ["a"]
+config["name"] = "renamed" # update does not change the order
+`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := globals["config"].(*pkgscript.Dict)
+	for i := 0; i < 2; i++ { // results are stable across calls
+		var got []string
+		for _, item := range config.Items() {
+			got = append(got, fmt.Sprintf("%s=%s", item[0], item[1]))
+		}
+		if got, want := fmt.Sprint(got), `["name"="renamed" "version"=3 "deps"=["a"]]`; got != want {
+			t.Errorf("Items() = %s, want %s", got, want)
+		}
+		if got, want := fmt.Sprint(config.Keys()), `["name" "version" "deps"]`; got != want {
+			t.Errorf("Keys() = %s, want %s", got, want)
+		}
+		if got, want := fmt.Sprint(config.Values()), `["renamed" 3 ["a"]]`; got != want {
+			t.Errorf("Values() = %s, want %s", got, want)
+		}
+	}
+}
+
+func TestSetOrder(t *testing.T) {
+	set := new(pkgscript.Set)
+	for _, x := range []int{3, 1, 4, 5, 9, 2} {
+		if err := set.Insert(pkgscript.MakeInt(x)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, x := range []int{4, 9, 7} {
+		if _, err := set.Delete(pkgscript.MakeInt(x)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := set.String(), "set([3, 1, 5, 2])"; got != want {
+		t.Errorf("after deletions, set = %s, want %s", got, want)
+	}
+
+	// Reinsertion of an existing element does not move it;
+	// reinsertion of a deleted element appends it.
+	set.Insert(pkgscript.MakeInt(1))
+	set.Insert(pkgscript.MakeInt(4))
+	var got []string
+	iter := set.Iterate()
+	defer iter.Done()
+	var x pkgscript.Value
+	for iter.Next(&x) {
+		got = append(got, x.String())
+	}
+	if got, want := strings.Join(got, " "), "3 1 5 2 4"; got != want {
+		t.Errorf("iteration yielded %s, want %s", got, want)
+	}
+}
+
+func TestFrozenSetHash(t *testing.T) {
+	x, err := pkgscript.NewFrozenSet([]pkgscript.Value{pkgscript.MakeInt(1), pkgscript.String("a"), pkgscript.MakeInt(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	y, err := pkgscript.NewFrozenSet([]pkgscript.Value{pkgscript.String("a"), pkgscript.MakeInt(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.Len() != 2 {
+		t.Errorf("x.Len() = %d, want 2", x.Len())
+	}
+	if eq, err := pkgscript.Equal(x, y); err != nil || !eq {
+		t.Errorf("%v == %v: got %t, %v", x, y, eq, err)
+	}
+	hx, _ := x.Hash()
+	hy, _ := y.Hash()
+	if hx != hy {
+		t.Errorf("equal frozensets have different hashes: %v, %v", hx, hy)
+	}
+
+	if _, err := pkgscript.NewFrozenSet([]pkgscript.Value{pkgscript.NewList(nil)}); err == nil {
+		t.Error("NewFrozenSet with unhashable element succeeded")
+	}
+}
+
+// TestStableHash checks that StableHash yields the same values in every
+// execution, unlike the hash built-in, which reports Value.Hash.
+func TestStableHash(t *testing.T) {
+	long := pkgscript.String("abcdefghijklmnop")
+	set, err := pkgscript.NewFrozenSet([]pkgscript.Value{long, pkgscript.MakeInt(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := pkgscriptstruct.FromStringDict(pkgscriptstruct.Default, pkgscript.StringDict{
+		"a": pkgscript.String("a long string value"),
+	})
+	for _, test := range []struct {
+		x    pkgscript.Value
+		want uint32
+	}{
+		{pkgscript.String(""), 0},
+		{pkgscript.String("hello"), 99162322}, // like java.lang.String
+		{pkgscript.String("Hello, 世界!"), 417292677},
+		{pkgscript.Tuple{long}, 1436185440},
+		{pkgscript.Tuple{pkgscript.String("a"), pkgscript.MakeInt(1), pkgscript.None}, 3786805647},
+		{set, 2985263318},
+		{pkgscript.Universe["len"], 107029},
+		{st, 2833479793},
+	} {
+		got, err := pkgscript.StableHash(test.x)
+		if err != nil {
+			t.Errorf("StableHash(%v): %v", test.x, err)
+		} else if got != test.want {
+			t.Errorf("StableHash(%v) = %d, want %d", test.x, got, test.want)
+		}
+	}
+
+	for _, x := range []pkgscript.Value{long, pkgscript.MakeInt(-1), st} {
+		got, err := pkgscript.Call(new(pkgscript.Thread), pkgscript.Universe["hash"], pkgscript.Tuple{x}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, _ := x.Hash()
+		if want := pkgscript.MakeUint64(uint64(h)); got.String() != want.String() {
+			t.Errorf("hash(%v) = %v, want %v", x, got, want)
+		}
+	}
+}
+
+// A table is a read-only Mapping backed by a Go map, like a database
+// table; it records the calls of its methods.
+type table struct {
+	rows  map[string]int
+	calls []string
+}
+
+var (
+	_ pkgscript.Mapping = (*table)(nil)
+	_ pkgscript.HasLen  = (*table)(nil)
+)
+
+func (tab *table) String() string        { return "table" }
+func (tab *table) Type() string          { return "table" }
+func (tab *table) Freeze()               {}
+func (tab *table) Truth() pkgscript.Bool { return len(tab.rows) > 0 }
+func (tab *table) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable: table") }
+func (tab *table) Len() int {
+	tab.calls = append(tab.calls, "Len")
+	return len(tab.rows)
+}
+func (tab *table) Get(k pkgscript.Value) (pkgscript.Value, bool, error) {
+	tab.calls = append(tab.calls, fmt.Sprintf("Get(%s)", k))
+	key, ok := pkgscript.AsString(k)
+	if !ok {
+		return nil, false, fmt.Errorf("table key: got %s, want string", k.Type())
+	}
+	v, ok := tab.rows[key]
+	if !ok {
+		return nil, false, nil
+	}
+	return pkgscript.MakeInt(v), true, nil
+}
+
+func TestCustomMapping(t *testing.T) {
+	tab := &table{rows: map[string]int{"a": 1, "b": 2}}
+	predeclared := pkgscript.StringDict{"tab": tab}
+	for _, test := range []struct {
+		src, want string
+		calls     string
+	}{
+		{`len(tab)`, "2", "[Len]"},
+		{`"a" in tab`, "True", `[Get("a")]`},
+		{`"z" not in tab`, "True", `[Get("z")]`},
+		{`tab["b"]`, "2", `[Get("b")]`},
+		{`tab["z"]`, "key \"z\" not in table", `[Get("z")]`},
+		{`tab[1]`, "table key: got int, want string", `[Get(1)]`},
+	} {
+		tab.calls = nil
+		v, err := pkgscript.Eval(new(pkgscript.Thread), "<expr>", test.src, predeclared)
+		var got string
+		if err != nil {
+			got = err.(*pkgscript.EvalError).Msg
+		} else {
+			got = v.String()
+		}
+		if got != test.want {
+			t.Errorf("%s = %s, want %s", test.src, got, test.want)
+		}
+		if calls := fmt.Sprint(tab.calls); calls != test.calls {
+			t.Errorf("%s called %s, want %s", test.src, calls, test.calls)
+		}
+	}
+}
+
+func TestNewDictFromItems(t *testing.T) {
+	dict, err := pkgscript.NewDictFromItems([]pkgscript.Tuple{
+		{pkgscript.String("b"), pkgscript.MakeInt(1)},
+		{pkgscript.String("a"), pkgscript.MakeInt(2)},
+		{pkgscript.String("b"), pkgscript.MakeInt(3)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dict.String(), `{"b": 3, "a": 2}`; got != want {
+		t.Errorf("NewDictFromItems: got %s, want %s", got, want)
+	}
+
+	_, err = pkgscript.NewDictFromItems([]pkgscript.Tuple{{pkgscript.NewList(nil), pkgscript.None}})
+	if err == nil || !strings.Contains(err.Error(), "unhashable") {
+		t.Errorf("NewDictFromItems with unhashable key: got %v, want unhashable error", err)
+	}
+	_, err = pkgscript.NewDictFromItems([]pkgscript.Tuple{{pkgscript.None}})
+	if err == nil || err.Error() != "dictionary item #0 has length 1, want 2" {
+		t.Errorf("NewDictFromItems with bad item: got %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pkgscriptcsv defines the 'csv' module of functions for
+// reading and writing comma-separated values, an optional language
+// extension.
+//
+// The format is that of RFC 4180, as implemented by Go's encoding/csv
+// package: a field that contains the delimiter, a quotation mark, or
+// a newline is enclosed in quotation marks, and a quotation mark
+// within such a field is doubled.
+//
+package pkgscriptcsv // import "github.com/andrewchambers/pkgscript/pkgscriptcsv"
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/andrewchambers/pkgscript/pkgscript"
+	"github.com/andrewchambers/pkgscript/pkgscriptstruct"
+)
+
+// Module is the 'csv' module. An application may make it available
+// to Starlark programs by adding it to the predeclared environment or by
+// returning it from its load function.
+//
+//   csv.read(s, delimiter=",", header=False)
+//                                            -- the records of s, as lists of strings,
+//                                               or as dicts keyed by the first record if header
+//   csv.write(rows, delimiter=",", crlf=False)
+//                                            -- the text of the records in rows
+//
+// Each row passed to write is a list or tuple of fields, or a dict,
+// in which case the keys of the first row are written as a header
+// and determine the order of the fields of every row. A string field
+// is written verbatim, None as an empty field, and any other value
+// as if by str. The lines written are terminated by "\r\n" if crlf,
+// and by "\n" otherwise.
+//
+var Module = &pkgscriptstruct.Module{
+	Name: "csv",
+	Members: pkgscript.StringDict{
+		"read":  pkgscript.NewBuiltin("csv.read", read),
+		"write": pkgscript.NewBuiltin("csv.write", write),
+	},
+}
+
+// delimiter returns the rune of a delimiter argument.
+func delimiter(b *pkgscript.Builtin, s string) (rune, error) {
+	r, size := utf8.DecodeRuneInString(s)
+	if size == 0 || size != len(s) || r == utf8.RuneError {
+		return 0, fmt.Errorf("%s: delimiter must be a single character, got %q", b.Name(), s)
+	}
+	if r == '"' || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("%s: invalid delimiter %q", b.Name(), s)
+	}
+	return r, nil
+}
+
+// csv.read(s, delimiter=",", header=False)
+func read(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var s string
+	delim := ","
+	var header bool
+	if err := pkgscript.UnpackArgs(b.Name(), args, kwargs, "s", &s, "delimiter?", &delim, "header?", &header); err != nil {
+		return nil, err
+	}
+	comma, err := delimiter(b, delim)
+	if err != nil {
+		return nil, err
+	}
+
+	r := csv.NewReader(strings.NewReader(s))
+	r.Comma = comma
+	if !header {
+		r.FieldsPerRecord = -1 // records may have different lengths
+	}
+	var keys []pkgscript.Value // elements of the header record
+	var rows []pkgscript.Value
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %v", b.Name(), err)
+		}
+		fields := make([]pkgscript.Value, len(record))
+		for i, field := range record {
+			fields[i] = pkgscript.String(field)
+		}
+		switch {
+		case !header:
+			rows = append(rows, pkgscript.NewList(fields))
+		case keys == nil:
+			keys = fields
+		default:
+			row := pkgscript.NewDict(len(fields))
+			for i, field := range fields {
+				row.SetKey(keys[i], field) // can't fail: strings are hashable
+			}
+			rows = append(rows, row)
+		}
+	}
+	return pkgscript.NewList(rows), nil
+}
+
+// csv.write(rows, delimiter=",", crlf=False)
+func write(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var rows pkgscript.Iterable
+	delim := ","
+	var crlf bool
+	if err := pkgscript.UnpackArgs(b.Name(), args, kwargs, "rows", &rows, "delimiter?", &delim, "crlf?", &crlf); err != nil {
+		return nil, err
+	}
+	comma, err := delimiter(b, delim)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf strings.Builder
+	w := csv.NewWriter(&buf)
+	w.Comma = comma
+	w.UseCRLF = crlf
+
+	var keys []pkgscript.Value // header of dict rows
+	iter := pkgscript.IterateThread(thread, rows)
+	defer iter.Done()
+	var row pkgscript.Value
+	for i := 0; iter.Next(&row); i++ {
+		var fields []pkgscript.Value
+		switch row := row.(type) {
+		case *pkgscript.Dict:
+			if i == 0 {
+				keys = row.Keys()
+				if err := writeRecord(w, keys); err != nil {
+					return nil, fmt.Errorf("%s: %v", b.Name(), err)
+				}
+			} else if keys == nil {
+				return nil, fmt.Errorf("%s: row %d: got dict, want list or tuple", b.Name(), i)
+			}
+			fields = make([]pkgscript.Value, len(keys))
+			for j, k := range keys {
+				v, found, _ := row.Get(k)
+				if !found {
+					return nil, fmt.Errorf("%s: row %d: no field %s", b.Name(), i, k)
+				}
+				fields[j] = v
+			}
+			if row.Len() != len(keys) {
+				return nil, fmt.Errorf("%s: row %d: has %d fields, want %d", b.Name(), i, row.Len(), len(keys))
+			}
+		case *pkgscript.List, pkgscript.Tuple:
+			if keys != nil {
+				return nil, fmt.Errorf("%s: row %d: got %s, want dict", b.Name(), i, row.Type())
+			}
+			fields = make([]pkgscript.Value, 0, pkgscript.Len(row))
+			fiter := pkgscript.Iterate(row)
+			var field pkgscript.Value
+			for fiter.Next(&field) {
+				fields = append(fields, field)
+			}
+			fiter.Done()
+		default:
+			return nil, fmt.Errorf("%s: row %d: got %s, want list, tuple, or dict", b.Name(), i, row.Type())
+		}
+		if err := writeRecord(w, fields); err != nil {
+			return nil, fmt.Errorf("%s: %v", b.Name(), err)
+		}
+	}
+	if err := pkgscript.IterErr(iter); err != nil {
+		return nil, err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	return pkgscript.String(buf.String()), nil
+}
+
+// writeRecord writes a record whose fields are the
+// string forms of the specified values.
+func writeRecord(w *csv.Writer, fields []pkgscript.Value) error {
+	record := make([]string, len(fields))
+	for i, field := range fields {
+		switch field := field.(type) {
+		case pkgscript.NoneType:
+			// empty
+		case pkgscript.String:
+			record[i] = string(field)
+		default:
+			record[i] = field.String()
+		}
+	}
+	return w.Write(record)
+}
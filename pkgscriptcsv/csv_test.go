@@ -0,0 +1,46 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkgscriptcsv_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/andrewchambers/pkgscript/pkgscript"
+	"github.com/andrewchambers/pkgscript/pkgscriptcsv"
+	"github.com/andrewchambers/pkgscript/pkgscripttest"
+	"github.com/andrewchambers/pkgscript/resolve"
+)
+
+func init() {
+	resolve.AllowLambda = true
+	resolve.AllowFloat = true
+	resolve.AllowSet = true
+}
+
+func Test(t *testing.T) {
+	testdata := pkgscripttest.DataFile("pkgscriptcsv", ".")
+	thread := &pkgscript.Thread{Load: load}
+	pkgscripttest.SetReporter(thread, t)
+	filename := filepath.Join(testdata, "testdata/csv.star")
+	predeclared := pkgscript.StringDict{
+		"csv": pkgscriptcsv.Module,
+	}
+	if _, err := pkgscript.ExecFile(thread, filename, nil, predeclared); err != nil {
+		if err, ok := err.(*pkgscript.EvalError); ok {
+			t.Fatal(err.Backtrace())
+		}
+		t.Fatal(err)
+	}
+}
+
+// load implements the 'load' operation as used in the evaluator tests.
+func load(thread *pkgscript.Thread, module pkgscript.Value) (pkgscript.StringDict, error) {
+	if module == pkgscript.String("assert.star") {
+		return pkgscripttest.LoadAssertModule()
+	}
+	return nil, fmt.Errorf("load not implemented")
+}
@@ -0,0 +1,351 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pkgscriptdata defines a compact binary encoding of
+// Starlark data values, for clients that cache or transmit the
+// results of Starlark programs.
+//
+// The encodable values are None, bools, ints, floats, strings, and
+// lists, tuples, dicts, sets, and frozensets of encodable values.
+// Functions and other values that are not plain data cannot be encoded.
+//
+// The encoding is self-describing, and deterministic: a value, or an
+// equal value built by the same sequence of operations, always has the
+// same encoding. Dicts and sets are encoded in iteration order, which
+// is preserved by decoding. Each encoding begins with a header that
+// identifies the format and its version.
+//
+package pkgscriptdata // import "github.com/andrewchambers/pkgscript/pkgscriptdata"
+
+// Encoding:
+//
+//   data    = magic version value
+//   magic   = "PSD"
+//   version = byte(1)
+//   value   = 'N'                        None
+//           | 'T' | 'F'                  True, False
+//           | 'i' varint                 int in the range of int64
+//           | 'I' sign uvarint bytes     big int: sign byte ('+' or '-'),
+//                                        big-endian magnitude
+//           | 'f' uint64                 float: IEEE 754 bits, big-endian
+//           | 's' uvarint bytes          string
+//           | 'l' uvarint value*         list
+//           | 't' uvarint value*         tuple
+//           | 'd' uvarint (value value)* dict
+//           | 'S' uvarint value*         set
+//           | 'z' uvarint value*         frozenset
+//
+// varint and uvarint are as defined by encoding/binary;
+// the uvarint before a sequence is its length.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/andrewchambers/pkgscript/pkgscript"
+)
+
+const (
+	magic   = "PSD"
+	version = 1
+)
+
+// maxDepth bounds the nesting of encoded and decoded values.
+const maxDepth = 1000
+
+// Encode returns the binary encoding of the data value v.
+// It fails if v is or contains a value that is not data,
+// or if v contains itself.
+func Encode(v pkgscript.Value) ([]byte, error) {
+	e := encoder{buf: bytes.NewBufferString(magic)}
+	e.buf.WriteByte(version)
+	if err := e.value(v, 0); err != nil {
+		return nil, fmt.Errorf("pkgscriptdata: %v", err)
+	}
+	return e.buf.Bytes(), nil
+}
+
+type encoder struct {
+	buf     *bytes.Buffer
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func (e *encoder) uvarint(x uint64) {
+	e.buf.Write(e.scratch[:binary.PutUvarint(e.scratch[:], x)])
+}
+
+func (e *encoder) value(v pkgscript.Value, depth int) error {
+	if depth > maxDepth {
+		return fmt.Errorf("value nested too deeply (possibly cyclic)")
+	}
+	switch v := v.(type) {
+	case pkgscript.NoneType:
+		e.buf.WriteByte('N')
+
+	case pkgscript.Bool:
+		if v {
+			e.buf.WriteByte('T')
+		} else {
+			e.buf.WriteByte('F')
+		}
+
+	case pkgscript.Int:
+		if i, ok := v.Int64(); ok {
+			e.buf.WriteByte('i')
+			e.buf.Write(e.scratch[:binary.PutVarint(e.scratch[:], i)])
+		} else {
+			b := v.BigInt()
+			e.buf.WriteByte('I')
+			if b.Sign() < 0 {
+				e.buf.WriteByte('-')
+			} else {
+				e.buf.WriteByte('+')
+			}
+			mag := b.Bytes()
+			e.uvarint(uint64(len(mag)))
+			e.buf.Write(mag)
+		}
+
+	case pkgscript.Float:
+		e.buf.WriteByte('f')
+		binary.BigEndian.PutUint64(e.scratch[:8], math.Float64bits(float64(v)))
+		e.buf.Write(e.scratch[:8])
+
+	case pkgscript.String:
+		e.buf.WriteByte('s')
+		e.uvarint(uint64(len(v)))
+		e.buf.WriteString(string(v))
+
+	case *pkgscript.List:
+		e.buf.WriteByte('l')
+		return e.elems(v, depth)
+
+	case pkgscript.Tuple:
+		e.buf.WriteByte('t')
+		return e.elems(v, depth)
+
+	case *pkgscript.Set:
+		e.buf.WriteByte('S')
+		return e.elems(v, depth)
+
+	case *pkgscript.FrozenSet:
+		e.buf.WriteByte('z')
+		return e.elems(v, depth)
+
+	case *pkgscript.Dict:
+		e.buf.WriteByte('d')
+		items := v.Items()
+		e.uvarint(uint64(len(items)))
+		for _, item := range items {
+			if err := e.value(item[0], depth+1); err != nil {
+				return err
+			}
+			if err := e.value(item[1], depth+1); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return fmt.Errorf("cannot encode %s", v.Type())
+	}
+	return nil
+}
+
+// elems encodes the length and elements of a list, tuple, set, or frozenset.
+func (e *encoder) elems(seq interface {
+	pkgscript.Iterable
+	Len() int
+}, depth int) error {
+	e.uvarint(uint64(seq.Len()))
+	iter := seq.Iterate()
+	defer iter.Done()
+	var elem pkgscript.Value
+	for iter.Next(&elem) {
+		if err := e.value(elem, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Decode returns the value whose binary encoding is data.
+// The result is not frozen.
+func Decode(data []byte) (pkgscript.Value, error) {
+	if len(data) < len(magic)+1 || string(data[:len(magic)]) != magic {
+		return nil, fmt.Errorf("pkgscriptdata: not an encoded value")
+	}
+	if v := data[len(magic)]; v != version {
+		return nil, fmt.Errorf("pkgscriptdata: unsupported version %d", v)
+	}
+	d := decoder{data: data[len(magic)+1:]}
+	v, err := d.value(0)
+	if err == nil && len(d.data) > 0 {
+		err = fmt.Errorf("%d bytes of trailing data", len(d.data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pkgscriptdata: %v", err)
+	}
+	return v, nil
+}
+
+type decoder struct {
+	data []byte // unread portion of input
+}
+
+var errTruncated = fmt.Errorf("truncated data")
+
+func (d *decoder) byte() (byte, error) {
+	if len(d.data) == 0 {
+		return 0, errTruncated
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b, nil
+}
+
+func (d *decoder) bytes(n uint64) ([]byte, error) {
+	if uint64(len(d.data)) < n {
+		return nil, errTruncated
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b, nil
+}
+
+func (d *decoder) uvarint() (uint64, error) {
+	x, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	d.data = d.data[n:]
+	return x, nil
+}
+
+// length decodes the length of a sequence. Each element occupies
+// at least one byte, so a length exceeding the remaining data is invalid.
+func (d *decoder) length() (int, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(d.data)) {
+		return 0, errTruncated
+	}
+	return int(n), nil
+}
+
+func (d *decoder) value(depth int) (pkgscript.Value, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("value nested too deeply")
+	}
+	tag, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	case 'N':
+		return pkgscript.None, nil
+
+	case 'T':
+		return pkgscript.True, nil
+
+	case 'F':
+		return pkgscript.False, nil
+
+	case 'i':
+		x, n := binary.Varint(d.data)
+		if n <= 0 {
+			return nil, errTruncated
+		}
+		d.data = d.data[n:]
+		return pkgscript.MakeInt64(x), nil
+
+	case 'I':
+		sign, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		n, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		mag, _ := d.bytes(uint64(n))
+		b := new(big.Int).SetBytes(mag)
+		switch sign {
+		case '-':
+			b.Neg(b)
+		case '+':
+		default:
+			return nil, fmt.Errorf("invalid sign %q of big int", sign)
+		}
+		return pkgscript.MakeBigInt(b), nil
+
+	case 'f':
+		b, err := d.bytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return pkgscript.Float(math.Float64frombits(binary.BigEndian.Uint64(b))), nil
+
+	case 's':
+		n, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		b, _ := d.bytes(uint64(n))
+		return pkgscript.String(b), nil
+
+	case 'l', 't', 'S', 'z':
+		n, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		elems := make([]pkgscript.Value, n)
+		for i := range elems {
+			if elems[i], err = d.value(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		switch tag {
+		case 'l':
+			return pkgscript.NewList(elems), nil
+		case 't':
+			return pkgscript.Tuple(elems), nil
+		case 'z':
+			return pkgscript.NewFrozenSet(elems)
+		}
+		set := pkgscript.NewSet(n)
+		for _, elem := range elems {
+			if err := set.Insert(elem); err != nil {
+				return nil, err
+			}
+		}
+		return set, nil
+
+	case 'd':
+		n, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		dict := pkgscript.NewDict(n)
+		for i := 0; i < n; i++ {
+			k, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if err := dict.SetKey(k, v); err != nil {
+				return nil, err
+			}
+		}
+		return dict, nil
+	}
+	return nil, fmt.Errorf("invalid tag %q", tag)
+}
@@ -0,0 +1,138 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkgscriptdata_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/andrewchambers/pkgscript/pkgscript"
+	"github.com/andrewchambers/pkgscript/pkgscriptdata"
+	"github.com/andrewchambers/pkgscript/resolve"
+)
+
+func init() {
+	resolve.AllowFloat = true
+	resolve.AllowSet = true
+	resolve.AllowLambda = true
+}
+
+// eval evaluates a Starlark expression.
+func eval(t *testing.T, expr string) pkgscript.Value {
+	thread := new(pkgscript.Thread)
+	v, err := pkgscript.Eval(thread, "<expr>", expr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, expr := range []string{
+		`None`,
+		`True`,
+		`False`,
+		`0`,
+		`-123456789`,
+		`1 << 63`,
+		`-(1 << 100)`,
+		`1.5`,
+		`float("-inf")`,
+		`""`,
+		`"hello, 世界"`,
+		`[]`,
+		`()`,
+		`{}`,
+		`set([1, "a"])`,
+		`frozenset()`,
+		`frozenset([3, 1, 2])`,
+		`{frozenset(["x"]): [frozenset([(1, 2), frozenset([None])])]}`,
+		`{
+			"name": "pkg",
+			"version": (1, 2, 3),
+			"deps": [{"name": "a", "big": 1 << 70}, {"name": "b", "ratio": 0.25}],
+			"tags": set(["x", "y"]),
+			(1, "k"): None,
+			False: [[], [[True]]],
+		}`,
+	} {
+		v := eval(t, expr)
+		data, err := pkgscriptdata.Encode(v)
+		if err != nil {
+			t.Errorf("Encode(%s) failed: %v", v, err)
+			continue
+		}
+		got, err := pkgscriptdata.Decode(data)
+		if err != nil {
+			t.Errorf("Decode(Encode(%s)) failed: %v", v, err)
+			continue
+		}
+		if got.Type() != v.Type() || got.String() != v.String() {
+			t.Errorf("Decode(Encode(%s)) = %s", v, got)
+		}
+		if eq, err := pkgscript.Equal(got, v); err != nil || !eq {
+			t.Errorf("Decode(Encode(%s)) = %s, not equal", v, got)
+		}
+
+		// The encoding is deterministic.
+		again, err := pkgscriptdata.Encode(got)
+		if err != nil || !bytes.Equal(again, data) {
+			t.Errorf("encoding of %s is not stable", v)
+		}
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	for _, test := range []struct {
+		expr, want string
+	}{
+		{`lambda: None`, "pkgscriptdata: cannot encode function"},
+		{`[1, {"f": len}]`, "pkgscriptdata: cannot encode builtin_function_or_method"},
+		{`range(3)`, "pkgscriptdata: cannot encode range"},
+	} {
+		_, err := pkgscriptdata.Encode(eval(t, test.expr))
+		if err == nil {
+			t.Errorf("Encode(%s) succeeded, want error", test.expr)
+		} else if err.Error() != test.want {
+			t.Errorf("Encode(%s) error = %q, want %q", test.expr, err, test.want)
+		}
+	}
+
+	// A list that contains itself cannot be encoded.
+	cyclic := pkgscript.NewList(nil)
+	cyclic.Append(cyclic)
+	if _, err := pkgscriptdata.Encode(cyclic); err == nil || !strings.Contains(err.Error(), "nested too deeply") {
+		t.Errorf("Encode(cyclic) error = %v", err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	valid, err := pkgscriptdata.Encode(eval(t, `{"a": [1, "b"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		data, want string
+	}{
+		{"", "pkgscriptdata: not an encoded value"},
+		{"XYZ\x01N", "pkgscriptdata: not an encoded value"},
+		{"PSD\x02N", "pkgscriptdata: unsupported version 2"},
+		{"PSD\x01", "pkgscriptdata: truncated data"},
+		{"PSD\x01NN", "pkgscriptdata: 1 bytes of trailing data"},
+		{"PSD\x01?", `pkgscriptdata: invalid tag '?'`},
+		{"PSD\x01s\x05abc", "pkgscriptdata: truncated data"},
+		{"PSD\x01d\x01l\x00N", "pkgscriptdata: unhashable type: list"},
+		{"PSD\x01z\x01l\x00", "pkgscriptdata: unhashable type: list"},
+		{string(valid[:len(valid)-1]), "pkgscriptdata: truncated data"},
+	} {
+		_, err := pkgscriptdata.Decode([]byte(test.data))
+		if err == nil {
+			t.Errorf("Decode(%q) succeeded, want error", test.data)
+		} else if err.Error() != test.want {
+			t.Errorf("Decode(%q) error = %q, want %q", test.data, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pkgscriptencoding defines the 'base64' and 'hex' modules of
+// functions for encoding binary data as text, an optional language
+// extension.
+//
+// Because Starlark strings are byte strings, binary data, such as the
+// result of decoding, is represented as a string.
+//
+package pkgscriptencoding // import "github.com/andrewchambers/pkgscript/pkgscriptencoding"
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/andrewchambers/pkgscript/pkgscript"
+	"github.com/andrewchambers/pkgscript/pkgscriptstruct"
+)
+
+// Base64 is the 'base64' module. An application may make it available
+// to Starlark programs by adding it to the predeclared environment or by
+// returning it from its load function.
+//
+//   base64.encode(s, url=False)  -- the padded base64 encoding of the bytes of s
+//   base64.decode(s, url=False)  -- the bytes encoded by s, which must be padded
+//
+// If url, the functions use the alternative alphabet of RFC 4648 for
+// URLs and file names, in which '-' and '_' replace '+' and '/'.
+//
+var Base64 = &pkgscriptstruct.Module{
+	Name: "base64",
+	Members: pkgscript.StringDict{
+		"decode": pkgscript.NewBuiltin("base64.decode", base64Decode),
+		"encode": pkgscript.NewBuiltin("base64.encode", base64Encode),
+	},
+}
+
+// Hex is the 'hex' module. An application may make it available
+// to Starlark programs by adding it to the predeclared environment or by
+// returning it from its load function.
+//
+//   hex.encode(s)  -- the lowercase hexadecimal encoding of the bytes of s
+//   hex.decode(s)  -- the bytes encoded by s, in either case
+//
+var Hex = &pkgscriptstruct.Module{
+	Name: "hex",
+	Members: pkgscript.StringDict{
+		"decode": pkgscript.NewBuiltin("hex.decode", hexDecode),
+		"encode": pkgscript.NewBuiltin("hex.encode", hexEncode),
+	},
+}
+
+// base64.encode(s, url=False)
+func base64Encode(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var s string
+	var url bool
+	if err := pkgscript.UnpackArgs(b.Name(), args, kwargs, "s", &s, "url?", &url); err != nil {
+		return nil, err
+	}
+	return pkgscript.String(base64Encoding(url).EncodeToString([]byte(s))), nil
+}
+
+// base64.decode(s, url=False)
+func base64Decode(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var s string
+	var url bool
+	if err := pkgscript.UnpackArgs(b.Name(), args, kwargs, "s", &s, "url?", &url); err != nil {
+		return nil, err
+	}
+	data, err := base64Encoding(url).Strict().DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	return pkgscript.String(data), nil
+}
+
+func base64Encoding(url bool) *base64.Encoding {
+	if url {
+		return base64.URLEncoding
+	}
+	return base64.StdEncoding
+}
+
+// hex.encode(s)
+func hexEncode(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var s string
+	if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &s); err != nil {
+		return nil, err
+	}
+	return pkgscript.String(hex.EncodeToString([]byte(s))), nil
+}
+
+// hex.decode(s)
+func hexDecode(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var s string
+	if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &s); err != nil {
+		return nil, err
+	}
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", b.Name(), strings.TrimPrefix(err.Error(), "encoding/hex: "))
+	}
+	return pkgscript.String(data), nil
+}
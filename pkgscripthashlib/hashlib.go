@@ -0,0 +1,136 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pkgscripthashlib defines the 'hashlib' module of functions
+// for computing message digests and checksums, an optional language
+// extension.
+//
+// The functions are pure computations over the bytes of a string,
+// so they are safe to provide to sandboxed programs. Because Starlark
+// strings are byte strings, a raw digest is also represented as a string.
+//
+package pkgscripthashlib // import "github.com/andrewchambers/pkgscript/pkgscripthashlib"
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/andrewchambers/pkgscript/pkgscript"
+	"github.com/andrewchambers/pkgscript/pkgscriptstruct"
+	"github.com/andrewchambers/pkgscript/syntax"
+)
+
+// Module is the 'hashlib' module. An application may make it available
+// to Starlark programs by adding it to the predeclared environment or by
+// returning it from its load function.
+//
+//   hashlib.md5(s)      -- the MD5 Digest of the string s
+//   hashlib.sha1(s)     -- the SHA-1 Digest of s
+//   hashlib.sha256(s)   -- the SHA-256 Digest of s
+//   hashlib.crc32(s)    -- the IEEE CRC-32 checksum of s, a non-negative int
+//
+// A Digest has these methods and fields:
+//
+//   d.digest()     -- the raw digest, a string of bytes
+//   d.hexdigest()  -- the digest as a string of lowercase hexadecimal digits
+//   d.name         -- the name of the algorithm, such as "sha256"
+//
+var Module = &pkgscriptstruct.Module{
+	Name: "hashlib",
+	Members: pkgscript.StringDict{
+		"crc32":  pkgscript.NewBuiltin("hashlib.crc32", checksum),
+		"md5":    pkgscript.NewBuiltin("hashlib.md5", digester("md5", md5Sum)),
+		"sha1":   pkgscript.NewBuiltin("hashlib.sha1", digester("sha1", sha1Sum)),
+		"sha256": pkgscript.NewBuiltin("hashlib.sha256", digester("sha256", sha256Sum)),
+	},
+}
+
+func md5Sum(data []byte) []byte    { sum := md5.Sum(data); return sum[:] }
+func sha1Sum(data []byte) []byte   { sum := sha1.Sum(data); return sum[:] }
+func sha256Sum(data []byte) []byte { sum := sha256.Sum256(data); return sum[:] }
+
+// digester returns the implementation of the named digest function.
+func digester(name string, sum func(data []byte) []byte) func(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	return func(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+		var s string
+		if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &s); err != nil {
+			return nil, err
+		}
+		return &Digest{name: name, sum: string(sum([]byte(s)))}, nil
+	}
+}
+
+// hashlib.crc32(s)
+func checksum(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var s string
+	if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &s); err != nil {
+		return nil, err
+	}
+	return pkgscript.MakeUint64(uint64(crc32.ChecksumIEEE([]byte(s)))), nil
+}
+
+// A Digest is the message digest of a string, the result of a
+// function such as hashlib.sha256.
+type Digest struct {
+	name string // name of algorithm
+	sum  string // raw digest
+}
+
+var (
+	_ pkgscript.HasAttrs      = (*Digest)(nil)
+	_ pkgscript.Comparable    = (*Digest)(nil)
+	_ pkgscript.HasStableHash = (*Digest)(nil)
+)
+
+// Sum returns the raw bytes of the digest.
+func (d *Digest) Sum() []byte { return []byte(d.sum) }
+
+func (d *Digest) String() string        { return fmt.Sprintf("<%s digest %x>", d.name, d.sum) }
+func (d *Digest) Type() string          { return "digest" }
+func (d *Digest) Freeze()               {} // immutable
+func (d *Digest) Truth() pkgscript.Bool { return pkgscript.True }
+func (d *Digest) Hash() (uint32, error) { return pkgscript.String(d.sum).Hash() }
+func (d *Digest) StableHash() (uint32, error) {
+	return pkgscript.StableHash(pkgscript.String(d.sum))
+}
+
+// Two digests are equal if they have the same algorithm and value.
+func (d *Digest) CompareSameType(op syntax.Token, y pkgscript.Value, depth int) (bool, error) {
+	e := y.(*Digest)
+	switch op {
+	case syntax.EQL:
+		return d.name == e.name && d.sum == e.sum, nil
+	case syntax.NEQ:
+		return d.name != e.name || d.sum != e.sum, nil
+	}
+	return false, fmt.Errorf("%s %s %s not implemented", d.Type(), op, e.Type())
+}
+
+func (d *Digest) Attr(name string) (pkgscript.Value, error) {
+	switch name {
+	case "name":
+		return pkgscript.String(d.name), nil
+	case "digest":
+		return pkgscript.NewBuiltin(name, func(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+			if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+				return nil, err
+			}
+			return pkgscript.String(d.sum), nil
+		}).BindReceiver(d), nil
+	case "hexdigest":
+		return pkgscript.NewBuiltin(name, func(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+			if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+				return nil, err
+			}
+			return pkgscript.String(hex.EncodeToString([]byte(d.sum))), nil
+		}).BindReceiver(d), nil
+	}
+	return nil, nil // no such attribute
+}
+
+func (d *Digest) AttrNames() []string { return []string{"digest", "hexdigest", "name"} }
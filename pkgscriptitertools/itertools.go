@@ -0,0 +1,322 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pkgscriptitertools defines the 'itertools' module of functions
+// for composing iterable values, an optional language extension.
+//
+// Each function returns a lazy iterable, which computes its elements
+// only as they are needed, and which may be iterated more than once.
+// Some of the results, such as that of count, are infinite; they are
+// typically consumed by a for loop that breaks, or limited by islice.
+package pkgscriptitertools // import "github.com/andrewchambers/pkgscript/pkgscriptitertools"
+
+import (
+	"fmt"
+
+	"github.com/andrewchambers/pkgscript/pkgscript"
+	"github.com/andrewchambers/pkgscript/pkgscriptstruct"
+	"github.com/andrewchambers/pkgscript/syntax"
+)
+
+// Module is the 'itertools' module. An application may make it available
+// to Starlark programs by adding it to the predeclared environment or by
+// returning it from its load function.
+//
+//	itertools.chain(*iterables)              -- the elements of each iterable in turn
+//	itertools.count(start=0, step=1)         -- start, start+step, start+2*step, ...
+//	itertools.cycle(iterable)                -- the elements of iterable, repeated forever
+//	itertools.islice(iterable, stop)         -- the elements before index stop
+//	itertools.islice(iterable, start, stop, step=1)
+//	                                         -- the elements at index start, start+step, ...
+//	itertools.repeat(x, n=None)              -- x, n times, or forever if n is None
+var Module = &pkgscriptstruct.Module{
+	Name: "itertools",
+	Members: pkgscript.StringDict{
+		"chain":  pkgscript.NewBuiltin("itertools.chain", chain),
+		"count":  pkgscript.NewBuiltin("itertools.count", count),
+		"cycle":  pkgscript.NewBuiltin("itertools.cycle", cycle),
+		"islice": pkgscript.NewBuiltin("itertools.islice", islice),
+		"repeat": pkgscript.NewBuiltin("itertools.repeat", repeat),
+	},
+}
+
+// An iterable is the lazy iterable result of an itertools function.
+// Its iterators pass the consuming thread, if any, on to the
+// iterators of its operands.
+type iterable struct {
+	name    string                                            // name of the function, e.g. "count"
+	args    []pkgscript.Value                                 // operands, frozen by Freeze
+	iterate func(thread *pkgscript.Thread) pkgscript.Iterator // returns a new iterator
+}
+
+var _ pkgscript.ThreadIterable = (*iterable)(nil)
+
+func (it *iterable) String() string        { return fmt.Sprintf("<itertools.%s>", it.name) }
+func (it *iterable) Type() string          { return "itertools." + it.name }
+func (it *iterable) Truth() pkgscript.Bool { return pkgscript.True }
+func (it *iterable) Hash() (uint32, error) {
+	return 0, fmt.Errorf("unhashable type: %s", it.Type())
+}
+func (it *iterable) Iterate() pkgscript.Iterator { return it.iterate(nil) }
+func (it *iterable) IterateThread(thread *pkgscript.Thread) pkgscript.Iterator {
+	return it.iterate(thread)
+}
+func (it *iterable) Freeze() {
+	for _, arg := range it.args {
+		arg.Freeze()
+	}
+}
+
+// An iterator is an Iterator defined by a pair of functions.
+// The next function records in err any failure of an underlying
+// iterator.
+type iterator struct {
+	next func(p *pkgscript.Value) bool
+	done func()
+	err  error
+}
+
+var _ pkgscript.FallibleIterator = (*iterator)(nil)
+
+func (it *iterator) Next(p *pkgscript.Value) bool { return it.next(p) }
+func (it *iterator) Done()                        { it.done() }
+func (it *iterator) Err() error                   { return it.err }
+
+func nop() {}
+
+// itertools.chain(*iterables)
+func chain(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	if err := pkgscript.UnpackPositionalArgs(b.Name(), nil, kwargs, 0); err != nil {
+		return nil, err
+	}
+	for i, arg := range args {
+		if _, ok := arg.(pkgscript.Iterable); !ok {
+			return nil, fmt.Errorf("%s: for parameter %d: got %s, want iterable", b.Name(), i+1, arg.Type())
+		}
+	}
+	iterables := append([]pkgscript.Value(nil), args...)
+	return &iterable{
+		name: "chain",
+		args: iterables,
+		iterate: func(thread *pkgscript.Thread) pkgscript.Iterator {
+			var i int                  // index of next iterable
+			var cur pkgscript.Iterator // iterator of iterables[i-1], if any
+			it := new(iterator)
+			it.next = func(p *pkgscript.Value) bool {
+				for {
+					if cur != nil {
+						if cur.Next(p) {
+							return true
+						}
+						cur.Done()
+						it.err = pkgscript.IterErr(cur)
+						cur = nil
+						if it.err != nil {
+							return false
+						}
+					}
+					if i == len(iterables) {
+						return false
+					}
+					cur = pkgscript.IterateThread(thread, iterables[i])
+					i++
+				}
+			}
+			it.done = func() {
+				if cur != nil {
+					cur.Done()
+					cur = nil
+				}
+			}
+			return it
+		},
+	}, nil
+}
+
+// itertools.count(start=0, step=1)
+func count(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var start, step pkgscript.Value = pkgscript.MakeInt(0), pkgscript.MakeInt(1)
+	if err := pkgscript.UnpackArgs(b.Name(), args, kwargs, "start?", &start, "step?", &step); err != nil {
+		return nil, err
+	}
+	for _, x := range []pkgscript.Value{start, step} {
+		switch x.(type) {
+		case pkgscript.Int, pkgscript.Float:
+		default:
+			return nil, fmt.Errorf("%s: got %s, want int or float", b.Name(), x.Type())
+		}
+	}
+	return &iterable{
+		name: "count",
+		iterate: func(thread *pkgscript.Thread) pkgscript.Iterator {
+			x := start
+			return &iterator{
+				next: func(p *pkgscript.Value) bool {
+					*p = x
+					// Addition of numbers cannot fail.
+					x, _ = pkgscript.Binary(syntax.PLUS, x, step)
+					return true
+				},
+				done: nop,
+			}
+		},
+	}, nil
+}
+
+// itertools.cycle(iterable)
+func cycle(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var x pkgscript.Iterable
+	if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &x); err != nil {
+		return nil, err
+	}
+	return &iterable{
+		name: "cycle",
+		args: []pkgscript.Value{x},
+		iterate: func(thread *pkgscript.Thread) pkgscript.Iterator {
+			// The first pass saves the elements, and later passes replay them.
+			var saved []pkgscript.Value
+			var i int
+			iter := pkgscript.IterateThread(thread, x)
+			it := new(iterator)
+			it.next = func(p *pkgscript.Value) bool {
+				if iter != nil {
+					if iter.Next(p) {
+						saved = append(saved, *p)
+						return true
+					}
+					iter.Done()
+					it.err = pkgscript.IterErr(iter)
+					iter = nil
+				}
+				if it.err != nil || len(saved) == 0 {
+					return false
+				}
+				*p = saved[i%len(saved)]
+				i++
+				return true
+			}
+			it.done = func() {
+				if iter != nil {
+					iter.Done()
+					iter = nil
+				}
+			}
+			return it
+		},
+	}, nil
+}
+
+// itertools.islice(iterable, stop)
+// itertools.islice(iterable, start, stop, step=1)
+func islice(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var x pkgscript.Iterable
+	var start, stop, step pkgscript.Value = pkgscript.None, pkgscript.None, pkgscript.None
+	if len(args) <= 2 {
+		if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &x, &stop); err != nil {
+			return nil, err
+		}
+	} else if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 3, &x, &start, &stop, &step); err != nil {
+		return nil, err
+	}
+
+	// index converts an optional non-negative int operand.
+	index := func(what string, v pkgscript.Value, dflt int) (int, error) {
+		if v == pkgscript.None {
+			return dflt, nil
+		}
+		i, err := pkgscript.AsInt32(v)
+		if err != nil {
+			return 0, fmt.Errorf("%s: for %s: %v", b.Name(), what, err)
+		}
+		if i < 0 {
+			return 0, fmt.Errorf("%s: %s must be non-negative, got %d", b.Name(), what, i)
+		}
+		return i, nil
+	}
+	lo, err := index("start", start, 0)
+	if err != nil {
+		return nil, err
+	}
+	hi, err := index("stop", stop, -1) // -1 means no limit
+	if err != nil {
+		return nil, err
+	}
+	stride, err := index("step", step, 1)
+	if err != nil {
+		return nil, err
+	}
+	if stride == 0 {
+		return nil, fmt.Errorf("%s: step must be positive", b.Name())
+	}
+
+	return &iterable{
+		name: "islice",
+		args: []pkgscript.Value{x},
+		iterate: func(thread *pkgscript.Thread) pkgscript.Iterator {
+			iter := pkgscript.IterateThread(thread, x)
+			next := lo // index of next element to yield
+			i := 0     // index of next element of iter
+			exhausted := false
+			it := new(iterator)
+			it.next = func(p *pkgscript.Value) bool {
+				// Never consume elements beyond the stop index,
+				// as the underlying iterable may be infinite.
+				if exhausted || hi >= 0 && next >= hi {
+					return false
+				}
+				for ; i <= next; i++ {
+					if !iter.Next(p) {
+						exhausted = true
+						it.err = pkgscript.IterErr(iter)
+						return false
+					}
+				}
+				next += stride
+				return true
+			}
+			it.done = func() { iter.Done() }
+			return it
+		},
+	}, nil
+}
+
+// itertools.repeat(x, n=None)
+func repeat(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var x pkgscript.Value
+	var n pkgscript.Value = pkgscript.None
+	if err := pkgscript.UnpackArgs(b.Name(), args, kwargs, "x", &x, "n?", &n); err != nil {
+		return nil, err
+	}
+	times := -1 // -1 means forever
+	if n != pkgscript.None {
+		i, err := pkgscript.AsInt32(n)
+		if err != nil {
+			return nil, fmt.Errorf("%s: for parameter n: %v", b.Name(), err)
+		}
+		if i < 0 {
+			i = 0
+		}
+		times = i
+	}
+	return &iterable{
+		name: "repeat",
+		args: []pkgscript.Value{x},
+		iterate: func(thread *pkgscript.Thread) pkgscript.Iterator {
+			remaining := times
+			return &iterator{
+				next: func(p *pkgscript.Value) bool {
+					if remaining == 0 {
+						return false
+					}
+					if remaining > 0 {
+						remaining--
+					}
+					*p = x
+					return true
+				},
+				done: nop,
+			}
+		},
+	}, nil
+}
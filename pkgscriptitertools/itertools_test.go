@@ -0,0 +1,65 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkgscriptitertools_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/andrewchambers/pkgscript/pkgscript"
+	"github.com/andrewchambers/pkgscript/pkgscriptitertools"
+	"github.com/andrewchambers/pkgscript/pkgscripttest"
+	"github.com/andrewchambers/pkgscript/resolve"
+)
+
+func init() {
+	resolve.AllowLambda = true
+	resolve.AllowFloat = true
+}
+
+func Test(t *testing.T) {
+	testdata := pkgscripttest.DataFile("pkgscriptitertools", ".")
+	thread := &pkgscript.Thread{Load: load}
+	pkgscripttest.SetReporter(thread, t)
+	filename := filepath.Join(testdata, "testdata/itertools.star")
+	predeclared := pkgscript.StringDict{
+		"itertools": pkgscriptitertools.Module,
+		"fib":       fib{},
+	}
+	if _, err := pkgscript.ExecFile(thread, filename, nil, predeclared); err != nil {
+		if err, ok := err.(*pkgscript.EvalError); ok {
+			t.Fatal(err.Backtrace())
+		}
+		t.Fatal(err)
+	}
+}
+
+// load implements the 'load' operation as used in the evaluator tests.
+func load(thread *pkgscript.Thread, module pkgscript.Value) (pkgscript.StringDict, error) {
+	if module == pkgscript.String("assert.star") {
+		return pkgscripttest.LoadAssertModule()
+	}
+	return nil, fmt.Errorf("load not implemented")
+}
+
+// fib is an infinite user-defined iterable of Fibonacci numbers.
+type fib struct{}
+
+func (fib) String() string              { return "fib" }
+func (fib) Type() string                { return "fib" }
+func (fib) Freeze()                     {}
+func (fib) Truth() pkgscript.Bool       { return true }
+func (fib) Hash() (uint32, error)       { return 0, fmt.Errorf("unhashable: fib") }
+func (fib) Iterate() pkgscript.Iterator { return &fibIterator{0, 1} }
+
+type fibIterator struct{ x, y int }
+
+func (it *fibIterator) Next(p *pkgscript.Value) bool {
+	*p = pkgscript.MakeInt(it.x)
+	it.x, it.y = it.y, it.x+it.y
+	return true
+}
+func (it *fibIterator) Done() {}
@@ -0,0 +1,317 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pkgscriptjson defines the 'json' module of functions for
+// encoding and decoding JSON, an optional language extension.
+//
+// The mapping between JSON and Starlark values is as follows:
+//
+//   null                -- None
+//   true, false         -- True, False
+//   number              -- int, if it has no fraction or exponent, else float
+//   string              -- string
+//   array               -- list (encode also accepts a tuple)
+//   object              -- dict (encode also accepts a struct)
+//
+// Decoded objects are dicts whose keys are in document order.
+//
+package pkgscriptjson // import "github.com/andrewchambers/pkgscript/pkgscriptjson"
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/andrewchambers/pkgscript/pkgscript"
+	"github.com/andrewchambers/pkgscript/pkgscriptstruct"
+)
+
+// Module is the 'json' module. An application may make it available
+// to Starlark programs by adding it to the predeclared environment or by
+// returning it from its load function.
+//
+//   json.encode(x, sort_keys=False, indent=None)
+//                                            -- the JSON encoding of x
+//   json.decode(s)                           -- the value of the JSON text s
+//
+// By default, encode emits the keys of each object in the iteration
+// order of the dict, and no insignificant white space. If sort_keys,
+// the keys of every object are emitted in sorted order, so that equal
+// values have identical encodings. If indent is an int or a string,
+// each element of a non-empty array or object appears on its own line,
+// indented by that many spaces, or by that string, per level of nesting.
+//
+var Module = &pkgscriptstruct.Module{
+	Name: "json",
+	Members: pkgscript.StringDict{
+		"encode": pkgscript.NewBuiltin("json.encode", encode),
+		"decode": pkgscript.NewBuiltin("json.decode", decode),
+	},
+}
+
+// maxDepth bounds the nesting of encoded and decoded values.
+const maxDepth = 1000
+
+// json.encode(x, sort_keys=False, indent=None)
+func encode(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var x pkgscript.Value
+	var sortKeys bool
+	var indent pkgscript.Value = pkgscript.None
+	if err := pkgscript.UnpackArgs(b.Name(), args, kwargs, "x", &x, "sort_keys?", &sortKeys, "indent?", &indent); err != nil {
+		return nil, err
+	}
+	var prefix string
+	switch indent := indent.(type) {
+	case pkgscript.NoneType:
+	case pkgscript.Int:
+		n, err := pkgscript.AsInt32(indent)
+		if err != nil || n < 0 || n > 100 {
+			return nil, fmt.Errorf("%s: indent must be between 0 and 100, got %v", b.Name(), indent)
+		}
+		prefix = strings.Repeat(" ", n)
+	case pkgscript.String:
+		prefix = string(indent)
+	default:
+		return nil, fmt.Errorf("%s: for parameter indent: got %s, want int, string, or None", b.Name(), indent.Type())
+	}
+
+	e := encoder{sortKeys: sortKeys}
+	if err := e.value(x, 0); err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	if indent == pkgscript.None {
+		return pkgscript.String(e.buf.String()), nil
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, e.buf.Bytes(), "", prefix); err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err) // can't happen
+	}
+	return pkgscript.String(out.String()), nil
+}
+
+type encoder struct {
+	buf      bytes.Buffer
+	sortKeys bool
+}
+
+func (e *encoder) value(x pkgscript.Value, depth int) error {
+	if depth > maxDepth {
+		return fmt.Errorf("value nested too deeply (possibly cyclic)")
+	}
+	switch x := x.(type) {
+	case pkgscript.NoneType:
+		e.buf.WriteString("null")
+
+	case pkgscript.Bool:
+		if x {
+			e.buf.WriteString("true")
+		} else {
+			e.buf.WriteString("false")
+		}
+
+	case pkgscript.Int:
+		e.buf.WriteString(x.String())
+
+	case pkgscript.Float:
+		if f := float64(x); math.IsInf(f, 0) || math.IsNaN(f) {
+			return fmt.Errorf("cannot encode non-finite float %v", x)
+		}
+		// Format the float independent of Thread.SetFloatFormat,
+		// keeping a fraction so that it decodes as a float.
+		s := strconv.FormatFloat(float64(x), 'g', -1, 64)
+		e.buf.WriteString(s)
+		if !strings.ContainsAny(s, ".e") {
+			e.buf.WriteString(".0")
+		}
+
+	case pkgscript.String:
+		e.quote(string(x))
+
+	case *pkgscript.List, pkgscript.Tuple:
+		e.buf.WriteByte('[')
+		iter := pkgscript.Iterate(x)
+		defer iter.Done()
+		var elem pkgscript.Value
+		for i := 0; iter.Next(&elem); i++ {
+			if i > 0 {
+				e.buf.WriteByte(',')
+			}
+			if err := e.value(elem, depth+1); err != nil {
+				return err
+			}
+		}
+		e.buf.WriteByte(']')
+
+	case *pkgscript.Dict:
+		items := x.Items()
+		for _, item := range items {
+			if _, ok := item[0].(pkgscript.String); !ok {
+				return fmt.Errorf("dict key: got %s, want string", item[0].Type())
+			}
+		}
+		if e.sortKeys {
+			sort.Slice(items, func(i, j int) bool {
+				return items[i][0].(pkgscript.String) < items[j][0].(pkgscript.String)
+			})
+		}
+		return e.object(items, depth)
+
+	case *pkgscriptstruct.Struct:
+		fields := make(pkgscript.StringDict)
+		x.ToStringDict(fields)
+		var items []pkgscript.Tuple
+		for _, name := range fields.Keys() { // sorted
+			items = append(items, pkgscript.Tuple{pkgscript.String(name), fields[name]})
+		}
+		return e.object(items, depth)
+
+	default:
+		return fmt.Errorf("cannot encode %s as JSON", x.Type())
+	}
+	return nil
+}
+
+// object encodes an object whose string keys and values are given by items.
+func (e *encoder) object(items []pkgscript.Tuple, depth int) error {
+	e.buf.WriteByte('{')
+	for i, item := range items {
+		if i > 0 {
+			e.buf.WriteByte(',')
+		}
+		e.quote(string(item[0].(pkgscript.String)))
+		e.buf.WriteByte(':')
+		if err := e.value(item[1], depth+1); err != nil {
+			return err
+		}
+	}
+	e.buf.WriteByte('}')
+	return nil
+}
+
+// quote writes s as a JSON string literal.
+// Invalid UTF-8 sequences are replaced by U+FFFD.
+func (e *encoder) quote(s string) {
+	const hex = "0123456789abcdef"
+	e.buf.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			e.buf.WriteByte('\\')
+			e.buf.WriteRune(r)
+		case r == '\n':
+			e.buf.WriteString(`\n`)
+		case r == '\r':
+			e.buf.WriteString(`\r`)
+		case r == '\t':
+			e.buf.WriteString(`\t`)
+		case r < 0x20:
+			e.buf.WriteString(`\u00`)
+			e.buf.WriteByte(hex[r>>4])
+			e.buf.WriteByte(hex[r&0xf])
+		default:
+			e.buf.WriteRune(r) // RuneError for invalid UTF-8
+		}
+	}
+	e.buf.WriteByte('"')
+}
+
+// json.decode(s)
+func decode(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var s string
+	if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &s); err != nil {
+		return nil, err
+	}
+	if !utf8.ValidString(s) {
+		return nil, fmt.Errorf("%s: invalid UTF-8", b.Name())
+	}
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	x, err := decodeValue(dec, 0)
+	if err == nil {
+		end := dec.InputOffset()
+		if _, err2 := dec.Token(); err2 != io.EOF {
+			err = fmt.Errorf("unexpected text after value at offset %d", end)
+		}
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = fmt.Errorf("unexpected end of JSON input")
+		}
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	return x, nil
+}
+
+// decodeValue decodes the next value from dec.
+func decodeValue(dec *json.Decoder, depth int) (pkgscript.Value, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("value nested too deeply")
+	}
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok := tok.(type) {
+	case nil:
+		return pkgscript.None, nil
+	case bool:
+		return pkgscript.Bool(tok), nil
+	case string:
+		return pkgscript.String(tok), nil
+	case json.Number:
+		if !strings.ContainsAny(string(tok), ".eE") {
+			i, ok := new(big.Int).SetString(string(tok), 10)
+			if !ok {
+				return nil, fmt.Errorf("invalid number %s", tok) // can't happen
+			}
+			return pkgscript.MakeBigInt(i), nil
+		}
+		f, err := tok.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", tok)
+		}
+		return pkgscript.Float(f), nil
+	case json.Delim:
+		switch tok {
+		case '[':
+			var elems []pkgscript.Value
+			for dec.More() {
+				elem, err := decodeValue(dec, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				elems = append(elems, elem)
+			}
+			if _, err := dec.Token(); err != nil { // ']'
+				return nil, err
+			}
+			return pkgscript.NewList(elems), nil
+		case '{':
+			dict := new(pkgscript.Dict)
+			for dec.More() {
+				k, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				v, err := decodeValue(dec, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				dict.SetKey(pkgscript.String(k.(string)), v) // can't fail: strings are hashable
+			}
+			if _, err := dec.Token(); err != nil { // '}'
+				return nil, err
+			}
+			return dict, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected JSON token %v", tok) // can't happen
+}
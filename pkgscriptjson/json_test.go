@@ -0,0 +1,64 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkgscriptjson_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/andrewchambers/pkgscript/pkgscript"
+	"github.com/andrewchambers/pkgscript/pkgscriptjson"
+	"github.com/andrewchambers/pkgscript/pkgscriptstruct"
+	"github.com/andrewchambers/pkgscript/pkgscripttest"
+	"github.com/andrewchambers/pkgscript/resolve"
+)
+
+func init() {
+	resolve.AllowLambda = true
+	resolve.AllowFloat = true
+	resolve.AllowSet = true
+}
+
+func Test(t *testing.T) {
+	testdata := pkgscripttest.DataFile("pkgscriptjson", ".")
+	thread := &pkgscript.Thread{Load: load}
+	pkgscripttest.SetReporter(thread, t)
+	filename := filepath.Join(testdata, "testdata/json.star")
+	predeclared := pkgscript.StringDict{
+		"json":   pkgscriptjson.Module,
+		"struct": pkgscript.NewBuiltin("struct", pkgscriptstruct.Make),
+	}
+	if _, err := pkgscript.ExecFile(thread, filename, nil, predeclared); err != nil {
+		if err, ok := err.(*pkgscript.EvalError); ok {
+			t.Fatal(err.Backtrace())
+		}
+		t.Fatal(err)
+	}
+}
+
+func TestFloatFormat(t *testing.T) {
+	// The encoding of floats does not depend on Thread.SetFloatFormat.
+	thread := new(pkgscript.Thread)
+	if err := thread.SetFloatFormat("%.3e"); err != nil {
+		t.Fatal(err)
+	}
+	predeclared := pkgscript.StringDict{"json": pkgscriptjson.Module}
+	v, err := pkgscript.Eval(thread, "<expr>", `json.encode([1.0, 0.5, 100.0, 1e100, 1e-7, 123456789.0])`, predeclared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := v.String(), `"[1.0,0.5,100.0,1e+100,1e-07,1.23456789e+08]"`; got != want {
+		t.Errorf("json.encode = %s, want %s", got, want)
+	}
+}
+
+// load implements the 'load' operation as used in the evaluator tests.
+func load(thread *pkgscript.Thread, module pkgscript.Value) (pkgscript.StringDict, error) {
+	if module == pkgscript.String("assert.star") {
+		return pkgscripttest.LoadAssertModule()
+	}
+	return nil, fmt.Errorf("load not implemented")
+}
@@ -0,0 +1,180 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pkgscriptoperator defines the 'operator' module of functions
+// corresponding to the operators of Starlark, an optional language
+// extension. They are useful as arguments to higher-order functions
+// such as reduce.
+//
+// Each function delegates to the same routine as the corresponding
+// operator, so its semantics, including the conversion of mixed int
+// and float operands and the messages of errors, are those of the
+// operator.
+//
+package pkgscriptoperator // import "github.com/andrewchambers/pkgscript/pkgscriptoperator"
+
+import (
+	"fmt"
+
+	"github.com/andrewchambers/pkgscript/pkgscript"
+	"github.com/andrewchambers/pkgscript/pkgscriptstruct"
+	"github.com/andrewchambers/pkgscript/syntax"
+)
+
+// Module is the 'operator' module. An application may make it available
+// to Starlark programs by adding it to the predeclared environment or by
+// returning it from its load function.
+//
+//   operator.add(x, y)         -- x + y
+//   operator.sub(x, y)         -- x - y
+//   operator.mul(x, y)         -- x * y
+//   operator.truediv(x, y)     -- x / y
+//   operator.floordiv(x, y)    -- x // y
+//   operator.mod(x, y)         -- x % y
+//   operator.and_(x, y)        -- x & y
+//   operator.or_(x, y)         -- x | y
+//   operator.xor(x, y)         -- x ^ y
+//   operator.lshift(x, y)      -- x << y
+//   operator.rshift(x, y)      -- x >> y
+//   operator.neg(x)            -- -x
+//   operator.pos(x)            -- +x
+//   operator.invert(x)         -- ~x
+//   operator.not_(x)           -- not x
+//   operator.truth(x)          -- bool(x)
+//   operator.eq(x, y)          -- x == y
+//   operator.ne(x, y)          -- x != y
+//   operator.lt(x, y)          -- x < y
+//   operator.le(x, y)          -- x <= y
+//   operator.gt(x, y)          -- x > y
+//   operator.ge(x, y)          -- x >= y
+//   operator.contains(x, y)    -- y in x
+//   operator.getitem(x, y)     -- x[y]
+//   operator.itemgetter(*keys) -- a function f such that f(x) is x[key],
+//                                 or a tuple of x[key] for each key if
+//                                 there are several
+//
+var Module = &pkgscriptstruct.Module{
+	Name: "operator",
+	Members: pkgscript.StringDict{
+		"add":        binary("add", syntax.PLUS),
+		"sub":        binary("sub", syntax.MINUS),
+		"mul":        binary("mul", syntax.STAR),
+		"truediv":    binary("truediv", syntax.SLASH),
+		"floordiv":   binary("floordiv", syntax.SLASHSLASH),
+		"mod":        binary("mod", syntax.PERCENT),
+		"and_":       binary("and_", syntax.AMP),
+		"or_":        binary("or_", syntax.PIPE),
+		"xor":        binary("xor", syntax.CIRCUMFLEX),
+		"lshift":     binary("lshift", syntax.LTLT),
+		"rshift":     binary("rshift", syntax.GTGT),
+		"neg":        unary("neg", syntax.MINUS),
+		"pos":        unary("pos", syntax.PLUS),
+		"invert":     unary("invert", syntax.TILDE),
+		"not_":       unary("not_", syntax.NOT),
+		"truth":      pkgscript.NewBuiltin("operator.truth", truth),
+		"eq":         compare("eq", syntax.EQL),
+		"ne":         compare("ne", syntax.NEQ),
+		"lt":         compare("lt", syntax.LT),
+		"le":         compare("le", syntax.LE),
+		"gt":         compare("gt", syntax.GT),
+		"ge":         compare("ge", syntax.GE),
+		"contains":   pkgscript.NewBuiltin("operator.contains", contains),
+		"getitem":    pkgscript.NewBuiltin("operator.getitem", getitem),
+		"itemgetter": pkgscript.NewBuiltin("operator.itemgetter", itemgetter),
+	},
+}
+
+// binary returns the function form of a binary operator.
+func binary(name string, op syntax.Token) *pkgscript.Builtin {
+	return pkgscript.NewBuiltin("operator."+name, func(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+		var x, y pkgscript.Value
+		if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &x, &y); err != nil {
+			return nil, err
+		}
+		return pkgscript.Binary(op, x, y)
+	})
+}
+
+// unary returns the function form of a unary operator.
+func unary(name string, op syntax.Token) *pkgscript.Builtin {
+	return pkgscript.NewBuiltin("operator."+name, func(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+		var x pkgscript.Value
+		if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &x); err != nil {
+			return nil, err
+		}
+		return pkgscript.Unary(op, x)
+	})
+}
+
+// compare returns the function form of a comparison operator.
+func compare(name string, op syntax.Token) *pkgscript.Builtin {
+	return pkgscript.NewBuiltin("operator."+name, func(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+		var x, y pkgscript.Value
+		if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &x, &y); err != nil {
+			return nil, err
+		}
+		ok, err := pkgscript.Compare(op, x, y)
+		if err != nil {
+			return nil, err
+		}
+		return pkgscript.Bool(ok), nil
+	})
+}
+
+// operator.truth(x)
+func truth(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var x pkgscript.Value
+	if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &x); err != nil {
+		return nil, err
+	}
+	return x.Truth(), nil
+}
+
+// operator.contains(x, y)
+func contains(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var x, y pkgscript.Value
+	if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &x, &y); err != nil {
+		return nil, err
+	}
+	return pkgscript.Binary(syntax.IN, y, x)
+}
+
+// operator.getitem(x, y)
+func getitem(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var x, y pkgscript.Value
+	if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &x, &y); err != nil {
+		return nil, err
+	}
+	return pkgscript.GetIndex(x, y)
+}
+
+// operator.itemgetter(*keys)
+func itemgetter(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	if err := pkgscript.UnpackPositionalArgs(b.Name(), nil, kwargs, 0); err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%s: got 0 arguments, want at least 1", b.Name())
+	}
+	keys := append(pkgscript.Tuple(nil), args...)
+	keys.Freeze()
+	return pkgscript.NewBuiltin("itemgetter", func(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+		var x pkgscript.Value
+		if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &x); err != nil {
+			return nil, err
+		}
+		if len(keys) == 1 {
+			return pkgscript.GetIndex(x, keys[0])
+		}
+		items := make(pkgscript.Tuple, len(keys))
+		for i, key := range keys {
+			item, err := pkgscript.GetIndex(x, key)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	}), nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pkgscriptpath defines the 'path' module of functions for
+// manipulating slash-separated paths, an optional language extension.
+//
+// The functions are purely lexical, like those of Go's path package,
+// on which they are based: they never access the file system, and
+// they use forward slashes regardless of the host operating system.
+// They are therefore safe to provide to sandboxed programs.
+//
+package pkgscriptpath // import "github.com/andrewchambers/pkgscript/pkgscriptpath"
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/andrewchambers/pkgscript/pkgscript"
+	"github.com/andrewchambers/pkgscript/pkgscriptstruct"
+)
+
+// Module is the 'path' module. An application may make it available to
+// Starlark programs by adding it to the predeclared environment or by
+// returning it from its load function.
+//
+//   path.join(*parts)  -- joins non-empty parts with slashes, and cleans the result
+//   path.split(p)      -- returns (dir, file), split after the final slash
+//   path.base(p)       -- the last element of p
+//   path.dir(p)        -- all but the last element of p
+//   path.ext(p)        -- the extension of the last element, including the dot
+//   path.clean(p)      -- the shortest equivalent path
+//   path.is_abs(p)     -- reports whether p is absolute
+//
+var Module = &pkgscriptstruct.Module{
+	Name: "path",
+	Members: pkgscript.StringDict{
+		"base":   pkgscript.NewBuiltin("path.base", base),
+		"clean":  pkgscript.NewBuiltin("path.clean", clean),
+		"dir":    pkgscript.NewBuiltin("path.dir", dir),
+		"ext":    pkgscript.NewBuiltin("path.ext", ext),
+		"is_abs": pkgscript.NewBuiltin("path.is_abs", isAbs),
+		"join":   pkgscript.NewBuiltin("path.join", join),
+		"split":  pkgscript.NewBuiltin("path.split", split),
+	},
+}
+
+// path.join(*parts)
+func join(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	if err := pkgscript.UnpackPositionalArgs(b.Name(), nil, kwargs, 0); err != nil {
+		return nil, err
+	}
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		s, ok := pkgscript.AsString(arg)
+		if !ok {
+			return nil, fmt.Errorf("%s: for parameter %d: got %s, want string", b.Name(), i+1, arg.Type())
+		}
+		parts[i] = s
+	}
+	return pkgscript.String(path.Join(parts...)), nil
+}
+
+// path.split(p)
+func split(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var p string
+	if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &p); err != nil {
+		return nil, err
+	}
+	dir, file := path.Split(p)
+	return pkgscript.Tuple{pkgscript.String(dir), pkgscript.String(file)}, nil
+}
+
+// path.base(p)
+func base(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	return unary(b, args, kwargs, path.Base)
+}
+
+// path.dir(p)
+func dir(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	return unary(b, args, kwargs, path.Dir)
+}
+
+// path.ext(p)
+func ext(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	return unary(b, args, kwargs, path.Ext)
+}
+
+// path.clean(p)
+func clean(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	return unary(b, args, kwargs, path.Clean)
+}
+
+// path.is_abs(p)
+func isAbs(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var p string
+	if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &p); err != nil {
+		return nil, err
+	}
+	return pkgscript.Bool(path.IsAbs(p)), nil
+}
+
+// unary implements a function of one string that returns a string.
+func unary(b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple, f func(string) string) (pkgscript.Value, error) {
+	var p string
+	if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &p); err != nil {
+		return nil, err
+	}
+	return pkgscript.String(f(p)), nil
+}
@@ -0,0 +1,175 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pkgscriptrandom defines the 'random' module of functions for
+// generating pseudo-random numbers, an optional language extension.
+//
+// The generator is deterministic: the same seed yields the same
+// sequence of results in every execution, on every platform, and in
+// every version of this package. It is not suitable for cryptography.
+//
+// The state of the generator belongs to the Starlark thread, not to the
+// process, so that concurrent threads do not affect each other.
+// A thread's generator is seeded with zero until the program calls
+// random.seed or the application calls Seed. A thread created by
+// Thread.NewChild gets its own generator, whose seed is drawn from
+// the parent's generator, so that the results of each thread depend
+// only on the parent's seed and the order of its operations.
+//
+package pkgscriptrandom // import "github.com/andrewchambers/pkgscript/pkgscriptrandom"
+
+import (
+	"fmt"
+
+	"github.com/andrewchambers/pkgscript/pkgscript"
+	"github.com/andrewchambers/pkgscript/pkgscriptstruct"
+)
+
+// Module is the 'random' module. An application may make it available
+// to Starlark programs by adding it to the predeclared environment or by
+// returning it from its load function.
+//
+//   random.seed(n)          -- resets the thread's generator to the state given by the int n
+//   random.randint(a, b)    -- a random int in the inclusive range [a, b]
+//   random.choice(seq)      -- a random element of the non-empty sequence seq
+//   random.shuffle(list)    -- permutes the elements of list in place, randomly
+//
+var Module = &pkgscriptstruct.Module{
+	Name: "random",
+	Members: pkgscript.StringDict{
+		"choice":  pkgscript.NewBuiltin("random.choice", choice),
+		"randint": pkgscript.NewBuiltin("random.randint", randint),
+		"seed":    pkgscript.NewBuiltin("random.seed", seed),
+		"shuffle": pkgscript.NewBuiltin("random.shuffle", shuffle),
+	},
+}
+
+// localKey is the thread-local key of the generator.
+const localKey = "pkgscriptrandom.generator"
+
+// Seed sets the state of the thread's generator, as if by random.seed(n).
+// Threads subsequently created from it by NewChild are seeded from it.
+// It must not be called after execution begins.
+func Seed(thread *pkgscript.Thread, n int64) {
+	thread.SetLocal(localKey, &generator{state: uint64(n)})
+}
+
+// A generator is a SplitMix64 pseudo-random number generator.
+// Its algorithm must not change, so that a given seed always
+// produces the same sequence.
+type generator struct {
+	state uint64
+}
+
+// getGenerator returns the generator of the thread,
+// creating it with seed zero if necessary.
+func getGenerator(thread *pkgscript.Thread) *generator {
+	g, _ := thread.Local(localKey).(*generator)
+	if g == nil {
+		g = new(generator)
+		thread.SetLocal(localKey, g)
+	}
+	return g
+}
+
+// ChildLocal returns the generator of a child thread,
+// seeded by the next value of g.
+func (g *generator) ChildLocal() interface{} {
+	return &generator{state: g.uint64()}
+}
+
+func (g *generator) seed(n int64) {
+	g.state = uint64(n)
+}
+
+// uint64 returns the next pseudo-random 64-bit value.
+func (g *generator) uint64() uint64 {
+	g.state += 0x9e3779b97f4a7c15
+	z := g.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// uint64n returns a pseudo-random value in [0, n), or any value if n is zero.
+// It rejects values from the incomplete final interval, to avoid bias.
+func (g *generator) uint64n(n uint64) uint64 {
+	if n == 0 {
+		return g.uint64()
+	}
+	threshold := -n % n // = 2^64 mod n
+	for {
+		if r := g.uint64(); r >= threshold {
+			return r % n
+		}
+	}
+}
+
+// random.seed(n)
+func seed(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var n pkgscript.Int
+	if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &n); err != nil {
+		return nil, err
+	}
+	x, ok := n.Int64()
+	if !ok {
+		return nil, fmt.Errorf("%s: seed %v out of range", b.Name(), n)
+	}
+	getGenerator(thread).seed(x)
+	return pkgscript.None, nil
+}
+
+// random.randint(a, b)
+func randint(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var lo, hi pkgscript.Int
+	if err := pkgscript.UnpackArgs(b.Name(), args, kwargs, "a", &lo, "b", &hi); err != nil {
+		return nil, err
+	}
+	a, ok1 := lo.Int64()
+	z, ok2 := hi.Int64()
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("%s: range [%v, %v] out of range of int64", b.Name(), lo, hi)
+	}
+	if a > z {
+		return nil, fmt.Errorf("%s: empty range [%d, %d]", b.Name(), a, z)
+	}
+	r := getGenerator(thread).uint64n(uint64(z-a) + 1)
+	return pkgscript.MakeInt64(a + int64(r)), nil
+}
+
+// random.choice(seq)
+func choice(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var x pkgscript.Value
+	if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &x); err != nil {
+		return nil, err
+	}
+	seq, ok := x.(pkgscript.Indexable)
+	if !ok {
+		return nil, fmt.Errorf("%s: got %s, want sequence", b.Name(), x.Type())
+	}
+	n := seq.Len()
+	if n == 0 {
+		return nil, fmt.Errorf("%s: cannot choose from an empty %s", b.Name(), seq.Type())
+	}
+	return seq.Index(int(getGenerator(thread).uint64n(uint64(n)))), nil
+}
+
+// random.shuffle(list)
+func shuffle(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var list *pkgscript.List
+	if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &list); err != nil {
+		return nil, err
+	}
+	// Fisher-Yates.
+	g := getGenerator(thread)
+	for i := list.Len() - 1; i > 0; i-- {
+		j := int(g.uint64n(uint64(i + 1)))
+		x, y := list.Index(i), list.Index(j)
+		if err := list.SetIndex(i, y); err != nil {
+			return nil, fmt.Errorf("%s: %v", b.Name(), err)
+		}
+		list.SetIndex(j, x) // can't fail
+	}
+	return pkgscript.None, nil
+}
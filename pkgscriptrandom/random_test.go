@@ -0,0 +1,114 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkgscriptrandom_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/andrewchambers/pkgscript/pkgscript"
+	"github.com/andrewchambers/pkgscript/pkgscriptrandom"
+	"github.com/andrewchambers/pkgscript/pkgscripttest"
+	"github.com/andrewchambers/pkgscript/resolve"
+)
+
+func init() {
+	resolve.AllowLambda = true
+}
+
+func Test(t *testing.T) {
+	testdata := pkgscripttest.DataFile("pkgscriptrandom", ".")
+	thread := &pkgscript.Thread{Load: load}
+	pkgscripttest.SetReporter(thread, t)
+	filename := filepath.Join(testdata, "testdata/random.star")
+	predeclared := pkgscript.StringDict{
+		"random": pkgscriptrandom.Module,
+	}
+	if _, err := pkgscript.ExecFile(thread, filename, nil, predeclared); err != nil {
+		if err, ok := err.(*pkgscript.EvalError); ok {
+			t.Fatal(err.Backtrace())
+		}
+		t.Fatal(err)
+	}
+}
+
+// TestSeed checks that Seed is equivalent to random.seed,
+// and that the generator belongs to the thread.
+func TestSeed(t *testing.T) {
+	predeclared := pkgscript.StringDict{"random": pkgscriptrandom.Module}
+	const src = "x = [random.randint(0, 1000) for _ in range(5)]"
+	run := func(thread *pkgscript.Thread) string {
+		globals, err := pkgscript.ExecFile(thread, "seed.star", src, predeclared)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return globals["x"].String()
+	}
+
+	thread := new(pkgscript.Thread)
+	pkgscriptrandom.Seed(thread, 42)
+	got := run(thread)
+
+	other := new(pkgscript.Thread)
+	if _, err := pkgscript.ExecFile(other, "seed.star", "random.seed(42)", predeclared); err != nil {
+		t.Fatal(err)
+	}
+	if want := run(other); got != want {
+		t.Errorf("after Seed, got %s; after random.seed, got %s", got, want)
+	}
+
+	// Each new thread starts with seed zero.
+	if x, y := run(new(pkgscript.Thread)), run(new(pkgscript.Thread)); x != y {
+		t.Errorf("unseeded threads gave %s and %s", x, y)
+	}
+}
+
+// TestNewChild checks that each child thread has its own generator,
+// seeded deterministically from its parent.
+func TestNewChild(t *testing.T) {
+	predeclared := pkgscript.StringDict{"random": pkgscriptrandom.Module}
+	const src = "x = [random.randint(0, 1000) for _ in range(5)]"
+	run := func() []string {
+		parent := new(pkgscript.Thread)
+		pkgscriptrandom.Seed(parent, 7)
+		children := []*pkgscript.Thread{parent.NewChild(), parent.NewChild()}
+		results := make([]string, len(children))
+		done := make(chan bool)
+		for i, child := range children {
+			go func(i int, child *pkgscript.Thread) {
+				defer func() { done <- true }()
+				globals, err := pkgscript.ExecFile(child, "child.star", src, predeclared)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				results[i] = globals["x"].String()
+			}(i, child)
+		}
+		for range children {
+			<-done
+		}
+		return results
+	}
+
+	first := run()
+	if first[0] == first[1] {
+		t.Errorf("children produced the same sequence %s", first[0])
+	}
+	for i := 0; i < 10; i++ {
+		if again := run(); again[0] != first[0] || again[1] != first[1] {
+			t.Fatalf("children produced %v, then %v", first, again)
+		}
+	}
+}
+
+// load implements the 'load' operation as used in the evaluator tests.
+func load(thread *pkgscript.Thread, module pkgscript.Value) (pkgscript.StringDict, error) {
+	if module == pkgscript.String("assert.star") {
+		return pkgscripttest.LoadAssertModule()
+	}
+	return nil, fmt.Errorf("load not implemented")
+}
@@ -0,0 +1,501 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pkgscriptre defines the 're' module of regular expression
+// functions, an optional language extension.
+//
+// Patterns use the RE2 syntax of the Go regexp package, not the syntax
+// of Python's re module: in particular, there are no backreferences or
+// lookaround assertions, and the replacement string of sub uses $1 or
+// ${name} to refer to a group. Like strings, match positions are byte
+// offsets.
+//
+package pkgscriptre // import "github.com/andrewchambers/pkgscript/pkgscriptre"
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/andrewchambers/pkgscript/pkgscript"
+	"github.com/andrewchambers/pkgscript/pkgscriptstruct"
+	"github.com/andrewchambers/pkgscript/syntax"
+)
+
+// Module is the 're' module. An application may make it available to
+// Starlark programs by adding it to the predeclared environment or by
+// returning it from its load function.
+//
+//   re.compile(pattern)                          -- returns a Pattern
+//   re.match(pattern, string)                    -- match at start of string, or None
+//   re.fullmatch(pattern, string)                -- match of whole string, or None
+//   re.search(pattern, string)                   -- leftmost match in string, or None
+//   re.findall(pattern, string)                  -- list of all matches
+//   re.sub(pattern, repl, string, count=0)       -- replace matches
+//   re.split(pattern, string, maxsplit=0)        -- split string at matches
+//   re.escape(string)                            -- quote metacharacters
+//
+// A Pattern has the methods match, fullmatch, search, findall, sub, and
+// split, which behave like the module functions of the same name but
+// without the pattern parameter, and a field, pattern, its source text.
+//
+// A Match has these methods:
+//
+//   m.group(*groups)     -- text of group (default 0), or None if the group did not participate
+//   m.groups(default)    -- tuple of the text of all groups
+//   m.groupdict(default) -- dict of the text of all named groups
+//   m.start(group)       -- start offset of group (default 0), or -1
+//   m.end(group)         -- end offset of group (default 0), or -1
+//   m.span(group)        -- (start, end) of group (default 0)
+//
+// A group is identified by its index or, if named, by its name.
+var Module = &pkgscriptstruct.Module{
+	Name: "re",
+	Members: pkgscript.StringDict{
+		"compile":   pkgscript.NewBuiltin("re.compile", compile),
+		"escape":    pkgscript.NewBuiltin("re.escape", escape),
+		"findall":   pkgscript.NewBuiltin("re.findall", withPattern(pattern_findall)),
+		"fullmatch": pkgscript.NewBuiltin("re.fullmatch", withPattern(pattern_fullmatch)),
+		"match":     pkgscript.NewBuiltin("re.match", withPattern(pattern_match)),
+		"search":    pkgscript.NewBuiltin("re.search", withPattern(pattern_search)),
+		"split":     pkgscript.NewBuiltin("re.split", withPattern(pattern_split)),
+		"sub":       pkgscript.NewBuiltin("re.sub", withPattern(pattern_sub)),
+	},
+}
+
+type builtinMethod func(thread *pkgscript.Thread, b *pkgscript.Builtin, p *Pattern, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error)
+
+var patternMethods = map[string]builtinMethod{
+	"findall":   pattern_findall,
+	"fullmatch": pattern_fullmatch,
+	"match":     pattern_match,
+	"search":    pattern_search,
+	"split":     pattern_split,
+	"sub":       pattern_sub,
+}
+
+var matchMethods = map[string]func(b *pkgscript.Builtin, m *Match, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error){
+	"end":       match_end,
+	"group":     match_group,
+	"groupdict": match_groupdict,
+	"groups":    match_groups,
+	"span":      match_span,
+	"start":     match_start,
+}
+
+// A Pattern is a compiled regular expression, the result of re.compile.
+type Pattern struct {
+	re *regexp.Regexp // the pattern
+
+	// Go's regexp has no anchored mode, so match and fullmatch use
+	// variants of the pattern, which are compiled on first use.
+	once      sync.Once
+	anchored  *regexp.Regexp // the pattern, anchored at the start
+	fullmatch *regexp.Regexp // the pattern, anchored at both ends
+}
+
+// Compile returns the Pattern for the specified RE2 regular expression.
+func Compile(pattern string) (*Pattern, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &Pattern{re: re}, nil
+}
+
+// variants returns the anchored variants of the pattern,
+// compiling them if necessary. They are always valid.
+func (p *Pattern) variants() (anchored, fullmatch *regexp.Regexp) {
+	p.once.Do(func() {
+		src := p.re.String()
+		p.anchored = regexp.MustCompile(`\A(?:` + src + `)`)
+		p.fullmatch = regexp.MustCompile(`\A(?:` + src + `)\z`)
+	})
+	return p.anchored, p.fullmatch
+}
+
+// Regexp returns the Go regular expression of the pattern.
+func (p *Pattern) Regexp() *regexp.Regexp { return p.re }
+
+var (
+	_ pkgscript.HasAttrs      = (*Pattern)(nil)
+	_ pkgscript.Comparable    = (*Pattern)(nil)
+	_ pkgscript.HasStableHash = (*Pattern)(nil)
+)
+
+func (p *Pattern) String() string {
+	return fmt.Sprintf("re.compile(%s)", pkgscript.String(p.re.String()))
+}
+
+func (p *Pattern) Type() string          { return "pattern" }
+func (p *Pattern) Freeze()               {} // immutable
+func (p *Pattern) Truth() pkgscript.Bool { return pkgscript.True }
+func (p *Pattern) Hash() (uint32, error) { return pkgscript.String(p.re.String()).Hash() }
+func (p *Pattern) StableHash() (uint32, error) {
+	return pkgscript.StableHash(pkgscript.String(p.re.String()))
+}
+
+// Two patterns are equal if they have the same source text.
+func (p *Pattern) CompareSameType(op syntax.Token, y pkgscript.Value, depth int) (bool, error) {
+	q := y.(*Pattern)
+	switch op {
+	case syntax.EQL:
+		return p.re.String() == q.re.String(), nil
+	case syntax.NEQ:
+		return p.re.String() != q.re.String(), nil
+	default:
+		return false, fmt.Errorf("%s %s %s not implemented", p.Type(), op, q.Type())
+	}
+}
+
+func (p *Pattern) Attr(name string) (pkgscript.Value, error) {
+	if name == "pattern" {
+		return pkgscript.String(p.re.String()), nil
+	}
+	method := patternMethods[name]
+	if method == nil {
+		return nil, nil // no such method
+	}
+	impl := func(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+		return method(thread, b, p, args, kwargs)
+	}
+	return pkgscript.NewBuiltin(name, impl).BindReceiver(p), nil
+}
+
+func (p *Pattern) AttrNames() []string {
+	return []string{"findall", "fullmatch", "match", "pattern", "search", "split", "sub"}
+}
+
+// re.compile(pattern)
+func compile(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var pattern string
+	if err := pkgscript.UnpackPositionalArgs(funcName(b), args, kwargs, 1, &pattern); err != nil {
+		return nil, err
+	}
+	p, err := Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", funcName(b), err)
+	}
+	return p, nil
+}
+
+// re.escape(string)
+func escape(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var s string
+	if err := pkgscript.UnpackPositionalArgs(funcName(b), args, kwargs, 1, &s); err != nil {
+		return nil, err
+	}
+	return pkgscript.String(regexp.QuoteMeta(s)), nil
+}
+
+// withPattern returns the implementation of a module function that
+// compiles its first argument, a pattern, then calls the corresponding
+// Pattern method with the remaining arguments.
+func withPattern(method builtinMethod) func(*pkgscript.Thread, *pkgscript.Builtin, pkgscript.Tuple, []pkgscript.Tuple) (pkgscript.Value, error) {
+	return func(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+		var p *Pattern
+		switch x := firstArg(args).(type) {
+		case nil:
+			return nil, fmt.Errorf("%s: missing argument for pattern", funcName(b))
+		case *Pattern:
+			p = x
+		case pkgscript.String:
+			var err error
+			p, err = Compile(string(x))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", funcName(b), err)
+			}
+		default:
+			return nil, fmt.Errorf("%s: for parameter pattern: got %s, want string or pattern", funcName(b), x.Type())
+		}
+		return method(thread, b, p, args[1:], kwargs)
+	}
+}
+
+// funcName returns the name of b for use in error messages. The name
+// of a method is qualified by the type of its receiver, as in
+// "pattern.match"; those of the module functions already are.
+func funcName(b *pkgscript.Builtin) string {
+	if recv := b.Receiver(); recv != nil {
+		return recv.Type() + "." + b.Name()
+	}
+	return b.Name()
+}
+
+func firstArg(args pkgscript.Tuple) pkgscript.Value {
+	if len(args) == 0 {
+		return nil
+	}
+	return args[0]
+}
+
+func pattern_match(thread *pkgscript.Thread, b *pkgscript.Builtin, p *Pattern, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	anchored, _ := p.variants()
+	return find(b, p, anchored, args, kwargs)
+}
+
+func pattern_fullmatch(thread *pkgscript.Thread, b *pkgscript.Builtin, p *Pattern, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	_, fullmatch := p.variants()
+	return find(b, p, fullmatch, args, kwargs)
+}
+
+func pattern_search(thread *pkgscript.Thread, b *pkgscript.Builtin, p *Pattern, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	return find(b, p, p.re, args, kwargs)
+}
+
+// find returns the leftmost match of re, a variant of p, or None.
+func find(b *pkgscript.Builtin, p *Pattern, re *regexp.Regexp, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var s string
+	if err := pkgscript.UnpackPositionalArgs(funcName(b), args, kwargs, 1, &s); err != nil {
+		return nil, err
+	}
+	loc := re.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return pkgscript.None, nil
+	}
+	return &Match{pattern: p, s: s, loc: loc}, nil
+}
+
+// findall returns a list of all non-overlapping matches.
+// Each element is the text of the match if the pattern has no groups,
+// the text of the group if it has one group, or a tuple of the text
+// of each group otherwise.
+func pattern_findall(thread *pkgscript.Thread, b *pkgscript.Builtin, p *Pattern, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var s string
+	if err := pkgscript.UnpackPositionalArgs(funcName(b), args, kwargs, 1, &s); err != nil {
+		return nil, err
+	}
+	var res []pkgscript.Value
+	ngroups := p.re.NumSubexp()
+	for _, loc := range p.re.FindAllStringSubmatchIndex(s, -1) {
+		switch ngroups {
+		case 0:
+			res = append(res, pkgscript.String(s[loc[0]:loc[1]]))
+		case 1:
+			res = append(res, groupText(s, loc, 1, pkgscript.String("")))
+		default:
+			groups := make(pkgscript.Tuple, ngroups)
+			for i := range groups {
+				groups[i] = groupText(s, loc, i+1, pkgscript.String(""))
+			}
+			res = append(res, groups)
+		}
+	}
+	return pkgscript.NewList(res), nil
+}
+
+// sub returns a copy of the string with the first count matches
+// (or all if count is not positive) replaced by repl.
+// The replacement is either a string, which may refer to groups as $1
+// or ${name}, or a function that is called with the Match and returns
+// a string.
+func pattern_sub(thread *pkgscript.Thread, b *pkgscript.Builtin, p *Pattern, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var repl pkgscript.Value
+	var s string
+	count := 0
+	if err := pkgscript.UnpackPositionalArgs(funcName(b), args, kwargs, 2, &repl, &s, &count); err != nil {
+		return nil, err
+	}
+	var template string
+	var fn pkgscript.Callable
+	switch repl := repl.(type) {
+	case pkgscript.String:
+		template = string(repl)
+	case pkgscript.Callable:
+		fn = repl
+	default:
+		return nil, fmt.Errorf("%s: for parameter repl: got %s, want string or function", funcName(b), repl.Type())
+	}
+
+	n := -1
+	if count > 0 {
+		n = count
+	}
+	var buf strings.Builder
+	last := 0
+	for _, loc := range p.re.FindAllStringSubmatchIndex(s, n) {
+		buf.WriteString(s[last:loc[0]])
+		if fn != nil {
+			res, err := pkgscript.Call(thread, fn, pkgscript.Tuple{&Match{pattern: p, s: s, loc: loc}}, nil)
+			if err != nil {
+				return nil, err
+			}
+			str, ok := pkgscript.AsString(res)
+			if !ok {
+				return nil, fmt.Errorf("%s: repl function returned %s, want string", funcName(b), res.Type())
+			}
+			buf.WriteString(str)
+		} else {
+			buf.Write(p.re.ExpandString(nil, template, s, loc))
+		}
+		last = loc[1]
+	}
+	buf.WriteString(s[last:])
+	return pkgscript.String(buf.String()), nil
+}
+
+// split returns the list of substrings of the string separated by
+// matches of the pattern. If maxsplit is positive, at most maxsplit
+// splits occur and the remainder of the string is the final element.
+func pattern_split(thread *pkgscript.Thread, b *pkgscript.Builtin, p *Pattern, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var s string
+	maxsplit := 0
+	if err := pkgscript.UnpackPositionalArgs(funcName(b), args, kwargs, 1, &s, &maxsplit); err != nil {
+		return nil, err
+	}
+	n := -1
+	if maxsplit > 0 {
+		n = maxsplit + 1
+	}
+	parts := p.re.Split(s, n)
+	res := make([]pkgscript.Value, len(parts))
+	for i, part := range parts {
+		res[i] = pkgscript.String(part)
+	}
+	return pkgscript.NewList(res), nil
+}
+
+// A Match is the result of a successful match of a Pattern.
+type Match struct {
+	pattern *Pattern
+	s       string // the string that was searched
+	loc     []int  // start/end byte offsets of each group, or -1
+}
+
+var _ pkgscript.HasAttrs = (*Match)(nil)
+
+func (m *Match) String() string {
+	return fmt.Sprintf("<match span=(%d, %d) text=%s>", m.loc[0], m.loc[1], pkgscript.String(m.s[m.loc[0]:m.loc[1]]))
+}
+func (m *Match) Type() string          { return "match" }
+func (m *Match) Freeze()               {} // immutable
+func (m *Match) Truth() pkgscript.Bool { return pkgscript.True }
+func (m *Match) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: match") }
+
+func (m *Match) Attr(name string) (pkgscript.Value, error) {
+	method := matchMethods[name]
+	if method == nil {
+		return nil, nil // no such method
+	}
+	impl := func(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+		return method(b, m, args, kwargs)
+	}
+	return pkgscript.NewBuiltin(name, impl).BindReceiver(m), nil
+}
+
+func (m *Match) AttrNames() []string {
+	return []string{"end", "group", "groupdict", "groups", "span", "start"}
+}
+
+// groupIndex returns the index of the group denoted by x,
+// an int or the name of a group.
+func (m *Match) groupIndex(b *pkgscript.Builtin, x pkgscript.Value) (int, error) {
+	if name, ok := pkgscript.AsString(x); ok {
+		for i, subexp := range m.pattern.re.SubexpNames() {
+			if name != "" && subexp == name {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("%s: no such group: %s", funcName(b), x)
+	}
+	i, err := pkgscript.AsInt32(x)
+	if err != nil {
+		return 0, fmt.Errorf("%s: got %s, want int or string", funcName(b), x.Type())
+	}
+	if i < 0 || i > m.pattern.re.NumSubexp() {
+		return 0, fmt.Errorf("%s: no such group: %d", funcName(b), i)
+	}
+	return i, nil
+}
+
+// optGroupIndex unpacks the optional group argument of start, end, and span.
+func (m *Match) optGroupIndex(b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (int, error) {
+	var group pkgscript.Value = pkgscript.MakeInt(0)
+	if err := pkgscript.UnpackPositionalArgs(funcName(b), args, kwargs, 0, &group); err != nil {
+		return 0, err
+	}
+	return m.groupIndex(b, group)
+}
+
+// groupText returns the text of group i, or dflt if it did not participate.
+func groupText(s string, loc []int, i int, dflt pkgscript.Value) pkgscript.Value {
+	if loc[2*i] < 0 {
+		return dflt
+	}
+	return pkgscript.String(s[loc[2*i]:loc[2*i+1]])
+}
+
+func match_group(b *pkgscript.Builtin, m *Match, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	if len(kwargs) > 0 {
+		return nil, fmt.Errorf("%s: unexpected keyword arguments", funcName(b))
+	}
+	if len(args) == 0 {
+		return groupText(m.s, m.loc, 0, pkgscript.None), nil
+	}
+	res := make(pkgscript.Tuple, len(args))
+	for j, arg := range args {
+		i, err := m.groupIndex(b, arg)
+		if err != nil {
+			return nil, err
+		}
+		res[j] = groupText(m.s, m.loc, i, pkgscript.None)
+	}
+	if len(res) == 1 {
+		return res[0], nil
+	}
+	return res, nil
+}
+
+func match_groups(b *pkgscript.Builtin, m *Match, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var dflt pkgscript.Value = pkgscript.None
+	if err := pkgscript.UnpackPositionalArgs(funcName(b), args, kwargs, 0, &dflt); err != nil {
+		return nil, err
+	}
+	res := make(pkgscript.Tuple, m.pattern.re.NumSubexp())
+	for i := range res {
+		res[i] = groupText(m.s, m.loc, i+1, dflt)
+	}
+	return res, nil
+}
+
+func match_groupdict(b *pkgscript.Builtin, m *Match, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var dflt pkgscript.Value = pkgscript.None
+	if err := pkgscript.UnpackPositionalArgs(funcName(b), args, kwargs, 0, &dflt); err != nil {
+		return nil, err
+	}
+	res := new(pkgscript.Dict)
+	for i, name := range m.pattern.re.SubexpNames() {
+		if name != "" {
+			if err := res.SetKey(pkgscript.String(name), groupText(m.s, m.loc, i, dflt)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return res, nil
+}
+
+func match_start(b *pkgscript.Builtin, m *Match, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	i, err := m.optGroupIndex(b, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	return pkgscript.MakeInt(m.loc[2*i]), nil
+}
+
+func match_end(b *pkgscript.Builtin, m *Match, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	i, err := m.optGroupIndex(b, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	return pkgscript.MakeInt(m.loc[2*i+1]), nil
+}
+
+func match_span(b *pkgscript.Builtin, m *Match, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	i, err := m.optGroupIndex(b, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	return pkgscript.Tuple{pkgscript.MakeInt(m.loc[2*i]), pkgscript.MakeInt(m.loc[2*i+1])}, nil
+}
@@ -11,6 +11,9 @@ import (
 //
 // It differs from Struct primarily in that its string representation
 // does not enumerate its fields.
+//
+// The members of a module may themselves be modules, forming a
+// hierarchy of names such as pkg.deps.add; see NewModuleTree.
 type Module struct {
 	Name    string
 	Members pkgscript.StringDict
@@ -18,13 +21,55 @@ type Module struct {
 
 var _ pkgscript.HasAttrs = (*Module)(nil)
 
-func (m *Module) Attr(name string) (pkgscript.Value, error) { return m.Members[name], nil }
-func (m *Module) AttrNames() []string                      { return m.Members.Keys() }
-func (m *Module) Freeze()                                  { m.Members.Freeze() }
-func (m *Module) Hash() (uint32, error)                    { return 0, fmt.Errorf("unhashable: %s", m.Type()) }
-func (m *Module) String() string                           { return fmt.Sprintf("<module %q>", m.Name) }
-func (m *Module) Truth() pkgscript.Bool                     { return true }
-func (m *Module) Type() string                             { return "module" }
+// Attr returns the named member of the module,
+// or a NoSuchAttrError if there is none.
+func (m *Module) Attr(name string) (pkgscript.Value, error) {
+	if v, ok := m.Members[name]; ok {
+		return v, nil
+	}
+	return nil, pkgscript.NoSuchAttrError(
+		fmt.Sprintf("module %q has no .%s member", m.Name, name))
+}
+
+// Has reports whether the module has a member of the specified name.
+func (m *Module) Has(name string) bool { return m.Members.Has(name) }
+
+func (m *Module) AttrNames() []string   { return m.Members.Keys() }
+func (m *Module) Freeze()               { m.Members.Freeze() }
+func (m *Module) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable: %s", m.Type()) }
+func (m *Module) String() string        { return fmt.Sprintf("<module %q>", m.Name) }
+func (m *Module) Truth() pkgscript.Bool { return true }
+func (m *Module) Type() string          { return "module" }
+
+// NewModuleTree returns a new module with the specified name whose
+// members are the entries of members. Each value in members must be
+// either a pkgscript.Value or a nested map[string]interface{}, which
+// becomes a submodule. A submodule is named by its path from the
+// root, such as "cfg.net", so that errors from attribute access
+// identify the full path of the missing member.
+//
+// For example, this call creates a module in which cfg.net.port is 80:
+//
+// 	cfg := pkgscriptstruct.NewModuleTree("cfg", map[string]interface{}{
+// 		"net": map[string]interface{}{
+// 			"port": pkgscript.MakeInt(80),
+// 		},
+// 	})
+//
+func NewModuleTree(name string, members map[string]interface{}) *Module {
+	m := &Module{Name: name, Members: make(pkgscript.StringDict, len(members))}
+	for k, v := range members {
+		switch v := v.(type) {
+		case pkgscript.Value:
+			m.Members[k] = v
+		case map[string]interface{}:
+			m.Members[k] = NewModuleTree(name+"."+k, v)
+		default:
+			panic(fmt.Sprintf("NewModuleTree: member %s.%s has unsupported type %T", name, k, v))
+		}
+	}
+	return m
+}
 
 // MakeModule may be used as the implementation of a Starlark built-in
 // function, module(name, **kwargs). It returns a new module with the
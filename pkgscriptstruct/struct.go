@@ -83,6 +83,28 @@ func FromStringDict(constructor pkgscript.Value, d pkgscript.StringDict) *Struct
 	return s
 }
 
+// FromDict returns a new struct whose fields are the entries of d,
+// whose keys must all be strings.
+// The constructor parameter specifies the constructor; use Default for an ordinary struct.
+func FromDict(constructor pkgscript.Value, d *pkgscript.Dict) (*Struct, error) {
+	if constructor == nil {
+		panic("nil constructor")
+	}
+	s := &Struct{
+		constructor: constructor,
+		entries:     make(entries, 0, d.Len()),
+	}
+	for _, item := range d.Items() {
+		k, ok := item[0].(pkgscript.String)
+		if !ok {
+			return nil, fmt.Errorf("struct field names must be strings, got %s", item[0].Type())
+		}
+		s.entries = append(s.entries, entry{string(k), item[1]})
+	}
+	sort.Sort(s.entries)
+	return s, nil
+}
+
 // Struct is an immutable Starlark type that maps field names to values.
 // It is not iterable and does not support len.
 //
@@ -97,7 +119,15 @@ func FromStringDict(constructor pkgscript.Value, d pkgscript.StringDict) *Struct
 // The constructor value appears in the printed form of the value,
 // and is accessible using the Constructor method.
 //
+// Two structs are equal if they have equal constructors and the same
+// fields with equal values. A struct is hashable if the values of all
+// its fields are hashable, so it may be used as a dictionary key.
+//
 // Use Attr to access its fields and AttrNames to enumerate them.
+//
+// A struct also has a to_dict method, which returns a new dictionary
+// of its fields in sorted order, unless a field of the same name
+// hides it. The method is not among its AttrNames.
 type Struct struct {
 	constructor pkgscript.Value
 	entries     entries // sorted by name
@@ -119,8 +149,10 @@ type entry struct {
 }
 
 var (
-	_ pkgscript.HasAttrs  = (*Struct)(nil)
-	_ pkgscript.HasBinary = (*Struct)(nil)
+	_ pkgscript.HasAttrs      = (*Struct)(nil)
+	_ pkgscript.HasBinary     = (*Struct)(nil)
+	_ pkgscript.HasDeepCopy   = (*Struct)(nil)
+	_ pkgscript.HasStableHash = (*Struct)(nil)
 )
 
 // ToStringDict adds a name/value entry to d for each field of the struct.
@@ -172,12 +204,44 @@ func (s *Struct) Hash() (uint32, error) {
 	}
 	return x, nil
 }
+
+// StableHash returns a hash of s that is the same in every execution.
+// It uses the algorithm of Hash, applied to the stable hashes of the
+// field names and values. See pkgscript.StableHash.
+func (s *Struct) StableHash() (uint32, error) {
+	var x, m uint32 = 8731, 9839
+	for _, e := range s.entries {
+		namehash, _ := pkgscript.StableHash(pkgscript.String(e.name))
+		x = x ^ 3*namehash
+		y, err := pkgscript.StableHash(e.value)
+		if err != nil {
+			return 0, err
+		}
+		x = x ^ y*m
+		m += 7349
+	}
+	return x, nil
+}
 func (s *Struct) Freeze() {
 	for _, e := range s.entries {
 		e.value.Freeze()
 	}
 }
 
+// DeepCopy returns a struct with the same constructor whose fields
+// are copies of those of s. See pkgscript.DeepCopy.
+func (s *Struct) DeepCopy(copy func(pkgscript.Value) (pkgscript.Value, error)) (pkgscript.Value, error) {
+	c := &Struct{constructor: s.constructor, entries: make(entries, len(s.entries))}
+	for i, e := range s.entries {
+		v, err := copy(e.value)
+		if err != nil {
+			return nil, err
+		}
+		c.entries[i] = entry{e.name, v}
+	}
+	return c, nil
+}
+
 func (x *Struct) Binary(op syntax.Token, y pkgscript.Value, side pkgscript.Side) (pkgscript.Value, error) {
 	if y, ok := y.(*Struct); ok && op == syntax.PLUS {
 		if side == pkgscript.Right {
@@ -223,6 +287,9 @@ func (s *Struct) Attr(name string) (pkgscript.Value, error) {
 	if i < n && s.entries[i].name == name {
 		return s.entries[i].value, nil
 	}
+	if name == "to_dict" {
+		return pkgscript.NewBuiltin(name, structToDict).BindReceiver(s), nil
+	}
 
 	var ctor string
 	if s.constructor != Default {
@@ -234,6 +301,23 @@ func (s *Struct) Attr(name string) (pkgscript.Value, error) {
 
 func (s *Struct) len() int { return len(s.entries) }
 
+// ToDict returns a new dictionary whose entries are the fields of
+// the struct, in sorted order.
+func (s *Struct) ToDict() *pkgscript.Dict {
+	d := pkgscript.NewDict(len(s.entries))
+	for _, e := range s.entries {
+		d.SetKey(pkgscript.String(e.name), e.value) // can't fail
+	}
+	return d
+}
+
+func structToDict(_ *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	return b.Receiver().(*Struct).ToDict(), nil
+}
+
 // AttrNames returns a new sorted list of the struct fields.
 func (s *Struct) AttrNames() []string {
 	names := make([]string, len(s.entries))
@@ -31,6 +31,11 @@ func Test(t *testing.T) {
 	predeclared := pkgscript.StringDict{
 		"struct": pkgscript.NewBuiltin("struct", pkgscriptstruct.Make),
 		"gensym": pkgscript.NewBuiltin("gensym", gensym),
+		"target": pkgscriptstruct.MakeTyped("target", map[string]func(pkgscript.Value) error{
+			"name":       pkgscriptstruct.HasType("string"),
+			"deps":       pkgscriptstruct.HasType("list"),
+			"visibility": nil,
+		}),
 	}
 	if _, err := pkgscript.ExecFile(thread, filename, nil, predeclared); err != nil {
 		if err, ok := err.(*pkgscript.EvalError); ok {
@@ -41,8 +46,8 @@ func Test(t *testing.T) {
 }
 
 // load implements the 'load' operation as used in the evaluator tests.
-func load(thread *pkgscript.Thread, module string) (pkgscript.StringDict, error) {
-	if module == "assert.star" {
+func load(thread *pkgscript.Thread, module pkgscript.Value) (pkgscript.StringDict, error) {
+	if module == pkgscript.String("assert.star") {
 		return pkgscripttest.LoadAssertModule()
 	}
 	return nil, fmt.Errorf("load not implemented")
@@ -76,3 +81,105 @@ func (sym *symbol) CallInternal(thread *pkgscript.Thread, args pkgscript.Tuple,
 	}
 	return pkgscriptstruct.FromKeywords(sym, kwargs), nil
 }
+
+func TestUnpackStruct(t *testing.T) {
+	s := pkgscriptstruct.FromStringDict(pkgscriptstruct.Default, pkgscript.StringDict{
+		"name":  pkgscript.String("pkg"),
+		"inner": pkgscriptstruct.FromStringDict(pkgscriptstruct.Default, pkgscript.StringDict{"n": pkgscript.MakeInt(7)}),
+	})
+	var cfg struct {
+		Name  string `starlark:"name"`
+		Jobs  int    `starlark:"jobs,optional"`
+		Inner *struct {
+			N int `starlark:"n"`
+		} `starlark:"inner"`
+	}
+	if err := pkgscript.UnpackStruct(s, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Name != "pkg" || cfg.Jobs != 0 || cfg.Inner == nil || cfg.Inner.N != 7 {
+		t.Errorf("UnpackStruct(%s) = %+v", s, cfg)
+	}
+}
+
+func TestFromDict(t *testing.T) {
+	d := pkgscript.NewDict(2)
+	d.SetKey(pkgscript.String("b"), pkgscript.MakeInt(2))
+	d.SetKey(pkgscript.String("a"), pkgscript.MakeInt(1))
+	s, err := pkgscriptstruct.FromDict(pkgscriptstruct.Default, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.String(), "struct(a = 1, b = 2)"; got != want {
+		t.Errorf("FromDict = %s, want %s", got, want)
+	}
+	d.SetKey(pkgscript.String("a"), pkgscript.MakeInt(3))
+	if got, want := s.String(), "struct(a = 1, b = 2)"; got != want {
+		t.Errorf("after updating dict, struct = %s, want %s", got, want)
+	}
+	if got, want := s.ToDict().String(), `{"a": 1, "b": 2}`; got != want {
+		t.Errorf("ToDict = %s, want %s", got, want)
+	}
+
+	d.SetKey(pkgscript.MakeInt(1), pkgscript.None)
+	if _, err := pkgscriptstruct.FromDict(pkgscriptstruct.Default, d); err == nil {
+		t.Errorf("FromDict with int key succeeded unexpectedly")
+	} else if got, want := err.Error(), "struct field names must be strings, got int"; got != want {
+		t.Errorf("FromDict with int key: got error %q, want %q", got, want)
+	}
+}
+
+func TestModuleAttr(t *testing.T) {
+	m := &pkgscriptstruct.Module{
+		Name:    "m",
+		Members: pkgscript.StringDict{"x": pkgscript.None},
+	}
+	if !m.Has("x") || m.Has("y") {
+		t.Errorf("Has(x), Has(y) = %t, %t, want true, false", m.Has("x"), m.Has("y"))
+	}
+	if v, err := m.Attr("x"); v != pkgscript.None || err != nil {
+		t.Errorf("Attr(x) = %v, %v, want None", v, err)
+	}
+	v, err := m.Attr("y")
+	if _, ok := err.(pkgscript.NoSuchAttrError); !ok || v != nil {
+		t.Fatalf("Attr(y) = %v, %v, want NoSuchAttrError", v, err)
+	}
+	if got, want := err.Error(), `module "m" has no .y member`; got != want {
+		t.Errorf("Attr(y) error = %q, want %q", got, want)
+	}
+}
+
+func TestModuleTree(t *testing.T) {
+	cfg := pkgscriptstruct.NewModuleTree("cfg", map[string]interface{}{
+		"name": pkgscript.String("app"),
+		"net": map[string]interface{}{
+			"port": pkgscript.MakeInt(80),
+			"tls": map[string]interface{}{
+				"enabled": pkgscript.True,
+			},
+		},
+	})
+	thread := new(pkgscript.Thread)
+	env := pkgscript.StringDict{"cfg": cfg}
+	for _, test := range []struct{ expr, want string }{
+		{`cfg.name`, `"app"`},
+		{`cfg.net.port`, `80`},
+		{`cfg.net.tls.enabled`, `True`},
+		{`str(cfg.net)`, `"<module \"cfg.net\">"`},
+		{`str(cfg.net.tls)`, `"<module \"cfg.net.tls\">"`},
+		{`dir(cfg)`, `["name", "net"]`},
+		{`dir(cfg.net)`, `["port", "tls"]`},
+		{`cfg.net.host`, `module "cfg.net" has no .host member`},
+		{`cfg.net.tls.enable`, `module "cfg.net.tls" has no .enable member (did you mean .enabled?)`},
+	} {
+		var got string
+		if v, err := pkgscript.Eval(thread, "<expr>", test.expr, env); err != nil {
+			got = err.Error()
+		} else {
+			got = v.String()
+		}
+		if got != test.want {
+			t.Errorf("%s = %s, want %s", test.expr, got, test.want)
+		}
+	}
+}
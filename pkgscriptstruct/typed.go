@@ -0,0 +1,108 @@
+package pkgscriptstruct
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/andrewchambers/pkgscript/pkgscript"
+)
+
+// A Constructor is a callable value that instantiates structs having a
+// fixed set of fields, each of whose values it validates. It is the
+// constructor of the structs it creates, so they are distinct from all
+// other structs, and print using its name.
+//
+// Use MakeTyped to create a Constructor.
+type Constructor struct {
+	name   string
+	fields map[string]func(pkgscript.Value) error
+	names  []string // sorted keys of fields
+}
+
+// MakeTyped returns a constructor of structs whose fields are exactly
+// the keys of fields. The constructor accepts only keyword arguments,
+// and fails if any field is missing or unknown, or if the function
+// associated with a field returns an error for its value. A nil
+// function accepts any value.
+//
+// An application can add a typed constructor to the Starlark
+// environment like so:
+//
+// 	globals := pkgscript.StringDict{
+// 		"target": pkgscriptstruct.MakeTyped("target", map[string]func(pkgscript.Value) error{
+// 			"name": pkgscriptstruct.HasType("string"),
+// 			"deps": pkgscriptstruct.HasType("list"),
+// 		}),
+// 	}
+//
+func MakeTyped(name string, fields map[string]func(pkgscript.Value) error) *Constructor {
+	c := &Constructor{name: name, fields: fields}
+	for field := range fields {
+		c.names = append(c.names, field)
+	}
+	sort.Strings(c.names)
+	return c
+}
+
+// HasType returns a field validation function, for use with MakeTyped,
+// that accepts only values whose Type is one of the specified types.
+func HasType(types ...string) func(pkgscript.Value) error {
+	return func(v pkgscript.Value) error {
+		for _, t := range types {
+			if v.Type() == t {
+				return nil
+			}
+		}
+		return fmt.Errorf("got %s, want %s", v.Type(), strings.Join(types, " or "))
+	}
+}
+
+var (
+	_ pkgscript.Callable      = (*Constructor)(nil)
+	_ pkgscript.HasStableHash = (*Constructor)(nil)
+)
+
+func (c *Constructor) Name() string          { return c.name }
+func (c *Constructor) String() string        { return c.name }
+func (c *Constructor) Type() string          { return "constructor" }
+func (c *Constructor) Freeze()               {} // immutable
+func (c *Constructor) Truth() pkgscript.Bool { return pkgscript.True }
+func (c *Constructor) Hash() (uint32, error) { return pkgscript.String(c.name).Hash() }
+func (c *Constructor) StableHash() (uint32, error) {
+	return pkgscript.StableHash(pkgscript.String(c.name))
+}
+
+// Fields returns the sorted names of the fields of the constructor's structs.
+func (c *Constructor) Fields() []string { return append([]string(nil), c.names...) }
+
+func (c *Constructor) CallInternal(thread *pkgscript.Thread, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	if len(args) > 0 {
+		return nil, fmt.Errorf("%s: unexpected positional arguments", c.name)
+	}
+	// Keyword arguments passed through **kwargs are not checked for
+	// duplicates by the interpreter, so check for them here.
+	given := make(map[string]bool, len(kwargs))
+	for _, kwarg := range kwargs {
+		field := string(kwarg[0].(pkgscript.String))
+		check, ok := c.fields[field]
+		if !ok {
+			return nil, fmt.Errorf("%s: unexpected field %s", c.name, field)
+		}
+		if given[field] {
+			return nil, fmt.Errorf("%s: duplicate field %s", c.name, field)
+		}
+		given[field] = true
+		if check != nil {
+			if err := check(kwarg[1]); err != nil {
+				return nil, fmt.Errorf("%s: for field %s: %v", c.name, field, err)
+			}
+		}
+	}
+	for _, field := range c.names {
+		if !given[field] {
+			return nil, fmt.Errorf("%s: missing field %s", c.name, field)
+		}
+	}
+	return FromKeywords(c, kwargs), nil
+}
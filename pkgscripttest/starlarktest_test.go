@@ -0,0 +1,55 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkgscripttest_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/andrewchambers/pkgscript/pkgscript"
+	"github.com/andrewchambers/pkgscript/pkgscripttest"
+	"github.com/andrewchambers/pkgscript/syntax"
+)
+
+// A recorder is a Reporter that records the errors reported to it.
+type recorder struct{ errors []string }
+
+func (r *recorder) Error(args ...interface{}) { r.errors = append(r.errors, fmt.Sprint(args...)) }
+
+func TestFails(t *testing.T) {
+	opts := &syntax.FileOptions{AllowLambda: true}
+	for _, test := range []struct{ src, want string }{
+		{`assert.fails(lambda: 1//0, "division by zero")`, ""},
+		{`assert.fails(lambda: 1//0, "^floored division")`, ""},
+		{`assert.fails(lambda: None, "oops")`, "evaluation succeeded unexpectedly (want error matching \"oops\")"},
+		{`assert.fails(lambda: 1//0, "^oops$")`, "regular expression (^oops$) did not match error (floored division by zero)"},
+	} {
+		thread := &pkgscript.Thread{Load: load}
+		r := new(recorder)
+		pkgscripttest.SetReporter(thread, r)
+		src := "load('assert.star', 'assert')\n" + test.src
+		if _, err := pkgscript.ExecFileOptions(opts, thread, "fails.star", src, nil); err != nil {
+			t.Errorf("%s: %v", test.src, err)
+			continue
+		}
+		got := strings.Join(r.errors, "\n")
+		if test.want == "" {
+			if got != "" {
+				t.Errorf("%s: unexpected errors: %s", test.src, got)
+			}
+		} else if !strings.Contains(got, test.want) {
+			t.Errorf("%s: got errors %q, want %q", test.src, got, test.want)
+		}
+	}
+}
+
+// load implements the 'load' operation for the assert module.
+func load(thread *pkgscript.Thread, module pkgscript.Value) (pkgscript.StringDict, error) {
+	if module == pkgscript.String("assert.star") {
+		return pkgscripttest.LoadAssertModule()
+	}
+	return nil, fmt.Errorf("load not implemented")
+}
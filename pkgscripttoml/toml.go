@@ -0,0 +1,229 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pkgscripttoml defines the 'toml' module of functions for
+// encoding and decoding TOML, an optional language extension.
+//
+// The mapping between TOML and Starlark values is as follows:
+//
+//   string              -- string
+//   integer             -- int
+//   float               -- float
+//   boolean             -- bool
+//   datetime            -- string, in RFC 3339 format
+//   array               -- list (encode also accepts a tuple)
+//   table               -- dict
+//
+// Tables, including inline tables, become nested dicts whose keys are
+// in document order, and arrays of tables become lists of dicts.
+// TOML has no null value, so None cannot be encoded.
+//
+package pkgscripttoml // import "github.com/andrewchambers/pkgscript/pkgscripttoml"
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/andrewchambers/pkgscript/pkgscript"
+	"github.com/andrewchambers/pkgscript/pkgscriptstruct"
+)
+
+// Module is the 'toml' module. An application may make it available
+// to Starlark programs by adding it to the predeclared environment or by
+// returning it from its load function.
+//
+//   toml.encode(x)                           -- the TOML encoding of the dict x
+//   toml.decode(s)                           -- the dict of the TOML document s
+//
+// The keys of each table encoded by encode appear in sorted order,
+// with the subtables of a table following its other keys.
+// The errors reported by decode give the line and column of the error.
+//
+var Module = &pkgscriptstruct.Module{
+	Name: "toml",
+	Members: pkgscript.StringDict{
+		"encode": pkgscript.NewBuiltin("toml.encode", encode),
+		"decode": pkgscript.NewBuiltin("toml.decode", decode),
+	},
+}
+
+// maxDepth bounds the nesting of encoded values.
+const maxDepth = 1000
+
+// toml.decode(s)
+func decode(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var s string
+	if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &s); err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	md, err := toml.Decode(s, &m)
+	if err != nil {
+		if err, ok := err.(toml.ParseError); ok {
+			return nil, fmt.Errorf("%s: %s", b.Name(), parseError(s, err))
+		}
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+
+	// Record the order in which the keys appear in the document,
+	// including the prefixes of dotted keys.
+	order := make(map[string]int)
+	for i, key := range md.Keys() {
+		for j := 1; j <= len(key); j++ {
+			if _, ok := order[key[:j].String()]; !ok {
+				order[key[:j].String()] = i
+			}
+		}
+	}
+	return fromGo(m, nil, order), nil
+}
+
+// parseError formats a TOML syntax error with its line and column.
+func parseError(s string, err toml.ParseError) string {
+	// The message is the first line of the detailed form,
+	// since the short form does not include the column.
+	msg := strings.SplitN(err.ErrorWithPosition(), "\n", 2)[0]
+	msg = strings.TrimPrefix(msg, "toml: error: ")
+	start := err.Position.Start
+	if start > len(s) {
+		start = len(s)
+	}
+	line := 1 + strings.Count(s[:start], "\n")
+	col := start - strings.LastIndex(s[:start], "\n")
+	return fmt.Sprintf("line %d, column %d: %s", line, col, msg)
+}
+
+// fromGo converts a value decoded by the toml package to a Starlark
+// value. The path is the key of the value, and order gives the index
+// of each key in the document.
+func fromGo(x interface{}, path toml.Key, order map[string]int) pkgscript.Value {
+	switch x := x.(type) {
+	case string:
+		return pkgscript.String(x)
+	case int64:
+		return pkgscript.MakeInt64(x)
+	case float64:
+		return pkgscript.Float(x)
+	case bool:
+		return pkgscript.Bool(x)
+	case time.Time:
+		switch x.Location().String() {
+		case "datetime-local":
+			return pkgscript.String(x.Format("2006-01-02T15:04:05.999999999"))
+		case "date-local":
+			return pkgscript.String(x.Format("2006-01-02"))
+		case "time-local":
+			return pkgscript.String(x.Format("15:04:05.999999999"))
+		}
+		return pkgscript.String(x.Format(time.RFC3339Nano))
+	case []interface{}:
+		elems := make([]pkgscript.Value, len(x))
+		for i, elem := range x {
+			elems[i] = fromGo(elem, path, order)
+		}
+		return pkgscript.NewList(elems)
+	case []map[string]interface{}: // array of tables
+		elems := make([]pkgscript.Value, len(x))
+		for i, elem := range x {
+			elems[i] = fromGo(elem, path, order)
+		}
+		return pkgscript.NewList(elems)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		index := make(map[string]int, len(x))
+		for k := range x {
+			keys = append(keys, k)
+			i, ok := order[append(path[:len(path):len(path)], k).String()]
+			if !ok {
+				i = math.MaxInt32
+			}
+			index[k] = i
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if index[keys[i]] != index[keys[j]] {
+				return index[keys[i]] < index[keys[j]]
+			}
+			return keys[i] < keys[j]
+		})
+		dict := pkgscript.NewDict(len(keys))
+		for _, k := range keys {
+			v := fromGo(x[k], append(path[:len(path):len(path)], k), order)
+			dict.SetKey(pkgscript.String(k), v) // can't fail: strings are hashable
+		}
+		return dict
+	}
+	panic(fmt.Sprintf("unexpected TOML value %T", x))
+}
+
+// toml.encode(x)
+func encode(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var x *pkgscript.Dict
+	if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &x); err != nil {
+		return nil, err
+	}
+	m, err := toGo(x, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(m); err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	return pkgscript.String(buf.String()), nil
+}
+
+// toGo converts a Starlark value to a value encodable by the toml package.
+func toGo(x pkgscript.Value, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("value nested too deeply (possibly cyclic)")
+	}
+	switch x := x.(type) {
+	case pkgscript.String:
+		return string(x), nil
+	case pkgscript.Int:
+		i, ok := x.Int64()
+		if !ok {
+			return nil, fmt.Errorf("int too large for TOML: %v", x)
+		}
+		return i, nil
+	case pkgscript.Float:
+		return float64(x), nil
+	case pkgscript.Bool:
+		return bool(x), nil
+	case *pkgscript.List, pkgscript.Tuple:
+		elems := make([]interface{}, 0, pkgscript.Len(x))
+		iter := pkgscript.Iterate(x)
+		defer iter.Done()
+		var elem pkgscript.Value
+		for iter.Next(&elem) {
+			v, err := toGo(elem, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, v)
+		}
+		return elems, nil
+	case *pkgscript.Dict:
+		m := make(map[string]interface{}, x.Len())
+		for _, item := range x.Items() {
+			k, ok := item[0].(pkgscript.String)
+			if !ok {
+				return nil, fmt.Errorf("dict key: got %s, want string", item[0].Type())
+			}
+			v, err := toGo(item[1], depth+1)
+			if err != nil {
+				return nil, err
+			}
+			m[string(k)] = v
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("cannot encode %s as TOML", x.Type())
+}
@@ -0,0 +1,352 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pkgscriptyaml defines the 'yaml' module of functions for
+// encoding and decoding YAML, an optional language extension.
+//
+// The mapping between YAML and Starlark values is as follows:
+//
+//   null                -- None
+//   true, false         -- True, False
+//   integer             -- int
+//   floating-point      -- float
+//   string              -- string
+//   sequence            -- list (encode also accepts a tuple)
+//   mapping             -- dict
+//
+// Other YAML scalars, such as timestamps, are decoded as strings.
+// Mapping keys may be any scalar; a merge key (<<) inserts the entries
+// of the mapping or mappings it refers to, unless they are overridden
+// by explicit keys. Aliases are replaced by the value of their anchor;
+// decoding fails if they would expand the document to more than about
+// ten values per byte of input.
+//
+package pkgscriptyaml // import "github.com/andrewchambers/pkgscript/pkgscriptyaml"
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/andrewchambers/pkgscript/pkgscript"
+	"github.com/andrewchambers/pkgscript/pkgscriptstruct"
+)
+
+// Module is the 'yaml' module. An application may make it available
+// to Starlark programs by adding it to the predeclared environment or by
+// returning it from its load function.
+//
+//   yaml.encode(x)                           -- the YAML encoding of x
+//   yaml.decode(s)                           -- the value of the single YAML document s
+//   yaml.decode_all(s)                       -- a list of the values of the YAML documents in s
+//
+// An empty document decodes as None. It is an error for the input of
+// decode to contain more than one document.
+//
+var Module = &pkgscriptstruct.Module{
+	Name: "yaml",
+	Members: pkgscript.StringDict{
+		"encode":     pkgscript.NewBuiltin("yaml.encode", encode),
+		"decode":     pkgscript.NewBuiltin("yaml.decode", decode),
+		"decode_all": pkgscript.NewBuiltin("yaml.decode_all", decodeAll),
+	},
+}
+
+// maxDepth bounds the nesting of encoded and decoded values.
+const maxDepth = 1000
+
+// The number of values decoded from an input of n bytes is limited to
+// minNodes + n*nodesPerByte, so that a small document cannot use
+// aliases to expand into an enormous value.
+const (
+	minNodes     = 10000
+	nodesPerByte = 10
+)
+
+// yaml.encode(x)
+func encode(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var x pkgscript.Value
+	if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &x); err != nil {
+		return nil, err
+	}
+	node, err := toNode(x, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(node); err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	return pkgscript.String(buf.String()), nil
+}
+
+// toNode returns the YAML node that encodes x.
+func toNode(x pkgscript.Value, depth int) (*yaml.Node, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("value nested too deeply (possibly cyclic)")
+	}
+	scalar := func(tag, value string) *yaml.Node {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value}
+	}
+	switch x := x.(type) {
+	case pkgscript.NoneType:
+		return scalar("!!null", "null"), nil
+	case pkgscript.Bool:
+		return scalar("!!bool", strconv.FormatBool(bool(x))), nil
+	case pkgscript.Int:
+		// The tag is implicit, as YAML would resolve a large
+		// integer as a float and thus require an explicit tag.
+		return scalar("", x.String()), nil
+	case pkgscript.Float:
+		f := float64(x)
+		switch {
+		case math.IsInf(f, +1):
+			return scalar("!!float", ".inf"), nil
+		case math.IsInf(f, -1):
+			return scalar("!!float", "-.inf"), nil
+		case math.IsNaN(f):
+			return scalar("!!float", ".nan"), nil
+		}
+		return scalar("!!float", x.String()), nil
+	case pkgscript.String:
+		return scalar("!!str", string(x)), nil
+	case *pkgscript.List, pkgscript.Tuple:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		iter := pkgscript.Iterate(x)
+		defer iter.Done()
+		var elem pkgscript.Value
+		for iter.Next(&elem) {
+			child, err := toNode(elem, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, child)
+		}
+		return node, nil
+	case *pkgscript.Dict:
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, item := range x.Items() {
+			key, err := toNode(item[0], depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if key.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("dict key: got %s, want scalar", item[0].Type())
+			}
+			value, err := toNode(item[1], depth+1)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, key, value)
+		}
+		return node, nil
+	}
+	return nil, fmt.Errorf("cannot encode %s as YAML", x.Type())
+}
+
+// yaml.decode(s)
+func decode(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var s string
+	if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &s); err != nil {
+		return nil, err
+	}
+	docs, err := decodeDocuments(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	switch len(docs) {
+	case 0:
+		return pkgscript.None, nil
+	case 1:
+		return docs[0], nil
+	}
+	return nil, fmt.Errorf("%s: input contains %d documents (use decode_all)", b.Name(), len(docs))
+}
+
+// yaml.decode_all(s)
+func decodeAll(thread *pkgscript.Thread, b *pkgscript.Builtin, args pkgscript.Tuple, kwargs []pkgscript.Tuple) (pkgscript.Value, error) {
+	var s string
+	if err := pkgscript.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &s); err != nil {
+		return nil, err
+	}
+	docs, err := decodeDocuments(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	return pkgscript.NewList(docs), nil
+}
+
+// decodeDocuments returns the values of the documents in s.
+func decodeDocuments(s string) ([]pkgscript.Value, error) {
+	dec := yaml.NewDecoder(strings.NewReader(s))
+	d := &decoder{maxNodes: minNodes + len(s)*nodesPerByte}
+	var docs []pkgscript.Value
+	for {
+		var node yaml.Node
+		if err := dec.Decode(&node); err == io.EOF {
+			return docs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		v, err := d.fromNode(&node, 0)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, v)
+	}
+}
+
+// integer matches the decimal integers that YAML resolves as floats
+// because they are too large for int64.
+var integer = regexp.MustCompile(`^[-+]?[0-9]+$`)
+
+// A decoder converts YAML nodes to values, counting the nodes it
+// converts, including each expansion of an alias.
+type decoder struct {
+	nodes, maxNodes int
+}
+
+// fromNode returns the value of a decoded YAML node.
+func (d *decoder) fromNode(node *yaml.Node, depth int) (pkgscript.Value, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("line %d: value nested too deeply", node.Line)
+	}
+	if d.nodes++; d.nodes > d.maxNodes {
+		return nil, fmt.Errorf("line %d: aliases expand to too many values", node.Line)
+	}
+	switch node.Kind {
+	case 0: // empty document
+		return pkgscript.None, nil
+
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return pkgscript.None, nil
+		}
+		return d.fromNode(node.Content[0], depth)
+
+	case yaml.AliasNode:
+		return d.fromNode(node.Alias, depth+1)
+
+	case yaml.SequenceNode:
+		elems := make([]pkgscript.Value, len(node.Content))
+		for i, child := range node.Content {
+			elem, err := d.fromNode(child, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			elems[i] = elem
+		}
+		return pkgscript.NewList(elems), nil
+
+	case yaml.MappingNode:
+		dict := new(pkgscript.Dict)
+		// Merged entries are inserted first so that
+		// explicit keys override them.
+		for i := 0; i < len(node.Content); i += 2 {
+			if key := node.Content[i]; key.ShortTag() == "!!merge" {
+				if err := d.merge(dict, node.Content[i+1], depth+1); err != nil {
+					return nil, err
+				}
+			}
+		}
+		for i := 0; i < len(node.Content); i += 2 {
+			key := node.Content[i]
+			if key.ShortTag() == "!!merge" {
+				continue
+			}
+			k, err := d.fromNode(key, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.fromNode(node.Content[i+1], depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if err := dict.SetKey(k, v); err != nil {
+				return nil, fmt.Errorf("line %d: %v", key.Line, err)
+			}
+		}
+		return dict, nil
+
+	case yaml.ScalarNode:
+		switch node.ShortTag() {
+		case "!!null":
+			return pkgscript.None, nil
+		case "!!bool":
+			var b bool
+			if err := node.Decode(&b); err != nil {
+				return nil, err
+			}
+			return pkgscript.Bool(b), nil
+		case "!!int":
+			var i int64
+			if err := node.Decode(&i); err == nil {
+				return pkgscript.MakeInt64(i), nil
+			}
+			return bigInt(node)
+		case "!!float":
+			if integer.MatchString(node.Value) {
+				return bigInt(node)
+			}
+			var f float64
+			if err := node.Decode(&f); err != nil {
+				return nil, err
+			}
+			return pkgscript.Float(f), nil
+		}
+		return pkgscript.String(node.Value), nil
+	}
+	return nil, fmt.Errorf("line %d: unexpected YAML node", node.Line)
+}
+
+// merge inserts into dict the entries of the mapping or mappings
+// referred to by the value of a merge key, unless already present.
+func (d *decoder) merge(dict *pkgscript.Dict, node *yaml.Node, depth int) error {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yaml.SequenceNode {
+		for _, child := range node.Content {
+			if err := d.merge(dict, child, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	v, err := d.fromNode(node, depth)
+	if err != nil {
+		return err
+	}
+	m, ok := v.(*pkgscript.Dict)
+	if !ok {
+		return fmt.Errorf("line %d: merge key refers to %s, want mapping", node.Line, v.Type())
+	}
+	for _, item := range m.Items() {
+		if _, found, _ := dict.Get(item[0]); !found {
+			dict.SetKey(item[0], item[1]) // can't fail: key is hashable
+		}
+	}
+	return nil
+}
+
+// bigInt returns the value of an integer scalar too large for int64.
+func bigInt(node *yaml.Node) (pkgscript.Value, error) {
+	i, ok := new(big.Int).SetString(node.Value, 0)
+	if !ok {
+		return nil, fmt.Errorf("line %d: invalid integer %q", node.Line, node.Value)
+	}
+	return pkgscript.MakeBigInt(i), nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repl
+
+// This file defines tab completion of names in the REPL.
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/andrewchambers/pkgscript/pkgscript"
+)
+
+// complete returns the candidate completions of the identifier, or
+// dotted sequence of identifiers such as mymod.fo, at the end of line.
+//
+// A plain identifier is completed from the names of globals and of
+// the universal built-ins. An attribute is completed from the
+// AttrNames of the value denoted by the preceding identifiers, which
+// are looked up without evaluating any other expression.
+// The candidates are complete identifiers, in sorted order.
+func complete(line string, globals pkgscript.StringDict) []string {
+	word := line[len(strings.TrimRightFunc(line, isIdentOrDot)):]
+
+	var names []string
+	if dot := strings.LastIndexByte(word, '.'); dot >= 0 {
+		v := lookup(word[:dot], globals)
+		if v, ok := v.(pkgscript.HasAttrs); ok {
+			names = v.AttrNames()
+		}
+		word = word[dot+1:]
+	} else {
+		names = append(globals.Keys(), pkgscript.Universe.Keys()...)
+	}
+
+	var candidates []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if strings.HasPrefix(name, word) && !seen[name] {
+			seen[name] = true
+			candidates = append(candidates, name)
+		}
+	}
+	sort.Strings(candidates)
+	return candidates
+}
+
+// lookup returns the value of a dotted sequence of identifiers,
+// or nil if it does not denote a value.
+func lookup(dotted string, globals pkgscript.StringDict) pkgscript.Value {
+	parts := strings.Split(dotted, ".")
+	v, ok := globals[parts[0]]
+	if !ok {
+		v = pkgscript.Universe[parts[0]]
+	}
+	for _, name := range parts[1:] {
+		x, ok := v.(pkgscript.HasAttrs)
+		if !ok {
+			return nil
+		}
+		if v, _ = x.Attr(name); v == nil {
+			return nil
+		}
+	}
+	return v
+}
+
+func isIdentOrDot(r rune) bool {
+	return r == '_' || r == '.' ||
+		'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9'
+}
+
+// A completer adapts complete to the readline.AutoCompleter interface.
+type completer struct {
+	globals pkgscript.StringDict
+}
+
+func (c completer) Do(line []rune, pos int) (suffixes [][]rune, length int) {
+	prefix := string(line[:pos])
+	word := prefix[len(strings.TrimRightFunc(prefix, isIdentOrDot)):]
+	if dot := strings.LastIndexByte(word, '.'); dot >= 0 {
+		word = word[dot+1:]
+	}
+	for _, candidate := range complete(prefix, c.globals) {
+		suffixes = append(suffixes, []rune(candidate[len(word):]))
+	}
+	return suffixes, len([]rune(word))
+}
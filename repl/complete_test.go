@@ -0,0 +1,58 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repl
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/andrewchambers/pkgscript/pkgscript"
+	"github.com/andrewchambers/pkgscript/pkgscriptstruct"
+)
+
+func TestComplete(t *testing.T) {
+	mymod := &pkgscriptstruct.Module{
+		Name: "mymod",
+		Members: pkgscript.StringDict{
+			"foo":    pkgscript.MakeInt(1),
+			"format": pkgscript.String("x"),
+			"bar":    pkgscript.None,
+		},
+	}
+	globals := pkgscript.StringDict{
+		"mymod":   mymod,
+		"lenient": pkgscript.True,
+		"s":       pkgscript.String("abc"),
+	}
+	for _, test := range []struct {
+		line string
+		want string
+	}{
+		{"mymod.fo", "[foo format]"},
+		{"x = mymod.", "[bar foo format]"},
+		{"mymod.format.spl", "[split splitlines]"},
+		{"print(len", "[len lenient]"},
+		{"my", "[mymod]"},
+		{"s.up", "[upper]"},
+		{"nosuch.x", "[]"},
+		{"mymod.bar.x", "[]"},
+		{"mymod.zzz", "[]"},
+	} {
+		got := fmt.Sprint(complete(test.line, globals))
+		if got != test.want {
+			t.Errorf("complete(%q) = %s, want %s", test.line, got, test.want)
+		}
+	}
+
+	// The readline adapter returns the suffixes of the candidates.
+	suffixes, length := completer{globals}.Do([]rune("x = mymod.fo + 1"), len("x = mymod.fo"))
+	var strs []string
+	for _, s := range suffixes {
+		strs = append(strs, string(s))
+	}
+	if got, want := fmt.Sprintf("%q %d", strs, length), `["o" "rmat"] 2`; got != want {
+		t.Errorf("Do = %s, want %s", got, want)
+	}
+}
@@ -1,33 +1,25 @@
 // Package repl provides a read/eval/print loop for Starlark.
 //
 // It supports readline-style command editing,
-// and interrupts through Control-C.
+// persistent command history, tab completion of global names and
+// attributes, and interrupts through Control-C.
 //
-// If an input line can be parsed as an expression,
-// the REPL parses and evaluates it and prints its result.
-// Otherwise the REPL reads lines until a blank line,
-// then tries again to parse the multi-line input as an
-// expression. If the input still cannot be parsed as an expression,
-// the REPL parses and executes it as a file (a list of statements),
-// for side effects.
+// The REPL reads lines until they form a complete item. Input that
+// is unfinished, such as an unclosed bracket or a block header with no
+// body yet, is continued with a "... " prompt. An item containing a
+// compound statement such as def, if, for, or while is ended by a blank
+// line, so a multi-line block may be pasted in its entirety.
+// If the item is a single expression, the REPL evaluates it and
+// prints its result; otherwise it executes the statements for
+// their side effects.
 package repl // import "github.com/andrewchambers/pkgscript/repl"
 
-// TODO(adonovan):
-//
-// - Unparenthesized tuples are not parsed as a single expression:
-//     >>> (1, 2)
-//     (1, 2)
-//     >>> 1, 2
-//     ...
-//     >>>
-//   This is not necessarily a bug.
-
 import (
 	"context"
 	"fmt"
-	"io"
 	"os"
 	"os/signal"
+	"strings"
 
 	"github.com/chzyer/readline"
 	"github.com/andrewchambers/pkgscript/resolve"
@@ -37,6 +29,15 @@ import (
 
 var interrupted = make(chan os.Signal, 1)
 
+// Options specifies optional features of the REPL.
+// The zero value is a valid configuration.
+type Options struct {
+	// HistoryFile is the name of the file from which command history
+	// is read at startup and to which each input line is appended,
+	// such as ~/.pkgscript_history. If empty, history is not persisted.
+	HistoryFile string
+}
+
 // REPL executes a read, eval, print loop.
 //
 // Before evaluating each expression, it sets the Starlark thread local
@@ -44,11 +45,15 @@ var interrupted = make(chan os.Signal, 1)
 // SIGINT (Control-C). Client-supplied global functions may use this
 // context to make long-running operations interruptable.
 //
-func REPL(thread *pkgscript.Thread, globals pkgscript.StringDict) {
+func REPL(thread *pkgscript.Thread, globals pkgscript.StringDict, opts Options) {
 	signal.Notify(interrupted, os.Interrupt)
 	defer signal.Stop(interrupted)
 
-	rl, err := readline.New(">>> ")
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:       ">>> ",
+		HistoryFile:  opts.HistoryFile,
+		AutoComplete: completer{globals},
+	})
 	if err != nil {
 		PrintError(err)
 		return
@@ -88,38 +93,26 @@ func rep(rl *readline.Instance, thread *pkgscript.Thread, globals pkgscript.Stri
 
 	thread.SetLocal("context", ctx)
 
-	eof := false
-
-	// readline returns EOF, ErrInterrupted, or a line including "\n".
-	rl.SetPrompt(">>> ")
-	readline := func() ([]byte, error) {
-		line, err := rl.Readline()
-		rl.SetPrompt("... ")
-		if err != nil {
-			if err == io.EOF {
-				eof = true
-			}
-			return nil, err
-		}
-		return []byte(line + "\n"), nil
+	// readLine returns EOF, ErrInterrupted, or a line.
+	readLine := func(prompt string) (string, error) {
+		rl.SetPrompt(prompt)
+		return rl.Readline()
 	}
 
 	// parse
-	f, err := syntax.ParseCompoundStmt("<stdin>", readline)
+	f, err := read(readLine)
 	if err != nil {
-		if eof {
-			return io.EOF
+		if _, ok := err.(syntax.Error); ok {
+			PrintError(err)
+			return nil
 		}
-		PrintError(err)
-		return nil
+		return err // EOF, interrupt, or other readline failure
 	}
 
 	// Treat load bindings as global (like they used to be) in the REPL.
 	// This is a workaround for github.com/google/pkgscript-go/issues/224.
-	// TODO(adonovan): not safe wrt concurrent interpreters.
-	// Come up with a more principled solution (or plumb options everywhere).
-	defer func(prev bool) { resolve.LoadBindsGlobally = prev }(resolve.LoadBindsGlobally)
-	resolve.LoadBindsGlobally = true
+	f.Options = resolve.GlobalOptions()
+	f.Options.LoadBindsGlobally = true
 
 	if expr := soleExpr(f); expr != nil {
 		// eval
@@ -158,6 +151,64 @@ func rep(rl *readline.Instance, thread *pkgscript.Thread, globals pkgscript.Stri
 	return nil
 }
 
+// read reads lines using the readline function until they form
+// a complete item, then returns the parsed item.
+// It returns an error from readline, or a syntax error.
+func read(readline func(prompt string) (string, error)) (*syntax.File, error) {
+	var buf strings.Builder
+	prompt := ">>> "
+	for {
+		line, err := readline(prompt)
+		if err != nil {
+			return nil, err
+		}
+		if buf.Len() == 0 && strings.TrimSpace(line) == "" {
+			continue // ignore leading blank lines
+		}
+		prompt = "... "
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+
+		f, err := syntax.Parse("<stdin>", buf.String(), 0)
+		if err != nil {
+			if incomplete(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		// A compound statement may be followed by more
+		// lines of its block, so it must end with a blank line.
+		if strings.TrimSpace(line) != "" && hasCompound(f) {
+			continue
+		}
+		return f, nil
+	}
+}
+
+// incomplete reports whether a syntax error was caused by input
+// that ended prematurely, for instance within a bracketed expression
+// or string literal, or before the body of a compound statement.
+func incomplete(err error) bool {
+	if err, ok := err.(syntax.Error); ok {
+		return strings.HasPrefix(err.Msg, "got end of file") ||
+			strings.HasPrefix(err.Msg, "unexpected EOF") ||
+			err.Msg == "got outdent, want indent"
+	}
+	return false
+}
+
+// hasCompound reports whether the file contains a compound statement.
+func hasCompound(f *syntax.File) bool {
+	for _, stmt := range f.Stmts {
+		switch stmt.(type) {
+		case *syntax.DefStmt, *syntax.IfStmt, *syntax.ForStmt, *syntax.WhileStmt:
+			return true
+		}
+	}
+	return false
+}
+
 func soleExpr(f *syntax.File) syntax.Expr {
 	if len(f.Stmts) == 1 {
 		if stmt, ok := f.Stmts[0].(*syntax.ExprStmt); ok {
@@ -181,6 +232,33 @@ func PrintError(err error) {
 // suitable for use in the REPL.
 // Each function returned by MakeLoad accesses a distinct private cache.
 func MakeLoad() func(thread *pkgscript.Thread, modval pkgscript.Value) (pkgscript.StringDict, error) {
+	return makeLoad(func(module string) (interface{}, error) {
+		return nil, nil // ExecFile reads the file
+	})
+}
+
+// MakeMapLoad returns a simple sequential implementation of module
+// loading that reads the source of each module from the sources map,
+// keyed by module name, and never accesses the file system.
+// Loading a module that is not in the map fails with the error
+// "module not found".
+// Each function returned by MakeMapLoad accesses a distinct private cache.
+func MakeMapLoad(sources map[string]string) func(thread *pkgscript.Thread, modval pkgscript.Value) (pkgscript.StringDict, error) {
+	return makeLoad(func(module string) (interface{}, error) {
+		src, ok := sources[module]
+		if !ok {
+			return nil, fmt.Errorf("module not found: %s", module)
+		}
+		return src, nil
+	})
+}
+
+// makeLoad returns a load function that caches the result of loading
+// each module, and detects cycles. The source function returns the
+// source of a module, as for the src parameter of ExecFile. Each module
+// is executed by a child of the loading thread, as if by NewChild, so
+// that loaded code is subject to the same limits and hooks.
+func makeLoad(source func(module string) (interface{}, error)) func(thread *pkgscript.Thread, modval pkgscript.Value) (pkgscript.StringDict, error) {
 	type entry struct {
 		globals pkgscript.StringDict
 		err     error
@@ -201,12 +279,19 @@ func MakeLoad() func(thread *pkgscript.Thread, modval pkgscript.Value) (pkgscrip
 				return nil, fmt.Errorf("cycle in load graph")
 			}
 
+			src, err := source(module)
+			if err != nil {
+				return nil, err
+			}
+
 			// Add a placeholder to indicate "load in progress".
 			cache[module] = nil
 
-			// Load it.
-			thread := &pkgscript.Thread{Name: "exec " + module, Load: thread.Load}
-			globals, err := pkgscript.ExecFile(thread, module, nil, nil)
+			// Load it on a child thread, which shares the limits
+			// of the loading thread, such as its step limit.
+			thread := thread.NewChild()
+			thread.Name = "exec " + module
+			globals, err := pkgscript.ExecFile(thread, module, src, nil)
 			e = &entry{globals, err}
 
 			// Update the cache.
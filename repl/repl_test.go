@@ -0,0 +1,121 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repl
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/andrewchambers/pkgscript/pkgscript"
+)
+
+func TestRead(t *testing.T) {
+	for _, test := range []struct {
+		input   string // lines fed to the reader
+		prompts string // prompts shown, one character per line
+		stmts   int    // number of statements parsed
+		err     string // expected error, if any
+	}{
+		{"1 + 2", ">", 1, ""},
+		{"1, 2", ">", 1, ""},
+		{"\n\nx = 1", ">>>", 1, ""},
+		{"def f(x):\n  y = x\n  return y\n\nignored", ">...", 1, ""},
+		{"def f():\n  return 1\nprint(f())\n", ">...", 2, ""},
+		{"if x:\n  if y:\n    pass\n\n", ">...", 1, ""},
+		{"x = [\n  1,\n\n  2]", ">...", 1, ""},
+		{"s = '''a\nb'''", ">.", 1, ""},
+		{"x = )", ">", 0, "<stdin>:1:5: unexpected ')'"},
+		{"x = 1 +", ">", 0, "<stdin>:2:1: got newline, want primary expression"},
+		{"def f():\n  return 1", ">.", 0, "EOF"},
+	} {
+		lines := strings.Split(test.input, "\n")
+		var prompts string
+		readline := func(prompt string) (string, error) {
+			if len(lines) == 0 {
+				return "", io.EOF
+			}
+			prompts += prompt[:1]
+			line := lines[0]
+			lines = lines[1:]
+			return line, nil
+		}
+		f, err := read(readline)
+		if err != nil {
+			if err.Error() != test.err {
+				t.Errorf("read(%q) failed: %v, want %s", test.input, err, test.err)
+			}
+			continue
+		}
+		if test.err != "" {
+			t.Errorf("read(%q) succeeded, want error %s", test.input, test.err)
+			continue
+		}
+		if prompts != test.prompts {
+			t.Errorf("read(%q) prompts = %q, want %q", test.input, prompts, test.prompts)
+		}
+		if len(f.Stmts) != test.stmts {
+			t.Errorf("read(%q) = %d statements, want %d", test.input, len(f.Stmts), test.stmts)
+		}
+	}
+}
+
+func TestMakeMapLoad(t *testing.T) {
+	load := MakeMapLoad(map[string]string{
+		"lib.star":     "def f(): return 42\nlist = []\n",
+		"main.star":    "load(\"lib.star\", \"f\")\nx = f()\n",
+		"missing.star": "load(\"nosuch.star\", \"f\")\n",
+		"cycle1.star":  "load(\"cycle2.star\", \"x\")\n",
+		"cycle2.star":  "load(\"cycle1.star\", \"x\")\n",
+	})
+	thread := &pkgscript.Thread{Load: load}
+
+	globals, err := load(thread, pkgscript.String("main.star"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := globals["x"]; got != pkgscript.MakeInt(42) {
+		t.Errorf("x = %v, want 42", got)
+	}
+
+	// Loading a module again yields the cached globals.
+	lib1, err := load(thread, pkgscript.String("lib.star"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib2, _ := load(thread, pkgscript.String("lib.star"))
+	if lib1["list"] != lib2["list"] {
+		t.Errorf("lib.star was loaded twice")
+	}
+
+	for _, test := range []struct{ module, want string }{
+		{"nosuch.star", "module not found: nosuch.star"},
+		{"missing.star", `cannot load "nosuch.star": module not found: nosuch.star`},
+		{"cycle1.star", "cycle in load graph"},
+	} {
+		_, err := load(thread, pkgscript.String(test.module))
+		if err == nil {
+			t.Errorf("load(%s) succeeded, want error", test.module)
+		} else if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("load(%s) failed with %v, want %s", test.module, err, test.want)
+		}
+	}
+}
+
+func TestMakeMapLoadLimits(t *testing.T) {
+	load := MakeMapLoad(map[string]string{
+		"lib.star": "def f():\n  for i in range(1000000):\n    pass\nf()\nx = 1\n",
+	})
+	thread := &pkgscript.Thread{Load: load}
+	thread.SetMaxExecutionSteps(1000)
+
+	// The loaded module cannot escape the step limit of the loading thread.
+	_, err := pkgscript.ExecFile(thread, "main.star", `load("lib.star", "x")`, nil)
+	if err == nil {
+		t.Fatal("load of an expensive module succeeded despite the step limit")
+	} else if want := "too many steps"; !strings.Contains(err.Error(), want) {
+		t.Errorf("load failed with %v, want %s", err, want)
+	}
+}
@@ -54,8 +54,10 @@ func (scope Scope) String() string { return scopeNames[scope] }
 // A Module contains resolver information about a file.
 // The resolver populates the Module field of each syntax.File.
 type Module struct {
-	Locals  []*Binding // the file's (comprehension-)local variables
-	Globals []*Binding // the file's global variables
+	Locals   []*Binding          // the file's (comprehension-)local variables
+	Globals  []*Binding          // the file's global variables
+	Warnings []Error             // diagnostics that are not errors, such as unreachable code
+	Options  *syntax.FileOptions // the dialect in which the file was resolved
 }
 
 // A Function contains resolver information about a named or anonymous function.
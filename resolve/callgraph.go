@@ -0,0 +1,178 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package resolve
+
+// This file defines the static call graph of a resolved file.
+
+import (
+	"sort"
+
+	"github.com/andrewchambers/pkgscript/syntax"
+)
+
+// Names of special nodes of a CallGraph.
+const (
+	Toplevel = "<toplevel>" // the statements of the file outside any function
+	Dynamic  = "<dynamic>"  // the callee of a call whose target is not statically known
+)
+
+// A CallGraph maps the name of each function of a file to the set of
+// names of the functions it calls.
+//
+// A function declared by a def statement at top level is named
+// by its identifier, and one nested within a function f by its
+// identifier qualified by "f.". Calls within a lambda expression are
+// attributed to the function enclosing it. A callee is the name of a
+// function of the file, a global, predeclared, or universal variable,
+// or an attribute of such a variable (as in "json.encode"). A call of
+// any other value, such as a parameter or the result of a call, has
+// the callee Dynamic.
+//
+// A function that refers to another function of the file without
+// calling it, for example to pass it as a callback or as the key of
+// sorted, is considered to call it, since the referenced function may
+// be called through the value.
+//
+// The call graph is computed syntactically, so a callee is included
+// even if the call is never executed.
+type CallGraph map[string]map[string]bool
+
+// NewCallGraph returns the static call graph of a file,
+// which must have been successfully resolved by File.
+func NewCallGraph(file *syntax.File) CallGraph {
+	b := &callGraphBuilder{defs: make(map[*syntax.Ident]string)}
+	// The first pass names the functions, which may be
+	// called before they are declared; the second records calls.
+	for _, stmt := range file.Stmts {
+		b.walk(stmt, Toplevel)
+	}
+	b.graph = CallGraph{Toplevel: make(map[string]bool)}
+	for _, stmt := range file.Stmts {
+		b.walk(stmt, Toplevel)
+	}
+	return b.graph
+}
+
+type callGraphBuilder struct {
+	defs  map[*syntax.Ident]string // maps the first binding of each def'd name to its function name
+	graph CallGraph                // nil in the first pass
+}
+
+func (b *callGraphBuilder) walk(n syntax.Node, caller string) {
+	syntax.Walk(n, func(n syntax.Node) bool {
+		switch n := n.(type) {
+		case *syntax.DefStmt:
+			name := n.Name.Name
+			if caller != Toplevel {
+				name = caller + "." + name
+			}
+			if b.graph == nil {
+				if bind, ok := n.Name.Binding.(*Binding); ok {
+					b.defs[bind.First] = name
+				}
+			} else if b.graph[name] == nil {
+				b.graph[name] = make(map[string]bool)
+			}
+			// Default values of parameters are evaluated by the caller.
+			for _, param := range n.Params {
+				b.walk(param, caller)
+			}
+			for _, stmt := range n.Body {
+				b.walk(stmt, name)
+			}
+			return false
+
+		case *syntax.CallExpr:
+			if b.graph != nil {
+				b.graph[caller][b.callee(n.Fn)] = true
+			}
+
+		case *syntax.Ident:
+			if b.graph != nil {
+				if bind, ok := n.Binding.(*Binding); ok {
+					if name, ok := b.defs[bind.First]; ok {
+						b.graph[caller][name] = true
+					}
+				}
+			}
+		}
+		return true
+	})
+}
+
+// callee returns the name of the function called by fn.
+func (b *callGraphBuilder) callee(fn syntax.Expr) string {
+	for {
+		paren, ok := fn.(*syntax.ParenExpr)
+		if !ok {
+			break
+		}
+		fn = paren.X
+	}
+	switch fn := fn.(type) {
+	case *syntax.Ident:
+		bind, ok := fn.Binding.(*Binding)
+		if !ok {
+			break
+		}
+		if name, ok := b.defs[bind.First]; ok {
+			return name
+		}
+		switch bind.Scope {
+		case Global, Predeclared, Universal:
+			return fn.Name
+		}
+
+	case *syntax.DotExpr:
+		if x, ok := fn.X.(*syntax.Ident); ok {
+			if bind, ok := x.Binding.(*Binding); ok {
+				switch bind.Scope {
+				case Global, Predeclared, Universal:
+					return x.Name + "." + fn.Name.Name
+				}
+			}
+		}
+	}
+	return Dynamic
+}
+
+// Reachable returns the sorted names of the functions of the file
+// that may be called, directly or indirectly, by the named function.
+func (g CallGraph) Reachable(from string) []string {
+	seen := make(map[string]bool)
+	var visit func(name string)
+	visit = func(name string) {
+		for callee := range g[name] {
+			if _, ok := g[callee]; ok && !seen[callee] {
+				seen[callee] = true
+				visit(callee)
+			}
+		}
+	}
+	visit(from)
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Uncalled returns the sorted names of the functions of the file
+// that are not reachable from its top level.
+func (g CallGraph) Uncalled() []string {
+	reachable := make(map[string]bool)
+	for _, name := range g.Reachable(Toplevel) {
+		reachable[name] = true
+	}
+	var names []string
+	for name := range g {
+		if name != Toplevel && !reachable[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
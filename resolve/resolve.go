@@ -97,20 +97,64 @@ const doesnt = "this Starlark dialect does not "
 // global options
 // These features are either not standard Starlark (yet), or deprecated
 // features of the BUILD language, so we put them behind flags.
+//
+// These variables are the defaults for files whose syntax.File.Options
+// is nil. Because they are shared by the whole process, they must not
+// be modified while any file is being resolved or executed.
+//
+// Deprecated: specify the dialect of each file using syntax.FileOptions,
+// for example by parsing it with FileOptions.Parse, which allows files
+// of different dialects to be used in the same process.
 var (
 	AllowNestedDef      = false // allow def statements within function bodies
 	AllowLambda         = false // allow lambda expressions
-	AllowFloat          = false // allow floating point literals, the 'float' built-in, and x / y
-	AllowSet            = false // allow the 'set' built-in
+	AllowFloat          = false // allow floating point literals, the 'float' built-in, and float results of x / y
+	AllowSet            = false // allow the 'set' and 'frozenset' built-ins
 	AllowGlobalReassign = false // allow reassignment to top-level names; also, allow if/for/while at top-level
 	AllowRecursion      = false // allow while statements and recursive functions
 	AllowBitwise        = true  // obsolete; bitwise operations (&, |, ^, ~, <<, and >>) are always enabled
 	LoadBindsGlobally   = false // load creates global not file-local bindings (deprecated)
 )
 
+// GlobalOptions returns a new FileOptions containing the current
+// values of the global options such as AllowFloat.
+// It is the dialect of a file whose Options are nil.
+func GlobalOptions() *syntax.FileOptions {
+	return &syntax.FileOptions{
+		AllowNestedDef:      AllowNestedDef,
+		AllowLambda:         AllowLambda,
+		AllowFloat:          AllowFloat,
+		AllowSet:            AllowSet,
+		AllowGlobalReassign: AllowGlobalReassign,
+		AllowRecursion:      AllowRecursion,
+		LoadBindsGlobally:   LoadBindsGlobally,
+	}
+}
+
+// fileOptions returns the dialect of the specified file.
+func fileOptions(file *syntax.File) *syntax.FileOptions {
+	if file.Options != nil {
+		return file.Options
+	}
+	return GlobalOptions()
+}
+
+// permitted reports whether the dialect allows references to the
+// specified predeclared or universal name.
+func (r *resolver) permitted(name string) bool {
+	switch name {
+	case "None", "True", "False":
+		return true
+	}
+	return r.options.PermittedPredeclared == nil || r.options.PermittedPredeclared(name)
+}
+
 // File resolves the specified file and records information about the
 // module in file.Module.
 //
+// The dialect of the file is determined by file.Options,
+// or by the global options such as AllowFloat if it is nil.
+//
 // The isPredeclared and isUniversal predicates report whether a name is
 // a pre-declared identifier (visible in the current module) or a
 // universal identifier (visible in every module).
@@ -122,7 +166,7 @@ var (
 // dependency upon pkgscript.Universe, not because users should ever need
 // to redefine it.
 func File(file *syntax.File, isPredeclared, isUniversal func(name string) bool) error {
-	r := newResolver(isPredeclared, isUniversal)
+	r := newResolver(fileOptions(file), isPredeclared, isUniversal)
 	r.stmts(file.Stmts)
 
 	r.env.resolveLocalUses()
@@ -133,8 +177,10 @@ func File(file *syntax.File, isPredeclared, isUniversal func(name string) bool)
 	r.resolveNonLocalUses(r.env)
 
 	file.Module = &Module{
-		Locals:  r.moduleLocals,
-		Globals: r.moduleGlobals,
+		Locals:   r.moduleLocals,
+		Globals:  r.moduleGlobals,
+		Warnings: r.warnings,
+		Options:  r.options,
 	}
 
 	if len(r.errors) > 0 {
@@ -143,12 +189,22 @@ func File(file *syntax.File, isPredeclared, isUniversal func(name string) bool)
 	return nil
 }
 
-// Expr resolves the specified expression.
+// Expr resolves the specified expression in the dialect specified
+// by the global options.
 // It returns the local variables bound within the expression.
 //
 // The isPredeclared and isUniversal predicates behave as for the File function.
 func Expr(expr syntax.Expr, isPredeclared, isUniversal func(name string) bool) ([]*Binding, error) {
-	r := newResolver(isPredeclared, isUniversal)
+	return ExprOptions(GlobalOptions(), expr, isPredeclared, isUniversal)
+}
+
+// ExprOptions resolves the specified expression in the dialect
+// specified by opts, or by the global options if opts is nil.
+func ExprOptions(opts *syntax.FileOptions, expr syntax.Expr, isPredeclared, isUniversal func(name string) bool) ([]*Binding, error) {
+	if opts == nil {
+		opts = GlobalOptions()
+	}
+	r := newResolver(opts, isPredeclared, isUniversal)
 	r.expr(expr)
 	r.env.resolveLocalUses()
 	r.resolveNonLocalUses(r.env) // globals & universals
@@ -171,9 +227,10 @@ type Error struct {
 
 func (e Error) Error() string { return e.Pos.String() + ": " + e.Msg }
 
-func newResolver(isPredeclared, isUniversal func(name string) bool) *resolver {
+func newResolver(opts *syntax.FileOptions, isPredeclared, isUniversal func(name string) bool) *resolver {
 	file := new(block)
 	return &resolver{
+		options:       opts,
 		file:          file,
 		env:           file,
 		isPredeclared: isPredeclared,
@@ -190,6 +247,8 @@ type resolver struct {
 	env  *block
 	file *block // file block (contains load bindings)
 
+	options *syntax.FileOptions // dialect of the file
+
 	// moduleLocals contains the local variables of the module
 	// (due to load statements and comprehensions outside any function).
 	// moduleGlobals contains the global variables of the module.
@@ -207,7 +266,8 @@ type resolver struct {
 
 	loops int // number of enclosing for loops
 
-	errors ErrorList
+	errors   ErrorList
+	warnings []Error
 }
 
 // container returns the innermost enclosing "container" block:
@@ -273,6 +333,10 @@ func (r *resolver) errorf(posn syntax.Position, format string, args ...interface
 	r.errors = append(r.errors, Error{posn, fmt.Sprintf(format, args...)})
 }
 
+func (r *resolver) warnf(posn syntax.Position, format string, args ...interface{}) {
+	r.warnings = append(r.warnings, Error{posn, fmt.Sprintf(format, args...)})
+}
+
 // A use records an identifier and the environment in which it appears.
 type use struct {
 	id  *syntax.Ident
@@ -302,7 +366,7 @@ func (r *resolver) bind(id *syntax.Ident) bool {
 				r.moduleGlobals = append(r.moduleGlobals, bind)
 			}
 		}
-		if ok && !AllowGlobalReassign {
+		if ok && !r.options.AllowGlobalReassign {
 			r.errorf(id.NamePos, "cannot reassign %s %s declared at %s",
 				bind.Scope, id.Name, bind.First.NamePos)
 		}
@@ -370,7 +434,7 @@ func (r *resolver) use(id *syntax.Ident) {
 	// We will piggyback support for the legacy semantics on the
 	// AllowGlobalReassign flag, which is loosely related and also
 	// required for Bazel.
-	if AllowGlobalReassign && r.env == r.file {
+	if r.options.AllowGlobalReassign && r.env == r.file {
 		r.useToplevel(use)
 		return
 	}
@@ -399,10 +463,10 @@ func (r *resolver) useToplevel(use use) (bind *Binding) {
 		r.predeclared[id.Name] = bind // save it
 	} else if r.isUniversal(id.Name) {
 		// use of universal name
-		if !AllowFloat && id.Name == "float" {
-			r.errorf(id.NamePos, doesnt+"support floating point")
+		if !r.options.AllowFloat && id.Name == "float" {
+			r.errorf(id.NamePos, "floating point not enabled")
 		}
-		if !AllowSet && id.Name == "set" {
+		if !r.options.AllowSet && (id.Name == "set" || id.Name == "frozenset") {
 			r.errorf(id.NamePos, doesnt+"support sets")
 		}
 		bind = &Binding{Scope: Universal}
@@ -415,6 +479,9 @@ func (r *resolver) useToplevel(use use) (bind *Binding) {
 		}
 		r.errorf(id.NamePos, "undefined: %s%s", id.Name, hint)
 	}
+	if (bind.Scope == Predeclared || bind.Scope == Universal) && !r.permitted(id.Name) {
+		r.errorf(id.NamePos, "use of %s is not permitted", id.Name)
+	}
 	id.Binding = bind
 	return bind
 }
@@ -458,11 +525,41 @@ func (b *block) resolveLocalUses() {
 }
 
 func (r *resolver) stmts(stmts []syntax.Stmt) {
-	for _, stmt := range stmts {
+	for i, stmt := range stmts {
 		r.stmt(stmt)
+		// Statements after a return, break, or continue are
+		// unreachable. They are resolved nonetheless, so that
+		// their errors are reported, but not compiled.
+		if i+1 < len(stmts) && Terminates(stmt) {
+			r.warnf(syntax.Start(stmts[i+1]), "unreachable code after %s", terminator(stmt))
+			for _, stmt := range stmts[i+1:] {
+				r.stmt(stmt)
+			}
+			break
+		}
 	}
 }
 
+// Terminates reports whether stmt is a return, break, or continue
+// statement, after which no statement of the same block is executed.
+func Terminates(stmt syntax.Stmt) bool {
+	switch stmt := stmt.(type) {
+	case *syntax.ReturnStmt:
+		return true
+	case *syntax.BranchStmt:
+		return stmt.Token != syntax.PASS
+	}
+	return false
+}
+
+// terminator returns the keyword of a statement for which Terminates is true.
+func terminator(stmt syntax.Stmt) string {
+	if stmt, ok := stmt.(*syntax.BranchStmt); ok {
+		return stmt.Token.String()
+	}
+	return "return"
+}
+
 func (r *resolver) stmt(stmt syntax.Stmt) {
 	switch stmt := stmt.(type) {
 	case *syntax.ExprStmt:
@@ -474,7 +571,7 @@ func (r *resolver) stmt(stmt syntax.Stmt) {
 		}
 
 	case *syntax.IfStmt:
-		if !AllowGlobalReassign && r.container().function == nil {
+		if !r.options.AllowGlobalReassign && r.container().function == nil {
 			r.errorf(stmt.If, "if statement not within a function")
 		}
 		r.expr(stmt.Cond)
@@ -486,8 +583,13 @@ func (r *resolver) stmt(stmt syntax.Stmt) {
 		isAugmented := stmt.Op != syntax.EQ
 		r.assign(stmt.LHS, isAugmented)
 
+	case *syntax.DelStmt:
+		for _, target := range stmt.Targets {
+			r.del(target)
+		}
+
 	case *syntax.DefStmt:
-		if !AllowNestedDef && r.container().function != nil {
+		if !r.options.AllowNestedDef && r.container().function != nil {
 			r.errorf(stmt.Def, doesnt+"support nested def")
 		}
 		r.bind(stmt.Name)
@@ -501,7 +603,7 @@ func (r *resolver) stmt(stmt syntax.Stmt) {
 		r.function(fn, stmt.Def)
 
 	case *syntax.ForStmt:
-		if !AllowGlobalReassign && r.container().function == nil {
+		if !r.options.AllowGlobalReassign && r.container().function == nil {
 			r.errorf(stmt.For, "for loop not within a function")
 		}
 		r.expr(stmt.X)
@@ -510,18 +612,20 @@ func (r *resolver) stmt(stmt syntax.Stmt) {
 		r.loops++
 		r.stmts(stmt.Body)
 		r.loops--
+		r.stmts(stmt.Else)
 
 	case *syntax.WhileStmt:
-		if !AllowRecursion {
+		if !r.options.AllowRecursion {
 			r.errorf(stmt.While, doesnt+"support while loops")
 		}
-		if !AllowGlobalReassign && r.container().function == nil {
+		if !r.options.AllowGlobalReassign && r.container().function == nil {
 			r.errorf(stmt.While, "while loop not within a function")
 		}
 		r.expr(stmt.Cond)
 		r.loops++
 		r.stmts(stmt.Body)
 		r.loops--
+		r.stmts(stmt.Else)
 
 	case *syntax.ReturnStmt:
 		if r.container().function == nil {
@@ -545,9 +649,9 @@ func (r *resolver) stmt(stmt syntax.Stmt) {
 			}
 
 			id := stmt.To[i]
-			if LoadBindsGlobally {
+			if r.options.LoadBindsGlobally {
 				r.bind(id)
-			} else if r.bindLocal(id) && !AllowGlobalReassign {
+			} else if r.bindLocal(id) && !r.options.AllowGlobalReassign {
 				// "Global" in AllowGlobalReassign is a misnomer for "toplevel".
 				// Sadly we can't report the previous declaration
 				// as id.Binding may not be set yet.
@@ -608,14 +712,70 @@ func (r *resolver) assign(lhs syntax.Expr, isAugmented bool) {
 	}
 }
 
+func (r *resolver) del(target syntax.Expr) {
+	switch target := target.(type) {
+	case *syntax.Ident:
+		// del x
+		if !r.options.AllowGlobalReassign && r.env == r.file {
+			r.errorf(target.NamePos, "cannot delete top-level variable %s", target.Name)
+			r.use(target)
+			return
+		}
+		r.bind(target)
+
+	case *syntax.IndexExpr:
+		// del x[i]
+		r.expr(target.X)
+		r.expr(target.Y)
+
+	case *syntax.DotExpr:
+		// del x.f
+		r.expr(target.X)
+
+	case *syntax.ParenExpr:
+		r.del(target.X)
+
+	default:
+		r.errorf(syntax.Start(target), "can't delete %s", describe(target))
+	}
+}
+
+// describe returns a phrase describing the kind of expression e,
+// for use in error messages.
+func describe(e syntax.Expr) string {
+	switch e.(type) {
+	case *syntax.Literal:
+		return "literal"
+	case *syntax.CallExpr:
+		return "function call"
+	case *syntax.ListExpr:
+		return "list expression"
+	case *syntax.TupleExpr:
+		return "tuple expression"
+	case *syntax.DictExpr:
+		return "dict expression"
+	case *syntax.Comprehension:
+		return "comprehension"
+	case *syntax.LambdaExpr:
+		return "lambda"
+	case *syntax.SliceExpr:
+		return "slice"
+	case *syntax.CondExpr:
+		return "conditional expression"
+	case *syntax.UnaryExpr, *syntax.BinaryExpr:
+		return "operator expression"
+	}
+	return "expression"
+}
+
 func (r *resolver) expr(e syntax.Expr) {
 	switch e := e.(type) {
 	case *syntax.Ident:
 		r.use(e)
 
 	case *syntax.Literal:
-		if !AllowFloat && e.Token == syntax.FLOAT {
-			r.errorf(e.TokenPos, doesnt+"support floating point")
+		if !r.options.AllowFloat && e.Token == syntax.FLOAT {
+			r.errorf(e.TokenPos, "floating point not enabled")
 		}
 
 	case *syntax.RenderExpr:
@@ -696,9 +856,6 @@ func (r *resolver) expr(e syntax.Expr) {
 		r.expr(e.X)
 
 	case *syntax.BinaryExpr:
-		if !AllowFloat && e.Op == syntax.SLASH {
-			r.errorf(e.OpPos, doesnt+"support floating point (use //)")
-		}
 		r.expr(e.X)
 		r.expr(e.Y)
 
@@ -770,7 +927,7 @@ func (r *resolver) expr(e syntax.Expr) {
 		}
 
 	case *syntax.LambdaExpr:
-		if !AllowLambda {
+		if !r.options.AllowLambda {
 			r.errorf(e.Lambda, doesnt+"support lambda")
 		}
 		fn := &Function{
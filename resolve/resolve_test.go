@@ -5,6 +5,8 @@
 package resolve_test
 
 import (
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
 
@@ -50,6 +52,51 @@ func TestResolve(t *testing.T) {
 	}
 }
 
+func TestFloatDisabledPosition(t *testing.T) {
+	file, err := syntax.Parse("foo.star", "x = 1 + 1.5\ny = 3 / 2\n", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = resolve.File(file, isPredeclared, isUniversal)
+	want := []string{
+		"foo.star:1:9: floating point not enabled",
+	}
+	errs, ok := err.(resolve.ErrorList)
+	if !ok || len(errs) != len(want) {
+		t.Fatalf("got errors %v, want %d errors", err, len(want))
+	}
+	for i, err := range errs {
+		if got := err.Error(); got != want[i] {
+			t.Errorf("error %d = %q, want %q", i, got, want[i])
+		}
+	}
+}
+
+func TestPermittedPredeclared(t *testing.T) {
+	opts := &syntax.FileOptions{
+		PermittedPredeclared: func(name string) bool { return name == "M" },
+	}
+	const src = "x = M(1)\ny = U(2)\ndef f(): return U + M\n"
+	file, err := opts.Parse("foo.star", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = resolve.File(file, isPredeclared, isUniversal)
+	want := []string{
+		"foo.star:3:17: use of U is not permitted",
+		"foo.star:2:5: use of U is not permitted",
+	}
+	errs, ok := err.(resolve.ErrorList)
+	if !ok || len(errs) != len(want) {
+		t.Fatalf("got errors %v, want %d errors", err, len(want))
+	}
+	for i, err := range errs {
+		if got := err.Error(); got != want[i] {
+			t.Errorf("error %d = %q, want %q", i, got, want[i])
+		}
+	}
+}
+
 func TestDefVarargsAndKwargsSet(t *testing.T) {
 	source := "def f(*args, **kwargs): pass\n"
 	file, err := syntax.Parse("foo.star", source, 0)
@@ -87,6 +134,121 @@ func TestLambdaVarargsAndKwargsSet(t *testing.T) {
 	}
 }
 
+func TestUnreachableCode(t *testing.T) {
+	const source = `
+def f(x):
+    for y in x:
+        if y:
+            continue
+            print(y)
+        break
+    return x
+    x += 1
+    return x
+
+def g():
+    pass
+    return
+`
+	file, err := syntax.Parse("foo.star", source, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resolve.File(file, isPredeclared, func(name string) bool { return name == "print" }); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, w := range file.Module.(*resolve.Module).Warnings {
+		got = append(got, w.Error())
+	}
+	want := "foo.star:6:13: unreachable code after continue; foo.star:9:5: unreachable code after return"
+	if strings.Join(got, "; ") != want {
+		t.Errorf("got warnings %q, want %q", got, want)
+	}
+
+	// Unreachable code is resolved nonetheless.
+	file, err = syntax.Parse("foo.star", "def f():\n    return\n    undefined\n", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resolve.File(file, isPredeclared, isUniversal); err == nil || err.Error() != "foo.star:3:5: undefined: undefined" {
+		t.Errorf("got error %v, want undefined name in unreachable code", err)
+	}
+}
+
+func TestCallGraph(t *testing.T) {
+	defer setOptions("")
+	setOptions("option:nesteddef option:lambda option:recursion")
+
+	const source = `
+def main(args):
+    check(args)
+    return M.sort([helper(x) for x in args], key = key)
+
+def key(x):
+    return x
+
+def helper(x, f = default()):
+    def inner(y):
+        return inner(y - 1) if y else M.log(y)
+    return inner(x) + (lambda: len(x))()
+
+def check(args):
+    for a in args:
+        a()
+
+def default():
+    return 0
+
+def unused():
+    helper(1)
+
+def unused2():
+    unused2()
+
+main([])
+`
+	file, err := syntax.Parse("foo.star", source, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resolve.File(file, isPredeclared, func(name string) bool { return name == "len" }); err != nil {
+		t.Fatal(err)
+	}
+	graph := resolve.NewCallGraph(file)
+	var got []string
+	for caller, callees := range graph {
+		var names []string
+		for callee := range callees {
+			names = append(names, callee)
+		}
+		sort.Strings(names)
+		got = append(got, caller+" -> "+strings.Join(names, " "))
+	}
+	sort.Strings(got)
+	want := []string{
+		"<toplevel> -> default main",
+		"check -> <dynamic>",
+		"default -> ",
+		"helper -> <dynamic> helper.inner len",
+		"helper.inner -> M.log helper.inner",
+		"key -> ",
+		"main -> M.sort check helper key",
+		"unused -> helper",
+		"unused2 -> unused2",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("call graph:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if got, want := strings.Join(graph.Reachable("main"), " "), "check helper helper.inner key"; got != want {
+		t.Errorf("Reachable(main) = %s, want %s", got, want)
+	}
+	if got, want := strings.Join(graph.Uncalled(), " "), "unused unused2"; got != want {
+		t.Errorf("Uncalled() = %s, want %s", got, want)
+	}
+}
+
 func isPredeclared(name string) bool { return name == "M" }
 
 func isUniversal(name string) bool { return name == "U" || name == "float" }
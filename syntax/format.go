@@ -0,0 +1,495 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package syntax
+
+// This file defines the unparser, which prints a syntax tree
+// as Starlark source in a canonical layout.
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// Format returns the canonical source form of a parsed file.
+//
+// Statements are printed one per line with four-space indentation,
+// and operators and separators are spaced uniformly. A list, dict,
+// call, or parenthesized tuple that spans several lines in the
+// original is printed with one element per line and a trailing
+// comma; otherwise it is printed on a single line. At most one
+// blank line is kept between statements. Comments are printed only
+// if the file was parsed with RetainComments.
+//
+// Formatting is idempotent: formatting the result of Format again
+// yields the same text.
+func Format(f *File) []byte {
+	p := new(printer)
+	p.stmts(f.Stmts, nil)
+	if c := f.Comments(); c != nil && len(c.After) > 0 {
+		if len(f.Stmts) > 0 && c.After[0].Start.Line > lastLine(f.Stmts[len(f.Stmts)-1])+1 {
+			p.newline()
+		}
+		p.comments(c.After, 0)
+	}
+	return p.buf.Bytes()
+}
+
+// Unparse returns the canonical source form of an expression,
+// on a single line. Comments are not printed.
+func Unparse(e Expr) string {
+	p := &printer{inline: true}
+	p.expr(e)
+	return p.buf.String()
+}
+
+// A printer accumulates formatted source text.
+type printer struct {
+	buf       bytes.Buffer
+	indent    int       // current indentation level
+	lineStart bool      // no text has been written on the current line
+	pending   []Comment // end-of-line comments awaiting the next newline
+	inline    bool      // force single-line layout and discard comments
+}
+
+func (p *printer) print(s string) {
+	if p.buf.Len() == 0 || p.lineStart {
+		p.buf.WriteString(strings.Repeat("    ", p.indent))
+		p.lineStart = false
+	}
+	p.buf.WriteString(s)
+}
+
+// newline ends the current line, first printing any pending comments.
+func (p *printer) newline() {
+	for _, c := range p.pending {
+		p.print("  " + c.Text)
+	}
+	p.pending = nil
+	p.buf.WriteByte('\n')
+	p.lineStart = true
+}
+
+// comments prints whole-line comments, each on its own line,
+// preserving single blank lines between them and before the
+// following source line, next (if nonzero).
+func (p *printer) comments(list []Comment, next int32) {
+	for i, c := range list {
+		p.print(c.Text)
+		p.newline()
+		if i+1 < len(list) {
+			if list[i+1].Start.Line > c.Start.Line+1 {
+				p.newline()
+			}
+		} else if next > c.Start.Line+1 {
+			p.newline()
+		}
+	}
+}
+
+// before prints the whole-line comments that precede node n.
+// If n is not at the start of a line, they are deferred to its end.
+func (p *printer) before(n Node) {
+	if c := n.Comments(); c != nil && !p.inline {
+		if p.lineStart || p.buf.Len() == 0 {
+			p.comments(c.Before, Start(n).Line)
+		} else {
+			p.pending = append(p.pending, c.Before...)
+		}
+	}
+}
+
+// suffix defers the end-of-line comments of node n to the next newline.
+func (p *printer) suffix(n Node) {
+	if c := n.Comments(); c != nil && !p.inline {
+		p.pending = append(p.pending, c.Suffix...)
+	}
+}
+
+// firstLine returns the first source line of statement s,
+// including its leading comments.
+func firstLine(s Stmt) int32 {
+	if c := s.Comments(); c != nil && len(c.Before) > 0 {
+		return c.Before[0].Start.Line
+	}
+	return Start(s).Line
+}
+
+// lastLine returns the last source line of statement s.
+func lastLine(s Stmt) int32 {
+	return End(s).Line
+}
+
+// stmts prints a block of statements.
+// The trailing comments belong to the end of the block's last line.
+func (p *printer) stmts(stmts []Stmt, trailing []Comment) {
+	for i, s := range stmts {
+		if i > 0 && firstLine(s) > lastLine(stmts[i-1])+1 {
+			p.newline() // preserve one blank line
+		}
+		var t []Comment
+		if i == len(stmts)-1 {
+			t = trailing
+		}
+		p.stmt(s, t)
+	}
+}
+
+// suite prints the indented body of a compound statement.
+func (p *printer) suite(body []Stmt, trailing []Comment) {
+	p.indent++
+	p.stmts(body, trailing)
+	p.indent--
+}
+
+// loopSuites prints the body of a loop and its else clause, if any.
+func (p *printer) loopSuites(body, els []Stmt, trailing []Comment) {
+	if len(els) == 0 {
+		p.suite(body, trailing)
+		return
+	}
+	p.suite(body, nil)
+	p.print("else:")
+	p.newline()
+	p.suite(els, trailing)
+}
+
+func (p *printer) stmt(s Stmt, trailing []Comment) {
+	p.before(s)
+
+	// The end-of-line comments of a compound statement
+	// belong to its last line, which is in its body.
+	if c := s.Comments(); c != nil {
+		trailing = append(append([]Comment(nil), c.Suffix...), trailing...)
+	}
+
+	switch s := s.(type) {
+	case *ExprStmt:
+		p.expr(s.X)
+
+	case *AssignStmt:
+		p.expr(s.LHS)
+		p.print(" " + s.Op.String() + " ")
+		p.expr(s.RHS)
+
+	case *BranchStmt:
+		p.print(s.Token.String())
+
+	case *DelStmt:
+		p.print("del ")
+		p.exprList(s.Targets)
+
+	case *ReturnStmt:
+		p.print("return")
+		if s.Result != nil {
+			p.print(" ")
+			p.expr(s.Result)
+		}
+
+	case *LoadStmt:
+		p.load(s)
+
+	case *DefStmt:
+		p.print("def ")
+		p.expr(s.Name)
+		p.print("(")
+		p.exprList(s.Params)
+		p.print("):")
+		p.newline()
+		p.suite(s.Body, trailing)
+		return
+
+	case *IfStmt:
+		p.print("if ")
+		for {
+			p.expr(s.Cond)
+			p.print(":")
+			p.newline()
+			if len(s.False) == 0 {
+				p.suite(s.True, trailing)
+				return
+			}
+			p.suite(s.True, nil)
+
+			// An elif clause is an IfStmt whose position is the ElsePos.
+			if elif, ok := s.False[0].(*IfStmt); ok && len(s.False) == 1 && elif.If == s.ElsePos {
+				p.before(elif)
+				if c := elif.Comments(); c != nil {
+					trailing = append(append([]Comment(nil), c.Suffix...), trailing...)
+				}
+				p.print("elif ")
+				s = elif
+				continue
+			}
+			p.print("else:")
+			p.newline()
+			p.suite(s.False, trailing)
+			return
+		}
+
+	case *ForStmt:
+		p.print("for ")
+		p.expr(s.Vars)
+		p.print(" in ")
+		p.expr(s.X)
+		p.print(":")
+		p.newline()
+		p.loopSuites(s.Body, s.Else, trailing)
+		return
+
+	case *WhileStmt:
+		p.print("while ")
+		p.expr(s.Cond)
+		p.print(":")
+		p.newline()
+		p.loopSuites(s.Body, s.Else, trailing)
+		return
+
+	default:
+		panic("unexpected statement")
+	}
+
+	p.pending = append(p.pending, trailing...)
+	p.newline()
+}
+
+func (p *printer) load(s *LoadStmt) {
+	var args []string
+	for i, to := range s.To {
+		from := strconv.Quote(s.From[i].Name)
+		if to.Name == s.From[i].Name {
+			args = append(args, from)
+		} else {
+			args = append(args, to.Name+"="+from)
+		}
+	}
+	p.print("load(")
+	if p.multiline(s.Load, s.Rparen) {
+		p.indent++
+		p.newline()
+		p.expr(s.Module)
+		p.print(",")
+		p.newline()
+		for _, arg := range args {
+			p.print(arg + ",")
+			p.newline()
+		}
+		p.indent--
+	} else {
+		p.expr(s.Module)
+		for _, arg := range args {
+			p.print(", " + arg)
+		}
+	}
+	p.print(")")
+}
+
+// multiline reports whether a bracketed construct spanning
+// the positions open to close should be printed one element per line.
+func (p *printer) multiline(open, close Position) bool {
+	return !p.inline && close.Line > open.Line
+}
+
+// exprList prints a comma-separated list on the current line.
+func (p *printer) exprList(list []Expr) {
+	for i, x := range list {
+		if i > 0 {
+			p.print(", ")
+		}
+		p.expr(x)
+	}
+}
+
+// elems prints the elements of a bracketed list, tuple, dict, or
+// call, between the already-printed open bracket and the close bracket.
+// Element separation depends on the layout of the original source.
+// A trailing comma is required after the sole element of a tuple,
+// and forbidden after a *args or **kwargs argument.
+func (p *printer) elems(list []Expr, open, close Position, tuple bool) {
+	if !p.multiline(open, close) || len(list) == 0 {
+		p.exprList(list)
+		if tuple && len(list) == 1 {
+			p.print(",")
+		}
+		return
+	}
+
+	trailingComma := true
+	for _, x := range list {
+		if u, ok := x.(*UnaryExpr); ok && (u.Op == STAR || u.Op == STARSTAR) {
+			trailingComma = false
+		}
+	}
+
+	p.indent++
+	p.newline()
+	for i, x := range list {
+		p.expr(x)
+		if i < len(list)-1 || trailingComma {
+			p.print(",")
+		}
+		p.newline()
+	}
+	p.indent--
+}
+
+func (p *printer) expr(e Expr) {
+	p.before(e)
+	defer p.suffix(e)
+
+	switch e := e.(type) {
+	case *Ident:
+		p.print(e.Name)
+
+	case *Literal:
+		if e.Raw != "" {
+			p.print(e.Raw)
+		} else if s, ok := e.Value.(string); ok {
+			p.print(strconv.Quote(s))
+		}
+
+	case *RenderExpr:
+		// The raw text of each literal chunk includes
+		// the delimiters of the adjacent expressions.
+		for i, chunk := range e.Chunks {
+			if i%2 == 0 {
+				p.print(chunk.(*Literal).Raw)
+			} else {
+				p.expr(chunk)
+			}
+		}
+
+	case *ParenExpr:
+		p.print("(")
+		if tuple, ok := e.X.(*TupleExpr); ok {
+			p.elems(tuple.List, e.Lparen, e.Rparen, true)
+		} else {
+			p.expr(e.X)
+		}
+		p.print(")")
+
+	case *TupleExpr:
+		if len(e.List) == 0 {
+			p.print("()")
+		} else {
+			p.exprList(e.List)
+			if len(e.List) == 1 {
+				p.print(",")
+			}
+		}
+
+	case *ListExpr:
+		p.print("[")
+		p.elems(e.List, e.Lbrack, e.Rbrack, false)
+		p.print("]")
+
+	case *DictExpr:
+		p.print("{")
+		p.elems(e.List, e.Lbrace, e.Rbrace, false)
+		p.print("}")
+
+	case *DictEntry:
+		p.expr(e.Key)
+		p.print(": ")
+		p.expr(e.Value)
+
+	case *Comprehension:
+		if e.Curly {
+			p.print("{")
+		} else {
+			p.print("[")
+		}
+		p.expr(e.Body)
+		for _, clause := range e.Clauses {
+			switch clause := clause.(type) {
+			case *ForClause:
+				p.print(" for ")
+				p.expr(clause.Vars)
+				p.print(" in ")
+				p.expr(clause.X)
+			case *IfClause:
+				p.print(" if ")
+				p.expr(clause.Cond)
+			}
+		}
+		if e.Curly {
+			p.print("}")
+		} else {
+			p.print("]")
+		}
+
+	case *CondExpr:
+		p.expr(e.True)
+		p.print(" if ")
+		p.expr(e.Cond)
+		p.print(" else ")
+		p.expr(e.False)
+
+	case *LambdaExpr:
+		p.print("lambda")
+		if len(e.Params) > 0 {
+			p.print(" ")
+			p.exprList(e.Params)
+		}
+		p.print(": ")
+		p.expr(e.Body)
+
+	case *UnaryExpr:
+		switch e.Op {
+		case NOT:
+			p.print("not ")
+		default:
+			p.print(e.Op.String())
+		}
+		if e.X != nil {
+			p.expr(e.X)
+		}
+
+	case *BinaryExpr:
+		p.expr(e.X)
+		if e.Op == EQ {
+			p.print("=") // named argument or parameter
+		} else {
+			p.print(" " + e.Op.String() + " ")
+		}
+		p.expr(e.Y)
+
+	case *DotExpr:
+		p.expr(e.X)
+		p.print(".")
+		p.expr(e.Name)
+
+	case *CallExpr:
+		p.expr(e.Fn)
+		p.print("(")
+		p.elems(e.Args, e.Lparen, e.Rparen, false)
+		p.print(")")
+
+	case *IndexExpr:
+		p.expr(e.X)
+		p.print("[")
+		p.expr(e.Y)
+		p.print("]")
+
+	case *SliceExpr:
+		p.expr(e.X)
+		p.print("[")
+		if e.Lo != nil {
+			p.expr(e.Lo)
+		}
+		p.print(":")
+		if e.Hi != nil {
+			p.expr(e.Hi)
+		}
+		if e.Step != nil {
+			p.print(":")
+			p.expr(e.Step)
+		}
+		p.print("]")
+
+	default:
+		panic("unexpected expression")
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package syntax_test
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/andrewchambers/pkgscript/pkgscripttest"
+	"github.com/andrewchambers/pkgscript/syntax"
+)
+
+func TestFormat(t *testing.T) {
+	for _, test := range []struct {
+		input, want string
+	}{
+		{`x=1`, "x = 1\n"},
+		{`x+=f(a,b=2,*c,**d)`, "x += f(a, b=2, *c, **d)\n"},
+		{`a=1;b=2`, "a = 1\nb = 2\n"},
+		{`if x: pass`, "if x:\n    pass\n"},
+		{`del  x,d[k] ,x.f`, "del x, d[k], x.f\n"},
+		{"if a:\n  x\nelif b:\n  y\nelse:\n  z", "if a:\n    x\nelif b:\n    y\nelse:\n    z\n"},
+		{"if a:\n  x\nelse:\n  if b:\n    y", "if a:\n    x\nelse:\n    if b:\n        y\n"},
+		{"def f(a,b=1,*args,**kwargs):\n  return a", "def f(a, b=1, *args, **kwargs):\n    return a\n"},
+		{"for x,y in z:\n continue", "for x, y in z:\n    continue\n"},
+		{"while not x :\n\tbreak", "while not x:\n    break\n"},
+		{"for x in y:\n  break\nelse:\n  z", "for x in y:\n    break\nelse:\n    z\n"},
+		{"while x:\n  break\nelse:\n  z # c", "while x:\n    break\nelse:\n    z  # c\n"},
+		{`load("m.star","a",b="c")`, "load(\"m.star\", \"a\", b=\"c\")\n"},
+		{`x = [a for a in b if a>1]`, "x = [a for a in b if a > 1]\n"},
+		{`x = {k:v for k,v in d.items()}`, "x = {k: v for k, v in d.items()}\n"},
+		{`x = a if b else c`, "x = a if b else c\n"},
+		{`f = lambda x,y=2: x*y`, "f = lambda x, y=2: x * y\n"},
+		{`g = lambda: None`, "g = lambda: None\n"},
+		{`x = (1,)`, "x = (1,)\n"},
+		{`x = ()`, "x = ()\n"},
+		{`x, y = y, x`, "x, y = y, x\n"},
+		{`x = a[1:2] + a[::3] + a[i]`, "x = a[1:2] + a[::3] + a[i]\n"},
+		{`x = -a + ~b - (c or d) not in e`, "x = -a + ~b - (c or d) not in e\n"},
+		{`x = {"a":1,'b':2}`, "x = {\"a\": 1, 'b': 2}\n"},
+		{"x = [\n  1, 2,\n  3]", "x = [\n    1,\n    2,\n    3,\n]\n"},
+		{"f(\n  a,\n  *b)", "f(\n    a,\n    *b\n)\n"},
+		{"x = (\n  1, 2)", "x = (\n    1,\n    2,\n)\n"},
+		{"a = 1\n\n\n\nb = 2", "a = 1\n\nb = 2\n"},
+		{"# head\nx = 1 # one\n\n# tail", "# head\nx = 1  # one\n\n# tail\n"},
+		{"def f():\n    # body\n    return 1  # ret\n", "def f():\n    # body\n    return 1  # ret\n"},
+		{"x = [\n    # first\n    1,  # one\n    2,\n]", "x = [\n    # first\n    1,  # one\n    2,\n]\n"},
+	} {
+		f, err := syntax.Parse("in.star", test.input, syntax.RetainComments)
+		if err != nil {
+			t.Errorf("parse `%s` failed: %v", test.input, stripPos(err))
+			continue
+		}
+		if got := string(syntax.Format(f)); got != test.want {
+			t.Errorf("Format(%q) = %q, want %q", test.input, got, test.want)
+		}
+	}
+}
+
+func TestUnparse(t *testing.T) {
+	for _, test := range []struct {
+		input, want string
+	}{
+		{`a+b*c`, `a + b * c`},
+		{"[\n1,\n2]", `[1, 2]`},
+		{`f(x)(y).z[0]`, `f(x)(y).z[0]`},
+		{`x if not y else [z]`, `x if not y else [z]`},
+	} {
+		e, err := syntax.ParseExpr("in.star", test.input, 0)
+		if err != nil {
+			t.Errorf("parse `%s` failed: %v", test.input, stripPos(err))
+			continue
+		}
+		if got := syntax.Unparse(e); got != test.want {
+			t.Errorf("Unparse(%q) = %q, want %q", test.input, got, test.want)
+		}
+	}
+}
+
+// messy is valid but poorly laid out Starlark source.
+const messy = `# A messy file.
+load( "lib.star" , "a",b = "c" )   # imports
+
+
+def  f( x,y = [1,2 ,3],* args , ** kwargs ) :
+  """doc"""
+  if x : return  y
+  elif  not x :
+        pass
+  else :
+      for i , j in  enumerate( args ) : print(i,j)
+  return {  'k' :x ,"v":[ y [ 1 : ] , y[::2]] }  # result
+z = [  # list
+  1 ,
+     2 , # two
+  (3, )
+]
+w = ( a if b else c ) ; v = lambda  q : -q
+s = ` + "```" + `
+    hello {name} ` + "`{" + `x}
+    ` + "```" + `
+# end
+`
+
+// TestFormatIdempotent checks that formatting preserves the syntax
+// tree of a file, and that formatting formatted output is a no-op.
+func TestFormatIdempotent(t *testing.T) {
+	check := func(filename, src string, mustParse bool) {
+		f, err := syntax.Parse(filename, src, syntax.RetainComments)
+		if err != nil {
+			if mustParse {
+				t.Errorf("%s: %v", filename, err)
+			}
+			return // not all test inputs are valid
+		}
+		once := syntax.Format(f)
+		g, err := syntax.Parse(filename, once, syntax.RetainComments)
+		if err != nil {
+			t.Errorf("%s: formatted output does not parse: %v\n%s", filename, err, once)
+			return
+		}
+		if got, want := treeString(g), treeString(f); got != want {
+			t.Errorf("%s: formatting changed the syntax tree:\ngot  %s\nwant %s", filename, got, want)
+		}
+		if twice := syntax.Format(g); string(twice) != string(once) {
+			t.Errorf("%s: formatting is not idempotent:\n--- once ---\n%s\n--- twice ---\n%s", filename, once, twice)
+		}
+	}
+
+	check("messy.star", messy, true)
+
+	for _, file := range []string{
+		"syntax/testdata/scan.star",
+		"pkgscript/testdata/builtins.star",
+		"pkgscript/testdata/control.star",
+		"pkgscript/testdata/function.star",
+		"pkgscript/testdata/string.star",
+	} {
+		filename := pkgscripttest.DataFile("", file)
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, chunk := range strings.Split(string(data), "\n---\n") {
+			check(filename, chunk, false)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2019 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package syntax
+
+// FileOptions specifies the dialect of Starlark accepted in a file.
+//
+// These features are either not standard Starlark (yet), or deprecated
+// features of the BUILD language. The zero value enables none of them.
+//
+// The resolver consults the Options of a File, if non-nil, in place
+// of the global variables of the resolve package, so that files of
+// different dialects may be resolved concurrently.
+type FileOptions struct {
+	AllowNestedDef      bool // allow def statements within function bodies
+	AllowLambda         bool // allow lambda expressions
+	AllowFloat          bool // allow floating point literals, the 'float' built-in, and float results of x / y
+	AllowSet            bool // allow the 'set' and 'frozenset' built-ins
+	AllowGlobalReassign bool // allow reassignment to top-level names; also, allow if/for/while at top-level
+	AllowRecursion      bool // allow while statements and recursive functions
+	LoadBindsGlobally   bool // load creates global not file-local bindings (deprecated)
+
+	// PermittedPredeclared, if non-nil, reports whether the file may
+	// refer to the specified predeclared or universal name. The resolver
+	// reports each reference to a name for which it returns false as an
+	// error, allowing applications to reject programs that use forbidden
+	// built-ins before they run. None, True, and False are always permitted.
+	PermittedPredeclared func(name string) bool
+}
+
+// Parse parses the input data as by the Parse function, and records
+// opts as the dialect of the resulting file.
+// A nil *FileOptions denotes the resolver's global defaults.
+func (opts *FileOptions) Parse(filename string, src interface{}, mode Mode) (*File, error) {
+	f, err := Parse(filename, src, mode)
+	if err != nil {
+		return nil, err
+	}
+	f.Options = opts
+	return f, nil
+}
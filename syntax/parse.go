@@ -11,7 +11,11 @@ package syntax
 // package.  Verify that error positions are correct using the
 // chunkedfile mechanism.
 
-import "log"
+import (
+	"bufio"
+	"io"
+	"log"
+)
 
 // Enable this flag to print the token stream and log.Fatal on the first error.
 const debug = false
@@ -82,6 +86,66 @@ func ParseCompoundStmt(filename string, readline func() ([]byte, error)) (f *Fil
 	return &File{Path: filename, Stmts: stmts}, nil
 }
 
+// ParseStmts parses a file one top-level statement at a time, calling
+// fn for each, with a File containing just that statement, or the
+// semicolon-separated list of simple statements on one line.
+// ParseStmts stops at the first syntax error, or the first error
+// returned by fn, and returns that error.
+//
+// Unlike Parse, ParseStmts does not retain the syntax tree of the
+// whole file, and if src is an io.Reader, it reads the input one line
+// at a time as needed. Otherwise, src is interpreted as by Parse.
+// Comments are discarded.
+func ParseStmts(filename string, src interface{}, fn func(f *File) error) error {
+	var in *scanner
+	if r, ok := src.(io.Reader); ok {
+		in, _ = newScanner(filename, []byte(nil), false) // can't fail
+		in.reader = bufio.NewReader(r)
+	} else {
+		var err error
+		in, err = newScanner(filename, src, false)
+		if err != nil {
+			return err
+		}
+	}
+	p := parser{in: in}
+	if err := p.start(); err != nil {
+		return err
+	}
+	for {
+		stmts, err := p.nextStmts()
+		if err != nil {
+			return err
+		}
+		if stmts == nil {
+			return nil // EOF
+		}
+		if err := fn(&File{Path: filename, Stmts: stmts}); err != nil {
+			return err
+		}
+	}
+}
+
+// start reads the first lookahead token.
+func (p *parser) start() (err error) {
+	defer p.in.recover(&err)
+	p.nextToken()
+	return nil
+}
+
+// nextStmts parses the next line of simple statements,
+// or compound statement. It returns nil at end of file.
+func (p *parser) nextStmts() (stmts []Stmt, err error) {
+	defer p.in.recover(&err)
+	for p.tok == NEWLINE {
+		p.nextToken()
+	}
+	if p.tok == EOF {
+		return nil, nil
+	}
+	return p.parseStmt(nil), nil
+}
+
 // ParseExpr parses a Starlark expression.
 // A comma-separated list of expressions is parsed as a tuple.
 // See Parse for explanation of parameters.
@@ -211,12 +275,18 @@ func (p *parser) parseForStmt() Stmt {
 	x := p.parseExpr(false)
 	p.consume(COLON)
 	body := p.parseSuite()
-	return &ForStmt{
+	forStmt := &ForStmt{
 		For:  forpos,
 		Vars: vars,
 		X:    x,
 		Body: body,
 	}
+	if p.tok == ELSE {
+		forStmt.ElsePos = p.nextToken() // consume ELSE
+		p.consume(COLON)
+		forStmt.Else = p.parseSuite()
+	}
+	return forStmt
 }
 
 func (p *parser) parseWhileStmt() Stmt {
@@ -224,11 +294,17 @@ func (p *parser) parseWhileStmt() Stmt {
 	cond := p.parseTest()
 	p.consume(COLON)
 	body := p.parseSuite()
-	return &WhileStmt{
+	whileStmt := &WhileStmt{
 		While: whilepos,
 		Cond:  cond,
 		Body:  body,
 	}
+	if p.tok == ELSE {
+		whileStmt.ElsePos = p.nextToken() // consume ELSE
+		p.consume(COLON)
+		whileStmt.Else = p.parseSuite()
+	}
+	return whileStmt
 }
 
 // Equivalent to 'exprlist' production in Python grammar.
@@ -276,6 +352,7 @@ func (p *parser) parseSimpleStmt(stmts []Stmt, consumeNL bool) []Stmt {
 
 // small_stmt = RETURN expr?
 //            | PASS | BREAK | CONTINUE
+//            | DEL expr
 //            | LOAD ...
 //            | expr ('=' | '+=' | '-=' | '*=' | '/=' | '%=' | '&=' | '|=' | '^=' | '<<=' | '>>=') expr   // assign
 //            | expr
@@ -289,6 +366,15 @@ func (p *parser) parseSmallStmt() Stmt {
 		}
 		return &ReturnStmt{Return: pos, Result: result}
 
+	case DEL:
+		pos := p.nextToken() // consume DEL
+		x := p.parseExpr(false)
+		targets := []Expr{x}
+		if tuple, ok := x.(*TupleExpr); ok && !tuple.Lparen.IsValid() {
+			targets = tuple.List // del a, b
+		}
+		return &DelStmt{Del: pos, Targets: targets}
+
 	case BREAK, CONTINUE, PASS:
 		tok := p.tok
 		pos := p.nextToken() // consume it
@@ -142,12 +142,24 @@ func TestStmtParseTrees(t *testing.T) {
 			`(ReturnStmt Result=(TupleExpr List=(1 2)))`},
 		{`return`,
 			`(ReturnStmt)`},
+		{`del x`,
+			`(DelStmt Targets=(x))`},
+		{`del d[k], x.f`,
+			`(DelStmt Targets=((IndexExpr X=d Y=k) (DotExpr X=x Name=f)))`},
+		{`del (a, b)`,
+			`(DelStmt Targets=((ParenExpr X=(TupleExpr List=(a b)))))`},
 		{`for i in "abc": break`,
 			`(ForStmt Vars=i X="abc" Body=((BranchStmt Token=break)))`},
 		{`for i in "abc": continue`,
 			`(ForStmt Vars=i X="abc" Body=((BranchStmt Token=continue)))`},
 		{`for x, y in z: pass`,
 			`(ForStmt Vars=(TupleExpr List=(x y)) X=z Body=((BranchStmt Token=pass)))`},
+		{`for x in y: break
+else: pass`,
+			`(ForStmt Vars=x X=y Body=((BranchStmt Token=break)) Else=((BranchStmt Token=pass)))`},
+		{`while x: break
+else: pass`,
+			`(WhileStmt Cond=x Body=((BranchStmt Token=break)) Else=((BranchStmt Token=pass)))`},
 		{`if True: pass`,
 			`(IfStmt Cond=True True=((BranchStmt Token=pass)))`},
 		{`if True: break`,
@@ -264,6 +276,88 @@ pass`,
 	}
 }
 
+// countingReader counts the bytes read from a reader.
+type countingReader struct {
+	r interface{ Read([]byte) (int, error) }
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += n
+	return n, err
+}
+
+// TestParseStmts tests incremental parsing of top-level statements.
+func TestParseStmts(t *testing.T) {
+	const src = `x = 1
+
+def f():
+	return """a
+b"""
+a = 1; b = 2
+if x:
+	pass
+else:
+	pass
+`
+	var got []string
+	for _, in := range []interface{}{src, strings.NewReader(src)} {
+		got = got[:0]
+		err := syntax.ParseStmts("foo.star", in, func(f *syntax.File) error {
+			var buf bytes.Buffer
+			for _, stmt := range f.Stmts {
+				start, _ := stmt.Span()
+				fmt.Fprintf(&buf, "%d:", start.Line)
+				writeTree(&buf, reflect.ValueOf(stmt))
+			}
+			got = append(got, buf.String())
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{
+			`1:(AssignStmt Op== LHS=x RHS=1)`,
+			`3:(DefStmt Name=f Body=((ReturnStmt Result="a\nb")))`,
+			`6:(AssignStmt Op== LHS=a RHS=1)6:(AssignStmt Op== LHS=b RHS=2)`,
+			`7:(IfStmt Cond=x True=((BranchStmt Token=pass)) False=((BranchStmt Token=pass)))`,
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("ParseStmts(%T) yielded\n%s\nwant\n%s", in, strings.Join(got, "\n"), strings.Join(want, "\n"))
+		}
+	}
+
+	// Parsing stops at the first syntax error, after yielding
+	// the preceding statements, or at the first error from fn.
+	got = got[:0]
+	err := syntax.ParseStmts("foo.star", "x = 1\ny = )\nz = 3\n", func(f *syntax.File) error {
+		got = append(got, "stmt")
+		return nil
+	})
+	if err == nil || err.Error() != "foo.star:2:5: unexpected ')'" || len(got) != 1 {
+		t.Errorf("ParseStmts with syntax error: yielded %d, error %v", len(got), err)
+	}
+	stop := fmt.Errorf("stop")
+	if err := syntax.ParseStmts("foo.star", "x = 1\ny = 2\n", func(*syntax.File) error { return stop }); err != stop {
+		t.Errorf("ParseStmts returned %v, want error from fn", err)
+	}
+
+	// A reader is consumed only as needed.
+	big := strings.Repeat("x = 1\n", 10000)
+	r := &countingReader{r: strings.NewReader(big)}
+	var read []int
+	if err := syntax.ParseStmts("foo.star", r, func(*syntax.File) error {
+		read = append(read, r.n)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 10000 || read[0] >= len(big)/2 {
+		t.Errorf("ParseStmts read %d of %d bytes before yielding the first of %d statements", read[0], len(big), len(read))
+	}
+}
+
 // TestCompoundStmt tests handling of REPL-style compound statements.
 func TestCompoundStmt(t *testing.T) {
 	for _, test := range []struct {
@@ -7,6 +7,7 @@ package syntax
 // A lexical scanner for Starlark.
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"io"
@@ -85,6 +86,7 @@ const (
 	BREAK
 	CONTINUE
 	DEF
+	DEL
 	ELIF
 	ELSE
 	FOR
@@ -172,6 +174,7 @@ var tokenNames = [...]string{
 	BREAK:          "break",
 	CONTINUE:       "continue",
 	DEF:            "def",
+	DEL:            "del",
 	ELIF:           "elif",
 	ELSE:           "else",
 	FOR:            "for",
@@ -251,6 +254,7 @@ type scanner struct {
 	suffixComments []Comment         // list of suffix comments (if keepComments)
 
 	readline func() ([]byte, error) // read next line of input (REPL only)
+	reader   *bufio.Reader          // source of further lines of a file read incrementally
 }
 
 func newScanner(filename string, src interface{}, keepComments bool) (*scanner, error) {
@@ -343,6 +347,14 @@ func (sc *scanner) readLine() bool {
 		}
 		return len(sc.rest) > 0
 	}
+	if sc.reader != nil {
+		var err error
+		sc.rest, err = sc.reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			sc.errorf(sc.pos, "%v", err)
+		}
+		return len(sc.rest) > 0
+	}
 	return false
 }
 
@@ -1194,6 +1206,7 @@ var keywordToken = map[string]Token{
 	"break":    BREAK,
 	"continue": CONTINUE,
 	"def":      DEF,
+	"del":      DEL,
 	"elif":     ELIF,
 	"else":     ELSE,
 	"for":      FOR,
@@ -1210,7 +1223,6 @@ var keywordToken = map[string]Token{
 	"as": ILLEGAL,
 	// "assert":   ILLEGAL, // heavily used by our tests
 	"class":    ILLEGAL,
-	"del":      ILLEGAL,
 	"except":   ILLEGAL,
 	"finally":  ILLEGAL,
 	"from":     ILLEGAL,
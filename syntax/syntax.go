@@ -67,8 +67,9 @@ func End(n Node) Position {
 // A File represents a Starlark file.
 type File struct {
 	commentsRef
-	Path  string
-	Stmts []Stmt
+	Path    string
+	Stmts   []Stmt
+	Options *FileOptions // dialect of the file; nil => the resolver's global defaults
 
 	Module interface{} // a *resolve.Module, set by resolver
 }
@@ -91,6 +92,7 @@ type Stmt interface {
 func (*AssignStmt) stmt() {}
 func (*BranchStmt) stmt() {}
 func (*DefStmt) stmt()    {}
+func (*DelStmt) stmt()    {}
 func (*ExprStmt) stmt()   {}
 func (*ForStmt) stmt()    {}
 func (*WhileStmt) stmt()  {}
@@ -132,6 +134,20 @@ func (x *DefStmt) Span() (start, end Position) {
 	return x.Def, end
 }
 
+// A DelStmt deletes variables, elements, or fields:
+//	del x
+//	del d[k], x.f
+type DelStmt struct {
+	commentsRef
+	Del     Position
+	Targets []Expr // each an Ident, IndexExpr, or DotExpr
+}
+
+func (x *DelStmt) Span() (start, end Position) {
+	_, end = x.Targets[len(x.Targets)-1].Span()
+	return x.Del, end
+}
+
 // An ExprStmt is an expression evaluated for side effects.
 type ExprStmt struct {
 	commentsRef
@@ -315,33 +331,46 @@ func (x *Comprehension) Span() (start, end Position) {
 	return x.Lbrack, x.Rbrack.add("]")
 }
 
-// A ForStmt represents a loop: for Vars in X: Body.
+// A ForStmt represents a loop: for Vars in X: Body [else: Else].
 type ForStmt struct {
 	commentsRef
-	For  Position
-	Vars Expr // name, or tuple of names
-	X    Expr
-	Body []Stmt
+	For     Position
+	Vars    Expr // name, or tuple of names
+	X       Expr
+	Body    []Stmt
+	ElsePos Position // position of ELSE, if Else is present
+	Else    []Stmt   // executed if the loop completes without break; may be empty
 }
 
 func (x *ForStmt) Span() (start, end Position) {
-	_, end = x.Body[len(x.Body)-1].Span()
+	_, end = lastStmt(x.Body, x.Else).Span()
 	return x.For, end
 }
 
-// A WhileStmt represents a while loop: while X: Body.
+// A WhileStmt represents a while loop: while X: Body [else: Else].
 type WhileStmt struct {
 	commentsRef
-	While Position
-	Cond  Expr
-	Body  []Stmt
+	While   Position
+	Cond    Expr
+	Body    []Stmt
+	ElsePos Position // position of ELSE, if Else is present
+	Else    []Stmt   // executed if the loop completes without break; may be empty
 }
 
 func (x *WhileStmt) Span() (start, end Position) {
-	_, end = x.Body[len(x.Body)-1].Span()
+	_, end = lastStmt(x.Body, x.Else).Span()
 	return x.While, end
 }
 
+// lastStmt returns the last statement of a loop,
+// which is in its else clause, if any.
+func lastStmt(body, els []Stmt) Stmt {
+	if len(els) > 0 {
+		return els[len(els)-1]
+	}
+	return body[len(body)-1]
+}
+
 // A ForClause represents a for clause in a list comprehension: for Vars in X.
 type ForClause struct {
 	commentsRef
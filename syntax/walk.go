@@ -48,12 +48,23 @@ func Walk(n Node, f func(Node) bool) {
 		Walk(n.Vars, f)
 		Walk(n.X, f)
 		walkStmts(n.Body, f)
+		walkStmts(n.Else, f)
+
+	case *WhileStmt:
+		Walk(n.Cond, f)
+		walkStmts(n.Body, f)
+		walkStmts(n.Else, f)
 
 	case *ReturnStmt:
 		if n.Result != nil {
 			Walk(n.Result, f)
 		}
 
+	case *DelStmt:
+		for _, target := range n.Targets {
+			Walk(target, f)
+		}
+
 	case *LoadStmt:
 		Walk(n.Module, f)
 		for _, from := range n.From {